@@ -0,0 +1,249 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ActivityEntry represents a single entry of an ADDRESS_ACTIVITY or NFT_ACTIVITY webhook.
+// Address activity reports the block as blockNum, NFT activity as blockNumber and adds contractAddress.
+type ActivityEntry struct {
+	BlockNum        string  `json:"blockNum"`
+	BlockNumber     string  `json:"blockNumber"`
+	Hash            string  `json:"hash"`
+	FromAddress     string  `json:"fromAddress"`
+	ToAddress       string  `json:"toAddress"`
+	ContractAddress string  `json:"contractAddress"`
+	Value           float64 `json:"value"`
+	Asset           string  `json:"asset"`
+	Category        string  `json:"category"`
+	ERC721TokenID   string  `json:"erc721TokenId"`
+	ERC1155Metadata []struct {
+		TokenID string `json:"tokenId"`
+		Value   string `json:"value"`
+	} `json:"erc1155Metadata"`
+	RawContract struct {
+		RawValue string `json:"rawValue"`
+		Address  string `json:"address"`
+		Decimals int    `json:"decimals"`
+	} `json:"rawContract"`
+	Log *ActivityLog `json:"log"`
+}
+
+// ActivityLog represents the raw log attached to token activity entries.
+type ActivityLog struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	BlockHash        string   `json:"blockHash"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+// Address activity categories carrying token transfers.
+var tokenActivityCategories = map[string]bool{
+	"token":      true,
+	"erc20":      true,
+	"erc721":     true,
+	"erc1155":    true,
+	"specialnft": true,
+}
+
+// ParseAddressActivity normalizes the activity entries of an ADDRESS_ACTIVITY webhook into TransferDocuments.
+// Token entries are decoded from their attached log, so documents match those of GRAPHQL webhooks.
+// External and internal ETH movements become native transfers when ENABLE_NATIVE_TRANSFERS is set
+// and are skipped otherwise. Entries whose log was removed by a chain reorganization become Tombstones.
+func ParseAddressActivity(webhook *WebhookEvent) (*ParsedWebhook, error) {
+	parsed, err := parseActivity(webhook, parseActivityEntry)
+	if err != nil {
+		return nil, err
+	}
+	if nativeTransfersEnabled() {
+		transfers, errs := parseNativeActivity(webhook)
+		parsed.Transfers = append(parsed.Transfers, transfers...)
+		parsed.Errors = append(parsed.Errors, errs...)
+	}
+	return parsed, nil
+}
+
+// parseNativeActivity maps the non-zero external and internal ETH movements of an address
+// activity webhook into native transfers, reporting malformed entries.
+func parseNativeActivity(webhook *WebhookEvent) ([]*TransferDocument, []LogError) {
+	var documents []*TransferDocument
+	var errs []LogError
+	internal := make(map[string]int)
+	for i, entry := range webhook.Event.Activity {
+		if entry.Category != "external" && entry.Category != "internal" {
+			continue
+		}
+		internalIndex := internal[entry.Hash]
+		if entry.Category == "internal" {
+			internal[entry.Hash]++
+		}
+		transfer, err := parseNativeActivityEntry(entry, internalIndex)
+		if err != nil {
+			errs = append(errs, LogError{Index: i, Kind: KindTransfer, Reason: err.Error()})
+			continue
+		}
+		if transfer.Value.Sign() == 0 {
+			continue
+		}
+		documents = append(documents, newActivityDocument(webhook, entry, transfer))
+	}
+	return documents, errs
+}
+
+// ParseNFTActivity maps the entries of an NFT_ACTIVITY webhook into NFT TransferDocuments, using
+// erc721TokenId and erc1155Metadata for token ids and amounts. The attached log supplies the log index,
+// plus the operator and batch layout of ERC1155 transfers, so document IDs match the GRAPHQL path.
+func ParseNFTActivity(webhook *WebhookEvent) (*ParsedWebhook, error) {
+	return parseActivity(webhook, parseNFTActivityEntry)
+}
+
+// parseActivity maps each activity entry into transfers with parseEntry.
+func parseActivity(webhook *WebhookEvent, parseEntry func(ActivityEntry) ([]Transfer, error)) (*ParsedWebhook, error) {
+	activity := webhook.Event.Activity
+	parsed := &ParsedWebhook{Transfers: make([]*TransferDocument, 0, len(activity))}
+	removed := &ParsedWebhook{}
+
+	for i, entry := range activity {
+		transfers, err := parseEntry(entry)
+		if errors.Is(err, ErrNotTransfer) {
+			continue
+		}
+		if err != nil {
+			parsed.Errors = append(parsed.Errors, LogError{Index: i, Kind: KindTransfer, Reason: err.Error()})
+			continue
+		}
+		target := parsed
+		if entry.Log.Removed {
+			target = removed
+		}
+		for _, transfer := range transfers {
+			target.Transfers = append(target.Transfers, newActivityDocument(webhook, entry, transfer))
+		}
+	}
+
+	parsed.Tombstones = newTombstones(removed)
+	return parsed, nil
+}
+
+// parseActivityEntry decodes the token transfers of a single activity entry.
+func parseActivityEntry(entry ActivityEntry) ([]Transfer, error) {
+	if !tokenActivityCategories[entry.Category] {
+		return nil, ErrNotTransfer
+	}
+	if entry.Log == nil {
+		return nil, fmt.Errorf("%w: %s activity has no log", ErrMalformedTransfer, entry.Category)
+	}
+	logIndex, err := parseHexUint64(entry.Log.LogIndex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid logIndex %q", ErrMalformedTransfer, entry.Log.LogIndex)
+	}
+
+	var raw WebhookLog
+	raw.Data = entry.Log.Data
+	raw.Topics = entry.Log.Topics
+	raw.Index = int(logIndex)
+	raw.Account.Address = entry.Log.Address
+	return decodeTransferLog(raw)
+}
+
+// parseNFTActivityEntry maps a single NFT activity entry into transfers.
+func parseNFTActivityEntry(entry ActivityEntry) ([]Transfer, error) {
+	if entry.Category != "erc721" && entry.Category != "erc1155" {
+		return nil, ErrNotTransfer
+	}
+	if entry.Log == nil {
+		return nil, fmt.Errorf("%w: %s activity has no log", ErrMalformedTransfer, entry.Category)
+	}
+	logIndex, err := parseHexUint64(entry.Log.LogIndex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid logIndex %q", ErrMalformedTransfer, entry.Log.LogIndex)
+	}
+
+	base := Transfer{
+		Contract: entry.ContractAddress,
+		From:     common.HexToAddress(entry.FromAddress).Hex(),
+		To:       common.HexToAddress(entry.ToAddress).Hex(),
+		LogIndex: int(logIndex),
+	}
+
+	if entry.Category == "erc721" {
+		tokenID, err := parseHexBig(entry.ERC721TokenID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid erc721TokenId %q", ErrMalformedTransfer, entry.ERC721TokenID)
+		}
+		base.Standard = StandardERC721
+		base.TokenID = tokenID
+		return []Transfer{base}, nil
+	}
+
+	if len(entry.ERC1155Metadata) == 0 {
+		return nil, fmt.Errorf("%w: erc1155 activity has no erc1155Metadata", ErrMalformedTransfer)
+	}
+	base.Standard = StandardERC1155
+	topics := entry.Log.Topics
+	if len(topics) > 1 {
+		base.Operator = common.HexToAddress(topics[1]).Hex()
+	}
+	batch := len(topics) > 0 && common.HexToHash(topics[0]) == transferBatchEventTopic
+
+	transfers := make([]Transfer, 0, len(entry.ERC1155Metadata))
+	for i, metadata := range entry.ERC1155Metadata {
+		tokenID, err := parseHexBig(metadata.TokenID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid erc1155 tokenId %q", ErrMalformedTransfer, metadata.TokenID)
+		}
+		value, ok := new(big.Int).SetString(metadata.Value, 0)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid erc1155 value %q", ErrMalformedTransfer, metadata.Value)
+		}
+		transfer := base
+		transfer.TokenID = tokenID
+		transfer.Value = value
+		if batch {
+			batchIndex := i
+			transfer.BatchIndex = &batchIndex
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
+
+// newActivityDocument combines a decoded transfer with the metadata available on an activity entry.
+// Address activity payloads carry no block timestamp or transaction gas data, so those fields stay empty;
+// only executed transfers are reported, so the transaction status is 1. ETH movements carry no
+// log, so their block hash stays empty too.
+func newActivityDocument(webhook *WebhookEvent, entry ActivityEntry, transfer Transfer) *TransferDocument {
+	blockNumber := entry.BlockNum
+	if blockNumber == "" {
+		blockNumber = entry.BlockNumber
+	}
+	number, _ := parseHexUint64(blockNumber)
+	var blockHash string
+	if entry.Log != nil {
+		blockHash = entry.Log.BlockHash
+	}
+	return &TransferDocument{
+		SchemaVersion: SchemaVersion,
+		Block: Block{
+			Hash:   blockHash,
+			Number: int64(number),
+		},
+		Transaction: Transaction{
+			Hash:        entry.Hash,
+			Status:      1,
+			statusKnown: true,
+		},
+		Transfer: transfer,
+		Network:  webhook.Event.Network,
+		Alchemy:  newAlchemyMetadata(webhook),
+	}
+}
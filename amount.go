@@ -0,0 +1,172 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// nativeDecimals is the number of decimals of native currency amounts, which are in wei.
+	nativeDecimals = 18
+
+	defaultAmountPercentileWindow     = 1000
+	defaultAmountPercentileMinSamples = 100
+)
+
+// setAmountBuckets sets the AmountBucket of transfers with a value. It runs after the enrichers,
+// so tokens whose metadata they found are bucketed in whole tokens.
+func setAmountBuckets(transfers []*TransferDocument) {
+	for _, doc := range transfers {
+		doc.AmountBucket = transferAmountBucket(doc)
+	}
+}
+
+// transferAmountBucket returns the amount bucket of doc, or "" for transfers without a value.
+func transferAmountBucket(doc *TransferDocument) string {
+	if doc.Transfer.Value == nil {
+		return ""
+	}
+	return amountBucket(doc.Transfer.Value, transferDecimals(doc))
+}
+
+// transferDecimals returns the decimals of the transferred token: 18 for native transfers, the
+// token metadata's when known, and 0 otherwise, leaving amounts in base units.
+func transferDecimals(doc *TransferDocument) int {
+	if doc.isNative() {
+		return nativeDecimals
+	}
+	if doc.Token != nil && doc.Token.Decimals != nil {
+		return *doc.Token.Decimals
+	}
+	return 0
+}
+
+// amountBucket returns the decimal order of magnitude of value scaled down by decimals, such as
+// "1e6" for 1,000,000 to 9,999,999 whole tokens, or "lt1" below one whole token.
+func amountBucket(value *big.Int, decimals int) string {
+	units := new(big.Int).Abs(value)
+	if decimals > 0 {
+		units.Quo(units, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	}
+	if units.Sign() == 0 {
+		return "lt1"
+	}
+	return fmt.Sprintf("1e%d", len(units.String())-1)
+}
+
+// percentileBand returns the coarse band of a percentile rank used as a filter attribute: "p99",
+// "p90" or "p50" at or above that percentile, and "lt50" below the median.
+func percentileBand(percentile float64) string {
+	switch {
+	case percentile >= 99:
+		return "p99"
+	case percentile >= 90:
+		return "p90"
+	case percentile >= 50:
+		return "p50"
+	}
+	return "lt50"
+}
+
+// AmountPercentileEnricher ranks each transfer's amount against the trailing amounts of the same
+// token on the same network, kept in memory per instance. Ranks are only meaningful once an
+// instance has seen enough transfers, so it belongs in the long-running enrichment worker rather
+// than in short-lived function instances.
+type AmountPercentileEnricher struct {
+	window     int
+	minSamples int
+
+	mu      sync.Mutex
+	windows map[string]*amountWindow
+}
+
+// amountWindow is a ring buffer of a token's most recent amounts.
+type amountWindow struct {
+	amounts []*big.Int
+	next    int
+}
+
+// NewAmountPercentileEnricher returns an amount percentile enricher when ENABLE_AMOUNT_PERCENTILE
+// is set, ranking against the last AMOUNT_PERCENTILE_WINDOW transfers of each token once
+// AMOUNT_PERCENTILE_MIN_SAMPLES of them have been seen. It returns nil when ranking is disabled.
+func NewAmountPercentileEnricher() (*AmountPercentileEnricher, error) {
+	if os.Getenv("ENABLE_AMOUNT_PERCENTILE") != "true" {
+		return nil, nil
+	}
+	window := envInt("AMOUNT_PERCENTILE_WINDOW", defaultAmountPercentileWindow)
+	minSamples := envInt("AMOUNT_PERCENTILE_MIN_SAMPLES", defaultAmountPercentileMinSamples)
+	if window < 1 {
+		return nil, fmt.Errorf("invalid AMOUNT_PERCENTILE_WINDOW %d", window)
+	}
+	if minSamples < 1 || minSamples > window {
+		return nil, fmt.Errorf("invalid AMOUNT_PERCENTILE_MIN_SAMPLES %d: must be between 1 and AMOUNT_PERCENTILE_WINDOW", minSamples)
+	}
+	return &AmountPercentileEnricher{
+		window:     window,
+		minSamples: minSamples,
+		windows:    make(map[string]*amountWindow),
+	}, nil
+}
+
+// Name returns the enricher name.
+func (e *AmountPercentileEnricher) Name() string {
+	return "amount-percentile"
+}
+
+// Enrich sets the AmountPercentile of transfers with a value, then adds their amounts to the
+// windows of their tokens. Reverted transfers are ranked but not added, since their amounts
+// never moved.
+func (e *AmountPercentileEnricher) Enrich(_ context.Context, transfers []*TransferDocument) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, doc := range transfers {
+		if doc.Transfer.Value == nil {
+			continue
+		}
+		key := doc.Network + "/" + strings.ToLower(doc.Transfer.Contract)
+		window, ok := e.windows[key]
+		if !ok {
+			window = &amountWindow{}
+			e.windows[key] = window
+		}
+		if len(window.amounts) >= e.minSamples {
+			percentile := window.rank(doc.Transfer.Value)
+			doc.AmountPercentile = &percentile
+		}
+		if !doc.Reverted {
+			window.add(doc.Transfer.Value, e.window)
+		}
+	}
+	return nil
+}
+
+// rank returns the percentile rank of value in the window: the percentage of amounts below it,
+// counting equal amounts as half, rounded to two decimals.
+func (w *amountWindow) rank(value *big.Int) float64 {
+	var below, equal int
+	for _, amount := range w.amounts {
+		switch amount.Cmp(value) {
+		case -1:
+			below++
+		case 0:
+			equal++
+		}
+	}
+	rank := (float64(below) + float64(equal)/2) / float64(len(w.amounts)) * 100
+	return math.Round(rank*100) / 100
+}
+
+// add appends value to the window, replacing its oldest amount once it holds size amounts.
+func (w *amountWindow) add(value *big.Int, size int) {
+	if len(w.amounts) < size {
+		w.amounts = append(w.amounts, value)
+		return
+	}
+	w.amounts[w.next] = value
+	w.next = (w.next + 1) % size
+}
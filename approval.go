@@ -0,0 +1,168 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const approvalsCollectionName = "alchemy_approvals"
+
+// Approval event names reported in Approval.Event.
+const (
+	ApprovalEvent       = "Approval"
+	ApprovalForAllEvent = "ApprovalForAll"
+)
+
+// ERC20 Approval and ERC721/ERC1155 ApprovalForAll event ABI definitions.
+const approvalEventABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"spender","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Approval","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"operator","type":"address"},{"indexed":false,"name":"approved","type":"bool"}],"name":"ApprovalForAll","type":"event"}]`
+
+var parsedApprovalABI abi.ABI
+
+// Approval event signature hashes expected in topics[0].
+var (
+	approvalEventTopic       = crypto.Keccak256Hash([]byte("Approval(address,address,uint256)"))
+	approvalForAllEventTopic = crypto.Keccak256Hash([]byte("ApprovalForAll(address,address,bool)"))
+)
+
+var (
+	// ErrNotApproval is returned for logs whose topics[0] is not an approval event signature.
+	ErrNotApproval = errors.New("log is not an approval event")
+	// ErrMalformedApproval is returned for approval logs whose topics or data cannot be decoded.
+	ErrMalformedApproval = errors.New("malformed approval event")
+)
+
+func init() {
+	var err error
+	parsedApprovalABI, err = abi.JSON(strings.NewReader(approvalEventABI))
+	if err != nil {
+		panic("failed to parse approval event ABI: " + err.Error())
+	}
+}
+
+// Approval represents a decoded allowance change. ERC20 Approval sets Value, ERC721 Approval
+// sets TokenID, and ApprovalForAll sets Approved. ApprovalForAll shares its signature across
+// ERC721 and ERC1155, so its Standard is left empty.
+type Approval struct {
+	Contract string `json:"contract"`
+	Event    string `json:"event"`
+	Standard string `json:"standard,omitempty"`
+	Owner    string `json:"owner"`
+	Spender  string `json:"spender"`
+	Value    string `json:"value,omitempty"`
+	TokenID  string `json:"tokenId,omitempty"`
+	Approved *bool  `json:"approved,omitempty"`
+	LogIndex int    `json:"logIndex"`
+}
+
+// ApprovalDocument represents the document structure for approval events.
+type ApprovalDocument struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Block         Block           `json:"block"`
+	Transaction   Transaction     `json:"transaction"`
+	Approval      Approval        `json:"approval"`
+	Network       string          `json:"network"`
+	Alchemy       AlchemyMetadata `json:"alchemy"`
+	RawLog        *RawLog         `json:"rawLog,omitempty"`
+}
+
+// DocumentID returns the idempotent document ID of the approval.
+func (d *ApprovalDocument) DocumentID() string {
+	return GetDocumentID(d.Transaction.Hash, d.Approval.LogIndex)
+}
+
+// decodedApprovalEvent holds the decoded value from Approval event data.
+type decodedApprovalEvent struct {
+	Value *big.Int `abi:"value"`
+}
+
+// decodedApprovalForAllEvent holds the decoded flag from ApprovalForAll event data.
+type decodedApprovalForAllEvent struct {
+	Approved bool `abi:"approved"`
+}
+
+// parseApprovalEntry parses a single log entry into an ApprovalDocument.
+func parseApprovalEntry(webhook *WebhookEvent, index int) (*ApprovalDocument, error) {
+	log := webhook.Event.Data.Block.Logs[index]
+	approval, err := decodeApprovalLog(log)
+	if err != nil {
+		return nil, err
+	}
+	return &ApprovalDocument{
+		SchemaVersion: SchemaVersion,
+		Block:         newBlock(webhook),
+		Transaction:   newTransaction(log),
+		Approval:      approval,
+		Network:       webhook.Event.Network,
+		Alchemy:       newAlchemyMetadata(webhook),
+	}, nil
+}
+
+// decodeApprovalLog decodes an Approval or ApprovalForAll log, dispatching by topics[0].
+func decodeApprovalLog(log WebhookLog) (Approval, error) {
+	if len(log.Topics) == 0 {
+		return Approval{}, ErrNotApproval
+	}
+	switch common.HexToHash(log.Topics[0]) {
+	case approvalEventTopic:
+		return decodeApproval(log)
+	case approvalForAllEventTopic:
+		return decodeApprovalForAll(log)
+	default:
+		return Approval{}, ErrNotApproval
+	}
+}
+
+// decodeApproval decodes an ERC20 or ERC721 Approval log, told apart by topic count.
+func decodeApproval(log WebhookLog) (Approval, error) {
+	if len(log.Topics) != 3 && len(log.Topics) != 4 {
+		return Approval{}, fmt.Errorf("%w: expected 3 or 4 topics, got %d", ErrMalformedApproval, len(log.Topics))
+	}
+	approval := newApproval(log, ApprovalEvent)
+	switch len(log.Topics) {
+	case 3:
+		var decoded decodedApprovalEvent
+		if err := parsedApprovalABI.UnpackIntoInterface(&decoded, ApprovalEvent, common.FromHex(log.Data)); err != nil {
+			return Approval{}, fmt.Errorf("%w: %v", ErrMalformedApproval, err)
+		}
+		approval.Standard = StandardERC20
+		approval.Value = bigIntString(decoded.Value)
+	case 4:
+		// ERC721 indexes tokenId, leaving the data empty.
+		if len(common.FromHex(log.Data)) != 0 {
+			return Approval{}, fmt.Errorf("%w: unexpected data on ERC721 approval", ErrMalformedApproval)
+		}
+		approval.Standard = StandardERC721
+		approval.TokenID = bigIntString(common.HexToHash(log.Topics[3]).Big())
+	}
+	return approval, nil
+}
+
+// decodeApprovalForAll decodes an ERC721 or ERC1155 ApprovalForAll log.
+func decodeApprovalForAll(log WebhookLog) (Approval, error) {
+	if len(log.Topics) != 3 {
+		return Approval{}, fmt.Errorf("%w: expected 3 topics on ApprovalForAll, got %d", ErrMalformedApproval, len(log.Topics))
+	}
+	var decoded decodedApprovalForAllEvent
+	if err := parsedApprovalABI.UnpackIntoInterface(&decoded, ApprovalForAllEvent, common.FromHex(log.Data)); err != nil {
+		return Approval{}, fmt.Errorf("%w: %v", ErrMalformedApproval, err)
+	}
+	approval := newApproval(log, ApprovalForAllEvent)
+	approval.Approved = &decoded.Approved
+	return approval, nil
+}
+
+func newApproval(log WebhookLog, event string) Approval {
+	return Approval{
+		Contract: log.Account.Address,
+		Event:    event,
+		Owner:    common.HexToAddress(log.Topics[1]).Hex(),
+		Spender:  common.HexToAddress(log.Topics[2]).Hex(),
+		LogIndex: log.Index,
+	}
+}
@@ -0,0 +1,99 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// archiveSink archives transfers, reverted ones included, to Cloud Storage as newline-delimited
+// JSON under <ARCHIVE_PREFIX>/<network>/dt=<YYYY-MM-DD>/ in ARCHIVE_BUCKET, dated by block.
+// Objects are immutable, so each webhook writes one object per network and date, named after
+// the webhook and event IDs: a redelivered event overwrites its own objects rather than adding
+// duplicates.
+type archiveSink struct {
+	client     *storage.Client
+	bucket     string
+	prefix     string
+	serializer Serializer
+}
+
+func (*archiveSink) Name() string { return sinkGCS }
+
+// Init reads the bucket and serializer configuration and creates the Cloud Storage client.
+func (s *archiveSink) Init(ctx context.Context) error {
+	s.bucket = os.Getenv("ARCHIVE_BUCKET")
+	if s.bucket == "" {
+		return errors.New("ARCHIVE_BUCKET must be set")
+	}
+	s.prefix = strings.Trim(os.Getenv("ARCHIVE_PREFIX"), "/")
+	serializer, err := sinkSerializer(sinkGCS)
+	if err != nil {
+		return err
+	}
+	if serializer.ContentType() != "application/json" {
+		return fmt.Errorf("the %s sink writes NDJSON and does not support GCS_SERIALIZER=%s", sinkGCS, serializer.Name())
+	}
+	s.serializer = serializer
+
+	client, err := storage.NewClient(context.WithoutCancel(ctx))
+	if err != nil {
+		return err
+	}
+	s.client = client
+	return nil
+}
+
+func (s *archiveSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	transfers := append(append([]*TransferDocument{}, parsed.Transfers...), parsed.Reverted...)
+	objects, byObject := groupTransfers(transfers, s.objectName)
+	for _, name := range objects {
+		var buf bytes.Buffer
+		for _, doc := range byObject[name] {
+			line, err := s.serializer.Marshal(doc)
+			if err != nil {
+				return fmt.Errorf("failed to marshal transfer %s: %w", doc.DocumentID(), err)
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+
+		writer := s.client.Bucket(s.bucket).Object(name).NewWriter(ctx)
+		writer.ContentType = "application/x-ndjson"
+		if _, err := writer.Write(buf.Bytes()); err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to write gs://%s/%s: %w", s.bucket, name, err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to write gs://%s/%s: %w", s.bucket, name, err)
+		}
+	}
+	return nil
+}
+
+// objectName returns the object doc is archived in:
+// <prefix>/<network>/dt=<YYYY-MM-DD>/<webhookId>-<eventId>.ndjson.
+func (s *archiveSink) objectName(doc *TransferDocument) string {
+	network := doc.Network
+	if network == "" {
+		network = "unknown"
+	}
+	file := doc.Alchemy.WebhookID + "-" + doc.Alchemy.EventID + ".ndjson"
+	return path.Join(s.prefix, network, "dt="+doc.blockTime().Format("2006-01-02"), file)
+}
+
+// Close closes the Cloud Storage client.
+func (s *archiveSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
@@ -0,0 +1,247 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/pubsub/v2"
+)
+
+// Filter attributes set on transfer messages under PUBSUB_FILTER_ATTRIBUTES, in the order they
+// make up a message's grouping key.
+var filterAttributeNames = []string{"contract", "from", "to", "transfer_type", "amount_bucket", "amount_percentile", "token_symbol", "block_bucket"}
+
+// Filter attributes set on event messages under PUBSUB_FILTER_ATTRIBUTES, in the order they make
+// up a message's grouping key.
+var eventFilterAttributeNames = []string{"contract", "event_type", "block_bucket"}
+
+const (
+	defaultBlockBucketSize = 10000
+	// maxSymbolAttributeLength bounds the token symbols set as attributes; longer symbols, which
+	// only odd or malicious tokens have, are left out.
+	maxSymbolAttributeLength = 32
+)
+
+// Pub/Sub message modes of PUBSUB_MESSAGE_MODE.
+const (
+	// PubSubMessageBatch publishes a webhook's transfers together, split only by
+	// PUBSUB_FILTER_ATTRIBUTES.
+	PubSubMessageBatch = "batch"
+	// PubSubMessagePerTransfer publishes every transfer as its own message.
+	PubSubMessagePerTransfer = "per-transfer"
+)
+
+// getPubSubMessageMode returns the message mode of PUBSUB_MESSAGE_MODE, batch by default.
+func getPubSubMessageMode() (string, error) {
+	switch mode := os.Getenv("PUBSUB_MESSAGE_MODE"); mode {
+	case "":
+		return PubSubMessageBatch, nil
+	case PubSubMessageBatch, PubSubMessagePerTransfer:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid PUBSUB_MESSAGE_MODE %q (want %s or %s)", mode, PubSubMessageBatch, PubSubMessagePerTransfer)
+	}
+}
+
+// filterAttributesEnabled reports whether PUBSUB_FILTER_ATTRIBUTES groups transfer messages by
+// filter attributes.
+func filterAttributesEnabled() bool {
+	return os.Getenv("PUBSUB_FILTER_ATTRIBUTES") == "true"
+}
+
+// publishFilterableTransfers publishes transfers grouped by their filter attributes, one message
+// per group, so every attribute of a message holds for every transfer in it and subscription
+// filters never see a transfer that does not match.
+func (p *PubSubPublisher) publishFilterableTransfers(ctx context.Context, transfers []*TransferDocument) error {
+	rules := p.redactionRules()
+	attributesOf := make(map[*TransferDocument]map[string]string, len(transfers))
+	keys, groups := groupTransfers(transfers, func(doc *TransferDocument) string {
+		attributes := transferFilterAttributes(doc, rules)
+		attributesOf[doc] = attributes
+		values := make([]string, len(filterAttributeNames))
+		for i, name := range filterAttributeNames {
+			values[i] = attributes[name]
+		}
+		return strings.Join(values, "\x00")
+	})
+
+	messages := make([]*pubsub.Message, 0, len(keys))
+	for _, key := range keys {
+		group := groups[key]
+		attributes := buildAttributes("transfers", group[0].Alchemy, group[0].Network, len(group))
+		for name, value := range attributesOf[group[0]] {
+			attributes[name] = value
+		}
+		parts, err := marshalMessages(p, group, attributes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal transfers: %w", err)
+		}
+		messages = append(messages, parts...)
+	}
+	return p.publishAll(ctx, messages)
+}
+
+// publishFilterableEvents publishes events grouped by their filter attributes, one message per
+// group, like publishFilterableTransfers.
+func (p *PubSubPublisher) publishFilterableEvents(ctx context.Context, events []*EventDocument) error {
+	rules := p.redactionRules()
+	var keys []string
+	groups := make(map[string][]*EventDocument)
+	attributesOf := make(map[string]map[string]string)
+	for _, doc := range events {
+		attributes := eventFilterAttributes(doc, rules)
+		values := make([]string, len(eventFilterAttributeNames))
+		for i, name := range eventFilterAttributeNames {
+			values[i] = attributes[name]
+		}
+		key := strings.Join(values, "\x00")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			attributesOf[key] = attributes
+		}
+		groups[key] = append(groups[key], doc)
+	}
+
+	messages := make([]*pubsub.Message, 0, len(keys))
+	for _, key := range keys {
+		group := groups[key]
+		attributes := buildAttributes("events", group[0].Alchemy, group[0].Network, len(group))
+		for name, value := range attributesOf[key] {
+			attributes[name] = value
+		}
+		parts, err := marshalMessages(p, group, attributes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal events: %w", err)
+		}
+		messages = append(messages, parts...)
+	}
+	return p.publishAll(ctx, messages)
+}
+
+// publishTransferMessages publishes every one of transfers as its own message, a one-element
+// array or envelope, with a count of 1 and the transfer's filter attributes, so subscribers
+// neither split batches nor see transfers their filters do not match.
+func (p *PubSubPublisher) publishTransferMessages(ctx context.Context, transfers []*TransferDocument) error {
+	rules := p.redactionRules()
+	messages := make([]*pubsub.Message, 0, len(transfers))
+	for _, doc := range transfers {
+		attributes := buildAttributes("transfers", doc.Alchemy, doc.Network, 1)
+		for name, value := range transferFilterAttributes(doc, rules) {
+			attributes[name] = value
+		}
+		message, err := marshalMessages(p, []*TransferDocument{doc}, attributes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal transfer %s: %w", doc.DocumentID(), err)
+		}
+		messages = append(messages, message...)
+	}
+	return p.publishAll(ctx, messages)
+}
+
+// publishContractTransfers publishes transfers grouped by contract, one message per contract with
+// its contract attribute, so each message has the contract's ordering key.
+func (p *PubSubPublisher) publishContractTransfers(ctx context.Context, transfers []*TransferDocument) error {
+	rules := p.redactionRules()
+	keys, groups := groupTransfers(transfers, func(doc *TransferDocument) string {
+		return transferFilterAttributes(doc, rules)["contract"]
+	})
+	messages := make([]*pubsub.Message, 0, len(keys))
+	for _, contract := range keys {
+		group := groups[contract]
+		attributes := buildAttributes("transfers", group[0].Alchemy, group[0].Network, len(group))
+		if contract != "" {
+			attributes["contract"] = contract
+		}
+		parts, err := marshalMessages(p, group, attributes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal transfers: %w", err)
+		}
+		messages = append(messages, parts...)
+	}
+	return p.publishAll(ctx, messages)
+}
+
+// redactionRules returns the redaction rules of the publisher's serializer, or nil.
+func (p *PubSubPublisher) redactionRules() *RedactionRules {
+	if redacting, ok := p.serializer.(redactingSerializer); ok {
+		return redacting.rules
+	}
+	return nil
+}
+
+// transferFilterAttributes returns the filter attributes of doc. Addresses are lowercased, since
+// filters compare attributes exactly. Fields the sink's redaction rules drop are left out and
+// those they hash carry the same digest as the payload, except the block number, whose bucket is
+// left out. Transfers without a value, such as ERC721 transfers, have no amount_bucket, transfers
+// not ranked by the amount percentile enricher have no amount_percentile, and transfers without
+// token metadata have no token_symbol.
+func transferFilterAttributes(doc *TransferDocument, rules *RedactionRules) map[string]string {
+	transfer := map[string]any{
+		"contract": doc.Transfer.Contract,
+		"from":     doc.Transfer.From,
+		"to":       doc.Transfer.To,
+	}
+	fields := map[string]any{"transfer": transfer, "block": map[string]any{"number": doc.Block.Number}}
+	if doc.Token != nil {
+		fields["token"] = map[string]any{"symbol": doc.Token.Symbol}
+	}
+	rules.apply(fields)
+
+	attributes := map[string]string{"transfer_type": doc.Transfer.Standard}
+	for _, name := range []string{"contract", "from", "to"} {
+		if value, ok := transfer[name].(string); ok && value != "" {
+			attributes[name] = strings.ToLower(value)
+		}
+	}
+	if bucket := transferAmountBucket(doc); bucket != "" {
+		attributes["amount_bucket"] = bucket
+	}
+	if doc.AmountPercentile != nil {
+		attributes["amount_percentile"] = percentileBand(*doc.AmountPercentile)
+	}
+	if token, ok := fields["token"].(map[string]any); ok {
+		if symbol, ok := token["symbol"].(string); ok && symbol != "" && len(symbol) <= maxSymbolAttributeLength && utf8.ValidString(symbol) {
+			attributes["token_symbol"] = symbol
+		}
+	}
+	if bucket := blockBucket(fields); bucket != "" {
+		attributes["block_bucket"] = bucket
+	}
+	return attributes
+}
+
+// eventFilterAttributes returns the filter attributes of doc: its lowercased contract, its event
+// name as event_type and its block bucket, leaving out what the sink's redaction rules drop.
+func eventFilterAttributes(doc *EventDocument, rules *RedactionRules) map[string]string {
+	event := map[string]any{"contract": doc.Event.Contract, "name": doc.Event.Name}
+	fields := map[string]any{"event": event, "block": map[string]any{"number": doc.Block.Number}}
+	rules.apply(fields)
+
+	attributes := map[string]string{}
+	if contract, ok := event["contract"].(string); ok && contract != "" {
+		attributes["contract"] = strings.ToLower(contract)
+	}
+	if name, ok := event["name"].(string); ok && name != "" {
+		attributes["event_type"] = name
+	}
+	if bucket := blockBucket(fields); bucket != "" {
+		attributes["block_bucket"] = bucket
+	}
+	return attributes
+}
+
+// blockBucket returns the block number of fields rounded down to a multiple of
+// PUBSUB_BLOCK_BUCKET_SIZE (default 10000), or "" when redaction removed or hashed it.
+func blockBucket(fields map[string]any) string {
+	block, _ := fields["block"].(map[string]any)
+	number, ok := block["number"].(int64)
+	if !ok {
+		return ""
+	}
+	size := int64(max(envInt("PUBSUB_BLOCK_BUCKET_SIZE", defaultBlockBucketSize), 1))
+	return strconv.FormatInt(number/size*size, 10)
+}
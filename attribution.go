@@ -0,0 +1,76 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Entity describes a known counterparty such as an exchange, bridge or mixer.
+type Entity struct {
+	Name     string `json:"entity"`
+	Category string `json:"category"`
+	Country  string `json:"country,omitempty"`
+}
+
+// Attribution holds the known entities behind a transfer's sender and recipient.
+type Attribution struct {
+	From *Entity `json:"from,omitempty"`
+	To   *Entity `json:"to,omitempty"`
+}
+
+// attributionRecord is a single row of the attribution dataset.
+type attributionRecord struct {
+	Address string `json:"address"`
+	Entity
+}
+
+// AttributionEnricher tags transfers whose counterparties appear in the attribution dataset.
+type AttributionEnricher struct {
+	entities map[string]*Entity
+}
+
+// NewAttributionEnricher loads the dataset at ATTRIBUTION_DATASET_FILE, a JSON array of
+// {"address", "entity", "category", "country"} records. It returns nil when no dataset is configured.
+func NewAttributionEnricher() (*AttributionEnricher, error) {
+	path := os.Getenv("ATTRIBUTION_DATASET_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ATTRIBUTION_DATASET_FILE: %w", err)
+	}
+
+	var records []attributionRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse attribution dataset: %w", err)
+	}
+
+	entities := make(map[string]*Entity, len(records))
+	for _, record := range records {
+		entity := record.Entity
+		entities[strings.ToLower(record.Address)] = &entity
+	}
+	return &AttributionEnricher{entities: entities}, nil
+}
+
+// Name returns the enricher name.
+func (a *AttributionEnricher) Name() string {
+	return "attribution"
+}
+
+// Enrich sets the attribution of transfers with a known sender or recipient.
+func (a *AttributionEnricher) Enrich(_ context.Context, transfers []*TransferDocument) error {
+	for _, transfer := range transfers {
+		from := a.entities[strings.ToLower(transfer.Transfer.From)]
+		to := a.entities[strings.ToLower(transfer.Transfer.To)]
+		if from == nil && to == nil {
+			continue
+		}
+		transfer.Attribution = &Attribution{From: from, To: to}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// AvroValueSchema is the Avro schema of avroSerializer payloads: a record wrapping a JSON-like
+// value, where arrays and maps hold nested records of the same type.
+const AvroValueSchema = `{
+  "type": "record",
+  "name": "Value",
+  "namespace": "com.alchemy.webhook",
+  "fields": [{
+    "name": "value",
+    "type": ["null", "boolean", "long", "double", "string",
+      {"type": "array", "items": "Value"},
+      {"type": "map", "values": "Value"}]
+  }]
+}`
+
+var avroValueCodec *goavro.Codec
+
+func init() {
+	var err error
+	avroValueCodec, err = goavro.NewCodec(AvroValueSchema)
+	if err != nil {
+		panic("failed to compile Avro value schema: " + err.Error())
+	}
+	registerSerializer(avroSerializer{})
+}
+
+// avroSerializer encodes payloads as Avro binary in AvroValueSchema. Documents have no fixed
+// Avro schema of their own, so they are encoded as the generic value of their JSON form and
+// carry the same fields as the JSON payloads, with integers as longs and amounts as decimal
+// strings. Payloads hold no schema or fingerprint; consumers read them with AvroValueSchema.
+type avroSerializer struct{}
+
+func (avroSerializer) Name() string        { return "avro" }
+func (avroSerializer) ContentType() string { return "application/avro" }
+
+func (avroSerializer) Marshal(v any) ([]byte, error) {
+	value, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	native, err := avroNative(value)
+	if err != nil {
+		return nil, err
+	}
+	return avroValueCodec.BinaryFromNative(nil, native)
+}
+
+func (avroSerializer) Unmarshal(data []byte, v any) error {
+	native, _, err := avroValueCodec.NativeFromBinary(data)
+	if err != nil {
+		return err
+	}
+	value, err := avroValue(native)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// avroNative returns the Value record of a JSON-decoded value in goavro's native form, where
+// each union value is a map from its branch name to the value.
+func avroNative(value any) (map[string]any, error) {
+	var branch any
+	switch value := value.(type) {
+	case nil:
+	case bool:
+		branch = goavro.Union("boolean", value)
+	case string:
+		branch = goavro.Union("string", value)
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			branch = goavro.Union("long", i)
+		} else if f, err := value.Float64(); err == nil {
+			branch = goavro.Union("double", f)
+		} else {
+			return nil, fmt.Errorf("avro: invalid number %q", value)
+		}
+	case []any:
+		items := make([]any, len(value))
+		for i, item := range value {
+			native, err := avroNative(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = native
+		}
+		branch = goavro.Union("array", items)
+	case map[string]any:
+		values := make(map[string]any, len(value))
+		for key, item := range value {
+			native, err := avroNative(item)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = native
+		}
+		branch = goavro.Union("map", values)
+	default:
+		return nil, fmt.Errorf("avro: unsupported value of type %T", value)
+	}
+	return map[string]any{"value": branch}, nil
+}
+
+// avroValue returns the JSON-like value of a Value record in goavro's native form.
+func avroValue(native any) (any, error) {
+	record, ok := native.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("avro: value of type %T is not a record", native)
+	}
+	union, ok := record["value"].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	for name, branch := range union {
+		switch name {
+		case "array":
+			items := branch.([]any)
+			values := make([]any, len(items))
+			for i, item := range items {
+				value, err := avroValue(item)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = value
+			}
+			return values, nil
+		case "map":
+			items := branch.(map[string]any)
+			values := make(map[string]any, len(items))
+			for key, item := range items {
+				value, err := avroValue(item)
+				if err != nil {
+					return nil, err
+				}
+				values[key] = value
+			}
+			return values, nil
+		default:
+			return branch, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,229 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	defaultBigQueryTable = "transfers"
+	// bigQueryAppendLimit caps the rows of a single append, keeping requests of large blocks
+	// well under the Storage Write API's 10 MB limit.
+	bigQueryAppendLimit = 500
+)
+
+// bigQuerySchema is the schema of the transfers table. Each transfer is one row, with the
+// columns analytics queries filter on and the whole document as JSON in document.
+var bigQuerySchema = bigquery.Schema{
+	{Name: "document_id", Type: bigquery.StringFieldType, Required: true},
+	{Name: "schema_version", Type: bigquery.IntegerFieldType},
+	{Name: "block_hash", Type: bigquery.StringFieldType},
+	{Name: "block_number", Type: bigquery.IntegerFieldType},
+	{Name: "block_timestamp", Type: bigquery.TimestampFieldType, Required: true},
+	{Name: "transaction_hash", Type: bigquery.StringFieldType},
+	{Name: "contract", Type: bigquery.StringFieldType},
+	{Name: "standard", Type: bigquery.StringFieldType},
+	{Name: "operator", Type: bigquery.StringFieldType},
+	{Name: "from_address", Type: bigquery.StringFieldType},
+	{Name: "to_address", Type: bigquery.StringFieldType},
+	{Name: "value", Type: bigquery.StringFieldType},
+	{Name: "token_id", Type: bigquery.StringFieldType},
+	{Name: "log_index", Type: bigquery.IntegerFieldType},
+	{Name: "batch_index", Type: bigquery.IntegerFieldType},
+	{Name: "trace_index", Type: bigquery.IntegerFieldType},
+	{Name: "network", Type: bigquery.StringFieldType},
+	{Name: "webhook_id", Type: bigquery.StringFieldType},
+	{Name: "event_id", Type: bigquery.StringFieldType},
+	{Name: "sequence_number", Type: bigquery.StringFieldType},
+	{Name: "reverted", Type: bigquery.BooleanFieldType},
+	{Name: "finality", Type: bigquery.StringFieldType},
+	{Name: "inserted_at", Type: bigquery.TimestampFieldType},
+	{Name: "document", Type: bigquery.JSONFieldType},
+}
+
+// bigQuerySink streams transfers, reverted ones included, into BIGQUERY_DATASET.BIGQUERY_TABLE
+// through the Storage Write API default stream. Init creates the table when it is missing,
+// partitioned by day of block_timestamp and clustered by contract.
+type bigQuerySink struct {
+	client     *managedwriter.Client
+	stream     *managedwriter.ManagedStream
+	descriptor protoreflect.MessageDescriptor
+}
+
+func (*bigQuerySink) Name() string { return sinkBigQuery }
+
+// Init ensures the table exists and opens its default stream.
+func (s *bigQuerySink) Init(ctx context.Context) error {
+	projectID := getProjectID()
+	if projectID == "" {
+		return errors.New("project ID not found (GCP_PROJECT or GOOGLE_CLOUD_PROJECT)")
+	}
+	dataset := os.Getenv("BIGQUERY_DATASET")
+	if dataset == "" {
+		return errors.New("BIGQUERY_DATASET must be set")
+	}
+	table := os.Getenv("BIGQUERY_TABLE")
+	if table == "" {
+		table = defaultBigQueryTable
+	}
+	if err := ensureBigQueryTable(ctx, projectID, dataset, table); err != nil {
+		return err
+	}
+
+	storageSchema, err := adapt.BQSchemaToStorageTableSchema(bigQuerySchema)
+	if err != nil {
+		return err
+	}
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(storageSchema, "root")
+	if err != nil {
+		return err
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return errors.New("BigQuery schema did not convert to a message descriptor")
+	}
+	descriptorProto, err := adapt.NormalizeDescriptor(messageDescriptor)
+	if err != nil {
+		return err
+	}
+
+	client, err := managedwriter.NewClient(context.WithoutCancel(ctx), projectID)
+	if err != nil {
+		return err
+	}
+	stream, err := client.NewManagedStream(context.WithoutCancel(ctx),
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(projectID, dataset, table)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(descriptorProto))
+	if err != nil {
+		client.Close()
+		return err
+	}
+	s.client, s.stream, s.descriptor = client, stream, messageDescriptor
+	return nil
+}
+
+// ensureBigQueryTable creates the transfers table unless it already exists. An existing table
+// is left as is, so its partitioning and clustering can be tuned after creation.
+func ensureBigQueryTable(ctx context.Context, projectID, dataset, table string) error {
+	client, err := bigquery.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ref := client.Dataset(dataset).Table(table)
+	_, err = ref.Metadata(ctx)
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+	err = ref.Create(ctx, &bigquery.TableMetadata{
+		Schema:           bigQuerySchema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "block_timestamp"},
+		Clustering:       &bigquery.Clustering{Fields: []string{"contract"}},
+	})
+	// Another instance may have created the table in the meantime.
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// Write appends a row per transfer and waits for BigQuery to acknowledge them. The default
+// stream is at-least-once, so a retried webhook appends its rows again; deduplicate on
+// document_id when querying.
+func (s *bigQuerySink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	transfers := append(append([]*TransferDocument{}, parsed.Transfers...), parsed.Reverted...)
+	insertedAt := time.Now()
+	for start := 0; start < len(transfers); start += bigQueryAppendLimit {
+		end := min(start+bigQueryAppendLimit, len(transfers))
+		rows := make([][]byte, 0, end-start)
+		for _, doc := range transfers[start:end] {
+			row, err := s.row(doc, insertedAt)
+			if err != nil {
+				return fmt.Errorf("encode transfer %s: %w", doc.DocumentID(), err)
+			}
+			rows = append(rows, row)
+		}
+		result, err := s.stream.AppendRows(ctx, rows)
+		if err != nil {
+			return err
+		}
+		if _, err := result.GetResult(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// row encodes doc as a serialized row of bigQuerySchema.
+func (s *bigQuerySink) row(doc *TransferDocument, insertedAt time.Time) ([]byte, error) {
+	document, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(s.descriptor)
+	fields := s.descriptor.Fields()
+	set := func(name string, value any) {
+		message.Set(fields.ByName(protoreflect.Name(name)), protoreflect.ValueOf(value))
+	}
+	setOptional := func(name, value string) {
+		if value != "" {
+			set(name, value)
+		}
+	}
+
+	set("document_id", doc.DocumentID())
+	set("schema_version", int64(doc.SchemaVersion))
+	set("block_hash", doc.Block.Hash)
+	set("block_number", doc.Block.Number)
+	set("block_timestamp", doc.blockTime().UnixMicro())
+	set("transaction_hash", doc.Transaction.Hash)
+	set("contract", doc.Transfer.Contract)
+	set("standard", doc.Transfer.Standard)
+	setOptional("operator", doc.Transfer.Operator)
+	set("from_address", doc.Transfer.From)
+	set("to_address", doc.Transfer.To)
+	setOptional("value", bigIntString(doc.Transfer.Value))
+	setOptional("token_id", bigIntString(doc.Transfer.TokenID))
+	set("log_index", int64(doc.Transfer.LogIndex))
+	if doc.Transfer.BatchIndex != nil {
+		set("batch_index", int64(*doc.Transfer.BatchIndex))
+	}
+	if doc.Transfer.TraceIndex != nil {
+		set("trace_index", int64(*doc.Transfer.TraceIndex))
+	}
+	set("network", doc.Network)
+	set("webhook_id", doc.Alchemy.WebhookID)
+	set("event_id", doc.Alchemy.EventID)
+	set("sequence_number", doc.Alchemy.SequenceNumber)
+	set("reverted", doc.Reverted)
+	setOptional("finality", doc.Finality)
+	set("inserted_at", insertedAt.UnixMicro())
+	set("document", string(document))
+	return proto.Marshal(message)
+}
+
+// Close closes the stream and the Storage Write API client.
+func (s *bigQuerySink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := errors.Join(s.stream.Close(), s.client.Close())
+	s.client, s.stream = nil, nil
+	return err
+}
@@ -0,0 +1,154 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSinkBreakerCooldown = 30 * time.Second
+
+// Circuit states of a sink breaker.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// errCircuitOpen is the error dead letters of a sink with an open circuit record.
+var errCircuitOpen = errors.New("circuit open")
+
+// sinkBreaker is the circuit breaker of a sink on this instance. It opens after Failures webhooks
+// in a row failed the sink, after its retries, so later webhooks skip it for Cooldown instead of
+// waiting on an outage; their documents are stored as dead letters. Once the cooldown has
+// passed, one webhook is let through as a probe: its success closes the circuit and its failure
+// opens it for another cooldown.
+type sinkBreaker struct {
+	sink     string
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	state       string
+	consecutive int
+	openedAt    time.Time
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*sinkBreaker{}
+)
+
+// getSinkBreaker returns the breaker of sink configured by SINK_<NAME>_BREAKER_FAILURES (default
+// 0, no breaker) and SINK_<NAME>_BREAKER_COOLDOWN (30s), created once per instance so its state
+// outlives requests, or nil when the sink has none.
+func getSinkBreaker(sink string) (*sinkBreaker, error) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	if breaker, ok := breakers[sink]; ok {
+		return breaker, nil
+	}
+
+	prefix := "SINK_" + strings.ToUpper(strings.ReplaceAll(sink, "-", "_")) + "_"
+	failures := 0
+	if v := os.Getenv(prefix + "BREAKER_FAILURES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %sBREAKER_FAILURES %q", prefix, v)
+		}
+		failures = n
+	}
+	cooldown := defaultSinkBreakerCooldown
+	if v := os.Getenv(prefix + "BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %sBREAKER_COOLDOWN %q", prefix, v)
+		}
+		cooldown = d
+	}
+	var breaker *sinkBreaker
+	if failures > 0 {
+		breaker = &sinkBreaker{sink: sink, failures: failures, cooldown: cooldown, state: circuitClosed}
+	}
+	breakers[sink] = breaker
+	return breaker, nil
+}
+
+// allow reports whether a webhook may be written to the sink: always while the circuit is closed,
+// and only as the single probe once the cooldown of an open circuit has passed.
+func (b *sinkBreaker) allow(ctx context.Context) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.mu.Unlock()
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.mu.Unlock()
+		log.Printf(`{"level":"info","message":"sink circuit half-open","sink":"%s"}`, b.sink)
+		emitLifecycleEvent(ctx, LifecycleCircuitHalfOpen, map[string]any{"sink": b.sink})
+		return true
+	case circuitHalfOpen:
+		b.mu.Unlock()
+		return false
+	}
+	b.mu.Unlock()
+	return true
+}
+
+// circuit returns the state of the circuit, closed when the sink has no breaker.
+func (b *sinkBreaker) circuit() string {
+	if b == nil {
+		return circuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// record records the outcome of a webhook written to the sink, logging and emitting a lifecycle
+// event when it opens or closes the circuit.
+func (b *sinkBreaker) record(ctx context.Context, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if err == nil {
+		b.consecutive = 0
+		closed := b.state == circuitHalfOpen
+		if closed {
+			b.state = circuitClosed
+		}
+		b.mu.Unlock()
+		if closed {
+			log.Printf(`{"level":"info","message":"sink circuit closed","sink":"%s"}`, b.sink)
+			emitLifecycleEvent(ctx, LifecycleCircuitClosed, map[string]any{"sink": b.sink})
+		}
+		return
+	}
+	b.consecutive++
+	opened := b.state == circuitHalfOpen || (b.state == circuitClosed && b.consecutive >= b.failures)
+	if opened {
+		b.state, b.openedAt = circuitOpen, time.Now()
+	}
+	consecutive := b.consecutive
+	b.mu.Unlock()
+	if opened {
+		log.Printf(`{"level":"warn","message":"sink circuit opened","metric":"sink_circuit_open","sink":"%s","failures":%d,"cooldown":"%s","error":"%s"}`,
+			b.sink, consecutive, b.cooldown, err.Error())
+		countMetric("alchemy_sink_circuit_open_total", 1, "sink", b.sink)
+		emitLifecycleEvent(ctx, LifecycleCircuitOpened, map[string]any{
+			"sink": b.sink, "failures": consecutive, "cooldown": b.cooldown.String(), "error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSinkBreaker(t *testing.T) {
+	ctx := context.Background()
+	errWrite := errors.New("write failed")
+
+	// Each step either asks the breaker for a webhook, checking allow, or records its outcome,
+	// then checks the state of the circuit.
+	type step struct {
+		wait   bool // let the cooldown pass first
+		record bool
+		err    error
+		allow  bool
+		state  string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"stays closed below the threshold", []step{
+			{record: true, err: errWrite, state: circuitClosed},
+			{record: true, state: circuitClosed},
+			{record: true, err: errWrite, state: circuitClosed},
+			{allow: true, state: circuitClosed},
+		}},
+		{"opens, probes and closes", []step{
+			{record: true, err: errWrite, state: circuitClosed},
+			{record: true, err: errWrite, state: circuitOpen},
+			{allow: false, state: circuitOpen},
+			{wait: true, allow: true, state: circuitHalfOpen},
+			{allow: false, state: circuitHalfOpen},
+			{record: true, state: circuitClosed},
+			{allow: true, state: circuitClosed},
+		}},
+		{"a failed probe opens it again", []step{
+			{record: true, err: errWrite, state: circuitClosed},
+			{record: true, err: errWrite, state: circuitOpen},
+			{wait: true, allow: true, state: circuitHalfOpen},
+			{record: true, err: errWrite, state: circuitOpen},
+			{allow: false, state: circuitOpen},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &sinkBreaker{sink: "test", failures: 2, cooldown: time.Hour, state: circuitClosed}
+			for i, s := range tt.steps {
+				if s.wait {
+					b.openedAt = b.openedAt.Add(-b.cooldown)
+				}
+				if s.record {
+					b.record(ctx, s.err)
+				} else if got := b.allow(ctx); got != s.allow {
+					t.Errorf("step %d: allow = %v, want %v", i, got, s.allow)
+				}
+				if got := b.circuit(); got != s.state {
+					t.Fatalf("step %d: state = %s, want %s", i, got, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSinkBreaker(t *testing.T) {
+	tests := []struct {
+		sink     string
+		failures string
+		cooldown string
+		breaker  bool
+		wantErr  bool
+	}{
+		{"breaker-off", "", "", false, false},
+		{"breaker-on", "3", "1m", true, false},
+		{"breaker-bad-failures", "-1", "", false, true},
+		{"breaker-bad-cooldown", "3", "soon", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sink, func(t *testing.T) {
+			prefix := "SINK_" + strings.ToUpper(strings.ReplaceAll(tt.sink, "-", "_")) + "_"
+			t.Setenv(prefix+"BREAKER_FAILURES", tt.failures)
+			t.Setenv(prefix+"BREAKER_COOLDOWN", tt.cooldown)
+			breaker, err := getSinkBreaker(tt.sink)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (breaker != nil) != tt.breaker {
+				t.Errorf("breaker = %v, want one: %v", breaker, tt.breaker)
+			}
+		})
+	}
+}
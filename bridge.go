@@ -0,0 +1,367 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const bridgeLegsCollectionName = "alchemy_bridge_legs"
+
+const defaultBridgeWindow = time.Hour
+
+// Sides of a bridge transfer.
+const (
+	BridgeWithdrawal = "withdrawal"
+	BridgeDeposit    = "deposit"
+)
+
+// BridgeEndpoint identifies one side of a bridge. Transfers to Address on the source network are
+// withdrawals and transfers from Address on the destination network are deposits. When Event is
+// set, decoded events of that name emitted by Address also become legs, matched exactly on the
+// value of their MessageIDField.
+type BridgeEndpoint struct {
+	Network        string `json:"network"`
+	Address        string `json:"address"`
+	Event          string `json:"event,omitempty"`
+	MessageIDField string `json:"messageIdField,omitempty"`
+}
+
+// BridgeConfig describes a bridge whose withdrawals on Source are stitched to deposits on
+// Destination. Transfer legs without a message ID match on the same account, a deposit within
+// Window after the withdrawal, and an amount within Tolerance (a fraction, e.g. 0.001 for bridge
+// fees) of the withdrawn amount.
+type BridgeConfig struct {
+	Name        string         `json:"name"`
+	Source      BridgeEndpoint `json:"source"`
+	Destination BridgeEndpoint `json:"destination"`
+	Window      string         `json:"window,omitempty"`
+	Tolerance   float64        `json:"tolerance,omitempty"`
+
+	window time.Duration
+}
+
+// BridgeLink links a stitched document to its counterpart on the other chain.
+type BridgeLink struct {
+	Bridge     string `json:"bridge"`
+	Side       string `json:"side"`
+	Network    string `json:"network"`
+	Collection string `json:"collection"`
+	DocumentID string `json:"documentId"`
+}
+
+// BridgeLeg records one side of a bridge transfer in the bridge legs collection until it is
+// matched with the other side.
+type BridgeLeg struct {
+	Bridge      string    `json:"bridge"`
+	Side        string    `json:"side"`
+	Network     string    `json:"network"`
+	Collection  string    `json:"collection"`
+	DocumentID  string    `json:"documentId"`
+	Account     string    `json:"account,omitempty"`
+	Amount      string    `json:"amount,omitempty"`
+	MessageID   string    `json:"messageId,omitempty"`
+	Time        time.Time `json:"time"`
+	Matched     bool      `json:"matched"`
+	Counterpart string    `json:"counterpart,omitempty"`
+}
+
+// ID returns the document ID of the leg in the bridge legs collection.
+func (l *BridgeLeg) ID() string {
+	return fmt.Sprintf("%s-%s-%s", l.Bridge, l.Side, l.DocumentID)
+}
+
+// link returns the BridgeLink pointing at l.
+func (l *BridgeLeg) link() BridgeLink {
+	return BridgeLink{Bridge: l.Bridge, Side: l.Side, Network: l.Network, Collection: l.Collection, DocumentID: l.DocumentID}
+}
+
+var (
+	bridgesOnce sync.Once
+	bridges     []*BridgeConfig
+	bridgesErr  error
+)
+
+// LoadBridges returns the bridges configured as a JSON array in BRIDGES or the file at
+// BRIDGES_FILE, parsed once per instance. It returns nil when neither is set.
+func LoadBridges() ([]*BridgeConfig, error) {
+	bridgesOnce.Do(func() {
+		bridges, bridgesErr = loadBridges()
+	})
+	return bridges, bridgesErr
+}
+
+func loadBridges() ([]*BridgeConfig, error) {
+	data := []byte(os.Getenv("BRIDGES"))
+	if path := os.Getenv("BRIDGES_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read BRIDGES_FILE: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var configs []*BridgeConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse bridge config: %w", err)
+	}
+	for _, config := range configs {
+		if config.Name == "" || config.Source.Network == "" || config.Source.Address == "" ||
+			config.Destination.Network == "" || config.Destination.Address == "" {
+			return nil, fmt.Errorf("bridge %q needs a name and a source and destination network and address", config.Name)
+		}
+		if (config.Source.Event == "") != (config.Destination.Event == "") ||
+			(config.Source.Event != "" && (config.Source.MessageIDField == "" || config.Destination.MessageIDField == "")) {
+			return nil, fmt.Errorf("bridge %q needs a message ID event and field on both sides or neither", config.Name)
+		}
+		if config.Tolerance < 0 || config.Tolerance >= 1 {
+			return nil, fmt.Errorf("bridge %q has invalid tolerance %v", config.Name, config.Tolerance)
+		}
+		config.window = defaultBridgeWindow
+		if config.Window != "" {
+			window, err := time.ParseDuration(config.Window)
+			if err != nil || window <= 0 {
+				return nil, fmt.Errorf("bridge %q has invalid window %q", config.Name, config.Window)
+			}
+			config.window = window
+		}
+	}
+	return configs, nil
+}
+
+// bridgeLegs returns the legs of bridge found among the parsed documents. Reverted transfers
+// moved nothing and are ignored.
+func bridgeLegs(bridge *BridgeConfig, parsed *ParsedWebhook) []*BridgeLeg {
+	var legs []*BridgeLeg
+	for _, doc := range parsed.Transfers {
+		if doc.Transaction.failed() || doc.Transfer.Value == nil {
+			continue
+		}
+		leg := &BridgeLeg{
+			Bridge:     bridge.Name,
+			Network:    doc.Network,
+			Collection: collectionName,
+			DocumentID: doc.DocumentID(),
+			Amount:     doc.Transfer.Value.String(),
+			Time:       documentTime(doc.Block, doc.Alchemy),
+		}
+		switch {
+		case doc.Network == bridge.Source.Network && strings.EqualFold(doc.Transfer.To, bridge.Source.Address):
+			leg.Side, leg.Account = BridgeWithdrawal, strings.ToLower(doc.Transfer.From)
+		case doc.Network == bridge.Destination.Network && strings.EqualFold(doc.Transfer.From, bridge.Destination.Address):
+			leg.Side, leg.Account = BridgeDeposit, strings.ToLower(doc.Transfer.To)
+		default:
+			continue
+		}
+		legs = append(legs, leg)
+	}
+	if bridge.Source.Event == "" {
+		return legs
+	}
+	for _, doc := range parsed.Events {
+		leg := &BridgeLeg{
+			Bridge:     bridge.Name,
+			Network:    doc.Network,
+			Collection: eventsCollectionName,
+			DocumentID: doc.DocumentID(),
+			Time:       documentTime(doc.Block, doc.Alchemy),
+		}
+		var endpoint BridgeEndpoint
+		switch {
+		case bridgeEvent(bridge.Source, doc):
+			leg.Side, endpoint = BridgeWithdrawal, bridge.Source
+		case bridgeEvent(bridge.Destination, doc):
+			leg.Side, endpoint = BridgeDeposit, bridge.Destination
+		default:
+			continue
+		}
+		messageID, ok := doc.Event.Fields[endpoint.MessageIDField]
+		if !ok {
+			continue
+		}
+		leg.MessageID = strings.ToLower(fmt.Sprint(messageID))
+		legs = append(legs, leg)
+	}
+	return legs
+}
+
+// bridgeEvent reports whether doc is the message ID event of endpoint.
+func bridgeEvent(endpoint BridgeEndpoint, doc *EventDocument) bool {
+	return doc.Network == endpoint.Network && doc.Event.Name == endpoint.Event &&
+		strings.EqualFold(doc.Event.Contract, endpoint.Address)
+}
+
+// documentTime returns the block time of a document, falling back to the webhook creation time
+// for payloads without block timestamps.
+func documentTime(block Block, alchemy AlchemyMetadata) time.Time {
+	if block.Timestamp > 0 {
+		return time.Unix(block.Timestamp, 0).UTC()
+	}
+	if createdAt, err := time.Parse(time.RFC3339, alchemy.CreatedAt); err == nil && !createdAt.IsZero() {
+		return createdAt.UTC()
+	}
+	return time.Now().UTC()
+}
+
+// StitchBridges records the bridge legs among parsed and links each to the unmatched leg on the
+// other chain that it matches, setting Bridge on both documents. Stitching is best effort:
+// failures are logged per leg and never fail the write.
+func (f *FirestoreWriter) StitchBridges(ctx context.Context, bridges []*BridgeConfig, parsed *ParsedWebhook) {
+	for _, bridge := range bridges {
+		for _, leg := range bridgeLegs(bridge, parsed) {
+			counterpart, err := f.matchBridgeLeg(ctx, bridge, leg)
+			if err != nil {
+				log.Printf(`{"level":"warn","message":"failed to stitch bridge leg","bridge":"%s","leg":"%s","error":"%s"}`,
+					bridge.Name, leg.ID(), err.Error())
+				continue
+			}
+			if counterpart == nil {
+				continue
+			}
+			if err := f.linkBridgeLegs(ctx, leg, counterpart); err != nil {
+				log.Printf(`{"level":"warn","message":"failed to link bridge documents","bridge":"%s","leg":"%s","error":"%s"}`,
+					bridge.Name, leg.ID(), err.Error())
+				continue
+			}
+			log.Printf(`{"level":"info","message":"stitched bridge transfer","bridge":"%s","withdrawal":"%s","deposit":"%s"}`,
+				bridge.Name, sideLeg(BridgeWithdrawal, leg, counterpart).DocumentID, sideLeg(BridgeDeposit, leg, counterpart).DocumentID)
+		}
+	}
+}
+
+// sideLeg returns whichever of a and b is on side.
+func sideLeg(side string, a, b *BridgeLeg) *BridgeLeg {
+	if a.Side == side {
+		return a
+	}
+	return b
+}
+
+// matchBridgeLeg stores leg and, in the same transaction, pairs it with the best unmatched leg on
+// the other side: the one with the same message ID, or else the closest in time among the legs of
+// the same account within the window and amount tolerance. It returns the counterpart, or nil
+// when leg stays unmatched. A leg already matched by an earlier delivery is left unchanged.
+func (f *FirestoreWriter) matchBridgeLeg(ctx context.Context, bridge *BridgeConfig, leg *BridgeLeg) (*BridgeLeg, error) {
+	legs := f.client.Collection(bridgeLegsCollectionName)
+	opposite := BridgeDeposit
+	if leg.Side == BridgeDeposit {
+		opposite = BridgeWithdrawal
+	}
+	query := legs.Where("Bridge", "==", bridge.Name).Where("Side", "==", opposite).Where("Matched", "==", false)
+	if leg.MessageID != "" {
+		query = query.Where("MessageID", "==", leg.MessageID)
+	} else {
+		query = query.Where("Account", "==", leg.Account).Where("MessageID", "==", "")
+	}
+
+	var counterpart *BridgeLeg
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		counterpart = nil
+		ref := legs.Doc(leg.ID())
+		snapshot, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if snapshot.Exists() {
+			var existing BridgeLeg
+			if err := snapshot.DataTo(&existing); err != nil {
+				return err
+			}
+			if existing.Matched {
+				return nil
+			}
+		}
+
+		var best *BridgeLeg
+		var bestRef *firestore.DocumentRef
+		iter := tx.Documents(query)
+		defer iter.Stop()
+		for {
+			candidate, err := iter.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			var other BridgeLeg
+			if err := candidate.DataTo(&other); err != nil {
+				return err
+			}
+			if leg.MessageID == "" && !bridgeLegsMatch(bridge, leg, &other) {
+				continue
+			}
+			if best == nil || absDuration(other.Time.Sub(leg.Time)) < absDuration(best.Time.Sub(leg.Time)) {
+				best, bestRef = &other, candidate.Ref
+			}
+		}
+
+		stored := *leg
+		if best != nil {
+			stored.Matched, stored.Counterpart = true, best.ID()
+			if err := tx.Update(bestRef, []firestore.Update{{Path: "Matched", Value: true}, {Path: "Counterpart", Value: leg.ID()}}); err != nil {
+				return err
+			}
+		}
+		counterpart = best
+		return tx.Set(ref, stored)
+	})
+	return counterpart, err
+}
+
+// bridgeLegsMatch reports whether transfer legs a and b of the same account are close enough in
+// time and amount to be the two sides of one bridge transfer.
+func bridgeLegsMatch(bridge *BridgeConfig, a, b *BridgeLeg) bool {
+	withdrawal, deposit := sideLeg(BridgeWithdrawal, a, b), sideLeg(BridgeDeposit, a, b)
+	if delay := deposit.Time.Sub(withdrawal.Time); delay < 0 || delay > bridge.window {
+		return false
+	}
+	withdrawn, ok := new(big.Int).SetString(withdrawal.Amount, 10)
+	if !ok {
+		return false
+	}
+	deposited, ok := new(big.Int).SetString(deposit.Amount, 10)
+	if !ok {
+		return false
+	}
+	// Bridge fees only ever reduce the deposit.
+	if deposited.Cmp(withdrawn) > 0 {
+		return false
+	}
+	fee := new(big.Float).SetInt(new(big.Int).Sub(withdrawn, deposited))
+	limit := new(big.Float).Mul(new(big.Float).SetInt(withdrawn), big.NewFloat(bridge.Tolerance))
+	return fee.Cmp(limit) <= 0
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// linkBridgeLegs sets Bridge on the documents of both legs to point at each other.
+func (f *FirestoreWriter) linkBridgeLegs(ctx context.Context, a, b *BridgeLeg) error {
+	for _, pair := range [][2]*BridgeLeg{{a, b}, {b, a}} {
+		doc := f.client.Collection(pair[0].Collection).Doc(pair[0].DocumentID)
+		if _, err := doc.Set(ctx, map[string]any{"Bridge": pair[1].link()}, firestore.MergeAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClickHouseDatabase = "default"
+	defaultClickHouseTable    = "transfers"
+	// defaultClickHouseFlushSize keeps inserts large, as ClickHouse prefers, while bounding the
+	// request size of large blocks.
+	defaultClickHouseFlushSize = 10000
+)
+
+//go:embed migrations/clickhouse/*.sql
+var clickHouseMigrations embed.FS
+
+// clickHouseRow is a row of the transfers table as inserted in JSONEachRow format. Integers too
+// large for JSON numbers are quoted, which ClickHouse parses into the UInt256 columns.
+type clickHouseRow struct {
+	DocumentID       string   `json:"document_id"`
+	SchemaVersion    int      `json:"schema_version"`
+	Network          string   `json:"network"`
+	BlockNumber      int64    `json:"block_number"`
+	BlockHash        string   `json:"block_hash"`
+	BlockTimestamp   int64    `json:"block_timestamp"`
+	TransactionHash  string   `json:"transaction_hash"`
+	LogIndex         int      `json:"log_index"`
+	BatchIndex       *int     `json:"batch_index"`
+	TraceIndex       *int     `json:"trace_index"`
+	Contract         string   `json:"contract"`
+	Standard         string   `json:"standard"`
+	Operator         string   `json:"operator"`
+	FromAddress      string   `json:"from_address"`
+	ToAddress        string   `json:"to_address"`
+	Value            *string  `json:"value"`
+	TokenID          *string  `json:"token_id"`
+	AmountBucket     string   `json:"amount_bucket"`
+	AmountPercentile *float64 `json:"amount_percentile"`
+	Reverted         bool     `json:"reverted"`
+	Finality         string   `json:"finality"`
+	WebhookID        string   `json:"webhook_id"`
+	EventID          string   `json:"event_id"`
+	SequenceNumber   string   `json:"sequence_number"`
+	Document         string   `json:"document"`
+	Removed          uint8    `json:"removed"`
+	Version          uint64   `json:"version"`
+}
+
+// clickHouseSink inserts transfers, reverted ones included, into CLICKHOUSE_DATABASE.CLICKHOUSE_TABLE
+// through the HTTP interface at CLICKHOUSE_URL, in inserts of up to CLICKHOUSE_FLUSH_SIZE rows.
+// The table is a ReplacingMergeTree keyed by document ID, so redelivered transfers collapse into
+// one row, and transfers removed by a reorg are replaced by a row marked removed, which
+// ClickHouse drops when merging. With CLICKHOUSE_FLUSH_INTERVAL, inserts are asynchronous:
+// ClickHouse buffers the small inserts of many webhooks and flushes them together every
+// interval, and each insert waits for its flush before the webhook is acknowledged.
+type clickHouseSink struct {
+	url       string
+	user      string
+	password  string
+	database  string
+	table     string
+	flushSize int
+	settings  url.Values
+}
+
+func (*clickHouseSink) Name() string { return sinkClickHouse }
+
+// Init reads the connection configuration and migrates the table's schema, unless
+// CLICKHOUSE_MIGRATE (or, formerly, CLICKHOUSE_CREATE_TABLE) is false, in which case pending
+// migrations are only logged.
+func (s *clickHouseSink) Init(ctx context.Context) error {
+	if err := s.configure(); err != nil {
+		return err
+	}
+	if os.Getenv("CLICKHOUSE_MIGRATE") == "false" || os.Getenv("CLICKHOUSE_CREATE_TABLE") == "false" {
+		pending, err := s.pendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		warnPendingMigrations(sinkClickHouse, pending)
+		return nil
+	}
+	_, err := s.migrate(ctx)
+	return err
+}
+
+// configure reads the connection configuration.
+func (s *clickHouseSink) configure() error {
+	s.url = os.Getenv("CLICKHOUSE_URL")
+	if s.url == "" {
+		return errors.New("CLICKHOUSE_URL must be set")
+	}
+	s.user = os.Getenv("CLICKHOUSE_USER")
+	s.password = os.Getenv("CLICKHOUSE_PASSWORD")
+	s.database, s.table = clickHouseTable()
+	s.flushSize = max(envInt("CLICKHOUSE_FLUSH_SIZE", defaultClickHouseFlushSize), 1)
+	s.settings = url.Values{}
+	if interval := envDuration("CLICKHOUSE_FLUSH_INTERVAL", 0); interval > 0 {
+		s.settings.Set("async_insert", "1")
+		s.settings.Set("wait_for_async_insert", "1")
+		s.settings.Set("async_insert_busy_timeout_ms", strconv.FormatInt(interval.Milliseconds(), 10))
+	}
+	return nil
+}
+
+// clickHouseTable returns the database and table named by CLICKHOUSE_DATABASE and CLICKHOUSE_TABLE.
+func clickHouseTable() (database, table string) {
+	database = os.Getenv("CLICKHOUSE_DATABASE")
+	if database == "" {
+		database = defaultClickHouseDatabase
+	}
+	table = os.Getenv("CLICKHOUSE_TABLE")
+	if table == "" {
+		table = defaultClickHouseTable
+	}
+	return database, table
+}
+
+// ClickHouseDDL returns the statements of the ClickHouse sink's embedded migrations, in order,
+// for the table named by CLICKHOUSE_DATABASE and CLICKHOUSE_TABLE. Together they create the
+// table, or bring one created by an older release up to date.
+func ClickHouseDDL() (string, error) {
+	migrations, err := clickHouseTableMigrations()
+	if err != nil {
+		return "", err
+	}
+	var statements []string
+	for _, m := range migrations {
+		statements = append(statements, m.statements()...)
+	}
+	return strings.Join(statements, ";\n\n"), nil
+}
+
+// clickHouseTableMigrations returns the embedded migrations with their placeholders replaced by
+// the quoted names of CLICKHOUSE_DATABASE and CLICKHOUSE_TABLE.
+func clickHouseTableMigrations() ([]migration, error) {
+	migrations, err := embeddedMigrations(clickHouseMigrations, "migrations/clickhouse")
+	if err != nil {
+		return nil, err
+	}
+	database, table := clickHouseTable()
+	names := strings.NewReplacer("{database}", clickHouseIdentifier(database), "{table}", clickHouseIdentifier(table))
+	for i := range migrations {
+		migrations[i].script = names.Replace(migrations[i].script)
+	}
+	return migrations, nil
+}
+
+// clickHouseMigrationsTable is the table recording the migrations applied to each table of its
+// database. ClickHouse has no transactions or locks, so instances starting together may apply a
+// migration twice: every statement must be idempotent, as with IF NOT EXISTS.
+const clickHouseMigrationsTable = `CREATE TABLE IF NOT EXISTS {database}.schema_migrations
+(
+    target String,
+    version String,
+    applied_at DateTime('UTC') DEFAULT now()
+)
+ENGINE = ReplacingMergeTree
+ORDER BY (target, version)`
+
+// migrate applies the embedded migrations not yet recorded for the table in schema_migrations,
+// in version order, and returns the versions it applied.
+func (s *clickHouseSink) migrate(ctx context.Context) ([]string, error) {
+	migrations, err := clickHouseTableMigrations()
+	if err != nil {
+		return nil, err
+	}
+	tracking := strings.ReplaceAll(clickHouseMigrationsTable, "{database}", clickHouseIdentifier(s.database))
+	if err := s.exec(ctx, tracking, nil); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		for _, statement := range m.statements() {
+			if err := s.exec(ctx, statement, nil); err != nil {
+				return versions, fmt.Errorf("%s migration %s: %w", sinkClickHouse, m.version, err)
+			}
+		}
+		record, err := json.Marshal(map[string]string{"target": s.table, "version": m.version})
+		if err != nil {
+			return versions, err
+		}
+		query := "INSERT INTO " + clickHouseIdentifier(s.database) + ".schema_migrations (target, version) FORMAT JSONEachRow"
+		if err := s.exec(ctx, query, record); err != nil {
+			return versions, err
+		}
+		versions = append(versions, m.version)
+	}
+	return versions, nil
+}
+
+// pendingMigrations returns the versions of the embedded migrations not recorded for the table,
+// all of them when schema_migrations does not exist yet.
+func (s *clickHouseSink) pendingMigrations(ctx context.Context) ([]string, error) {
+	migrations, err := clickHouseTableMigrations()
+	if err != nil {
+		return nil, err
+	}
+	exists, err := s.query(ctx, "EXISTS TABLE "+clickHouseIdentifier(s.database)+".schema_migrations", nil)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[string]bool{}
+	if strings.TrimSpace(string(exists)) == "1" {
+		if applied, err = s.appliedMigrations(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return pendingVersions(migrations, applied), nil
+}
+
+// appliedMigrations returns the versions recorded for the table in schema_migrations.
+func (s *clickHouseSink) appliedMigrations(ctx context.Context) (map[string]bool, error) {
+	query := "SELECT version FROM " + clickHouseIdentifier(s.database) + ".schema_migrations WHERE target = {target:String} FORMAT TabSeparated"
+	data, err := s.query(ctx, query, url.Values{"param_target": {s.table}})
+	if err != nil {
+		return nil, err
+	}
+	applied := map[string]bool{}
+	for _, version := range strings.Fields(string(data)) {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// clickHouseIdentifier quotes name as a ClickHouse identifier.
+func clickHouseIdentifier(name string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(name) + "`"
+}
+
+// Write inserts the webhook's transfers and the removed rows of its transfer tombstones. The
+// removed rows take a lower version than the transfers, so a transfer re-included after a reorg
+// ends up not removed.
+func (s *clickHouseSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	version := uint64(time.Now().UnixNano())
+	var rows []*clickHouseRow
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.Kind != KindTransfer || tombstone.transfer == nil {
+			continue
+		}
+		row, err := newClickHouseRow(tombstone.transfer, version)
+		if err != nil {
+			return err
+		}
+		row.Removed = 1
+		rows = append(rows, row)
+	}
+	for _, doc := range append(append([]*TransferDocument{}, parsed.Transfers...), parsed.Reverted...) {
+		row, err := newClickHouseRow(doc, version+1)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+
+	query := "INSERT INTO " + clickHouseIdentifier(s.database) + "." + clickHouseIdentifier(s.table) + " FORMAT JSONEachRow"
+	for start := 0; start < len(rows); start += s.flushSize {
+		var body bytes.Buffer
+		encoder := json.NewEncoder(&body)
+		for _, row := range rows[start:min(start+s.flushSize, len(rows))] {
+			if err := encoder.Encode(row); err != nil {
+				return fmt.Errorf("failed to encode transfer %s: %w", row.DocumentID, err)
+			}
+		}
+		if err := s.exec(ctx, query, body.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newClickHouseRow returns the row of doc at version.
+func newClickHouseRow(doc *TransferDocument, version uint64) (*clickHouseRow, error) {
+	document, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transfer %s: %w", doc.DocumentID(), err)
+	}
+	row := &clickHouseRow{
+		DocumentID:       doc.DocumentID(),
+		SchemaVersion:    doc.SchemaVersion,
+		Network:          doc.Network,
+		BlockNumber:      doc.Block.Number,
+		BlockHash:        doc.Block.Hash,
+		BlockTimestamp:   doc.blockTime().Unix(),
+		TransactionHash:  doc.Transaction.Hash,
+		LogIndex:         doc.Transfer.LogIndex,
+		BatchIndex:       doc.Transfer.BatchIndex,
+		TraceIndex:       doc.Transfer.TraceIndex,
+		Contract:         doc.Transfer.Contract,
+		Standard:         doc.Transfer.Standard,
+		Operator:         doc.Transfer.Operator,
+		FromAddress:      doc.Transfer.From,
+		ToAddress:        doc.Transfer.To,
+		AmountBucket:     doc.AmountBucket,
+		AmountPercentile: doc.AmountPercentile,
+		Reverted:         doc.Reverted,
+		Finality:         doc.Finality,
+		WebhookID:        doc.Alchemy.WebhookID,
+		EventID:          doc.Alchemy.EventID,
+		SequenceNumber:   doc.Alchemy.SequenceNumber,
+		Document:         string(document),
+		Version:          version,
+	}
+	if doc.Transfer.Value != nil {
+		value := doc.Transfer.Value.String()
+		row.Value = &value
+	}
+	if doc.Transfer.TokenID != nil {
+		tokenID := doc.Transfer.TokenID.String()
+		row.TokenID = &tokenID
+	}
+	return row, nil
+}
+
+// exec sends query to the HTTP interface, with data as the insert's rows when set. Requests go
+// through the "clickhouse" ProviderClient, which retries transport errors, 429 and 5xx responses;
+// retried inserts collapse into the same rows.
+func (s *clickHouseSink) exec(ctx context.Context, query string, data []byte) error {
+	_, err := s.do(ctx, query, data, nil)
+	return err
+}
+
+// query sends a read-only query to the HTTP interface, with params as its query parameters,
+// and returns the result.
+func (s *clickHouseSink) query(ctx context.Context, query string, params url.Values) ([]byte, error) {
+	return s.do(ctx, query, nil, params)
+}
+
+func (s *clickHouseSink) do(ctx context.Context, query string, data []byte, extra url.Values) ([]byte, error) {
+	endpoint, err := url.Parse(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLICKHOUSE_URL: %w", err)
+	}
+	params := endpoint.Query()
+	for name, values := range s.settings {
+		params[name] = values
+	}
+	for name, values := range extra {
+		params[name] = values
+	}
+	var body []byte
+	if data == nil {
+		body = []byte(query)
+	} else {
+		params.Set("query", query)
+		body = data
+	}
+	endpoint.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+	resp, err := ProviderFor(sinkClickHouse).Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s responded with status %d: %s", sinkClickHouse, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Close is a no-op, as the sink holds no connections of its own.
+func (*clickHouseSink) Close() error { return nil }
@@ -0,0 +1,137 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/pubsub/v2"
+	"cloud.google.com/go/storage"
+	firebase "firebase.google.com/go"
+	cloudtasks "google.golang.org/api/cloudtasks/v2"
+	"google.golang.org/api/option"
+)
+
+// GCP clients are shared by all requests of an instance, so connections are reused and only
+// the first request, or the warm-up, pays for credential discovery and dialing. A failed
+// creation is not cached and is retried by the next caller.
+var (
+	clientsMu       sync.Mutex
+	sharedFirestore *firestore.Client
+	sharedPubSub    *pubsub.Client
+	sharedKMS       *kms.KeyManagementClient
+	sharedStorage   *storage.Client
+	sharedTasks     *cloudtasks.Service
+)
+
+// firestoreClient returns the instance-wide Firestore client, creating it on first use.
+func firestoreClient(ctx context.Context) (*firestore.Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if sharedFirestore != nil {
+		return sharedFirestore, nil
+	}
+
+	app, err := firebase.NewApp(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := app.Firestore(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+	sharedFirestore = client
+	return client, nil
+}
+
+// pubsubClient returns the instance-wide Pub/Sub client, creating it on first use. It connects
+// to PUBSUB_ENDPOINT, or to the regional endpoint of PUBSUB_REGION, so messages are published
+// and stored in that region; without either, the global endpoint routes them to the nearest one.
+func pubsubClient(ctx context.Context) (*pubsub.Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if sharedPubSub != nil {
+		return sharedPubSub, nil
+	}
+
+	projectID := getProjectID()
+	if projectID == "" {
+		return nil, errors.New("project ID not found (GCP_PROJECT or GOOGLE_CLOUD_PROJECT)")
+	}
+	var opts []option.ClientOption
+	if endpoint := pubsubEndpoint(); endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+	client, err := pubsub.NewClient(context.WithoutCancel(ctx), projectID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sharedPubSub = client
+	return client, nil
+}
+
+// pubsubEndpoint returns PUBSUB_ENDPOINT, the regional endpoint of PUBSUB_REGION, or "" for the
+// global endpoint. The emulator's endpoint, set by PUBSUB_EMULATOR_HOST, takes precedence.
+func pubsubEndpoint() string {
+	if os.Getenv("PUBSUB_EMULATOR_HOST") != "" {
+		return ""
+	}
+	if endpoint := os.Getenv("PUBSUB_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if region := os.Getenv("PUBSUB_REGION"); region != "" {
+		return region + "-pubsub.googleapis.com:443"
+	}
+	return ""
+}
+
+// kmsClient returns the instance-wide Cloud KMS client, creating it on first use.
+func kmsClient(ctx context.Context) (*kms.KeyManagementClient, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if sharedKMS != nil {
+		return sharedKMS, nil
+	}
+
+	client, err := kms.NewKeyManagementClient(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+	sharedKMS = client
+	return client, nil
+}
+
+// storageClient returns the instance-wide Cloud Storage client, creating it on first use.
+func storageClient(ctx context.Context) (*storage.Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if sharedStorage != nil {
+		return sharedStorage, nil
+	}
+
+	client, err := storage.NewClient(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+	sharedStorage = client
+	return client, nil
+}
+
+// cloudTasksService returns the instance-wide Cloud Tasks client, creating it on first use.
+func cloudTasksService(ctx context.Context) (*cloudtasks.Service, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if sharedTasks != nil {
+		return sharedTasks, nil
+	}
+
+	service, err := cloudtasks.NewService(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, err
+	}
+	sharedTasks = service
+	return service, nil
+}
@@ -0,0 +1,27 @@
+// Command backfill-timestamps adds the native Firestore timestamps, Block.Time and
+// Alchemy.CreatedAtTime, to documents written before they existed. It visits the default
+// collections, or the collections given as arguments, and can be run again after an
+// interruption. Run it with the same environment as the function.
+//
+//	go run ./cmd/backfill-timestamps
+//	go run ./cmd/backfill-timestamps treasury_transfers
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	result, err := function.BackfillFirestoreTimestamps(context.Background(), os.Args[1:]...)
+	if err != nil {
+		log.Fatalf("backfill failed: %v", err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
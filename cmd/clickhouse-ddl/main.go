@@ -0,0 +1,22 @@
+// Command clickhouse-ddl prints the statements of the ClickHouse sink's schema migrations for its
+// table, named by CLICKHOUSE_DATABASE and CLICKHOUSE_TABLE, for deployments that manage their
+// schema themselves and run the sink with CLICKHOUSE_MIGRATE=false. Every statement is
+// idempotent, so the output can be applied again after an upgrade.
+//
+//	CLICKHOUSE_DATABASE=analytics go run ./cmd/clickhouse-ddl | clickhouse-client --multiquery
+package main
+
+import (
+	"fmt"
+	"log"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	ddl, err := function.ClickHouseDDL()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(ddl + ";")
+}
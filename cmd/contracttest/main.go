@@ -0,0 +1,243 @@
+// Command contracttest checks the webhook pipeline end to end against Alchemy. It registers a
+// temporary GRAPHQL webhook through the Notify API for transfers of a test token, serves the
+// function behind CONTRACT_TEST_PUBLIC_URL, and waits until a real delivery is verified, parsed
+// and accepted by every enabled sink. The temporary webhook is deleted before exiting.
+//
+// Run it with the function's environment plus ALCHEMY_NOTIFY_TOKEN, CONTRACT_TEST_PUBLIC_URL
+// (a public URL forwarding to CONTRACT_TEST_LISTEN_ADDR) and CONTRACT_TEST_ADDRESS, then send a
+// testnet transfer of the token once the webhook is registered.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	function "webhook.local/function"
+	"webhook.local/function/fakes"
+)
+
+const notifyAPIURL = "https://dashboard.alchemy.com/api"
+
+// transferQuery selects ERC-20 Transfer logs of the test token with the fields the default
+// GraphQL mapping reads.
+const transferQuery = `{
+  block {
+    hash
+    number
+    timestamp
+    logs(filter: {addresses: ["%s"], topics: ["0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"]}) {
+      data
+      topics
+      index
+      account { address }
+      transaction {
+        hash
+        from { address }
+        to { address }
+        type
+        createdContract { address }
+        value
+        gasPrice
+        maxFeePerGas
+        maxPriorityFeePerGas
+        effectiveGasPrice
+        gas
+        status
+        gasUsed
+      }
+    }
+  }
+}`
+
+// Result reports the delivery that passed the contract test.
+type Result struct {
+	WebhookID string `json:"webhookId"`
+	Network   string `json:"network"`
+	Transfers int    `json:"transfers"`
+}
+
+func main() {
+	result, err := run()
+	if err != nil {
+		log.Fatalf("contract test failed: %v", err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run registers the temporary webhook and waits for the first accepted delivery, deleting the
+// webhook again on every path.
+func run() (*Result, error) {
+	token := os.Getenv("ALCHEMY_NOTIFY_TOKEN")
+	publicURL := os.Getenv("CONTRACT_TEST_PUBLIC_URL")
+	address := os.Getenv("CONTRACT_TEST_ADDRESS")
+	if token == "" || publicURL == "" || address == "" {
+		return nil, errors.New("ALCHEMY_NOTIFY_TOKEN, CONTRACT_TEST_PUBLIC_URL and CONTRACT_TEST_ADDRESS must be set")
+	}
+	network := getenv("CONTRACT_TEST_NETWORK", "ETH_SEPOLIA")
+	listenAddr := getenv("CONTRACT_TEST_LISTEN_ADDR", ":8080")
+	timeout, err := time.ParseDuration(getenv("CONTRACT_TEST_TIMEOUT", "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CONTRACT_TEST_TIMEOUT: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	notify := &notifyClient{token: token}
+	webhook, err := notify.createWebhook(ctx, network, publicURL, fmt.Sprintf(transferQuery, address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	defer func() {
+		if err := notify.deleteWebhook(context.Background(), webhook.ID); err != nil {
+			log.Printf("failed to delete webhook %s: %v", webhook.ID, err)
+		}
+	}()
+	if err := os.Setenv("ALCHEMY_SIGNING_KEY", webhook.SigningKey); err != nil {
+		return nil, err
+	}
+
+	sink := fakes.NewSink("contracttest")
+	function.RegisterSink(sink)
+	defer func() {
+		if err := function.CloseSinks(); err != nil {
+			log.Printf("failed to close sinks: %v", err)
+		}
+	}()
+	passed := make(chan int, 1)
+	server := &http.Server{
+		Addr: listenAddr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			function.AlchemyWebhook(recorder, r)
+			if recorder.status != http.StatusOK {
+				log.Printf("delivery rejected with status %d", recorder.status)
+				return
+			}
+			if n := countTransfers(sink.Transfers(), address); n > 0 {
+				select {
+				case passed <- n:
+				default:
+				}
+			}
+		}),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	defer server.Shutdown(context.Background())
+
+	log.Printf("registered webhook %s on %s; send a transfer of %s to continue", webhook.ID, network, address)
+	select {
+	case n := <-passed:
+		return &Result{WebhookID: webhook.ID, Network: network, Transfers: n}, nil
+	case err := <-serveErr:
+		return nil, fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no accepted delivery within %s", timeout)
+	}
+}
+
+// countTransfers counts the delivered transfers of the test token.
+func countTransfers(transfers []*function.TransferDocument, address string) int {
+	n := 0
+	for _, doc := range transfers {
+		if strings.EqualFold(doc.Transfer.Contract, address) {
+			n++
+		}
+	}
+	return n
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// statusRecorder records the status code written by the function.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// notifyClient calls the Alchemy Notify API webhook endpoints.
+type notifyClient struct {
+	token string
+}
+
+// createdWebhook is the part of a create-webhook response the test uses.
+type createdWebhook struct {
+	ID         string `json:"id"`
+	SigningKey string `json:"signing_key"`
+}
+
+func (c *notifyClient) createWebhook(ctx context.Context, network, webhookURL, query string) (*createdWebhook, error) {
+	body, err := json.Marshal(map[string]string{
+		"network":       network,
+		"webhook_type":  function.WebhookTypeGraphQL,
+		"webhook_url":   webhookURL,
+		"graphql_query": query,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Data createdWebhook `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, notifyAPIURL+"/create-webhook", body, &response); err != nil {
+		return nil, err
+	}
+	if response.Data.ID == "" || response.Data.SigningKey == "" {
+		return nil, errors.New("create-webhook response has no webhook ID or signing key")
+	}
+	return &response.Data, nil
+}
+
+func (c *notifyClient) deleteWebhook(ctx context.Context, webhookID string) error {
+	return c.do(ctx, http.MethodDelete, notifyAPIURL+"/delete-webhook?webhook_id="+url.QueryEscape(webhookID), nil, nil)
+}
+
+func (c *notifyClient) do(ctx context.Context, method, endpoint string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Alchemy-Token", c.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: status %d: %s", method, endpoint, resp.StatusCode, data)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,253 @@
+// Command diff reports webhook payload schema drift. Given one archived payload it decodes the
+// payload strictly into function.WebhookEvent and lists every field the struct does not know
+// (unknown) and every field the struct expects inside a present object that the payload lacks
+// (missing). Given two payloads it lists the fields present in only one of them and the fields
+// whose JSON type changed. Array elements are merged, so paths read like
+// event.data.block.logs[].topics.
+//
+//	go run ./cmd/diff payload.json
+//	go run ./cmd/diff old.json new.json
+//
+// The command exits with status 1 when it finds any difference.
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	var diffs []string
+	var err error
+	switch len(os.Args) {
+	case 2:
+		diffs, err = diffStruct(os.Args[1])
+	case 3:
+		diffs, err = diffPayloads(os.Args[1], os.Args[2])
+	default:
+		fmt.Fprintln(os.Stderr, "usage: diff payload.json | diff old.json new.json")
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, diff := range diffs {
+		fmt.Println(diff)
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// diffStruct compares the payload in path against function.WebhookEvent.
+func diffStruct(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []string
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var event function.WebhookEvent
+	if err := decoder.Decode(&event); err != nil {
+		diffs = append(diffs, "strict decode: "+err.Error())
+	}
+
+	payload, err := decodePayload(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	expected := make(map[string]string)
+	structFields(reflect.TypeOf(event), "", expected)
+	actual := make(map[string]string)
+	payloadFields(payload, "", actual)
+
+	for _, field := range sortedKeys(actual) {
+		if _, ok := expected[lookupPath(field, expected)]; !ok {
+			diffs = append(diffs, "unknown "+field)
+		}
+	}
+	for _, field := range sortedKeys(expected) {
+		if strings.HasSuffix(field, "[]") {
+			continue
+		}
+		parent := parentPath(field)
+		if _, ok := actual[lookupPath(field, actual)]; !ok && (parent == "" || actual[lookupPath(parent, actual)] == "object") {
+			diffs = append(diffs, "missing "+field)
+		}
+	}
+	return diffs, nil
+}
+
+// diffPayloads compares the fields of the payloads in oldPath and newPath.
+func diffPayloads(oldPath, newPath string) ([]string, error) {
+	oldFields, err := readPayloadFields(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := readPayloadFields(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []string
+	for _, field := range sortedKeys(oldFields) {
+		newKind, ok := newFields[field]
+		switch {
+		case !ok:
+			diffs = append(diffs, "removed "+field)
+		case newKind != oldFields[field] && oldFields[field] != "null" && newKind != "null":
+			diffs = append(diffs, fmt.Sprintf("changed %s: %s -> %s", field, oldFields[field], newKind))
+		}
+	}
+	for _, field := range sortedKeys(newFields) {
+		if _, ok := oldFields[field]; !ok {
+			diffs = append(diffs, "added "+field)
+		}
+	}
+	return diffs, nil
+}
+
+func readPayloadFields(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := decodePayload(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	fields := make(map[string]string)
+	payloadFields(payload, "", fields)
+	return fields, nil
+}
+
+func decodePayload(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var payload any
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// payloadFields records the JSON type of every field path in value. A path seen with several
+// types across array elements keeps the first non-null one.
+func payloadFields(value any, path string, fields map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		record(fields, path, "object")
+		for key, child := range v {
+			payloadFields(child, joinPath(path, key), fields)
+		}
+	case []any:
+		record(fields, path, "array")
+		for _, child := range v {
+			payloadFields(child, path+"[]", fields)
+		}
+	case string:
+		record(fields, path, "string")
+	case json.Number:
+		record(fields, path, "number")
+	case bool:
+		record(fields, path, "bool")
+	default:
+		record(fields, path, "null")
+	}
+}
+
+func record(fields map[string]string, path, kind string) {
+	if path == "" {
+		return
+	}
+	if existing, ok := fields[path]; ok && existing != "null" {
+		return
+	}
+	fields[path] = kind
+}
+
+var (
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// structFields records the field paths encoding/json decodes into t. Types with their own
+// unmarshaling are leaves.
+func structFields(t reflect.Type, path string, fields map[string]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if path != "" {
+		fields[path] = t.Kind().String()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshaler) || reflect.PointerTo(t).Implements(textUnmarshaler) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			structFields(field.Type, joinPath(path, name), fields)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() != reflect.Uint8 {
+			structFields(t.Elem(), path+"[]", fields)
+		}
+	}
+}
+
+// lookupPath returns the key of fields matching path, ignoring case like encoding/json does.
+func lookupPath(path string, fields map[string]string) string {
+	if _, ok := fields[path]; ok {
+		return path
+	}
+	for field := range fields {
+		if strings.EqualFold(field, path) {
+			return field
+		}
+	}
+	return path
+}
+
+func parentPath(path string) string {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(fields map[string]string) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,24 @@
+// Command enricher runs the enrichment worker: it consumes the transfer topic through
+// ENRICHMENT_SUBSCRIPTION, enriches each batch with the configured enrichers and writes the
+// results, scaling independently of the ingest function. Run it with the function's
+// environment plus the enrichers to apply, e.g. on Cloud Run or GKE. It stops on SIGINT or SIGTERM.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := function.RunEnrichmentWorker(ctx); err != nil {
+		log.Fatalf("enrichment worker failed: %v", err)
+	}
+}
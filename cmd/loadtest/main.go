@@ -0,0 +1,350 @@
+// Command loadtest fires signed synthetic GRAPHQL webhooks at a deployed function and reports
+// latency percentiles and error rates. Each request carries a freshly generated block of
+// LOADTEST_LOGS_PER_BLOCK ERC-20 Transfer logs whose tokens follow the weights in
+// LOADTEST_TOKENS, signed with ALCHEMY_SIGNING_KEY like an Alchemy delivery.
+//
+// Run it with ALCHEMY_SIGNING_KEY and LOADTEST_TARGET_URL, tuning LOADTEST_REQUESTS,
+// LOADTEST_CONCURRENCY, LOADTEST_LOGS_PER_BLOCK, LOADTEST_TOKENS, LOADTEST_NETWORK,
+// LOADTEST_TIMEOUT and LOADTEST_SEED. The command exits with status 1 when any request fails.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	function "webhook.local/function"
+)
+
+// transferTopic is the topic of ERC-20 and ERC-721 Transfer events.
+const transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// defaultTokens spreads transfers over a dominant token and a long tail, like mainnet blocks.
+const defaultTokens = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48=50," +
+	"0xdac17f958d2ee523a2206206994597c13d831ec7=30," +
+	"0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2=15," +
+	"0x6b175474e89094c44da98b954eedeac495271d0f=5"
+
+// Result reports the outcome of a load test.
+type Result struct {
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+	ErrorRate  float64        `json:"errorRate"`
+	Statuses   map[string]int `json:"statuses"`
+	Logs       int            `json:"logs"`
+	Duration   string         `json:"duration"`
+	Throughput float64        `json:"requestsPerSecond"`
+	Latency    Latency        `json:"latencyMs"`
+}
+
+// Latency holds request latency percentiles in milliseconds.
+type Latency struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// config is the load test configuration read from the environment.
+type config struct {
+	target       string
+	signingKey   []byte
+	requests     int
+	concurrency  int
+	logsPerBlock int
+	tokens       []weightedToken
+	network      string
+	timeout      time.Duration
+	seed         uint64
+}
+
+// weightedToken is a token contract picked with probability weight over the total weight.
+type weightedToken struct {
+	contract string
+	weight   int
+}
+
+// outcome is the result of a single request.
+type outcome struct {
+	status  string
+	latency time.Duration
+	failed  bool
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	result := run(cfg)
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		log.Fatal(err)
+	}
+	if result.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+func loadConfig() (*config, error) {
+	target := os.Getenv("LOADTEST_TARGET_URL")
+	signingKey := os.Getenv("ALCHEMY_SIGNING_KEY")
+	if target == "" || signingKey == "" {
+		return nil, errors.New("LOADTEST_TARGET_URL and ALCHEMY_SIGNING_KEY must be set")
+	}
+	cfg := &config{
+		target:     target,
+		signingKey: []byte(signingKey),
+		network:    getenv("LOADTEST_NETWORK", "ETH_MAINNET"),
+	}
+
+	ints := []struct {
+		name     string
+		fallback string
+		set      *int
+	}{
+		{"LOADTEST_REQUESTS", "1000", &cfg.requests},
+		{"LOADTEST_CONCURRENCY", "10", &cfg.concurrency},
+		{"LOADTEST_LOGS_PER_BLOCK", "100", &cfg.logsPerBlock},
+	}
+	for _, i := range ints {
+		value := getenv(i.name, i.fallback)
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s %q", i.name, value)
+		}
+		*i.set = n
+	}
+
+	timeout, err := time.ParseDuration(getenv("LOADTEST_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOADTEST_TIMEOUT: %w", err)
+	}
+	cfg.timeout = timeout
+
+	cfg.seed = uint64(time.Now().UnixNano())
+	if value := os.Getenv("LOADTEST_SEED"); value != "" {
+		if cfg.seed, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid LOADTEST_SEED %q", value)
+		}
+	}
+
+	if cfg.tokens, err = parseTokens(getenv("LOADTEST_TOKENS", defaultTokens)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseTokens parses comma-separated contract=weight pairs.
+func parseTokens(value string) ([]weightedToken, error) {
+	var tokens []weightedToken
+	for _, pair := range strings.Split(value, ",") {
+		contract, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		n, err := strconv.Atoi(weight)
+		if !ok || contract == "" || err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid LOADTEST_TOKENS entry %q", pair)
+		}
+		tokens = append(tokens, weightedToken{contract: contract, weight: n})
+	}
+	return tokens, nil
+}
+
+// run sends cfg.requests webhooks over cfg.concurrency workers and aggregates their outcomes.
+func run(cfg *config) *Result {
+	client := &http.Client{Timeout: cfg.timeout}
+	jobs := make(chan int)
+	outcomes := make(chan outcome, cfg.requests)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for worker := range cfg.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			generator := &generator{cfg: cfg, rand: rand.New(rand.NewPCG(cfg.seed, uint64(worker)))}
+			for n := range jobs {
+				outcomes <- send(client, cfg, generator.webhook(n))
+			}
+		}()
+	}
+	for n := range cfg.requests {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+	close(outcomes)
+	elapsed := time.Since(start)
+
+	result := &Result{
+		Requests:   cfg.requests,
+		Statuses:   make(map[string]int),
+		Logs:       cfg.requests * cfg.logsPerBlock,
+		Duration:   elapsed.Round(time.Millisecond).String(),
+		Throughput: float64(cfg.requests) / elapsed.Seconds(),
+	}
+	latencies := make([]time.Duration, 0, cfg.requests)
+	for o := range outcomes {
+		result.Statuses[o.status]++
+		if o.failed {
+			result.Errors++
+		}
+		latencies = append(latencies, o.latency)
+	}
+	result.ErrorRate = float64(result.Errors) / float64(result.Requests)
+	slices.Sort(latencies)
+	result.Latency = Latency{
+		P50: percentile(latencies, 0.50),
+		P90: percentile(latencies, 0.90),
+		P95: percentile(latencies, 0.95),
+		P99: percentile(latencies, 0.99),
+		Max: percentile(latencies, 1),
+	}
+	return result
+}
+
+// send posts a signed webhook and times it. Transport errors are reported as status "error".
+func send(client *http.Client, cfg *config, event *function.WebhookEvent) outcome {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return outcome{status: "error", failed: true}
+	}
+	mac := hmac.New(sha256.New, cfg.signingKey)
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, cfg.target, bytes.NewReader(body))
+	if err != nil {
+		return outcome{status: "error", failed: true}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alchemy-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("request %s failed: %v", event.ID, err)
+		return outcome{status: "error", latency: time.Since(start), failed: true}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+	return outcome{status: strconv.Itoa(resp.StatusCode), latency: latency, failed: resp.StatusCode != http.StatusOK}
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies in milliseconds.
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return float64(sorted[rank].Microseconds()) / 1000
+}
+
+// generator builds synthetic GRAPHQL webhooks. Each worker has its own generator, so no locking
+// is needed around its random source.
+type generator struct {
+	cfg  *config
+	rand *rand.Rand
+}
+
+// webhook returns the synthetic webhook of request n, for block 20,000,000 + n.
+func (g *generator) webhook(n int) *function.WebhookEvent {
+	now := time.Now().UTC()
+	event := &function.WebhookEvent{
+		WebhookID: "wh_loadtest",
+		ID:        "whevt_loadtest_" + g.hex(12),
+		CreatedAt: now,
+		Type:      function.WebhookTypeGraphQL,
+	}
+	event.Event.Network = g.cfg.network
+	event.Event.SequenceNumber = strconv.Itoa(n)
+
+	block := &event.Event.Data.Block
+	block.Hash = "0x" + g.hex(32)
+	block.Number = 20_000_000 + int64(n)
+	block.Timestamp = now.Unix()
+
+	total := 0
+	for _, token := range g.cfg.tokens {
+		total += token.weight
+	}
+	txType := int64(2)
+	status := 1
+	for i := range g.cfg.logsPerBlock {
+		from, to := g.address(), g.address()
+		value := new(big.Int).Lsh(big.NewInt(g.rand.Int64N(1_000_000)+1), uint(g.rand.IntN(64)))
+
+		var entry function.WebhookLog
+		entry.Index = i
+		entry.Account.Address = g.token(total)
+		entry.Topics = []string{transferTopic, padAddress(from), padAddress(to)}
+		entry.Data = fmt.Sprintf("0x%064x", value)
+		entry.Transaction.Hash = "0x" + g.hex(32)
+		entry.Transaction.From.Address = from
+		entry.Transaction.To.Address = entry.Account.Address
+		entry.Transaction.Type = &txType
+		entry.Transaction.Value = "0x0"
+		entry.Transaction.MaxFeePerGas = "0x2540be400"
+		entry.Transaction.MaxPriorityFeePerGas = "0x3b9aca00"
+		entry.Transaction.EffectiveGasPrice = "0x12a05f200"
+		entry.Transaction.Gas = 100000
+		entry.Transaction.GasUsed = 51000
+		entry.Transaction.Status = &status
+		block.Logs = append(block.Logs, entry)
+	}
+	return event
+}
+
+// token picks a token contract by weight.
+func (g *generator) token(total int) string {
+	pick := g.rand.IntN(total)
+	for _, token := range g.cfg.tokens {
+		if pick < token.weight {
+			return token.contract
+		}
+		pick -= token.weight
+	}
+	return g.cfg.tokens[len(g.cfg.tokens)-1].contract
+}
+
+func (g *generator) address() string {
+	return "0x" + g.hex(20)
+}
+
+// hex returns n random bytes in hex.
+func (g *generator) hex(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(g.rand.UintN(256))
+	}
+	return hex.EncodeToString(b)
+}
+
+// padAddress left-pads an address to a 32-byte topic.
+func padAddress(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.TrimPrefix(address, "0x")
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
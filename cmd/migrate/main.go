@@ -0,0 +1,54 @@
+// Command migrate applies the pending schema migrations embedded in the binary to the
+// relational sinks, for deployments that run them with POSTGRES_MIGRATE=false or
+// CLICKHOUSE_MIGRATE=false and migrate from a release step instead. It migrates the sinks given
+// as arguments, or those enabled by POSTGRES_URL and CLICKHOUSE_URL, and prints one result per
+// sink. With -status, it only reports the pending migrations. Run it with the same environment
+// as the function.
+//
+//	go run ./cmd/migrate
+//	go run ./cmd/migrate -status postgres
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	status := flag.Bool("status", false, "report pending migrations without applying them")
+	flag.Parse()
+
+	sinks := flag.Args()
+	if len(sinks) == 0 {
+		if os.Getenv("POSTGRES_URL") != "" {
+			sinks = append(sinks, "postgres")
+		}
+		if os.Getenv("CLICKHOUSE_URL") != "" {
+			sinks = append(sinks, "clickhouse")
+		}
+	}
+	if len(sinks) == 0 {
+		log.Fatal("no sinks to migrate: set POSTGRES_URL or CLICKHOUSE_URL, or name the sinks")
+	}
+
+	ctx := context.Background()
+	encoder := json.NewEncoder(os.Stdout)
+	for _, sink := range sinks {
+		migrate := function.Migrate
+		if *status {
+			migrate = function.PendingMigrations
+		}
+		result, err := migrate(ctx, sink)
+		if encodeErr := encoder.Encode(result); encodeErr != nil {
+			log.Fatal(encodeErr)
+		}
+		if err != nil {
+			log.Fatalf("%s migration failed: %v", sink, err)
+		}
+	}
+}
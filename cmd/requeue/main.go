@@ -0,0 +1,26 @@
+// Command requeue decodes the logs held in the quarantine collection again with the
+// current decoders and delivers those that now succeed. Run it with the same
+// environment as the function, e.g. after deploying a decoder fix.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	result, err := function.RequeueQuarantined(context.Background())
+	if err != nil {
+		log.Fatalf("requeue failed: %v", err)
+	}
+	if err := function.CloseSinks(); err != nil {
+		log.Printf("failed to close sinks: %v", err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
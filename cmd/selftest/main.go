@@ -0,0 +1,28 @@
+// Command selftest runs the function's readiness checks, the same as GET /readyz, and prints
+// the report with each failure and warning explained. It exits with status 1 when a check
+// fails, so a deploy pipeline can run it before shifting traffic. Run it with the same
+// environment as the function.
+//
+//	go run ./cmd/selftest
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	report := function.CheckReadiness(context.Background())
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+	if !report.Ready {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,63 @@
+// Command serve runs the function as a local HTTP server on PORT (default 8080), for
+// development with the local sink and no GCP credentials. Send it signed webhooks, for example
+// with cmd/loadtest. Sinks are closed on interrupt, flushing the local file or database.
+//
+//	ALCHEMY_SIGNING_KEY=dev LOCAL_SINK_PATH=out/documents.db go run ./cmd/serve
+//
+// Run as a long-lived server, for example on GKE, it also runs the SCHEDULED_JOBS itself instead
+// of Cloud Scheduler, on the replica holding the Firestore leader lease, so they run once across
+// replicas, and serves its metrics for Prometheus on /metrics.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", function.MetricsHTTP)
+	mux.HandleFunc("/", function.AlchemyWebhook)
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+	jobs, err := function.ScheduledJobs()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	scheduled := make(chan error, 1)
+	go func() {
+		scheduled <- function.RunScheduledJobs(ctx, jobs)
+		if ctx.Err() == nil {
+			stop()
+		}
+	}()
+
+	log.Printf("serving the function on :%s", port)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	scheduledErr := <-scheduled
+	if err := function.CloseSinks(); err != nil {
+		log.Fatal(err)
+	}
+	if scheduledErr != nil {
+		log.Fatal(scheduledErr)
+	}
+}
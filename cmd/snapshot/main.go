@@ -0,0 +1,25 @@
+// Command snapshot builds a point-in-time holder snapshot of SNAPSHOT_CONTRACT as of
+// SNAPSHOT_BLOCK from the accumulated transfer history, e.g. for a governance airdrop, and
+// writes it to Firestore or a Parquet file. Run it with the same environment as the function.
+//
+//	SNAPSHOT_CONTRACT=0x... SNAPSHOT_BLOCK=19000000 go run ./cmd/snapshot
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	result, err := function.BuildHolderSnapshot(context.Background())
+	if err != nil {
+		log.Fatalf("snapshot failed: %v", err)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		log.Fatal(err)
+	}
+}
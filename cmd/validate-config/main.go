@@ -0,0 +1,32 @@
+// Command validate-config checks a pipeline config before it is deployed: it rejects unknown
+// keys, then builds the event decoders, transfer filter, enrichers, enabled sinks and routing
+// rules the config declares, as the function would on its first webhook, and exits non-zero on
+// the first error. It validates the file given as argument, or PIPELINE_CONFIG. Variables set in
+// the environment override the file, as they do in the function.
+//
+//	go run ./cmd/validate-config pipeline.yaml
+package main
+
+import (
+	"log"
+	"os"
+
+	function "webhook.local/function"
+)
+
+func main() {
+	path := os.Getenv("PIPELINE_CONFIG")
+	if len(os.Args) > 1 {
+		if path != "" && path != os.Args[1] {
+			log.Fatal("PIPELINE_CONFIG names another file; unset it to validate " + os.Args[1])
+		}
+		path = os.Args[1]
+	}
+	if path == "" {
+		log.Fatal("no pipeline config: name the file or set PIPELINE_CONFIG")
+	}
+	if err := function.ValidatePipelineConfig(path); err != nil {
+		log.Fatalf("invalid pipeline config %s: %v", path, err)
+	}
+	log.Printf("pipeline config %s is valid", path)
+}
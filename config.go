@@ -0,0 +1,280 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PipelineConfig is the pipeline declared in the YAML file at PIPELINE_CONFIG: its decoders,
+// filters, enrichers, sinks and routing rules, plus any other setting under env. Each section
+// stands for the environment variables documented for it, so the file and the environment
+// configure the same pipeline and a variable set in the environment overrides the file.
+type PipelineConfig struct {
+	// Env sets environment variables by name, for settings without a section of their own.
+	Env       map[string]string `yaml:"env"`
+	Decoders  DecodersConfig    `yaml:"decoders"`
+	Filters   FiltersConfig     `yaml:"filters"`
+	Enrichers EnrichersConfig   `yaml:"enrichers"`
+	// Sinks lists the sinks every webhook is written to, in order, as SINKS.
+	Sinks []string `yaml:"sinks"`
+	// Routing lists the routing rules, as RULES.
+	Routing []RuleConfig `yaml:"routing"`
+}
+
+// DecodersConfig declares the event decoders, as EVENT_DECODERS and EVENT_DECODER_VERSION. An
+// event's abi is the ABI as a YAML list or a JSON string.
+type DecodersConfig struct {
+	Version string             `yaml:"version"`
+	Events  []eventDecoderYAML `yaml:"events"`
+}
+
+// eventDecoderYAML is an EventDecoderConfig as written in YAML.
+type eventDecoderYAML struct {
+	Name      string `yaml:"name"`
+	ABI       any    `yaml:"abi"`
+	Event     string `yaml:"event"`
+	Version   string `yaml:"version"`
+	ValidFrom string `yaml:"validFrom"`
+}
+
+// FiltersConfig declares the transfer filters, as the FILTER_* variables. MinValues maps
+// contracts, or * for every other contract, to minimum values in base units.
+type FiltersConfig struct {
+	Contracts AllowDenyConfig   `yaml:"contracts"`
+	Addresses AllowDenyConfig   `yaml:"addresses"`
+	MinValues map[string]string `yaml:"minValues"`
+}
+
+// AllowDenyConfig holds an allowlist and a denylist of addresses.
+type AllowDenyConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// EnrichersConfig declares the enrichers, as ATTRIBUTION_DATASET_FILE, ENABLE_TOKEN_METADATA,
+// ADDRESS_LABELS_FILE, ADDRESS_LABELS_COLLECTION, ENABLE_ENS and ENABLE_AMOUNT_PERCENTILE.
+// Unset switches leave the variables alone.
+type EnrichersConfig struct {
+	AttributionDataset string `yaml:"attributionDataset"`
+	TokenMetadata      *bool  `yaml:"tokenMetadata"`
+	Labels             struct {
+		File       string `yaml:"file"`
+		Collection string `yaml:"collection"`
+	} `yaml:"labels"`
+	ENS              *bool `yaml:"ens"`
+	AmountPercentile *bool `yaml:"amountPercentile"`
+}
+
+// envName is the form of the environment variable names env may set.
+var envName = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+var (
+	pipelineConfigOnce sync.Once
+	pipelineConfigErr  error
+)
+
+func init() {
+	// Applied before the other init functions read the environment, as config.go sorts first.
+	if err := LoadPipelineConfig(); err != nil {
+		logError("failed to load PIPELINE_CONFIG", err)
+	}
+}
+
+// LoadPipelineConfig applies the pipeline declared in the YAML file at PIPELINE_CONFIG to the
+// environment, once per instance, setting the variables the environment does not already set.
+// It does nothing when PIPELINE_CONFIG is unset.
+func LoadPipelineConfig() error {
+	pipelineConfigOnce.Do(func() {
+		if path := os.Getenv("PIPELINE_CONFIG"); path != "" {
+			pipelineConfigErr = applyPipelineConfig(path)
+		}
+	})
+	return pipelineConfigErr
+}
+
+// applyPipelineConfig sets the environment variables the pipeline config at path stands for,
+// unless the environment already sets them.
+func applyPipelineConfig(path string) error {
+	config, err := ReadPipelineConfig(path)
+	if err != nil {
+		return err
+	}
+	env, err := config.Environment()
+	if err != nil {
+		return fmt.Errorf("invalid PIPELINE_CONFIG %s: %w", path, err)
+	}
+	for name, value := range env {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, value)
+		}
+	}
+	return nil
+}
+
+// ReadPipelineConfig reads the pipeline config at path, rejecting unknown keys.
+func ReadPipelineConfig(path string) (*PipelineConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PIPELINE_CONFIG: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	config := &PipelineConfig{}
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse PIPELINE_CONFIG %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Environment returns the environment variables the config stands for. A variable set both
+// under env and by a section is an error, as is a malformed variable name.
+func (c *PipelineConfig) Environment() (map[string]string, error) {
+	env := make(map[string]string)
+	for name, value := range c.Env {
+		if !envName.MatchString(name) {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+		env[name] = value
+	}
+	set := func(name, value string) error {
+		if value == "" {
+			return nil
+		}
+		if _, ok := env[name]; ok {
+			return fmt.Errorf("%s is set both under env and by its section", name)
+		}
+		env[name] = value
+		return nil
+	}
+	setBool := func(name string, value *bool) error {
+		if value == nil {
+			return nil
+		}
+		return set(name, strconv.FormatBool(*value))
+	}
+
+	decoders, err := c.Decoders.json()
+	if err != nil {
+		return nil, err
+	}
+	rules := ""
+	if len(c.Routing) > 0 {
+		data, err := json.Marshal(c.Routing)
+		if err != nil {
+			return nil, err
+		}
+		rules = string(data)
+	}
+	minValues := make([]string, 0, len(c.Filters.MinValues))
+	for contract, value := range c.Filters.MinValues {
+		minValues = append(minValues, contract+"="+value)
+	}
+	sort.Strings(minValues)
+
+	for _, err := range []error{
+		set("EVENT_DECODERS", decoders),
+		set("EVENT_DECODER_VERSION", c.Decoders.Version),
+		set("FILTER_CONTRACT_ALLOWLIST", strings.Join(c.Filters.Contracts.Allow, ",")),
+		set("FILTER_CONTRACT_DENYLIST", strings.Join(c.Filters.Contracts.Deny, ",")),
+		set("FILTER_ADDRESS_ALLOWLIST", strings.Join(c.Filters.Addresses.Allow, ",")),
+		set("FILTER_ADDRESS_DENYLIST", strings.Join(c.Filters.Addresses.Deny, ",")),
+		set("FILTER_MIN_VALUES", strings.Join(minValues, ",")),
+		set("ATTRIBUTION_DATASET_FILE", c.Enrichers.AttributionDataset),
+		setBool("ENABLE_TOKEN_METADATA", c.Enrichers.TokenMetadata),
+		set("ADDRESS_LABELS_FILE", c.Enrichers.Labels.File),
+		set("ADDRESS_LABELS_COLLECTION", c.Enrichers.Labels.Collection),
+		setBool("ENABLE_ENS", c.Enrichers.ENS),
+		setBool("ENABLE_AMOUNT_PERCENTILE", c.Enrichers.AmountPercentile),
+		set("SINKS", strings.Join(c.Sinks, ",")),
+		set("RULES", rules),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// json returns the decoders as the JSON array EVENT_DECODERS holds, or "" when there are none.
+func (c DecodersConfig) json() (string, error) {
+	if len(c.Events) == 0 {
+		return "", nil
+	}
+	configs := make([]EventDecoderConfig, len(c.Events))
+	for i, event := range c.Events {
+		configs[i] = EventDecoderConfig{Name: event.Name, Event: event.Event, Version: event.Version, ValidFrom: event.ValidFrom}
+		switch abi := event.ABI.(type) {
+		case nil:
+			return "", fmt.Errorf("event decoder %q has no abi", event.Event)
+		case string:
+			if !json.Valid([]byte(abi)) {
+				return "", fmt.Errorf("abi of event decoder %q is not valid JSON", event.Event)
+			}
+			configs[i].ABI = json.RawMessage(abi)
+		default:
+			data, err := json.Marshal(abi)
+			if err != nil {
+				return "", fmt.Errorf("invalid abi for event decoder %q: %w", event.Event, err)
+			}
+			configs[i].ABI = data
+		}
+	}
+	data, err := json.Marshal(configs)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ValidatePipelineConfig applies the pipeline config at path, or at PIPELINE_CONFIG when path is
+// empty, and builds the pipeline it declares with the rest of the environment: the event
+// decoders, transfer filter, enrichers, enabled sinks and routing rules, returning the first
+// error. Sinks are not initialized, so no sink is contacted, but enrichers and rules read their
+// Firestore collections when configured to. It changes the process environment, so it is meant
+// for cmd/validate-config rather than a running function.
+func ValidatePipelineConfig(path string) error {
+	if err := LoadPipelineConfig(); err != nil {
+		return err
+	}
+	if path != "" {
+		if err := applyPipelineConfig(path); err != nil {
+			return err
+		}
+	}
+	if _, err := LoadEventDecoderRegistry(); err != nil {
+		return fmt.Errorf("decoders: %w", err)
+	}
+	if _, err := getTransferFilter(); err != nil {
+		return fmt.Errorf("filters: %w", err)
+	}
+	if _, err := LoadEnrichers(); err != nil {
+		return fmt.Errorf("enrichers: %w", err)
+	}
+	enabled, err := enabledSinks()
+	if err != nil {
+		return fmt.Errorf("sinks: %w", err)
+	}
+	if _, _, err := getSinkFanOut(); err != nil {
+		return fmt.Errorf("sinks: %w", err)
+	}
+	for _, entry := range enabled {
+		if _, err := getSinkBreaker(entry.sink.Name()); err != nil {
+			return fmt.Errorf("sinks: %w", err)
+		}
+	}
+	if _, err := LoadRules(); err != nil {
+		return fmt.Errorf("routing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package function
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	defaultDeadLetterCollection = "alchemy_dead_letters"
+	defaultDeadLetterPrefix     = "dead-letters"
+)
+
+// Reasons documents are dead-lettered.
+const (
+	DeadLetterCircuitOpen  = "circuit_open"
+	DeadLetterSinkFailed   = "sink_failed"
+	DeadLetterDecodeFailed = "decode_failed"
+	DeadLetterOverflow     = "overflow"
+)
+
+// DeadLetterDocument records the documents of a webhook a sink did not write, as the sink would
+// have received them, or the logs that failed to decode when no sink keeps them, with the reason
+// and error, so they are kept rather than lost.
+type DeadLetterDocument struct {
+	Sink           string    `json:"sink,omitempty"`
+	Reason         string    `json:"reason"`
+	Error          string    `json:"error"`
+	WebhookID      string    `json:"webhookId,omitempty"`
+	EventID        string    `json:"eventId,omitempty"`
+	Network        string    `json:"network,omitempty"`
+	Documents      string    `json:"documents"`
+	DeadLetteredAt time.Time `json:"deadLetteredAt"`
+}
+
+// DocumentID returns the sink, or the reason of a dead letter without one, and the Alchemy event
+// ID, or a digest of the documents when the event is unknown, so a redelivered webhook overwrites
+// its dead letter. Overflow dead letters also carry the reason, so the failure of the documents
+// the sink kept does not overwrite them.
+func (d *DeadLetterDocument) DocumentID() string {
+	prefix := d.Sink
+	switch {
+	case prefix == "":
+		prefix = d.Reason
+	case d.Reason == DeadLetterOverflow:
+		prefix += "-" + d.Reason
+	}
+	if d.EventID != "" {
+		return prefix + "-" + d.EventID
+	}
+	sum := sha256.Sum256([]byte(d.Documents))
+	return prefix + "-" + hex.EncodeToString(sum[:16])
+}
+
+// deadLetterDocuments is the JSON of a dead letter's documents, by kind.
+type deadLetterDocuments struct {
+	Transfers    []*TransferDocument    `json:"transfers,omitempty"`
+	Reverted     []*TransferDocument    `json:"reverted,omitempty"`
+	Events       []*EventDocument       `json:"events,omitempty"`
+	Approvals    []*ApprovalDocument    `json:"approvals,omitempty"`
+	Swaps        []*SwapDocument        `json:"swaps,omitempty"`
+	Transactions []*TransactionDocument `json:"transactions,omitempty"`
+	Tombstones   []*Tombstone           `json:"tombstones,omitempty"`
+	RawLogs      []*RawLogDocument      `json:"rawLogs,omitempty"`
+	Quarantined  []*QuarantineDocument  `json:"quarantined,omitempty"`
+}
+
+// writeDeadLetter stores the documents of parsed the sink did not write.
+func writeDeadLetter(ctx context.Context, sink, reason string, cause error, parsed *ParsedWebhook) error {
+	return storeDeadLetter(ctx, sink, reason, cause, deadLetterDocuments{
+		Transfers:    parsed.Transfers,
+		Reverted:     parsed.Reverted,
+		Events:       parsed.Events,
+		Approvals:    parsed.Approvals,
+		Swaps:        parsed.Swaps,
+		Transactions: parsed.Transactions,
+		Tombstones:   parsed.Tombstones,
+		RawLogs:      parsed.RawLogs,
+	})
+}
+
+// writeDecodeDeadLetter stores the quarantined logs of parsed, for deployments without the
+// firestore sink that would otherwise keep them in the quarantine collection.
+func writeDecodeDeadLetter(ctx context.Context, parsed *ParsedWebhook) error {
+	cause := errors.New(parsed.Quarantined[0].Error)
+	return storeDeadLetter(ctx, "", DeadLetterDecodeFailed, cause, deadLetterDocuments{Quarantined: parsed.Quarantined})
+}
+
+// storeDeadLetter stores a dead letter as the JSON object
+// <DEAD_LETTER_PREFIX>/<reason>/dt=<YYYY-MM-DD>/<id>.json in DEAD_LETTER_BUCKET when it is set, and
+// otherwise in the Firestore collection DEAD_LETTER_COLLECTION (default alchemy_dead_letters),
+// logging the dead_letter_stored metric and emitting the lifecycle event of the same name.
+func storeDeadLetter(ctx context.Context, sink, reason string, cause error, documents deadLetterDocuments) error {
+	data, err := json.Marshal(documents)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+	doc := &DeadLetterDocument{
+		Sink:           sink,
+		Reason:         reason,
+		Error:          cause.Error(),
+		Documents:      string(data),
+		DeadLetteredAt: time.Now().UTC(),
+	}
+	if webhook := pipelineStateFrom(ctx, nil).Webhook; webhook != nil {
+		doc.WebhookID, doc.EventID, doc.Network = webhook.WebhookID, webhook.ID, webhook.Event.Network
+	}
+
+	if err := saveDeadLetter(ctx, doc); err != nil {
+		return err
+	}
+
+	// Dead letters stand for webhooks acknowledged without some of their documents, so each one is
+	// counted and announced, even though the webhook itself succeeds.
+	log.Printf(`{"level":"warn","message":"stored dead letter","metric":"dead_letter_stored","sink":"%s","reason":"%s","webhook_id":"%s","event_id":"%s","id":"%s"}`,
+		doc.Sink, doc.Reason, doc.WebhookID, doc.EventID, doc.DocumentID())
+	countMetric("alchemy_dead_letter_stored_total", 1, "sink", doc.Sink, "reason", doc.Reason)
+	emitLifecycleEvent(ctx, LifecycleDeadLetterStored, map[string]any{
+		"sink": doc.Sink, "reason": doc.Reason, "webhookId": doc.WebhookID, "eventId": doc.EventID, "id": doc.DocumentID(),
+	})
+	return nil
+}
+
+func saveDeadLetter(ctx context.Context, doc *DeadLetterDocument) error {
+	if bucket := os.Getenv("DEAD_LETTER_BUCKET"); bucket != "" {
+		return storeDeadLetterObject(ctx, bucket, doc)
+	}
+	writer, err := newWriter(ctx)
+	if err != nil {
+		return err
+	}
+	collection := os.Getenv("DEAD_LETTER_COLLECTION")
+	if collection == "" {
+		collection = defaultDeadLetterCollection
+	}
+	if err := writer.WriteDocuments(ctx, collection, []Document{doc}); err != nil {
+		return fmt.Errorf("failed to write dead letter %s: %w", doc.DocumentID(), err)
+	}
+	return nil
+}
+
+func storeDeadLetterObject(ctx context.Context, bucket string, doc *DeadLetterDocument) error {
+	client, err := storageClient(ctx)
+	if err != nil {
+		return err
+	}
+	prefix := os.Getenv("DEAD_LETTER_PREFIX")
+	if prefix == "" {
+		prefix = defaultDeadLetterPrefix
+	}
+	name := path.Join(prefix, doc.Reason, "dt="+doc.DeadLetteredAt.Format(time.DateOnly), doc.DocumentID()+".json")
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+	writer := client.Bucket(bucket).Object(name).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write dead letter gs://%s/%s: %w", bucket, name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write dead letter gs://%s/%s: %w", bucket, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const eventsCollectionName = "alchemy_events"
+
+// ErrMalformedEvent is returned for registered events whose topics or data cannot be decoded.
+var ErrMalformedEvent = errors.New("malformed event")
+
+// EventDecoderConfig describes a user-supplied event decoder: an ABI and the event to decode from it.
+// Version labels the decoder's schema, and ValidFrom (RFC 3339) is when it took effect, so several
+// versions of the same event can be registered and webhooks decode with the one current when
+// they were created.
+type EventDecoderConfig struct {
+	Name      string          `json:"name"`
+	ABI       json.RawMessage `json:"abi"`
+	Event     string          `json:"event"`
+	Version   string          `json:"version,omitempty"`
+	ValidFrom string          `json:"validFrom,omitempty"`
+}
+
+// EventDecoder decodes logs of a single ABI event into named fields.
+type EventDecoder struct {
+	name      string
+	event     abi.Event
+	version   string
+	validFrom time.Time
+}
+
+// EventDecoderRegistry maps topics[0] event signatures to their decoder versions, ordered by
+// ValidFrom. A pinned version, when set, takes precedence over selection by time.
+type EventDecoderRegistry struct {
+	decoders map[common.Hash][]*EventDecoder
+	pinned   string
+}
+
+// DecodedEvent represents a log decoded by a registered EventDecoder.
+type DecodedEvent struct {
+	Contract  string         `json:"contract"`
+	Name      string         `json:"name"`
+	Signature string         `json:"signature"`
+	Topic     string         `json:"topic"`
+	Version   string         `json:"version,omitempty"`
+	LogIndex  int            `json:"logIndex"`
+	Fields    map[string]any `json:"fields"`
+}
+
+// EventDocument represents the document structure for events decoded through the registry.
+type EventDocument struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Block         Block           `json:"block"`
+	Transaction   Transaction     `json:"transaction"`
+	Event         DecodedEvent    `json:"event"`
+	Network       string          `json:"network"`
+	Alchemy       AlchemyMetadata `json:"alchemy"`
+	Bridge        *BridgeLink     `json:"bridge,omitempty"`
+	RawLog        *RawLog         `json:"rawLog,omitempty"`
+}
+
+// DocumentID returns the idempotent document ID of the event.
+func (d *EventDocument) DocumentID() string {
+	return GetDocumentID(d.Transaction.Hash, d.Event.LogIndex)
+}
+
+var (
+	decoderRegistryOnce sync.Once
+	decoderRegistry     *EventDecoderRegistry
+	decoderRegistryErr  error
+)
+
+// LoadEventDecoderRegistry returns the registry configured through EVENT_DECODERS_FILE (path to a
+// JSON file) or EVENT_DECODERS (inline JSON), each holding an array of EventDecoderConfig.
+// The registry is built once per instance; it is empty when neither variable is set.
+func LoadEventDecoderRegistry() (*EventDecoderRegistry, error) {
+	decoderRegistryOnce.Do(func() {
+		decoderRegistry, decoderRegistryErr = loadEventDecoderRegistry()
+	})
+	return decoderRegistry, decoderRegistryErr
+}
+
+func loadEventDecoderRegistry() (*EventDecoderRegistry, error) {
+	data := []byte(os.Getenv("EVENT_DECODERS"))
+	if path := os.Getenv("EVENT_DECODERS_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read EVENT_DECODERS_FILE: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		return NewEventDecoderRegistry(nil)
+	}
+
+	var configs []EventDecoderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse event decoder config: %w", err)
+	}
+	registry, err := NewEventDecoderRegistry(configs)
+	if err != nil {
+		return nil, err
+	}
+	return registry.Pin(os.Getenv("EVENT_DECODER_VERSION")), nil
+}
+
+// NewEventDecoderRegistry compiles the given decoder configs into a registry.
+func NewEventDecoderRegistry(configs []EventDecoderConfig) (*EventDecoderRegistry, error) {
+	r := &EventDecoderRegistry{decoders: make(map[common.Hash][]*EventDecoder, len(configs))}
+	for _, config := range configs {
+		decoder, err := NewEventDecoder(config)
+		if err != nil {
+			return nil, err
+		}
+		for _, existing := range r.decoders[decoder.Topic()] {
+			if existing.validFrom.Equal(decoder.validFrom) || (decoder.version != "" && existing.version == decoder.version) {
+				return nil, fmt.Errorf("duplicate event decoder for %s", decoder.event.Sig)
+			}
+		}
+		r.decoders[decoder.Topic()] = append(r.decoders[decoder.Topic()], decoder)
+	}
+	for _, versions := range r.decoders {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].validFrom.Before(versions[j].validFrom) })
+	}
+	return r, nil
+}
+
+// Pin returns a copy of the registry that decodes with version wherever a topic has a decoder
+// labeled with it, for reprocessing archived payloads with their original schema.
+// Topics without that version keep selecting by time. An empty version returns r unchanged.
+func (r *EventDecoderRegistry) Pin(version string) *EventDecoderRegistry {
+	if r == nil || version == "" {
+		return r
+	}
+	return &EventDecoderRegistry{decoders: r.decoders, pinned: version}
+}
+
+// NewEventDecoder compiles the ABI of a decoder config and looks up its event.
+func NewEventDecoder(config EventDecoderConfig) (*EventDecoder, error) {
+	parsed, err := abi.JSON(strings.NewReader(string(config.ABI)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ABI for event %q: %w", config.Event, err)
+	}
+	event, ok := parsed.Events[config.Event]
+	if !ok {
+		return nil, fmt.Errorf("event %q not found in ABI", config.Event)
+	}
+	if event.Anonymous {
+		return nil, fmt.Errorf("event %q is anonymous and cannot be matched by topic", config.Event)
+	}
+	name := config.Name
+	if name == "" {
+		name = event.Name
+	}
+	var validFrom time.Time
+	if config.ValidFrom != "" {
+		validFrom, err = time.Parse(time.RFC3339, config.ValidFrom)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validFrom for event %q: %w", config.Event, err)
+		}
+	}
+	return &EventDecoder{name: name, event: event, version: config.Version, validFrom: validFrom}, nil
+}
+
+// Lookup returns the latest decoder version registered for topic, if any.
+func (r *EventDecoderRegistry) Lookup(topic common.Hash) (*EventDecoder, bool) {
+	return r.LookupAt(topic, time.Time{})
+}
+
+// LookupAt returns the decoder for topic that was current at, the latest version whose ValidFrom
+// is not after at, or the pinned version when the topic has one. A zero at selects the latest
+// version. It reports false when no version of the event existed yet at that time.
+func (r *EventDecoderRegistry) LookupAt(topic common.Hash, at time.Time) (*EventDecoder, bool) {
+	if r == nil {
+		return nil, false
+	}
+	versions := r.decoders[topic]
+	if r.pinned != "" {
+		for _, decoder := range versions {
+			if decoder.version == r.pinned {
+				return decoder, true
+			}
+		}
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if at.IsZero() || !versions[i].validFrom.After(at) {
+			return versions[i], true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of registered decoders, counting every version.
+func (r *EventDecoderRegistry) Len() int {
+	if r == nil {
+		return 0
+	}
+	n := 0
+	for _, versions := range r.decoders {
+		n += len(versions)
+	}
+	return n
+}
+
+// Topic returns the topics[0] signature hash matched by the decoder.
+func (d *EventDecoder) Topic() common.Hash {
+	return d.event.ID
+}
+
+// Decode decodes the indexed topics and data of log into named fields.
+func (d *EventDecoder) Decode(log WebhookLog) (DecodedEvent, error) {
+	var indexed abi.Arguments
+	for _, input := range d.event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	if len(log.Topics) != len(indexed)+1 {
+		return DecodedEvent{}, fmt.Errorf("%w: expected %d topics on %s, got %d",
+			ErrMalformedEvent, len(indexed)+1, d.event.Name, len(log.Topics))
+	}
+
+	raw := make(map[string]any, len(d.event.Inputs))
+	topics := make([]common.Hash, 0, len(indexed))
+	for _, topic := range log.Topics[1:] {
+		topics = append(topics, common.HexToHash(topic))
+	}
+	if err := abi.ParseTopicsIntoMap(raw, indexed, topics); err != nil {
+		return DecodedEvent{}, fmt.Errorf("%w: %v", ErrMalformedEvent, err)
+	}
+	if err := d.event.Inputs.NonIndexed().UnpackIntoMap(raw, common.FromHex(log.Data)); err != nil {
+		return DecodedEvent{}, fmt.Errorf("%w: %v", ErrMalformedEvent, err)
+	}
+
+	fields := make(map[string]any, len(raw))
+	for key, value := range raw {
+		fields[key] = normalizeABIValue(reflect.ValueOf(value))
+	}
+	return DecodedEvent{
+		Contract:  log.Account.Address,
+		Name:      d.name,
+		Signature: d.event.Sig,
+		Topic:     d.event.ID.Hex(),
+		Version:   d.version,
+		LogIndex:  log.Index,
+		Fields:    fields,
+	}, nil
+}
+
+// normalizeABIValue converts decoded ABI values into JSON- and Firestore-friendly values:
+// integers as decimal strings, addresses and bytes as hex, tuples as maps.
+func normalizeABIValue(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	switch value := v.Interface().(type) {
+	case *big.Int:
+		return bigIntString(value)
+	case common.Address:
+		return value.Hex()
+	case common.Hash:
+		return value.Hex()
+	case []byte:
+		return hexutil.Encode(value)
+	case string, bool:
+		return value
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int()).String()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(v.Uint()).String()
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return hexutil.Encode(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i] = normalizeABIValue(v.Index(i))
+		}
+		return items
+	case reflect.Struct:
+		fields := make(map[string]any, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := field.Tag.Get("json")
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = normalizeABIValue(v.Field(i))
+		}
+		return fields
+	case reflect.Pointer:
+		return normalizeABIValue(v.Elem())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// newEventDocument combines a decoded event with the block, transaction and webhook metadata of its log.
+func newEventDocument(webhook *WebhookEvent, log WebhookLog, event DecodedEvent) *EventDocument {
+	return &EventDocument{
+		SchemaVersion: SchemaVersion,
+		Block:         newBlock(webhook),
+		Transaction:   newTransaction(log),
+		Event:         event,
+		Network:       webhook.Event.Network,
+		Alchemy:       newAlchemyMetadata(webhook),
+	}
+}
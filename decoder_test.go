@@ -0,0 +1,36 @@
+package function
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// wethDepositABI is the WETH Deposit event, a typical registry decoder.
+const wethDepositABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"dst","type":"address"},{"indexed":false,"name":"wad","type":"uint256"}],"name":"Deposit","type":"event"}]`
+
+// BenchmarkRegistryDecodeLog measures decoding one log through a registry decoder, whose ABI is
+// compiled once when the registry is built.
+func BenchmarkRegistryDecodeLog(b *testing.B) {
+	registry, err := NewEventDecoderRegistry([]EventDecoderConfig{
+		{Name: "weth_deposit", ABI: json.RawMessage(wethDepositABI), Event: "Deposit"},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	webhook := singleTransferWebhook(b)
+	entry := &webhook.Event.Data.Block.Logs[0]
+	for _, decoder := range registry.decoders {
+		entry.Topics = []string{decoder[0].Topic().Hex(), entry.Topics[2]}
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		event, err := parseRegisteredEvent(webhook, registry, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if event == nil {
+			b.Fatal("no decoder matched the log")
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	replayCollectionName      = "alchemy_replay"
+	defaultReplayTTL          = 24 * time.Hour
+	idempotencyCollectionName = "alchemy_processed_events"
+	defaultIdempotencyTTL     = 7 * 24 * time.Hour
+)
+
+// ClaimStore records keys that have already been seen, for replay protection and idempotency.
+type ClaimStore interface {
+	Claim(ctx context.Context, key string) (bool, error)
+	Release(ctx context.Context, key string) error
+	Close() error
+}
+
+// ClaimStoreFactory opens the ClaimStore for a collection with the given claim TTL.
+type ClaimStoreFactory func(ctx context.Context, collection string, ttl time.Duration) (ClaimStore, error)
+
+// newClaimStore opens claim stores for the request handlers; NewDedupStore unless replaced.
+var newClaimStore ClaimStoreFactory = func(ctx context.Context, collection string, ttl time.Duration) (ClaimStore, error) {
+	return NewDedupStore(ctx, collection, ttl)
+}
+
+// SetClaimStoreFactory replaces the Firestore-backed claim stores used for replay protection and
+// idempotency, e.g. with an in-memory store in tests. It is not safe to call while serving requests.
+func SetClaimStoreFactory(factory ClaimStoreFactory) {
+	newClaimStore = factory
+}
+
+// DedupStore records keys that have already been seen in a Firestore collection.
+// Claims use Create preconditions so they hold across function instances.
+type DedupStore struct {
+	client     *firestore.Client
+	collection string
+	ttl        time.Duration
+}
+
+// dedupRecord is the document stored for each claimed key.
+// ExpireAt is meant to back a Firestore TTL policy on the collection.
+type dedupRecord struct {
+	CreatedAt time.Time `firestore:"createdAt"`
+	ExpireAt  time.Time `firestore:"ExpireAt"`
+}
+
+// expired reports whether the claim no longer holds at now.
+func (r *dedupRecord) expired(now time.Time) bool {
+	return !r.ExpireAt.After(now)
+}
+
+// NewDedupStore creates a new dedup store backed by the given Firestore collection.
+func NewDedupStore(ctx context.Context, collection string, ttl time.Duration) (*DedupStore, error) {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DedupStore{client: client, collection: collection, ttl: ttl}, nil
+}
+
+// Claim records key and reports whether this call was the first to claim it.
+// Records past their expireAt are treated as absent, since Firestore TTL deletion is lazy.
+func (d *DedupStore) Claim(ctx context.Context, key string) (bool, error) {
+	now := time.Now().UTC()
+	record := dedupRecord{CreatedAt: now, ExpireAt: now.Add(d.ttl)}
+	ref := d.client.Collection(d.collection).Doc(key)
+
+	_, err := ref.Create(ctx, record)
+	if err == nil {
+		return true, nil
+	}
+	if status.Code(err) != codes.AlreadyExists {
+		return false, err
+	}
+
+	claimed := false
+	err = d.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			claimed = true
+			return tx.Create(ref, record)
+		}
+		if err != nil {
+			return err
+		}
+		var existing dedupRecord
+		if err := snap.DataTo(&existing); err != nil {
+			return err
+		}
+		if !existing.expired(now) {
+			return nil
+		}
+		claimed = true
+		return tx.Set(ref, record)
+	})
+	if err != nil {
+		return false, err
+	}
+	return claimed, nil
+}
+
+// Release removes the claim on key so a later delivery can claim it again.
+func (d *DedupStore) Release(ctx context.Context, key string) error {
+	_, err := d.client.Collection(d.collection).Doc(key).Delete(ctx)
+	return err
+}
+
+// Close releases the store. The Firestore client is shared by the instance and stays open.
+func (d *DedupStore) Close() error {
+	return nil
+}
+
+// getReplayTTL returns the replay protection window from REPLAY_TTL.
+func getReplayTTL() (time.Duration, error) {
+	value := os.Getenv("REPLAY_TTL")
+	if value == "" {
+		return defaultReplayTTL, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid REPLAY_TTL %q: %w", value, err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid REPLAY_TTL %q: must be positive", value)
+	}
+	return ttl, nil
+}
+
+// getIdempotencyTTL returns how long processed event IDs are remembered from IDEMPOTENCY_TTL.
+func getIdempotencyTTL() (time.Duration, error) {
+	value := os.Getenv("IDEMPOTENCY_TTL")
+	if value == "" {
+		return defaultIdempotencyTTL, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IDEMPOTENCY_TTL %q: %w", value, err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid IDEMPOTENCY_TTL %q: must be positive", value)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,56 @@
+package function
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupRecordExpired(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		expireAt time.Time
+		want     bool
+	}{
+		{"within the TTL", now.Add(time.Second), false},
+		{"at expiry", now, true},
+		{"past expiry, awaiting TTL deletion", now.Add(-time.Hour), true},
+		{"no expiry", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := dedupRecord{CreatedAt: now.Add(-time.Minute), ExpireAt: tt.expireAt}
+			if got := record.expired(now); got != tt.want {
+				t.Errorf("expired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimTTLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		get     func() (time.Duration, error)
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"replay default", "REPLAY_TTL", getReplayTTL, "", defaultReplayTTL, false},
+		{"replay", "REPLAY_TTL", getReplayTTL, "10m", 10 * time.Minute, false},
+		{"replay zero", "REPLAY_TTL", getReplayTTL, "0s", 0, true},
+		{"replay malformed", "REPLAY_TTL", getReplayTTL, "a day", 0, true},
+		{"idempotency default", "IDEMPOTENCY_TTL", getIdempotencyTTL, "", defaultIdempotencyTTL, false},
+		{"idempotency", "IDEMPOTENCY_TTL", getIdempotencyTTL, "48h", 48 * time.Hour, false},
+		{"idempotency negative", "IDEMPOTENCY_TTL", getIdempotencyTTL, "-1h", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			got, err := tt.get()
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
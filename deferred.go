@@ -0,0 +1,167 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	cloudtasks "google.golang.org/api/cloudtasks/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// cloudTasksMaxBody is the largest webhook body deferred; a task holds at most 1 MiB, headers
+// included, so larger webhooks are processed in the request instead.
+const cloudTasksMaxBody = 1<<20 - 16<<10
+
+func init() {
+	functions.HTTP("ProcessWebhookTask", ProcessWebhookTask)
+}
+
+// deferredProcessingEnabled reports whether ENABLE_DEFERRED_PROCESSING is set, making
+// AlchemyWebhook enqueue verified webhooks to Cloud Tasks for ProcessWebhookTask.
+func deferredProcessingEnabled() bool {
+	return os.Getenv("ENABLE_DEFERRED_PROCESSING") == "true"
+}
+
+// deferredTarget is where deferred webhooks are enqueued and delivered.
+type deferredTarget struct {
+	queue          string
+	url            string
+	serviceAccount string
+	audience       string
+}
+
+// getDeferredTarget reads the queue, CLOUD_TASKS_QUEUE, as
+// projects/<project>/locations/<location>/queues/<queue>, the URL of the ProcessWebhookTask
+// function, CLOUD_TASKS_URL, and the service account whose OIDC token authenticates tasks,
+// CLOUD_TASKS_SERVICE_ACCOUNT, with its audience CLOUD_TASKS_AUDIENCE, the URL by default.
+func getDeferredTarget() (*deferredTarget, error) {
+	target := &deferredTarget{
+		queue:          os.Getenv("CLOUD_TASKS_QUEUE"),
+		url:            os.Getenv("CLOUD_TASKS_URL"),
+		serviceAccount: os.Getenv("CLOUD_TASKS_SERVICE_ACCOUNT"),
+		audience:       os.Getenv("CLOUD_TASKS_AUDIENCE"),
+	}
+	parts := strings.Split(target.queue, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "queues" {
+		return nil, fmt.Errorf("invalid CLOUD_TASKS_QUEUE %q (want projects/<project>/locations/<location>/queues/<queue>)", target.queue)
+	}
+	if !strings.HasPrefix(target.url, "https://") {
+		return nil, fmt.Errorf("invalid CLOUD_TASKS_URL %q (want the https URL of ProcessWebhookTask)", target.url)
+	}
+	if target.serviceAccount == "" {
+		return nil, errors.New("CLOUD_TASKS_SERVICE_ACCOUNT must be set")
+	}
+	return target, nil
+}
+
+// deferWebhook verifies the signature of the request and enqueues its body, with the signature,
+// as a Cloud Tasks task for ProcessWebhookTask, answering 200 once the task is created. The task
+// is named after a digest of the body, so a webhook Alchemy redelivers while its task is still
+// known to the queue is not enqueued twice. Bodies too large for a task are processed in the
+// request by next.
+func deferWebhook(w http.ResponseWriter, r *http.Request, signingKey string, next http.HandlerFunc) {
+	target, err := getDeferredTarget()
+	if err != nil {
+		logError("invalid deferred processing configuration", err)
+		http.Error(w, "Server configuration error", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logError("failed to read request body", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	signature := r.Header.Get("x-alchemy-signature")
+	valid := verifySignature(body, signature, []byte(signingKey))
+	alertSignatureResult(r.Context(), valid)
+	if !valid {
+		logError("signature validation failed", nil)
+		http.Error(w, "Unauthorized", http.StatusForbidden)
+		return
+	}
+
+	if len(body) > cloudTasksMaxBody {
+		log.Printf(`{"level":"warn","message":"webhook too large to defer; processing it in the request","size":%d}`, len(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+		return
+	}
+
+	name, err := enqueueWebhook(r.Context(), target, body, signature)
+	if err != nil {
+		logError("failed to enqueue webhook", err)
+		http.Error(w, "Failed to enqueue webhook", http.StatusInternalServerError)
+		return
+	}
+	log.Printf(`{"level":"info","message":"webhook deferred to cloud tasks","task":"%s"}`, name)
+	w.WriteHeader(http.StatusOK)
+}
+
+// enqueueWebhook creates the task delivering body and signature to target, returning its name.
+// A task of the same name that already exists counts as created.
+func enqueueWebhook(ctx context.Context, target *deferredTarget, body []byte, signature string) (string, error) {
+	service, err := cloudTasksService(ctx)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	name := target.queue + "/tasks/" + hex.EncodeToString(sum[:])
+	audience := target.audience
+	if audience == "" {
+		audience = target.url
+	}
+	task := &cloudtasks.Task{
+		Name: name,
+		HttpRequest: &cloudtasks.HttpRequest{
+			Url:        target.url,
+			HttpMethod: http.MethodPost,
+			Headers: map[string]string{
+				"Content-Type":        "application/json",
+				"X-Alchemy-Signature": signature,
+			},
+			Body:      base64.StdEncoding.EncodeToString(body),
+			OidcToken: &cloudtasks.OidcToken{ServiceAccountEmail: target.serviceAccount, Audience: audience},
+		},
+	}
+	_, err = service.Projects.Locations.Queues.Tasks.Create(target.queue, &cloudtasks.CreateTaskRequest{Task: task}).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return name, nil
+	}
+	return name, err
+}
+
+// ProcessWebhookTask is the Cloud Run Function entrypoint that processes the webhooks
+// AlchemyWebhook deferred to Cloud Tasks, exactly as AlchemyWebhook processes them otherwise:
+// the signature is verified again and any failure responds non-2xx, for the queue to retry the
+// task. The queue's settings bound its concurrency and retries. Deploy it without
+// unauthenticated access, invokable by CLOUD_TASKS_SERVICE_ACCOUNT.
+func ProcessWebhookTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	signingKey, ok := loadWebhookConfig(w)
+	if !ok {
+		return
+	}
+	if name := r.Header.Get("X-CloudTasks-TaskName"); name != "" {
+		log.Printf(`{"level":"info","message":"processing deferred webhook","task":"%s","retry_count":"%s"}`,
+			name, r.Header.Get("X-CloudTasks-TaskRetryCount"))
+	}
+	processWebhook(w, r, signingKey)
+}
@@ -0,0 +1,123 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Features that can be shed under deadline pressure, named in SHED_ORDER.
+const (
+	FeatureEnrichment = "enrichment"
+	FeaturePubSub     = "pubsub"
+	FeatureFirestore  = "firestore"
+)
+
+const defaultShedMargin = 10 * time.Second
+
+// Shedder skips optional work when a request's deadline is close, in the configured order.
+// The feature shed first is skipped once less than the margin remains; each later feature
+// waits for a proportionally smaller remainder, so the last one is only shed at margin/N.
+type Shedder struct {
+	order    []string
+	margin   time.Duration
+	deadline time.Time
+}
+
+var (
+	shedCountsMu sync.Mutex
+	shedCounts   = map[string]int64{}
+)
+
+// getShedder returns the shedder configured by SHED_ORDER (comma-separated features, first shed
+// first) and SHED_MARGIN. The deadline is the request context's, or start plus REQUEST_TIMEOUT
+// when the context has none. It returns nil when shedding is disabled or no deadline is known.
+func getShedder(ctx context.Context, start time.Time) (*Shedder, error) {
+	value := os.Getenv("SHED_ORDER")
+	if value == "" {
+		return nil, nil
+	}
+
+	var order []string
+	for _, feature := range strings.Split(value, ",") {
+		feature = strings.TrimSpace(feature)
+		switch feature {
+		case FeatureEnrichment, FeaturePubSub, FeatureFirestore:
+			order = append(order, feature)
+		default:
+			return nil, fmt.Errorf("invalid SHED_ORDER feature %q (want %s, %s or %s)",
+				feature, FeatureEnrichment, FeaturePubSub, FeatureFirestore)
+		}
+	}
+
+	margin := defaultShedMargin
+	if value := os.Getenv("SHED_MARGIN"); value != "" {
+		var err error
+		margin, err = time.ParseDuration(value)
+		if err != nil || margin <= 0 {
+			return nil, fmt.Errorf("invalid SHED_MARGIN %q", value)
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		value := os.Getenv("REQUEST_TIMEOUT")
+		if value == "" {
+			return nil, nil
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid REQUEST_TIMEOUT %q", value)
+		}
+		deadline = start.Add(timeout)
+	}
+	return &Shedder{order: order, margin: margin, deadline: deadline}, nil
+}
+
+// Shed reports whether feature should be skipped for webhookID, logging and counting the shed.
+// Features outside SHED_ORDER are never shed.
+func (s *Shedder) Shed(feature, webhookID string) bool {
+	if s == nil {
+		return false
+	}
+	rank := -1
+	for i, f := range s.order {
+		if f == feature {
+			rank = i
+			break
+		}
+	}
+	if rank < 0 {
+		return false
+	}
+
+	remaining := time.Until(s.deadline)
+	threshold := s.margin * time.Duration(len(s.order)-rank) / time.Duration(len(s.order))
+	if remaining >= threshold {
+		return false
+	}
+
+	shedCountsMu.Lock()
+	shedCounts[feature]++
+	count := shedCounts[feature]
+	shedCountsMu.Unlock()
+	log.Printf(`{"level":"warn","message":"feature shed under deadline pressure","metric":"feature_shed","feature":"%s","webhook_id":"%s","remaining_ms":%d,"threshold_ms":%d,"shed_total":%d}`,
+		feature, webhookID, remaining.Milliseconds(), threshold.Milliseconds(), count)
+	countMetric("alchemy_feature_shed_total", 1, "feature", feature)
+	return true
+}
+
+// ShedCounts returns how many times each feature has been shed by this instance.
+func ShedCounts() map[string]int64 {
+	shedCountsMu.Lock()
+	defer shedCountsMu.Unlock()
+	counts := make(map[string]int64, len(shedCounts))
+	for feature, count := range shedCounts {
+		counts[feature] = count
+	}
+	return counts
+}
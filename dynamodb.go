@@ -0,0 +1,221 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// dynamoDBBatchSize is the most requests BatchWriteItem accepts.
+	dynamoDBBatchSize = 25
+	// defaultDynamoDBMaxAttempts gives a throttled table a few seconds to absorb the burst
+	// before the write fails and the webhook is retried.
+	defaultDynamoDBMaxAttempts = 8
+	defaultDynamoDBBackoff     = 50 * time.Millisecond
+	defaultDynamoDBMaxBackoff  = 5 * time.Second
+)
+
+// dynamoDBSink writes transfers, reverted ones included, to the DynamoDB table DYNAMODB_TABLE,
+// with the transfer's contract as partition key (pk) and its block number and log index as sort
+// key (sk), so a contract's transfers can be queried by block range. Items are put in
+// BatchWriteItem requests of up to 25, under their key, so redelivered transfers overwrite
+// themselves, and transfers removed by a reorg are deleted. Throttled requests and unprocessed
+// items are retried with jittered exponential backoff, up to DYNAMODB_MAX_ATTEMPTS attempts.
+type dynamoDBSink struct {
+	client  *dynamodb.Client
+	table   string
+	backoff RetryPolicy
+}
+
+func (*dynamoDBSink) Name() string { return sinkDynamoDB }
+
+// Init loads the AWS configuration from the default chain (environment, shared files or web
+// identity federation) and checks that the table exists. DYNAMODB_REGION overrides the region
+// and DYNAMODB_ENDPOINT the endpoint, as for DynamoDB Local.
+func (s *dynamoDBSink) Init(ctx context.Context) error {
+	table := os.Getenv("DYNAMODB_TABLE")
+	if table == "" {
+		return errors.New("DYNAMODB_TABLE must be set")
+	}
+	maxAttempts := envInt("DYNAMODB_MAX_ATTEMPTS", defaultDynamoDBMaxAttempts)
+	if maxAttempts < 1 {
+		return fmt.Errorf("invalid DYNAMODB_MAX_ATTEMPTS %d", maxAttempts)
+	}
+
+	var options []func(*awsconfig.LoadOptions) error
+	if region := os.Getenv("DYNAMODB_REGION"); region != "" {
+		options = append(options, awsconfig.WithRegion(region))
+	}
+	config, err := awsconfig.LoadDefaultConfig(context.WithoutCancel(ctx), options...)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	client := dynamodb.NewFromConfig(config, func(o *dynamodb.Options) {
+		if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.Retryer = retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = maxAttempts
+			o.MaxBackoff = defaultDynamoDBMaxBackoff
+		})
+	})
+	if _, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}); err != nil {
+		return fmt.Errorf("failed to describe DYNAMODB_TABLE %s: %w", table, err)
+	}
+
+	s.client, s.table = client, table
+	s.backoff = RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: defaultDynamoDBBackoff,
+		MaxBackoff:     defaultDynamoDBMaxBackoff,
+		Multiplier:     defaultSinkBackoffMultiplier,
+	}
+	return nil
+}
+
+// Write deletes the items of the webhook's transfer tombstones, then puts its transfers. The
+// deletes go in batches of their own, as a batch cannot touch an item twice, so a transfer
+// re-included after a reorg ends up stored.
+func (s *dynamoDBSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	deletes := newDynamoDBRequests()
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.Kind != KindTransfer || tombstone.transfer == nil {
+			continue
+		}
+		deletes.add(tombstone.transfer, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: dynamoDBKey(tombstone.transfer)}})
+	}
+	puts := newDynamoDBRequests()
+	for _, doc := range append(append([]*TransferDocument{}, parsed.Transfers...), parsed.Reverted...) {
+		item, err := newDynamoDBItem(doc)
+		if err != nil {
+			return err
+		}
+		puts.add(doc, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+	}
+
+	for _, requests := range [][]types.WriteRequest{deletes.requests, puts.requests} {
+		for start := 0; start < len(requests); start += dynamoDBBatchSize {
+			if err := s.batchWrite(ctx, requests[start:min(start+dynamoDBBatchSize, len(requests))]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dynamoDBRequests collects write requests, one per item: a request for an item already
+// collected replaces the earlier one, as a batch cannot touch an item twice.
+type dynamoDBRequests struct {
+	requests []types.WriteRequest
+	index    map[string]int
+}
+
+func newDynamoDBRequests() *dynamoDBRequests {
+	return &dynamoDBRequests{index: map[string]int{}}
+}
+
+func (r *dynamoDBRequests) add(doc *TransferDocument, request types.WriteRequest) {
+	key := doc.Transfer.Contract + "\x00" + dynamoDBSortKey(doc)
+	if i, ok := r.index[key]; ok {
+		r.requests[i] = request
+		return
+	}
+	r.index[key] = len(r.requests)
+	r.requests = append(r.requests, request)
+}
+
+// batchWrite sends requests in one BatchWriteItem request, then resends the items DynamoDB
+// left unprocessed, as it does when the table is throttled, after a jittered backoff.
+func (s *dynamoDBSink) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	for attempt := 1; ; attempt++ {
+		out, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{s.table: requests},
+		})
+		if err != nil {
+			return err
+		}
+		requests = out.UnprocessedItems[s.table]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == s.backoff.MaxAttempts {
+			return fmt.Errorf("%s left %d items unprocessed after %d attempts", sinkDynamoDB, len(requests), attempt)
+		}
+		if err := sleepContext(ctx, s.backoff.delay(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// dynamoDBKey returns the primary key of doc's item.
+func dynamoDBKey(doc *TransferDocument) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: doc.Transfer.Contract},
+		"sk": &types.AttributeValueMemberS{Value: dynamoDBSortKey(doc)},
+	}
+}
+
+// dynamoDBSortKey returns the sort key of doc: its block number and log index, zero-padded so
+// keys sort numerically, plus the batch index of ERC1155 batch entries. Native transfers have no
+// log and use their document ID instead of the log index.
+func dynamoDBSortKey(doc *TransferDocument) string {
+	switch {
+	case doc.isNative():
+		return fmt.Sprintf("%012d#%s", doc.Block.Number, doc.DocumentID())
+	case doc.Transfer.BatchIndex != nil:
+		return fmt.Sprintf("%012d#%06d#%04d", doc.Block.Number, doc.Transfer.LogIndex, *doc.Transfer.BatchIndex)
+	}
+	return fmt.Sprintf("%012d#%06d", doc.Block.Number, doc.Transfer.LogIndex)
+}
+
+// newDynamoDBItem returns the item of doc: its key, the fields transfers are usually filtered
+// by as attributes of their own, and the whole document as JSON in document. Values and token
+// IDs are decimal strings, as uint256 exceeds DynamoDB's 38 digits.
+func newDynamoDBItem(doc *TransferDocument) (map[string]types.AttributeValue, error) {
+	document, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transfer %s: %w", doc.DocumentID(), err)
+	}
+	item := dynamoDBKey(doc)
+	attributes := map[string]string{
+		"documentId": doc.DocumentID(),
+		"network":    doc.Network,
+		"txHash":     doc.Transaction.Hash,
+		"standard":   doc.Transfer.Standard,
+		"from":       doc.Transfer.From,
+		"to":         doc.Transfer.To,
+		"value":      bigIntString(doc.Transfer.Value),
+		"tokenId":    bigIntString(doc.Transfer.TokenID),
+		"finality":   doc.Finality,
+		"webhookId":  doc.Alchemy.WebhookID,
+		"eventId":    doc.Alchemy.EventID,
+		"document":   string(document),
+	}
+	for name, value := range attributes {
+		if value != "" {
+			item[name] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+	item["blockNumber"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(doc.Block.Number, 10)}
+	item["blockTimestamp"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(doc.blockTime().Unix(), 10)}
+	item["schemaVersion"] = &types.AttributeValueMemberN{Value: strconv.Itoa(doc.SchemaVersion)}
+	item["reverted"] = &types.AttributeValueMemberBOOL{Value: doc.Reverted}
+	return item, nil
+}
+
+// Close drops the client, which holds no connections that need closing.
+func (s *dynamoDBSink) Close() error {
+	s.client = nil
+	return nil
+}
@@ -0,0 +1,247 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultElasticsearchIndex = "alchemy-transfers"
+	// defaultElasticsearchBulkSize keeps bulk requests within a few megabytes, the size both
+	// Elasticsearch and OpenSearch index most efficiently.
+	defaultElasticsearchBulkSize = 1000
+)
+
+// elasticsearchAddressFields are the address fields mapped as lowercased keywords, so searches
+// match regardless of checksum casing.
+var elasticsearchAddressFields = []string{
+	"transaction.from", "transaction.to", "transaction.createdContract",
+	"transfer.contract", "transfer.operator", "transfer.from", "transfer.to",
+}
+
+// elasticsearchDocument is the indexed source of a transfer: the document as published, plus
+// @timestamp, the block time for Kibana data views, and valueScaled, the value in whole tokens.
+type elasticsearchDocument struct {
+	*TransferDocument
+	Timestamp   time.Time `json:"@timestamp"`
+	ValueScaled *float64  `json:"valueScaled,omitempty"`
+}
+
+// elasticsearchSink bulk-indexes transfers, reverted ones included, into ELASTICSEARCH_INDEX
+// through the REST API at ELASTICSEARCH_URL, in bulk requests of up to ELASTICSEARCH_BULK_SIZE
+// actions. Transfers are indexed under their document ID, so redelivered transfers overwrite
+// themselves, and transfers removed by a reorg are deleted. The same API is served by
+// Elasticsearch 7.8 or later and OpenSearch.
+type elasticsearchSink struct {
+	url      string
+	index    string
+	apiKey   string
+	username string
+	password string
+	bulkSize int
+}
+
+func (*elasticsearchSink) Name() string { return sinkElasticsearch }
+
+// Init reads the connection configuration and puts the index template, unless
+// ELASTICSEARCH_CREATE_TEMPLATE is false.
+func (s *elasticsearchSink) Init(ctx context.Context) error {
+	s.url = strings.TrimSuffix(os.Getenv("ELASTICSEARCH_URL"), "/")
+	if s.url == "" {
+		return errors.New("ELASTICSEARCH_URL must be set")
+	}
+	s.index = os.Getenv("ELASTICSEARCH_INDEX")
+	if s.index == "" {
+		s.index = defaultElasticsearchIndex
+	}
+	s.apiKey = os.Getenv("ELASTICSEARCH_API_KEY")
+	s.username = os.Getenv("ELASTICSEARCH_USERNAME")
+	s.password = os.Getenv("ELASTICSEARCH_PASSWORD")
+	s.bulkSize = max(envInt("ELASTICSEARCH_BULK_SIZE", defaultElasticsearchBulkSize), 1)
+
+	if os.Getenv("ELASTICSEARCH_CREATE_TEMPLATE") == "false" {
+		return nil
+	}
+	template, err := json.Marshal(elasticsearchIndexTemplate(s.index))
+	if err != nil {
+		return err
+	}
+	_, err = s.do(ctx, http.MethodPut, "/_index_template/"+s.index, "application/json", template)
+	return err
+}
+
+// elasticsearchIndexTemplate returns the composable index template of index. Strings are
+// keywords, addresses lowercased, so transfers can be filtered and aggregated by address and
+// token. Values and token IDs stay exact keywords, as uint256 exceeds every numeric type, and
+// valueScaled carries the value as a double for range queries and charts. Raw logs are kept in
+// the source but not indexed.
+func elasticsearchIndexTemplate(index string) map[string]any {
+	properties := map[string]any{
+		"@timestamp":       map[string]any{"type": "date"},
+		"valueScaled":      map[string]any{"type": "double"},
+		"amountPercentile": map[string]any{"type": "double"},
+		"confirmedAt":      map[string]any{"type": "date"},
+		"rawLog":           map[string]any{"type": "object", "enabled": false},
+		"block": map[string]any{"properties": map[string]any{
+			"number":    map[string]any{"type": "long"},
+			"timestamp": map[string]any{"type": "date", "format": "epoch_second"},
+		}},
+	}
+	for _, field := range elasticsearchAddressFields {
+		object, name, _ := strings.Cut(field, ".")
+		parent, ok := properties[object].(map[string]any)
+		if !ok {
+			parent = map[string]any{"properties": map[string]any{}}
+			properties[object] = parent
+		}
+		parent["properties"].(map[string]any)[name] = map[string]any{"type": "keyword", "normalizer": "lowercase"}
+	}
+
+	return map[string]any{
+		"index_patterns": []string{index},
+		"template": map[string]any{
+			"settings": map[string]any{
+				"analysis": map[string]any{
+					"normalizer": map[string]any{
+						"lowercase": map[string]any{"type": "custom", "filter": []string{"lowercase"}},
+					},
+				},
+			},
+			"mappings": map[string]any{
+				"dynamic_templates": []any{
+					map[string]any{"strings": map[string]any{
+						"match_mapping_type": "string",
+						"mapping":            map[string]any{"type": "keyword"},
+					}},
+				},
+				"properties": properties,
+			},
+		},
+	}
+}
+
+// Write deletes the webhook's transfer tombstones, then indexes its transfers, so a transfer
+// re-included after a reorg ends up indexed.
+func (s *elasticsearchSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	var actions [][]byte
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.Kind != KindTransfer {
+			continue
+		}
+		action, err := json.Marshal(map[string]any{"delete": map[string]string{"_index": s.index, "_id": tombstone.DocumentID()}})
+		if err != nil {
+			return err
+		}
+		actions = append(actions, append(action, '\n'))
+	}
+	for _, doc := range append(append([]*TransferDocument{}, parsed.Transfers...), parsed.Reverted...) {
+		action, err := json.Marshal(map[string]any{"index": map[string]string{"_index": s.index, "_id": doc.DocumentID()}})
+		if err != nil {
+			return err
+		}
+		source, err := json.Marshal(newElasticsearchDocument(doc))
+		if err != nil {
+			return fmt.Errorf("failed to marshal transfer %s: %w", doc.DocumentID(), err)
+		}
+		actions = append(actions, append(append(append(action, '\n'), source...), '\n'))
+	}
+
+	for start := 0; start < len(actions); start += s.bulkSize {
+		body := bytes.Join(actions[start:min(start+s.bulkSize, len(actions))], nil)
+		if err := s.bulk(ctx, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newElasticsearchDocument returns the indexed source of doc.
+func newElasticsearchDocument(doc *TransferDocument) *elasticsearchDocument {
+	indexed := &elasticsearchDocument{TransferDocument: doc, Timestamp: doc.blockTime()}
+	if doc.Transfer.Value != nil {
+		value := new(big.Float).SetInt(doc.Transfer.Value)
+		if decimals := transferDecimals(doc); decimals > 0 {
+			value.Quo(value, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+		}
+		scaled, _ := value.Float64()
+		indexed.ValueScaled = &scaled
+	}
+	return indexed
+}
+
+// bulk sends body to the bulk API and returns the first failed action's error. Deleting a
+// document that does not exist is not a failure.
+func (s *elasticsearchSink) bulk(ctx context.Context, body []byte) error {
+	data, err := s.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("failed to decode %s bulk response: %w", sinkElasticsearch, err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	for _, item := range resp.Items {
+		for action, result := range item {
+			if result.Error == nil || (action == "delete" && result.Status == http.StatusNotFound) {
+				continue
+			}
+			return fmt.Errorf("%s failed to %s %s with status %d: %s", sinkElasticsearch, action, result.ID, result.Status, result.Error)
+		}
+	}
+	return nil
+}
+
+// do sends a request to path and returns the response body. Requests go through the
+// "elasticsearch" ProviderClient, which retries transport errors, 429 and 5xx responses;
+// retried bulk requests index the same documents under the same IDs.
+func (s *elasticsearchSink) do(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case s.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	case s.username != "":
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := ProviderFor(sinkElasticsearch).Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 1024 {
+			data = data[:1024]
+		}
+		return nil, fmt.Errorf("%s responded with status %d: %s", sinkElasticsearch, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// Close is a no-op, as the sink holds no connections of its own.
+func (*elasticsearchSink) Close() error { return nil }
@@ -0,0 +1,468 @@
+package function
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+)
+
+// encryptedPrefix marks encrypted field values. The rest of the value is the base64url encoding
+// of the wrapped data key's length (two bytes, big-endian), the wrapped data key, the GCM nonce
+// and the sealed value.
+const encryptedPrefix = "enc:v1:"
+
+const (
+	defaultEncryptionKeyRotation = 24 * time.Hour
+	// maxUnwrappedDataKeys bounds the data keys kept after unwrapping; a reader going through
+	// documents of many instances and rotations unwraps the older ones again.
+	maxUnwrappedDataKeys = 256
+)
+
+var (
+	fieldEncryptorOnce sync.Once
+	fieldEncryptor     *FieldEncryptor
+	fieldEncryptorErr  error
+
+	unwrappedKeysMu sync.Mutex
+	unwrappedKeys   = make(map[string]cipher.AEAD)
+)
+
+// FieldEncryptor encrypts selected document fields before they reach selected sinks, using
+// envelope encryption: values are sealed with AES-256-GCM under a data key generated by the
+// instance, and each value carries that data key wrapped by the Cloud KMS key, so it can be
+// decrypted anywhere the KMS key can be used, without a key store. The field path is bound to
+// the value as additional data, so an encrypted value copied to another field does not decrypt.
+type FieldEncryptor struct {
+	keyName  string
+	fields   [][]string
+	sinks    map[string]bool
+	rotation time.Duration
+
+	mu      sync.Mutex
+	current *dataKey
+}
+
+// dataKey is a data key ready to seal values, with its KMS-wrapped form.
+type dataKey struct {
+	aead    cipher.AEAD
+	wrapped []byte
+	created time.Time
+}
+
+// LoadFieldEncryptor returns the encryptor for the sinks listed in ENCRYPT_SINKS
+// (comma-separated), encrypting the string fields listed in ENCRYPT_FIELDS (comma-separated
+// dotted JSON paths, such as fromLabel or attribution.from.entity) under the Cloud KMS key
+// ENCRYPT_KMS_KEY (projects/…/locations/…/keyRings/…/cryptoKeys/…). A new data key is wrapped
+// every ENCRYPT_KEY_ROTATION (default 24h). It is built once per instance and is nil when no
+// sinks are configured.
+func LoadFieldEncryptor() (*FieldEncryptor, error) {
+	fieldEncryptorOnce.Do(func() {
+		fieldEncryptor, fieldEncryptorErr = newFieldEncryptor()
+	})
+	return fieldEncryptor, fieldEncryptorErr
+}
+
+func newFieldEncryptor() (*FieldEncryptor, error) {
+	sinks := parseList(os.Getenv("ENCRYPT_SINKS"))
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	fields := parseList(os.Getenv("ENCRYPT_FIELDS"))
+	if len(fields) == 0 {
+		return nil, errors.New("ENCRYPT_SINKS requires ENCRYPT_FIELDS")
+	}
+	keyName := os.Getenv("ENCRYPT_KMS_KEY")
+	if keyName == "" {
+		return nil, errors.New("ENCRYPT_KMS_KEY environment variable is not set")
+	}
+
+	e := &FieldEncryptor{
+		keyName:  keyName,
+		sinks:    make(map[string]bool, len(sinks)),
+		rotation: envDuration("ENCRYPT_KEY_ROTATION", defaultEncryptionKeyRotation),
+	}
+	for _, sink := range sinks {
+		e.sinks[sink] = true
+	}
+	for _, field := range fields {
+		e.fields = append(e.fields, strings.Split(field, "."))
+	}
+	return e, nil
+}
+
+// Apply returns a copy of parsed with the configured fields of every document kind encrypted
+// when sink is configured, or parsed unchanged otherwise. Everything else, such as tombstones,
+// quarantined and raw logs and parse errors, is passed through: it carries no enriched fields.
+func (e *FieldEncryptor) Apply(ctx context.Context, sink string, parsed *ParsedWebhook) (*ParsedWebhook, error) {
+	if e == nil || !e.sinks[sink] {
+		return parsed, nil
+	}
+	copied := *parsed
+	out := &copied
+	var err error
+	if out.Transfers, err = encryptDocuments(ctx, e, parsed.Transfers); err != nil {
+		return nil, err
+	}
+	if out.Reverted, err = encryptDocuments(ctx, e, parsed.Reverted); err != nil {
+		return nil, err
+	}
+	if out.Events, err = encryptDocuments(ctx, e, parsed.Events); err != nil {
+		return nil, err
+	}
+	if out.Transactions, err = encryptDocuments(ctx, e, parsed.Transactions); err != nil {
+		return nil, err
+	}
+	if out.Approvals, err = encryptDocuments(ctx, e, parsed.Approvals); err != nil {
+		return nil, err
+	}
+	if out.Swaps, err = encryptDocuments(ctx, e, parsed.Swaps); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Transfers returns copies of transfers with the configured fields encrypted when sink is
+// configured, or transfers unchanged otherwise.
+func (e *FieldEncryptor) Transfers(ctx context.Context, sink string, transfers []*TransferDocument) ([]*TransferDocument, error) {
+	if e == nil || !e.sinks[sink] {
+		return transfers, nil
+	}
+	return encryptDocuments(ctx, e, transfers)
+}
+
+// encryptDocuments returns copies of docs with the configured fields encrypted. Only the
+// structs, maps and slices on the way to an encrypted field are copied.
+func encryptDocuments[T any](ctx context.Context, e *FieldEncryptor, docs []*T) ([]*T, error) {
+	if len(docs) == 0 {
+		return docs, nil
+	}
+	key, err := e.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*T, 0, len(docs))
+	for _, doc := range docs {
+		copied := *doc
+		for _, path := range e.fields {
+			field := strings.Join(path, ".")
+			err := rewriteField(reflect.ValueOf(&copied).Elem(), path, func(value string) (string, error) {
+				return key.seal(field, value)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt %s: %w", field, err)
+			}
+		}
+		out = append(out, &copied)
+	}
+	return out, nil
+}
+
+// dataKey returns the current data key, generating and wrapping a new one with Cloud KMS when
+// there is none or it is older than the rotation period.
+func (e *FieldEncryptor) dataKey(ctx context.Context) (*dataKey, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.current != nil && time.Since(e.current.created) < e.rotation {
+		return e.current, nil
+	}
+
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+	client, err := kmsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Encrypt(ctx, &kmspb.EncryptRequest{Name: e.keyName, Plaintext: plaintext})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	aead, err := newDataKeyAEAD(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	cacheUnwrappedKey(resp.Ciphertext, aead)
+	e.current = &dataKey{aead: aead, wrapped: resp.Ciphertext, created: time.Now()}
+	return e.current, nil
+}
+
+// seal returns the encrypted form of the value of field. Empty values stay empty.
+func (k *dataKey) seal(field, value string) (string, error) {
+	if value == "" || strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := binary.BigEndian.AppendUint16(nil, uint16(len(k.wrapped)))
+	data = append(data, k.wrapped...)
+	data = append(data, nonce...)
+	data = k.aead.Seal(data, nonce, []byte(value), []byte(field))
+	return encryptedPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecryptFields decrypts, in place, every encrypted field of v: a document, a slice of
+// documents or a MessageEnvelope, decoded from any sink the encryptor wrote to. Data keys are
+// unwrapped with ENCRYPT_KMS_KEY, which needs the Cloud KMS decrypter role, and kept for reuse,
+// so a batch sealed under one data key costs a single KMS call. Values that are not encrypted
+// are left as is, so it can be applied to every document read.
+func DecryptFields(ctx context.Context, v any) error {
+	if envelope, ok := v.(*MessageEnvelope); ok {
+		for _, docs := range []any{envelope.Transfers, envelope.Events, envelope.Approvals, envelope.Swaps, envelope.Transactions} {
+			if err := DecryptFields(ctx, docs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return decryptValue(ctx, reflect.ValueOf(v), "")
+}
+
+// decryptValue decrypts the encrypted strings within v, whose dotted JSON path is field.
+func decryptValue(ctx context.Context, v reflect.Value, field string) error {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface && v.CanSet() {
+			elem := reflect.New(v.Elem().Type()).Elem()
+			elem.Set(v.Elem())
+			if err := decryptValue(ctx, elem, field); err != nil {
+				return err
+			}
+			v.Set(elem)
+			return nil
+		}
+		return decryptValue(ctx, v.Elem(), field)
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			name, ok := jsonFieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			if err := decryptValue(ctx, v.Field(i), joinField(field, name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			if err := decryptValue(ctx, v.Index(i), field); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(iter.Value().Type()).Elem()
+			elem.Set(iter.Value())
+			if err := decryptValue(ctx, elem, joinField(field, iter.Key().String())); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	case reflect.String:
+		if !v.CanSet() || !strings.HasPrefix(v.String(), encryptedPrefix) {
+			return nil
+		}
+		value, err := openField(ctx, field, v.String())
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", field, err)
+		}
+		v.SetString(value)
+	}
+	return nil
+}
+
+// openField returns the plaintext of the encrypted value of field.
+func openField(ctx context.Context, field, value string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(data) < 2 {
+		return "", errors.New("malformed encrypted value")
+	}
+	size := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+size {
+		return "", errors.New("malformed encrypted value")
+	}
+	wrapped, sealed := data[2:2+size], data[2+size:]
+	aead, err := unwrapDataKey(ctx, wrapped)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// unwrapDataKey returns the data key of its wrapped form, unwrapping it with ENCRYPT_KMS_KEY
+// unless it was used or unwrapped by this instance before.
+func unwrapDataKey(ctx context.Context, wrapped []byte) (cipher.AEAD, error) {
+	unwrappedKeysMu.Lock()
+	aead, ok := unwrappedKeys[string(wrapped)]
+	unwrappedKeysMu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	keyName := os.Getenv("ENCRYPT_KMS_KEY")
+	if keyName == "" {
+		return nil, errors.New("ENCRYPT_KMS_KEY environment variable is not set")
+	}
+	client, err := kmsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Decrypt(ctx, &kmspb.DecryptRequest{Name: keyName, Ciphertext: wrapped})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	if aead, err = newDataKeyAEAD(resp.Plaintext); err != nil {
+		return nil, err
+	}
+	cacheUnwrappedKey(wrapped, aead)
+	return aead, nil
+}
+
+// cacheUnwrappedKey keeps aead for the data key wrapped as wrapped, starting over when the
+// cache is full.
+func cacheUnwrappedKey(wrapped []byte, aead cipher.AEAD) {
+	unwrappedKeysMu.Lock()
+	defer unwrappedKeysMu.Unlock()
+	if len(unwrappedKeys) >= maxUnwrappedDataKeys {
+		clear(unwrappedKeys)
+	}
+	unwrappedKeys[string(wrapped)] = aead
+}
+
+func newDataKeyAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// rewriteField replaces each non-empty string at path within v, an addressable value, with
+// the result of rewrite. Pointers, maps and slices on the way are replaced by copies, so
+// documents sharing them with v are left unchanged. Slices apply the path to each element.
+func rewriteField(v reflect.Value, path []string, rewrite func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		elem.Elem().Set(v.Elem())
+		v.Set(elem)
+		return rewriteField(elem.Elem(), path, rewrite)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := rewriteField(elem, path, rewrite); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		if len(path) == 0 {
+			return nil
+		}
+		t := v.Type()
+		for i := range t.NumField() {
+			if name, ok := jsonFieldName(t.Field(i)); ok && name == path[0] {
+				return rewriteField(v.Field(i), path[1:], rewrite)
+			}
+		}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		elems := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(elems, v)
+		v.Set(elems)
+		for i := range elems.Len() {
+			if err := rewriteField(elems.Index(i), path, rewrite); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if len(path) == 0 || v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		key := reflect.ValueOf(path[0]).Convert(v.Type().Key())
+		value := v.MapIndex(key)
+		if !value.IsValid() {
+			return nil
+		}
+		entries := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries.SetMapIndex(iter.Key(), iter.Value())
+		}
+		v.Set(entries)
+		elem := reflect.New(value.Type()).Elem()
+		elem.Set(value)
+		if err := rewriteField(elem, path[1:], rewrite); err != nil {
+			return err
+		}
+		entries.SetMapIndex(key, elem)
+	case reflect.String:
+		if len(path) > 0 || v.String() == "" {
+			return nil
+		}
+		value, err := rewrite(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(value)
+	}
+	return nil
+}
+
+// jsonFieldName returns the JSON name of an exported struct field, and false for unexported
+// and skipped fields.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+	return name, true
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
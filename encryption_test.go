@@ -0,0 +1,186 @@
+package function
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDataKey returns a data key wrapped as wrapped, cached as if unwrapped by KMS.
+func newTestDataKey(t *testing.T, wrapped string) *dataKey {
+	t.Helper()
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newDataKeyAEAD(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheUnwrappedKey([]byte(wrapped), aead)
+	return &dataKey{aead: aead, wrapped: []byte(wrapped), created: time.Now()}
+}
+
+// wrappedKeyOf returns the wrapped data key an encrypted value was sealed under.
+func wrappedKeyOf(t *testing.T, value string) string {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(data) < 2 {
+		t.Fatalf("malformed encrypted value %q", value)
+	}
+	return string(data[2 : 2+binary.BigEndian.Uint16(data)])
+}
+
+func newTestEncryptor(t *testing.T, fields ...string) *FieldEncryptor {
+	t.Helper()
+	e := &FieldEncryptor{keyName: "test", sinks: map[string]bool{sinkBigQuery: true}, rotation: time.Hour, current: newTestDataKey(t, "key-a")}
+	for _, field := range fields {
+		e.fields = append(e.fields, strings.Split(field, "."))
+	}
+	return e
+}
+
+func TestFieldEncryptorRoundTrip(t *testing.T) {
+	const (
+		owner   = "0x1111111111111111111111111111111111111111"
+		spender = "0x2222222222222222222222222222222222222222"
+	)
+	ctx := context.Background()
+	e := newTestEncryptor(t, "fromLabel", "attribution.from.entity", "event.fields.owner", "event.fields.path", "event.fields.order.maker")
+
+	transfer := &TransferDocument{
+		FromLabel:   "Treasury",
+		ToLabel:     "Exchange",
+		Attribution: &Attribution{From: &Entity{Name: "Acme", Category: "fund"}, To: &Entity{Name: "Binance", Category: "exchange"}},
+	}
+	eventFields := func() map[string]any {
+		return map[string]any{
+			"owner":  owner,
+			"amount": "42",
+			"path":   []any{owner, spender},
+			"order":  map[string]any{"maker": spender, "taker": owner},
+		}
+	}
+	event := &EventDocument{Event: DecodedEvent{Fields: eventFields()}}
+	parsed := &ParsedWebhook{Transfers: []*TransferDocument{transfer}, Events: []*EventDocument{event}}
+	wantTransfer := *transfer
+	wantAttribution, wantFrom := *transfer.Attribution, *transfer.Attribution.From
+
+	out, err := e.Apply(ctx, sinkBigQuery, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotTransfer, gotEvent := out.Transfers[0], out.Events[0]
+	fields := gotEvent.Event.Fields
+	for name, value := range map[string]any{
+		"fromLabel":                gotTransfer.FromLabel,
+		"attribution.from.entity":  gotTransfer.Attribution.From.Name,
+		"event.fields.owner":       fields["owner"],
+		"event.fields.path[0]":     fields["path"].([]any)[0],
+		"event.fields.path[1]":     fields["path"].([]any)[1],
+		"event.fields.order.maker": fields["order"].(map[string]any)["maker"],
+	} {
+		if s, _ := value.(string); !strings.HasPrefix(s, encryptedPrefix) {
+			t.Errorf("%s = %v, want it encrypted", name, value)
+		}
+	}
+	for name, value := range map[string]any{
+		"toLabel":                   gotTransfer.ToLabel,
+		"attribution.from.category": gotTransfer.Attribution.From.Category,
+		"attribution.to.entity":     gotTransfer.Attribution.To.Name,
+		"event.fields.amount":       fields["amount"],
+		"event.fields.order.taker":  fields["order"].(map[string]any)["taker"],
+	} {
+		if s, _ := value.(string); strings.HasPrefix(s, encryptedPrefix) {
+			t.Errorf("%s is encrypted, want it unchanged", name)
+		}
+	}
+
+	if transfer.FromLabel != wantTransfer.FromLabel || *transfer.Attribution != wantAttribution || *transfer.Attribution.From != wantFrom {
+		t.Error("Apply changed the shared transfer")
+	}
+	if !reflect.DeepEqual(event.Event.Fields, eventFields()) {
+		t.Error("Apply changed the shared event")
+	}
+
+	if err := DecryptFields(ctx, out.Transfers); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecryptFields(ctx, out.Events); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotTransfer, transfer) {
+		t.Errorf("decrypted transfer = %+v, want %+v", gotTransfer, transfer)
+	}
+	if !reflect.DeepEqual(gotEvent, event) {
+		t.Errorf("decrypted event fields = %v, want %v", gotEvent.Event.Fields, event.Event.Fields)
+	}
+}
+
+func TestFieldEncryptorKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("ENCRYPT_KMS_KEY", "")
+	e := newTestEncryptor(t, "fromLabel")
+	first := e.current
+	if key, err := e.dataKey(ctx); err != nil || key != first {
+		t.Fatalf("dataKey = %v, %v, want the current key within the rotation period", key, err)
+	}
+
+	before, err := e.Transfers(ctx, sinkBigQuery, []*TransferDocument{{FromLabel: "Treasury"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A rotation replaces the current data key; values sealed before keep the one they carry.
+	e.current = newTestDataKey(t, "key-b")
+	after, err := e.Transfers(ctx, sinkBigQuery, []*TransferDocument{{FromLabel: "Treasury"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := wrappedKeyOf(t, before[0].FromLabel); got != "key-a" {
+		t.Errorf("value sealed before the rotation carries %q, want key-a", got)
+	}
+	if got := wrappedKeyOf(t, after[0].FromLabel); got != "key-b" {
+		t.Errorf("value sealed after the rotation carries %q, want key-b", got)
+	}
+	for _, docs := range [][]*TransferDocument{before, after} {
+		if err := DecryptFields(ctx, docs); err != nil {
+			t.Fatal(err)
+		}
+		if docs[0].FromLabel != "Treasury" {
+			t.Errorf("decrypted fromLabel = %q, want Treasury", docs[0].FromLabel)
+		}
+	}
+
+	// Without the cached data key the value must be unwrapped with KMS, here unconfigured.
+	sealed, err := e.Transfers(ctx, sinkBigQuery, []*TransferDocument{{FromLabel: "Treasury"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrappedKeysMu.Lock()
+	clear(unwrappedKeys)
+	unwrappedKeysMu.Unlock()
+	if err := DecryptFields(ctx, sealed); err == nil {
+		t.Error("decrypted a value whose data key is neither cached nor unwrappable")
+	}
+}
+
+func TestFieldEncryptorBindsFieldPath(t *testing.T) {
+	ctx := context.Background()
+	e := newTestEncryptor(t, "fromLabel")
+	out, err := e.Transfers(ctx, sinkBigQuery, []*TransferDocument{{FromLabel: "Treasury"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := []*TransferDocument{{ToLabel: out[0].FromLabel}}
+	if err := DecryptFields(ctx, moved); err == nil {
+		t.Error("decrypted a value copied to another field")
+	}
+	if got, err := e.Transfers(ctx, sinkFirestore, []*TransferDocument{{FromLabel: "Treasury"}}); err != nil || got[0].FromLabel != "Treasury" {
+		t.Errorf("unconfigured sink got %v, %v, want the transfer unchanged", got, err)
+	}
+}
@@ -0,0 +1,100 @@
+package function
+
+import (
+	"context"
+	"sync"
+)
+
+// Enricher adds derived data to transfer documents before they are sent to sinks.
+type Enricher interface {
+	Name() string
+	Enrich(ctx context.Context, transfers []*TransferDocument) error
+}
+
+var (
+	enrichersOnce sync.Once
+	enrichers     []Enricher
+	enrichersErr  error
+
+	customEnrichersMu sync.Mutex
+	customEnrichers   []Enricher
+)
+
+// RegisterEnricher adds enricher after the enrichers enabled by configuration. It must be called
+// before the first webhook is processed, typically from an init function.
+func RegisterEnricher(enricher Enricher) {
+	customEnrichersMu.Lock()
+	defer customEnrichersMu.Unlock()
+	customEnrichers = append(customEnrichers, enricher)
+}
+
+// LoadEnrichers returns the enrichers enabled by configuration, built once per instance.
+func LoadEnrichers() ([]Enricher, error) {
+	enrichersOnce.Do(func() {
+		enrichers, enrichersErr = loadEnrichers()
+	})
+	return enrichers, enrichersErr
+}
+
+func loadEnrichers() ([]Enricher, error) {
+	var loaded []Enricher
+
+	attribution, err := NewAttributionEnricher()
+	if err != nil {
+		return nil, err
+	}
+	if attribution != nil {
+		loaded = append(loaded, attribution)
+	}
+
+	tokens, err := NewTokenMetadataEnricher()
+	if err != nil {
+		return nil, err
+	}
+	if tokens != nil {
+		loaded = append(loaded, tokens)
+	}
+
+	labels, err := NewLabelEnricher(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if labels != nil {
+		loaded = append(loaded, labels)
+	}
+
+	ens, err := NewENSEnricher()
+	if err != nil {
+		return nil, err
+	}
+	if ens != nil {
+		loaded = append(loaded, ens)
+	}
+
+	percentile, err := NewAmountPercentileEnricher()
+	if err != nil {
+		return nil, err
+	}
+	if percentile != nil {
+		loaded = append(loaded, percentile)
+	}
+
+	customEnrichersMu.Lock()
+	loaded = append(loaded, customEnrichers...)
+	customEnrichersMu.Unlock()
+
+	return loaded, nil
+}
+
+// enrichTransfers runs each enricher over transfers. Enrichment is best effort:
+// failures are logged and the documents are still sent to sinks.
+func enrichTransfers(ctx context.Context, enrichers []Enricher, transfers []*TransferDocument) {
+	if len(transfers) == 0 {
+		return
+	}
+	for _, enricher := range enrichers {
+		if err := enricher.Enrich(ctx, transfers); err != nil {
+			logError("failed to enrich transfers with "+enricher.Name(), err)
+		}
+	}
+}
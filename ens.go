@@ -0,0 +1,162 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// ensRegistryAddress is the ENS registry on Ethereum mainnet.
+	ensRegistryAddress = "0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e"
+	// ensNetwork is the network names are resolved on, whatever the transfer's network.
+	ensNetwork         = "ETH_MAINNET"
+	defaultENSCacheTTL = time.Hour
+)
+
+// ENS registry and resolver selectors.
+var (
+	resolverSelector = hexutil.MustDecode("0x0178b8bf") // resolver(bytes32)
+	ensNameSelector  = hexutil.MustDecode("0x691f3431") // name(bytes32)
+	ensAddrSelector  = hexutil.MustDecode("0x3b3b57de") // addr(bytes32)
+)
+
+// ensCacheEntry is a resolved name, or "" for an address without a primary name.
+type ensCacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+// ENSEnricher sets the primary ENS names of transfer senders and recipients, resolved over RPC.
+// Results, including addresses without a name, are cached per instance for ENS_CACHE_TTL.
+type ENSEnricher struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	cache map[string]ensCacheEntry
+}
+
+// NewENSEnricher returns an ENS enricher when ENABLE_ENS is set, reading from the ETH_MAINNET
+// RPC endpoints. It returns nil when ENS resolution is disabled.
+func NewENSEnricher() (*ENSEnricher, error) {
+	if os.Getenv("ENABLE_ENS") != "true" {
+		return nil, nil
+	}
+	if len(rpcEndpointURLs(ensNetwork)) == 0 {
+		return nil, errors.New("ENABLE_ENS requires ALCHEMY_RPC_URL or RPC_ENDPOINTS_ETH_MAINNET")
+	}
+	return &ENSEnricher{
+		ttl:   envDuration("ENS_CACHE_TTL", defaultENSCacheTTL),
+		cache: make(map[string]ensCacheEntry),
+	}, nil
+}
+
+// Name returns the enricher name.
+func (e *ENSEnricher) Name() string {
+	return "ens"
+}
+
+// Enrich sets FromENS and ToENS on transfers whose addresses have a primary ENS name.
+func (e *ENSEnricher) Enrich(ctx context.Context, transfers []*TransferDocument) error {
+	for _, transfer := range transfers {
+		from, err := e.lookup(ctx, transfer.Transfer.From)
+		if err != nil {
+			return err
+		}
+		to, err := e.lookup(ctx, transfer.Transfer.To)
+		if err != nil {
+			return err
+		}
+		transfer.FromENS = from
+		transfer.ToENS = to
+	}
+	return nil
+}
+
+// lookup returns the cached primary name of address, resolving it when absent or expired.
+func (e *ENSEnricher) lookup(ctx context.Context, address string) (string, error) {
+	if !common.IsHexAddress(address) || common.HexToAddress(address) == (common.Address{}) {
+		return "", nil
+	}
+	key := strings.ToLower(address)
+
+	e.mu.Lock()
+	entry, ok := e.cache[key]
+	e.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.name, nil
+	}
+
+	name, err := reverseResolveENS(ctx, common.HexToAddress(address))
+	if err != nil {
+		return "", err
+	}
+	e.mu.Lock()
+	e.cache[key] = ensCacheEntry{name: name, expires: time.Now().Add(e.ttl)}
+	e.mu.Unlock()
+	return name, nil
+}
+
+// reverseResolveENS returns the primary name of address from its <addr>.addr.reverse record. The name
+// is only returned when it resolves forward to the same address, as ENS requires for primary names.
+func reverseResolveENS(ctx context.Context, address common.Address) (string, error) {
+	reverseNode := ensNamehash(strings.ToLower(address.Hex()[2:]) + ".addr.reverse")
+	resolver, err := ensResolver(ctx, reverseNode)
+	if err != nil || resolver == "" {
+		return "", err
+	}
+	result, err := ethCall(ctx, ensNetwork, resolver, append(common.CopyBytes(ensNameSelector), reverseNode[:]...))
+	if err != nil {
+		return "", err
+	}
+	name := decodeABIString(result)
+	if name == "" {
+		return "", nil
+	}
+
+	node := ensNamehash(name)
+	resolver, err = ensResolver(ctx, node)
+	if err != nil || resolver == "" {
+		return "", err
+	}
+	result, err = ethCall(ctx, ensNetwork, resolver, append(common.CopyBytes(ensAddrSelector), node[:]...))
+	if err != nil {
+		return "", err
+	}
+	if len(result) != 32 || common.BytesToAddress(result) != address {
+		return "", nil
+	}
+	return name, nil
+}
+
+// ensResolver returns the resolver set for node in the ENS registry, or "" when there is none.
+func ensResolver(ctx context.Context, node common.Hash) (string, error) {
+	result, err := ethCall(ctx, ensNetwork, ensRegistryAddress, append(common.CopyBytes(resolverSelector), node[:]...))
+	if err != nil || len(result) != 32 {
+		return "", err
+	}
+	resolver := common.BytesToAddress(result)
+	if resolver == (common.Address{}) {
+		return "", nil
+	}
+	return resolver.Hex(), nil
+}
+
+// ensNamehash computes the EIP-137 namehash of name.
+func ensNamehash(name string) common.Hash {
+	var node common.Hash
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = crypto.Keccak256Hash(node[:], crypto.Keccak256([]byte(labels[i])))
+	}
+	return node
+}
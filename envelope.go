@@ -0,0 +1,135 @@
+package function
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// formatEnvelope is the format attribute of messages whose payload is a MessageEnvelope.
+// Messages without the attribute carry a bare array of documents.
+const formatEnvelope = "envelope"
+
+// MessageEnvelope is the payload of Pub/Sub messages published with PUBSUB_ENVELOPE=true. It
+// carries the batch metadata also found in the message attributes, so consumers need not read
+// them, and holds the documents in the field named by Type, leaving the others empty. New
+// metadata and document kinds are added as fields, so consumers decoding into this type keep
+// working as the format grows.
+type MessageEnvelope struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// BatchID identifies the batch by its type and document IDs, so a batch published again,
+	// after a retried webhook or a republish, has the same ID and can be deduplicated.
+	BatchID   string    `json:"batchId"`
+	Type      string    `json:"type"`
+	Network   string    `json:"network,omitempty"`
+	WebhookID string    `json:"webhookId,omitempty"`
+	EventID   string    `json:"eventId,omitempty"`
+	SentAt    time.Time `json:"sentAt"`
+	Count     int       `json:"count"`
+
+	Transfers    []*TransferDocument    `json:"transfers,omitempty"`
+	Events       []*EventDocument       `json:"events,omitempty"`
+	Approvals    []*ApprovalDocument    `json:"approvals,omitempty"`
+	Swaps        []*SwapDocument        `json:"swaps,omitempty"`
+	Transactions []*TransactionDocument `json:"transactions,omitempty"`
+	Tombstones   []*Tombstone           `json:"tombstones,omitempty"`
+}
+
+// newMessageEnvelope returns the envelope of docs, a slice of one document kind, taking the batch
+// metadata from the message attributes.
+func newMessageEnvelope(docs any, attributes map[string]string) *MessageEnvelope {
+	envelope := &MessageEnvelope{
+		SchemaVersion: SchemaVersion,
+		Type:          attributes["type"],
+		Network:       attributes["network"],
+		WebhookID:     attributes["webhook_id"],
+		EventID:       attributes["event_id"],
+		SentAt:        time.Now().UTC(),
+	}
+	var ids []string
+	switch docs := docs.(type) {
+	case []*TransferDocument:
+		envelope.Transfers, ids = docs, documentIDs(docs)
+	case []*EventDocument:
+		envelope.Events, ids = docs, documentIDs(docs)
+	case []*ApprovalDocument:
+		envelope.Approvals, ids = docs, documentIDs(docs)
+	case []*SwapDocument:
+		envelope.Swaps, ids = docs, documentIDs(docs)
+	case []*TransactionDocument:
+		envelope.Transactions, ids = docs, documentIDs(docs)
+	case []*Tombstone:
+		envelope.Tombstones, ids = docs, documentIDs(docs)
+	}
+	envelope.Count = len(ids)
+
+	h := sha256.New()
+	h.Write([]byte(envelope.Type))
+	for _, id := range ids {
+		h.Write([]byte{0})
+		h.Write([]byte(id))
+	}
+	envelope.BatchID = hex.EncodeToString(h.Sum(nil)[:16])
+	return envelope
+}
+
+// documentIDs returns the document IDs of docs.
+func documentIDs[T Document](docs []T) []string {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.DocumentID()
+	}
+	return ids
+}
+
+// redactEnvelope applies rules to a JSON-decoded envelope: to each of its documents, and to the
+// batch metadata as to the document fields it is copied from, so dropping alchemy.webhookId from
+// documents also drops webhookId from the envelope.
+func redactEnvelope(rules *RedactionRules, envelope map[string]any) {
+	if kind, ok := envelope["type"].(string); ok {
+		rules.apply(envelope[kind])
+	}
+	metadata := map[string]any{
+		"network": envelope["network"],
+		"alchemy": map[string]any{"webhookId": envelope["webhookId"], "eventId": envelope["eventId"]},
+	}
+	rules.apply(metadata)
+	alchemy, _ := metadata["alchemy"].(map[string]any)
+	for field, value := range map[string]any{"network": metadata["network"], "webhookId": alchemy["webhookId"], "eventId": alchemy["eventId"]} {
+		if value == nil {
+			delete(envelope, field)
+		} else {
+			envelope[field] = value
+		}
+	}
+}
+
+// transfersPayload returns the transfers of a JSON message payload, a bare array or, with the
+// envelope format attribute, a MessageEnvelope, upgraded to SchemaVersion when version is older.
+func transfersPayload(data []byte, attributes map[string]string, version int) ([]*TransferDocument, error) {
+	if attributes["format"] == formatEnvelope {
+		var envelope struct {
+			Transfers json.RawMessage `json:"transfers"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, fmt.Errorf("failed to decode envelope: %w", err)
+		}
+		if len(envelope.Transfers) == 0 {
+			return nil, nil
+		}
+		data = envelope.Transfers
+	}
+	if version < SchemaVersion {
+		var err error
+		if data, err = migratePayload(data); err != nil {
+			return nil, fmt.Errorf("failed to migrate transfers: %w", err)
+		}
+	}
+	var transfers []*TransferDocument
+	if err := json.Unmarshal(data, &transfers); err != nil {
+		return nil, fmt.Errorf("failed to decode transfers: %w", err)
+	}
+	return transfers, nil
+}
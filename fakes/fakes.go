@@ -0,0 +1,511 @@
+// Package fakes provides in-memory implementations of the pipeline's extension interfaces, with
+// inspection helpers, so programs embedding the pipeline can unit-test their integrations without
+// the Pub/Sub and Firestore emulators.
+//
+//	sink := fakes.NewSink("audit")
+//	function.RegisterSink(sink)
+//	function.SetClaimStoreFactory(fakes.NewClaimStore().Factory())
+//	function.SetPublisherFactory(fakes.NewPublisher().Factory())
+//	function.SetWriterFactory(fakes.NewWriter().Factory())
+package fakes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	function "webhook.local/function"
+)
+
+// Sink is an in-memory function.Sink that records every delivery.
+// Set Err to make deliveries fail.
+type Sink struct {
+	name string
+
+	mu          sync.Mutex
+	initialized bool
+	deliveries  []*function.ParsedWebhook
+	Err         error
+}
+
+// NewSink returns an empty Sink with the given name.
+func NewSink(name string) *Sink {
+	return &Sink{name: name}
+}
+
+// Name returns the sink name.
+func (s *Sink) Name() string {
+	return s.name
+}
+
+// Init marks the sink initialized.
+func (s *Sink) Init(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.initialized = true
+	return nil
+}
+
+// Write records parsed, or returns Err when it is set.
+func (s *Sink) Write(_ context.Context, parsed *function.ParsedWebhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.deliveries = append(s.deliveries, parsed)
+	return nil
+}
+
+// Close marks the sink closed.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.initialized = false
+	return nil
+}
+
+// Initialized reports whether the sink was initialized and not closed since.
+func (s *Sink) Initialized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.initialized
+}
+
+// Deliveries returns the webhooks delivered so far, oldest first.
+func (s *Sink) Deliveries() []*function.ParsedWebhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*function.ParsedWebhook(nil), s.deliveries...)
+}
+
+// Transfers returns the transfers of every delivery, in delivery order.
+func (s *Sink) Transfers() []*function.TransferDocument {
+	var transfers []*function.TransferDocument
+	for _, parsed := range s.Deliveries() {
+		transfers = append(transfers, parsed.Transfers...)
+	}
+	return transfers
+}
+
+// Reset discards the recorded deliveries.
+func (s *Sink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = nil
+}
+
+// Enricher is an in-memory function.Enricher that records the transfers it is given and
+// applies Func to them when it is set.
+type Enricher struct {
+	name string
+	Func func(transfers []*function.TransferDocument) error
+
+	mu    sync.Mutex
+	calls [][]*function.TransferDocument
+}
+
+// NewEnricher returns an Enricher with the given name that applies fn, which may be nil.
+func NewEnricher(name string, fn func(transfers []*function.TransferDocument) error) *Enricher {
+	return &Enricher{name: name, Func: fn}
+}
+
+// Name returns the enricher name.
+func (e *Enricher) Name() string {
+	return e.name
+}
+
+// Enrich records transfers and applies Func.
+func (e *Enricher) Enrich(_ context.Context, transfers []*function.TransferDocument) error {
+	e.mu.Lock()
+	e.calls = append(e.calls, transfers)
+	e.mu.Unlock()
+	if e.Func == nil {
+		return nil
+	}
+	return e.Func(transfers)
+}
+
+// Calls returns the transfers passed to each Enrich call, oldest first.
+func (e *Enricher) Calls() [][]*function.TransferDocument {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([][]*function.TransferDocument(nil), e.calls...)
+}
+
+// ClaimStore is an in-memory function.ClaimStore shared by every collection it is opened for.
+// Claims expire after the TTL the collection was opened with.
+type ClaimStore struct {
+	mu     sync.Mutex
+	claims map[string]time.Time
+}
+
+// NewClaimStore returns an empty ClaimStore.
+func NewClaimStore() *ClaimStore {
+	return &ClaimStore{claims: make(map[string]time.Time)}
+}
+
+// Factory returns a function.ClaimStoreFactory that opens views of s per collection,
+// for function.SetClaimStoreFactory.
+func (s *ClaimStore) Factory() function.ClaimStoreFactory {
+	return func(_ context.Context, collection string, ttl time.Duration) (function.ClaimStore, error) {
+		return &claimView{store: s, collection: collection, ttl: ttl}, nil
+	}
+}
+
+// Claimed reports whether key is currently claimed in collection.
+func (s *ClaimStore) Claimed(collection, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expireAt, ok := s.claims[collection+"/"+key]
+	return ok && expireAt.After(time.Now())
+}
+
+// Len returns the number of claims recorded, including expired ones.
+func (s *ClaimStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.claims)
+}
+
+// claimView is a ClaimStore opened for one collection.
+type claimView struct {
+	store      *ClaimStore
+	collection string
+	ttl        time.Duration
+}
+
+func (v *claimView) Claim(_ context.Context, key string) (bool, error) {
+	v.store.mu.Lock()
+	defer v.store.mu.Unlock()
+	id := v.collection + "/" + key
+	now := time.Now()
+	if expireAt, ok := v.store.claims[id]; ok && expireAt.After(now) {
+		return false, nil
+	}
+	v.store.claims[id] = now.Add(v.ttl)
+	return true, nil
+}
+
+func (v *claimView) Release(_ context.Context, key string) error {
+	v.store.mu.Lock()
+	defer v.store.mu.Unlock()
+	delete(v.store.claims, v.collection+"/"+key)
+	return nil
+}
+
+func (v *claimView) Close() error {
+	return nil
+}
+
+// Publication is a publish call recorded by Publisher: the topic, the kind of documents,
+// transfers, events, approvals, swaps, tombstones, transactions or lifecycle, and the documents,
+// or the lifecycle event.
+type Publication struct {
+	Topic     string
+	Kind      string
+	Documents []function.Document
+	Event     *function.LifecycleEvent
+}
+
+// Publisher is an in-memory function.Publisher shared by every topic it is opened for, recording
+// every publish call. Set Err to make publishing fail.
+type Publisher struct {
+	mu           sync.Mutex
+	publications []Publication
+	Err          error
+}
+
+// NewPublisher returns an empty Publisher.
+func NewPublisher() *Publisher {
+	return &Publisher{}
+}
+
+// Factory returns a function.PublisherFactory that opens views of p per topic,
+// for function.SetPublisherFactory.
+func (p *Publisher) Factory() function.PublisherFactory {
+	return func(_ context.Context, topicID string) (function.Publisher, error) {
+		return &topicPublisher{publisher: p, topic: topicID}, nil
+	}
+}
+
+// Publications returns the publish calls so far, oldest first.
+func (p *Publisher) Publications() []Publication {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Publication(nil), p.publications...)
+}
+
+// Transfers returns the transfers published to topic, in publish order.
+func (p *Publisher) Transfers(topic string) []*function.TransferDocument {
+	var transfers []*function.TransferDocument
+	for _, publication := range p.Publications() {
+		if publication.Topic != topic {
+			continue
+		}
+		for _, doc := range publication.Documents {
+			if transfer, ok := doc.(*function.TransferDocument); ok {
+				transfers = append(transfers, transfer)
+			}
+		}
+	}
+	return transfers
+}
+
+// LifecycleEvents returns the lifecycle events published to topic, in publish order.
+func (p *Publisher) LifecycleEvents(topic string) []*function.LifecycleEvent {
+	var events []*function.LifecycleEvent
+	for _, publication := range p.Publications() {
+		if publication.Topic == topic && publication.Event != nil {
+			events = append(events, publication.Event)
+		}
+	}
+	return events
+}
+
+// Reset discards the recorded publish calls.
+func (p *Publisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.publications = nil
+}
+
+func (p *Publisher) record(publication Publication) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.publications = append(p.publications, publication)
+	return nil
+}
+
+// topicPublisher is a Publisher opened for one topic.
+type topicPublisher struct {
+	publisher *Publisher
+	topic     string
+}
+
+func (t *topicPublisher) PublishTransfers(_ context.Context, transfers []*function.TransferDocument) error {
+	return t.publisher.record(Publication{Topic: t.topic, Kind: "transfers", Documents: documents(transfers)})
+}
+
+func (t *topicPublisher) PublishEvents(_ context.Context, events []*function.EventDocument) error {
+	return t.publisher.record(Publication{Topic: t.topic, Kind: "events", Documents: documents(events)})
+}
+
+func (t *topicPublisher) PublishApprovals(_ context.Context, approvals []*function.ApprovalDocument) error {
+	return t.publisher.record(Publication{Topic: t.topic, Kind: "approvals", Documents: documents(approvals)})
+}
+
+func (t *topicPublisher) PublishSwaps(_ context.Context, swaps []*function.SwapDocument) error {
+	return t.publisher.record(Publication{Topic: t.topic, Kind: "swaps", Documents: documents(swaps)})
+}
+
+func (t *topicPublisher) PublishTombstones(_ context.Context, tombstones []*function.Tombstone) error {
+	return t.publisher.record(Publication{Topic: t.topic, Kind: "tombstones", Documents: documents(tombstones)})
+}
+
+func (t *topicPublisher) PublishTransactions(_ context.Context, transactions []*function.TransactionDocument) error {
+	return t.publisher.record(Publication{Topic: t.topic, Kind: "transactions", Documents: documents(transactions)})
+}
+
+func (t *topicPublisher) PublishLifecycleEvent(_ context.Context, event *function.LifecycleEvent) error {
+	return t.publisher.record(Publication{Topic: t.topic, Kind: "lifecycle", Event: event})
+}
+
+func (t *topicPublisher) Close() error {
+	return nil
+}
+
+// documents returns docs as Documents.
+func documents[T function.Document](docs []T) []function.Document {
+	out := make([]function.Document, len(docs))
+	for i, doc := range docs {
+		out[i] = doc
+	}
+	return out
+}
+
+// Writer is an in-memory function.Writer keeping the last document written under each
+// collection and document ID, and recording the tombstones it applies. Tombstones delete the
+// documents they name under function.RemovedLogDelete and leave them in place otherwise.
+// Set Err to make writes fail.
+type Writer struct {
+	mu         sync.Mutex
+	docs       map[string]map[string]function.Document
+	tombstones []*function.Tombstone
+	Err        error
+}
+
+// NewWriter returns an empty Writer.
+func NewWriter() *Writer {
+	return &Writer{docs: make(map[string]map[string]function.Document)}
+}
+
+// Factory returns a function.WriterFactory that opens w, for function.SetWriterFactory.
+func (w *Writer) Factory() function.WriterFactory {
+	return func(context.Context) (function.Writer, error) {
+		return w, nil
+	}
+}
+
+// WriteDocuments stores docs in collection by their DocumentID, or returns Err when it is set.
+func (w *Writer) WriteDocuments(_ context.Context, collection string, docs []function.Document) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Err != nil {
+		return w.Err
+	}
+	if w.docs[collection] == nil {
+		w.docs[collection] = make(map[string]function.Document)
+	}
+	for _, doc := range docs {
+		w.docs[collection][doc.DocumentID()] = doc
+	}
+	return nil
+}
+
+// CreateDocument stores doc in collection unless a document with its ID is stored, reporting
+// whether it did, or returns Err when it is set.
+func (w *Writer) CreateDocument(_ context.Context, collection string, doc function.Document) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Err != nil {
+		return false, w.Err
+	}
+	if _, ok := w.docs[collection][doc.DocumentID()]; ok {
+		return false, nil
+	}
+	if w.docs[collection] == nil {
+		w.docs[collection] = make(map[string]function.Document)
+	}
+	w.docs[collection][doc.DocumentID()] = doc
+	return true, nil
+}
+
+// DeleteDocuments removes the documents with the IDs of docs from collection, or returns Err
+// when it is set.
+func (w *Writer) DeleteDocuments(_ context.Context, collection string, docs []function.Document) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Err != nil {
+		return w.Err
+	}
+	for _, doc := range docs {
+		delete(w.docs[collection], doc.DocumentID())
+	}
+	return nil
+}
+
+// ApplyTombstones records tombstones and, under function.RemovedLogDelete, deletes the documents
+// they name, or returns Err when it is set.
+func (w *Writer) ApplyTombstones(_ context.Context, policy function.RemovedLogPolicy, tombstones []*function.Tombstone) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.Err != nil {
+		return w.Err
+	}
+	for _, tombstone := range tombstones {
+		if policy == function.RemovedLogDelete {
+			delete(w.docs[tombstone.Collection], tombstone.DocumentID())
+		}
+	}
+	w.tombstones = append(w.tombstones, tombstones...)
+	return nil
+}
+
+// Document returns the document stored in collection under id.
+func (w *Writer) Document(collection, id string) (function.Document, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	doc, ok := w.docs[collection][id]
+	return doc, ok
+}
+
+// Documents returns the documents stored in collection, such as alchemy_stream for transfers,
+// ordered by document ID.
+func (w *Writer) Documents(collection string) []function.Document {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	docs := make([]function.Document, 0, len(w.docs[collection]))
+	for _, doc := range w.docs[collection] {
+		docs = append(docs, doc)
+	}
+	slices.SortFunc(docs, func(a, b function.Document) int { return strings.Compare(a.DocumentID(), b.DocumentID()) })
+	return docs
+}
+
+// Tombstones returns the tombstones applied so far, oldest first.
+func (w *Writer) Tombstones() []*function.Tombstone {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*function.Tombstone(nil), w.tombstones...)
+}
+
+// NotifierRequest is a request received by Notifier: its URL path and JSON body.
+type NotifierRequest struct {
+	Path string
+	Body json.RawMessage
+}
+
+// Notifier is a local HTTP endpoint standing in for the Slack, Discord, Telegram and PagerDuty
+// APIs. Point SLACK_WEBHOOK_URL, DISCORD_WEBHOOK_URL, TELEGRAM_API_URL or PAGERDUTY_EVENTS_URL
+// at URL and inspect the requests it received. It answers every request with 202 Accepted and
+// the Telegram Bot API's {"ok":true}, or with Status when it is set.
+type Notifier struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests []NotifierRequest
+	Status   int
+}
+
+// NewNotifier starts a Notifier. Close it when done.
+func NewNotifier() *Notifier {
+	n := &Notifier{}
+	n.server = httptest.NewServer(http.HandlerFunc(n.serveHTTP))
+	return n
+}
+
+func (n *Notifier) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	n.mu.Lock()
+	n.requests = append(n.requests, NotifierRequest{Path: r.URL.Path, Body: body})
+	status := n.Status
+	n.mu.Unlock()
+
+	if status == 0 {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status < 300 {
+		_, _ = io.WriteString(w, `{"ok":true}`)
+	} else {
+		_, _ = io.WriteString(w, `{"ok":false,"description":"fake failure"}`)
+	}
+}
+
+// URL returns the base URL of the Notifier.
+func (n *Notifier) URL() string {
+	return n.server.URL
+}
+
+// Requests returns the requests received so far, oldest first.
+func (n *Notifier) Requests() []NotifierRequest {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]NotifierRequest(nil), n.requests...)
+}
+
+// Close shuts the Notifier down.
+func (n *Notifier) Close() {
+	n.server.Close()
+}
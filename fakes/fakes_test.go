@@ -0,0 +1,198 @@
+package fakes_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	function "webhook.local/function"
+	"webhook.local/function/fakes"
+)
+
+const signingKey = "test-signing-key"
+
+// transferWebhook returns a GRAPHQL webhook with one ERC20 Transfer log in event eventID.
+func transferWebhook(eventID string) string {
+	return `{
+  "webhookId": "wh_test",
+  "id": "` + eventID + `",
+  "createdAt": "2026-01-02T03:04:05Z",
+  "type": "GRAPHQL",
+  "event": {
+    "network": "ETH_MAINNET",
+    "data": {"block": {
+      "hash": "0x9b2b1c4f6a3d8e7f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6071",
+      "number": 20000000,
+      "timestamp": 1767323045,
+      "logs": [{
+        "data": "0x00000000000000000000000000000000000000000000000000000000000f4240",
+        "topics": [
+          "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+          "0x000000000000000000000000a9d1e08c7793af67e9d92fe308d5697fb81d3e43",
+          "0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"
+        ],
+        "index": 7,
+        "account": {"address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"},
+        "transaction": {
+          "hash": "0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060",
+          "from": {"address": "0xa9d1e08c7793af67e9d92fe308d5697fb81d3e43"},
+          "to": {"address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"},
+          "status": 1
+        }
+      }]
+    }}
+  }
+}`
+}
+
+// post sends body to the function signed with signingKey and returns the response status.
+func post(t *testing.T, body string) int {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(body))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("x-alchemy-signature", hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	function.AlchemyWebhook(w, r)
+	return w.Code
+}
+
+func TestPipelineWithFakes(t *testing.T) {
+	notifier := fakes.NewNotifier()
+	defer notifier.Close()
+	t.Setenv("ALCHEMY_SIGNING_KEY", signingKey)
+	t.Setenv("ALCHEMY_PUBSUB_TOPIC", "transfers")
+	t.Setenv("SLACK_WEBHOOK_URL", notifier.URL()+"/slack")
+	t.Setenv("SINKS", "firestore,pubsub,slack,audit")
+	t.Setenv("ENABLE_IDEMPOTENCY", "true")
+
+	publisher := fakes.NewPublisher()
+	writer := fakes.NewWriter()
+	claims := fakes.NewClaimStore()
+	sink := fakes.NewSink("audit")
+	function.SetPublisherFactory(publisher.Factory())
+	function.SetWriterFactory(writer.Factory())
+	function.SetClaimStoreFactory(claims.Factory())
+	function.RegisterSink(sink)
+	t.Cleanup(func() {
+		function.SetPublisherFactory(nil)
+		function.SetWriterFactory(nil)
+	})
+
+	const documentID = "0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060-7"
+
+	t.Run("delivers to every sink", func(t *testing.T) {
+		if code := post(t, transferWebhook("whevt_1")); code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if _, ok := writer.Document("alchemy_stream", documentID); !ok {
+			t.Errorf("transfer %s not written", documentID)
+		}
+		if got := publisher.Transfers("transfers"); len(got) != 1 || got[0].DocumentID() != documentID {
+			t.Errorf("published transfers = %v, want %s", got, documentID)
+		}
+		if got := len(sink.Transfers()); got != 1 {
+			t.Errorf("audit sink received %d transfers, want 1", got)
+		}
+		requests := notifier.Requests()
+		if len(requests) != 1 || requests[0].Path != "/slack" {
+			t.Fatalf("notifier requests = %v, want one to /slack", requests)
+		}
+		if !strings.Contains(string(requests[0].Body), `"text"`) {
+			t.Errorf("Slack message %s has no text", requests[0].Body)
+		}
+		if !claims.Claimed("alchemy_processed_events", "whevt_1") {
+			t.Error("event ID not claimed")
+		}
+	})
+
+	t.Run("ignores a redelivered event", func(t *testing.T) {
+		before := len(publisher.Publications())
+		if code := post(t, transferWebhook("whevt_1")+" "); code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if got := len(publisher.Publications()); got != before {
+			t.Errorf("got %d publications, want %d", got, before)
+		}
+	})
+
+	t.Run("records the outbox through the writer", func(t *testing.T) {
+		t.Setenv("PUBSUB_OUTBOX", "true")
+		before := len(publisher.Publications())
+		if code := post(t, transferWebhook("whevt_3")); code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if got := len(writer.Documents("alchemy_outbox")); got != 1 {
+			t.Errorf("got %d outbox entries, want 1", got)
+		}
+		if got := len(publisher.Publications()); got != before {
+			t.Errorf("got %d publications, want %d", got, before)
+		}
+	})
+
+	t.Run("dead-letters through the writer and announces it through the publisher", func(t *testing.T) {
+		t.Setenv("SINK_FAILURE_POLICY", "best-effort")
+		t.Setenv("ALCHEMY_OPS_TOPIC", "ops")
+		sink.Err = errors.New("audit failed")
+		defer func() { sink.Err = nil }()
+		if code := post(t, transferWebhook("whevt_4")); code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", code, http.StatusOK)
+		}
+		if _, ok := writer.Document("alchemy_dead_letters", "audit-whevt_4"); !ok {
+			t.Error("dead letter audit-whevt_4 not written")
+		}
+		events := publisher.LifecycleEvents("ops")
+		if len(events) != 1 || events[0].Type != "dead_letter_stored" || events[0].Details["sink"] != "audit" {
+			t.Errorf("lifecycle events = %v, want one dead_letter_stored for audit", events)
+		}
+	})
+
+	t.Run("fails and releases the claim when publishing fails", func(t *testing.T) {
+		publisher.Err = errors.New("publish failed")
+		defer func() { publisher.Err = nil }()
+		if code := post(t, transferWebhook("whevt_2")); code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want %d", code, http.StatusInternalServerError)
+		}
+		if claims.Claimed("alchemy_processed_events", "whevt_2") {
+			t.Error("claim of a failed event not released")
+		}
+	})
+}
+
+func TestWriterApplyTombstones(t *testing.T) {
+	ctx := context.Background()
+	tombstone := &function.Tombstone{Collection: "alchemy_stream", ID: "0xabc-1"}
+	tests := []struct {
+		name   string
+		policy function.RemovedLogPolicy
+		kept   bool
+	}{
+		{"mark keeps the document", function.RemovedLogMark, true},
+		{"delete removes the document", function.RemovedLogDelete, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := fakes.NewWriter()
+			doc := &function.TransferDocument{}
+			doc.Transaction.Hash, doc.Transfer.LogIndex = "0xabc", 1
+			if err := writer.WriteDocuments(ctx, "alchemy_stream", []function.Document{doc}); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.ApplyTombstones(ctx, tt.policy, []*function.Tombstone{tombstone}); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := writer.Document("alchemy_stream", "0xabc-1"); ok != tt.kept {
+				t.Errorf("document kept = %v, want %v", ok, tt.kept)
+			}
+			if got := writer.Tombstones(); len(got) != 1 {
+				t.Errorf("got %d tombstones, want 1", len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package function
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// anyContract keys the minimum value applied to contracts without their own threshold.
+const anyContract = "*"
+
+// TransferFilter drops parsed transfers before they reach any sink. Addresses are compared
+// case-insensitively.
+type TransferFilter struct {
+	contractAllow map[string]bool
+	contractDeny  map[string]bool
+	addressAllow  map[string]bool
+	addressDeny   map[string]bool
+	minValues     map[string]*big.Int
+}
+
+// getTransferFilter returns the filter configured by FILTER_CONTRACT_ALLOWLIST,
+// FILTER_CONTRACT_DENYLIST, FILTER_ADDRESS_ALLOWLIST, FILTER_ADDRESS_DENYLIST and
+// FILTER_MIN_VALUES, or nil when none is set.
+func getTransferFilter() (*TransferFilter, error) {
+	f := &TransferFilter{}
+	var err error
+	if f.contractAllow, err = parseAddressSet("FILTER_CONTRACT_ALLOWLIST", true); err != nil {
+		return nil, err
+	}
+	if f.contractDeny, err = parseAddressSet("FILTER_CONTRACT_DENYLIST", true); err != nil {
+		return nil, err
+	}
+	if f.addressAllow, err = parseAddressSet("FILTER_ADDRESS_ALLOWLIST", false); err != nil {
+		return nil, err
+	}
+	if f.addressDeny, err = parseAddressSet("FILTER_ADDRESS_DENYLIST", false); err != nil {
+		return nil, err
+	}
+
+	// FILTER_MIN_VALUES lists contract=value pairs in base units, with * for every other contract.
+	for _, item := range parseList(os.Getenv("FILTER_MIN_VALUES")) {
+		contract, value, ok := strings.Cut(item, "=")
+		contract = strings.ToLower(strings.TrimSpace(contract))
+		if !ok || (contract != anyContract && contract != NativeContract && !common.IsHexAddress(contract)) {
+			return nil, fmt.Errorf("invalid FILTER_MIN_VALUES entry %q", item)
+		}
+		minValue, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+		if !ok || minValue.Sign() < 0 {
+			return nil, fmt.Errorf("invalid FILTER_MIN_VALUES value %q", item)
+		}
+		if f.minValues == nil {
+			f.minValues = make(map[string]*big.Int)
+		}
+		f.minValues[contract] = minValue
+	}
+
+	if f.contractAllow == nil && f.contractDeny == nil && f.addressAllow == nil && f.addressDeny == nil && f.minValues == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// parseAddressSet reads the comma-separated addresses in env, accepting NativeContract in
+// contract lists.
+func parseAddressSet(env string, contracts bool) (map[string]bool, error) {
+	items := parseList(os.Getenv(env))
+	if len(items) == 0 {
+		return nil, nil
+	}
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		item = strings.ToLower(item)
+		if !common.IsHexAddress(item) && !(contracts && item == NativeContract) {
+			return nil, fmt.Errorf("invalid %s address %q", env, item)
+		}
+		set[item] = true
+	}
+	return set, nil
+}
+
+// Keep reports whether transfer passes the filter. The contract lists are checked first, then
+// the address lists against either side of the transfer, then the minimum value of the contract.
+// Transfers without a value, such as ERC721 transfers, are not subject to minimum values.
+func (f *TransferFilter) Keep(transfer *Transfer) bool {
+	if f == nil {
+		return true
+	}
+	contract := strings.ToLower(transfer.Contract)
+	if f.contractAllow != nil && !f.contractAllow[contract] {
+		return false
+	}
+	if f.contractDeny[contract] {
+		return false
+	}
+	from, to := strings.ToLower(transfer.From), strings.ToLower(transfer.To)
+	if f.addressAllow != nil && !f.addressAllow[from] && !f.addressAllow[to] {
+		return false
+	}
+	if f.addressDeny[from] || f.addressDeny[to] {
+		return false
+	}
+	if transfer.Value != nil {
+		minValue, ok := f.minValues[contract]
+		if !ok {
+			minValue = f.minValues[anyContract]
+		}
+		if minValue != nil && transfer.Value.Cmp(minValue) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// applyTransferFilter removes the transfers rejected by filter from parsed, along with the
+// tombstones of removed transfers that were never persisted, and returns how many transfers
+// were removed.
+func applyTransferFilter(filter *TransferFilter, parsed *ParsedWebhook) int {
+	if filter == nil {
+		return 0
+	}
+	count := len(parsed.Transfers) + len(parsed.Reverted)
+	parsed.Transfers = filterTransfers(filter, parsed.Transfers)
+	parsed.Reverted = filterTransfers(filter, parsed.Reverted)
+
+	tombstones := parsed.Tombstones[:0]
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.transfer == nil || filter.Keep(&tombstone.transfer.Transfer) {
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+	parsed.Tombstones = tombstones
+	return count - len(parsed.Transfers) - len(parsed.Reverted)
+}
+
+func filterTransfers(filter *TransferFilter, transfers []*TransferDocument) []*TransferDocument {
+	kept := transfers[:0]
+	for _, doc := range transfers {
+		if filter.Keep(&doc.Transfer) {
+			kept = append(kept, doc)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,103 @@
+package function
+
+import (
+	"math/big"
+	"testing"
+)
+
+const (
+	usdcContract = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	wethContract = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"
+	alice        = "0xa9d1e08c7793af67e9d92fe308d5697fb81d3e43"
+	bob          = "0x28c6c06298d514db089934071355e5743bf21d60"
+	carol        = "0x0000000000000000000000000000000000000001"
+)
+
+func TestTransferFilterKeep(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		keep []bool // USDC alice→bob 100, WETH bob→carol 5, ERC721 USDC carol→alice
+	}{
+		{"no filter", nil, []bool{true, true, true}},
+		{"contract allowlist", map[string]string{"FILTER_CONTRACT_ALLOWLIST": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"}, []bool{true, false, true}},
+		{"contract denylist", map[string]string{"FILTER_CONTRACT_DENYLIST": usdcContract}, []bool{false, true, false}},
+		{"address allowlist matches either side", map[string]string{"FILTER_ADDRESS_ALLOWLIST": alice}, []bool{true, false, true}},
+		{"address denylist", map[string]string{"FILTER_ADDRESS_DENYLIST": carol}, []bool{true, false, false}},
+		{"minimum value per contract", map[string]string{"FILTER_MIN_VALUES": usdcContract + "=101"}, []bool{false, true, true}},
+		{"default minimum value", map[string]string{"FILTER_MIN_VALUES": "*=10, " + usdcContract + "=1"}, []bool{true, false, true}},
+	}
+	transfers := []*Transfer{
+		{Contract: usdcContract, From: alice, To: bob, Value: big.NewInt(100)},
+		{Contract: wethContract, From: bob, To: carol, Value: big.NewInt(5)},
+		{Contract: usdcContract, From: carol, To: alice, TokenID: big.NewInt(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFilterEnv(t, tt.env)
+			filter, err := getTransferFilter()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (filter == nil) != (tt.env == nil) {
+				t.Fatalf("filter = %v, want nil only without configuration", filter)
+			}
+			for i, transfer := range transfers {
+				if got := filter.Keep(transfer); got != tt.keep[i] {
+					t.Errorf("Keep(transfer %d) = %v, want %v", i, got, tt.keep[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetTransferFilterInvalid(t *testing.T) {
+	tests := map[string]string{
+		"FILTER_CONTRACT_ALLOWLIST": "usdc",
+		"FILTER_ADDRESS_DENYLIST":   NativeContract,
+		"FILTER_MIN_VALUES":         usdcContract + "=-1",
+	}
+	for env, value := range tests {
+		t.Run(env, func(t *testing.T) {
+			setFilterEnv(t, map[string]string{env: value})
+			if _, err := getTransferFilter(); err == nil {
+				t.Errorf("%s=%s accepted, want an error", env, value)
+			}
+		})
+	}
+}
+
+func TestApplyTransferFilter(t *testing.T) {
+	kept := &TransferDocument{Transfer: Transfer{Contract: usdcContract, From: alice, To: bob}}
+	dropped := &TransferDocument{Transfer: Transfer{Contract: wethContract, From: bob, To: carol}}
+	parsed := &ParsedWebhook{
+		Transfers: []*TransferDocument{kept, dropped},
+		Reverted:  []*TransferDocument{dropped},
+		Tombstones: []*Tombstone{
+			{ID: "kept", transfer: kept},
+			{ID: "dropped", transfer: dropped},
+			{ID: "approval"},
+		},
+	}
+	filter := &TransferFilter{contractAllow: map[string]bool{usdcContract: true}}
+
+	if got := applyTransferFilter(filter, parsed); got != 2 {
+		t.Errorf("removed %d transfers, want 2", got)
+	}
+	if len(parsed.Transfers) != 1 || parsed.Transfers[0] != kept || len(parsed.Reverted) != 0 {
+		t.Errorf("got %d transfers and %d reverted, want only the USDC transfer", len(parsed.Transfers), len(parsed.Reverted))
+	}
+	var tombstones []string
+	for _, tombstone := range parsed.Tombstones {
+		tombstones = append(tombstones, tombstone.ID)
+	}
+	assertStrings(t, "tombstones", tombstones, []string{"kept", "approval"})
+}
+
+// setFilterEnv sets the filter variables in env and clears the others.
+func setFilterEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, name := range []string{"FILTER_CONTRACT_ALLOWLIST", "FILTER_CONTRACT_DENYLIST", "FILTER_ADDRESS_ALLOWLIST", "FILTER_ADDRESS_DENYLIST", "FILTER_MIN_VALUES"} {
+		t.Setenv(name, env[name])
+	}
+}
@@ -0,0 +1,198 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"google.golang.org/api/iterator"
+)
+
+// Finality states of a TransferDocument under ENABLE_FINALITY_TRACKING.
+const (
+	FinalityPending   = "pending"
+	FinalityConfirmed = "confirmed"
+	FinalityOrphaned  = "orphaned"
+)
+
+const defaultConfirmationBlocks = 12
+
+func init() {
+	functions.HTTP("ConfirmTransfers", ConfirmTransfersHTTP)
+}
+
+// getConfirmationBlocks returns how many blocks must be built on a transfer's block before it is
+// confirmed, from CONFIRMATION_BLOCKS.
+func getConfirmationBlocks() (int64, error) {
+	value := os.Getenv("CONFIRMATION_BLOCKS")
+	if value == "" {
+		return defaultConfirmationBlocks, nil
+	}
+	blocks, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || blocks < 0 {
+		return 0, fmt.Errorf("invalid CONFIRMATION_BLOCKS %q", value)
+	}
+	return blocks, nil
+}
+
+// markPending sets every transfer to FinalityPending, for ConfirmTransfers to promote later.
+func markPending(transfers []*TransferDocument) {
+	for _, doc := range transfers {
+		doc.Finality = FinalityPending
+	}
+}
+
+// ConfirmResult reports the outcome of ConfirmTransfers.
+type ConfirmResult struct {
+	Head      int64 `json:"head"`
+	Confirmed int   `json:"confirmed"`
+	Orphaned  int   `json:"orphaned"`
+	Pending   int   `json:"pending"`
+}
+
+// ConfirmTransfers promotes pending transfers buried under CONFIRMATION_BLOCKS blocks to
+// FinalityConfirmed. Each transfer's block hash is checked against the canonical chain over RPC;
+// a transfer whose block was replaced by a reorg becomes FinalityOrphaned and is marked removed,
+// or deleted under RemovedLogDelete. Under RemovedLogSoftDelete orphaned transfers also get an
+// ExpireAt, and their tombstones are recorded and published to Pub/Sub when the pubsub sink is
+// enabled.
+func ConfirmTransfers(ctx context.Context) (ConfirmResult, error) {
+	var result ConfirmResult
+
+	confirmations, err := getConfirmationBlocks()
+	if err != nil {
+		return result, err
+	}
+	removedPolicy, err := getRemovedLogPolicy()
+	if err != nil {
+		return result, err
+	}
+	removedTTL, err := getRemovedLogTTL()
+	if err != nil {
+		return result, err
+	}
+
+	var head hexutil.Uint64
+	if err := rpcCall(ctx, "", "eth_blockNumber", nil, &head); err != nil {
+		return result, err
+	}
+	result.Head = int64(head)
+
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return result, err
+	}
+	canonical := make(map[int64]string)
+	var tombstones []*Tombstone
+	iter := client.Collection(collectionName).Where("Finality", "==", FinalityPending).Documents(ctx)
+	defer iter.Stop()
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		var doc TransferDocument
+		if err := snapshot.DataTo(&doc); err != nil {
+			return result, err
+		}
+		if doc.Block.Number > result.Head-confirmations {
+			result.Pending++
+			continue
+		}
+
+		hash, ok := canonical[doc.Block.Number]
+		if !ok {
+			var block struct {
+				Hash string `json:"hash"`
+			}
+			if err := rpcCall(ctx, "", "eth_getBlockByNumber", []any{hexutil.EncodeUint64(uint64(doc.Block.Number)), false}, &block); err != nil {
+				return result, err
+			}
+			hash = block.Hash
+			canonical[doc.Block.Number] = hash
+		}
+
+		now := time.Now().UTC()
+		if hash == doc.Block.Hash {
+			_, err = snapshot.Ref.Update(ctx, []firestore.Update{
+				{Path: "Finality", Value: FinalityConfirmed},
+				{Path: "ConfirmedAt", Value: now},
+			})
+			result.Confirmed++
+		} else if removedPolicy == RemovedLogDelete {
+			_, err = snapshot.Ref.Delete(ctx)
+			result.Orphaned++
+		} else if removedPolicy == RemovedLogSoftDelete {
+			tombstone := newTombstone(collectionName, KindTransfer, &doc, doc.Block, doc.Transaction, doc.Transfer.LogIndex, doc.Network, doc.Alchemy)
+			tombstone.Reason = TombstoneOrphaned
+			stampTombstones(removedPolicy, removedTTL, []*Tombstone{tombstone})
+			_, err = snapshot.Ref.Update(ctx, []firestore.Update{
+				{Path: "Finality", Value: FinalityOrphaned},
+				{Path: "Removed", Value: true},
+				{Path: "RemovedAt", Value: now},
+				{Path: "RemovedReason", Value: tombstone.Reason},
+				{Path: "ExpireAt", Value: *tombstone.ExpireAt},
+			})
+			tombstones = append(tombstones, tombstone)
+			result.Orphaned++
+		} else {
+			_, err = snapshot.Ref.Update(ctx, []firestore.Update{
+				{Path: "Finality", Value: FinalityOrphaned},
+				{Path: "Removed", Value: true},
+				{Path: "RemovedAt", Value: now},
+			})
+			result.Orphaned++
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if len(tombstones) > 0 {
+		if err := recordTombstones(ctx, client, tombstones); err != nil {
+			return result, err
+		}
+		if sinkEnabled(sinkPubSub) {
+			publisher, err := openPublisher(ctx)
+			if err != nil {
+				return result, err
+			}
+			defer closePublisher(publisher)
+			if err := publisher.PublishTombstones(ctx, tombstones); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	log.Printf(`{"level":"info","message":"confirmed pending transfers","head":%d,"confirmed":%d,"orphaned":%d,"pending":%d}`,
+		result.Head, result.Confirmed, result.Orphaned, result.Pending)
+	return result, nil
+}
+
+// ConfirmTransfersHTTP is the Cloud Run Function entrypoint that runs ConfirmTransfers, meant to
+// be invoked on a schedule by Cloud Scheduler. Deploy it without unauthenticated access.
+func ConfirmTransfersHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := ConfirmTransfers(r.Context())
+	if err != nil {
+		logError("failed to confirm transfers", err)
+		http.Error(w, "Failed to confirm transfers", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logError("failed to write confirm result", err)
+	}
+}
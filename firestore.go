@@ -5,7 +5,8 @@ import (
 	"log"
 
 	"cloud.google.com/go/firestore"
-	firebase "firebase.google.com/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -13,43 +14,172 @@ const (
 	batchLimit     = 500
 )
 
+// Document is implemented by every document type written to Firestore.
+type Document interface {
+	DocumentID() string
+}
+
+// Writer writes and deletes documents, keyed by their DocumentID, in named collections, and
+// applies tombstones to the documents they name. FirestoreWriter is the production
+// implementation.
+type Writer interface {
+	WriteDocuments(ctx context.Context, collection string, docs []Document) error
+	CreateDocument(ctx context.Context, collection string, doc Document) (bool, error)
+	DeleteDocuments(ctx context.Context, collection string, docs []Document) error
+	ApplyTombstones(ctx context.Context, policy RemovedLogPolicy, tombstones []*Tombstone) error
+}
+
+// WriterFactory opens a Writer.
+type WriterFactory func(ctx context.Context) (Writer, error)
+
+// newWriter opens the writers of the firestore sink, the pubsub outbox, dead letters and the
+// enrichment worker; openFirestoreWriter unless replaced.
+var newWriter WriterFactory = openFirestoreWriter
+
+// writerReplaced reports whether SetWriterFactory has replaced newWriter.
+var writerReplaced bool
+
+func openFirestoreWriter(ctx context.Context) (Writer, error) {
+	writer, err := NewFirestoreWriter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// SetWriterFactory replaces the Firestore writers of the firestore sink, the pubsub outbox, dead
+// letters and the enrichment worker, e.g. with an in-memory writer in tests; nil restores the
+// Firestore writer. The firestore sink then does not create the Firestore client. Bridge
+// stitching and the first-seen registry read Firestore, so they only run with writers that also
+// implement them, as FirestoreWriter does. It is not safe to call while serving requests.
+func SetWriterFactory(factory WriterFactory) {
+	if factory == nil {
+		newWriter, writerReplaced = openFirestoreWriter, false
+		return
+	}
+	newWriter, writerReplaced = factory, true
+}
+
 // FirestoreWriter handles writing webhook events to Google Cloud Firestore.
 type FirestoreWriter struct {
-	app *firebase.App
+	client *firestore.Client
 }
 
-// NewFirestoreWriter creates a new Firestore writer using Firebase Admin SDK.
+// NewFirestoreWriter creates a new Firestore writer on the instance's shared Firebase Admin SDK client.
 func NewFirestoreWriter(ctx context.Context) (*FirestoreWriter, error) {
-	app, err := firebase.NewApp(ctx, nil)
+	client, err := firestoreClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &FirestoreWriter{app: app}, nil
+	return &FirestoreWriter{client: client}, nil
 }
 
-// WriteBatchTransfers writes multiple TransferDocuments to Firestore using transactions.
-// Ensures atomicity per batch - either all writes succeed or none are applied.
-func (f *FirestoreWriter) WriteBatchTransfers(ctx context.Context, transfers []*TransferDocument) error {
-	client, err := f.app.Firestore(ctx)
-	if err != nil {
-		return err
+// WriteDocuments writes docs to collection using transactions.
+func (f *FirestoreWriter) WriteDocuments(ctx context.Context, collection string, docs []Document) error {
+	return writeBatchDocuments(ctx, f.client, collection, docs)
+}
+
+// CreateDocument writes doc to collection unless a document with its ID exists, reporting
+// whether it did.
+func (f *FirestoreWriter) CreateDocument(ctx context.Context, collection string, doc Document) (bool, error) {
+	_, err := f.client.Collection(collection).Doc(doc.DocumentID()).Create(ctx, doc)
+	switch status.Code(err) {
+	case codes.OK:
+		return true, nil
+	case codes.AlreadyExists:
+		return false, nil
+	default:
+		return false, err
 	}
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Printf(`{"level":"error","message":"failed to close firestore client","error":"%s"}`, err.Error())
+}
+
+// DeleteDocuments deletes the documents with the IDs of docs from collection using transactions.
+// Missing documents are ignored.
+func (f *FirestoreWriter) DeleteDocuments(ctx context.Context, collection string, docs []Document) error {
+	return deleteBatchDocuments(ctx, f.client, collection, docs)
+}
+
+// linkWriter is implemented by writers that also read Firestore to stitch bridge legs and record
+// first sightings; FirestoreWriter is one.
+type linkWriter interface {
+	StitchBridges(ctx context.Context, bridges []*BridgeConfig, parsed *ParsedWebhook)
+	RecordFirstSeen(ctx context.Context, parsed *ParsedWebhook) error
+}
+
+// writeDocuments writes docs to collection through writer.
+func writeDocuments[T Document](ctx context.Context, writer Writer, collection string, docs []T) error {
+	return writer.WriteDocuments(ctx, collection, documents(docs))
+}
+
+// deleteDocuments deletes docs from collection through writer.
+func deleteDocuments[T Document](ctx context.Context, writer Writer, collection string, docs []T) error {
+	return writer.DeleteDocuments(ctx, collection, documents(docs))
+}
+
+// documents returns docs as Documents.
+func documents[T Document](docs []T) []Document {
+	out := make([]Document, len(docs))
+	for i, doc := range docs {
+		out[i] = doc
+	}
+	return out
+}
+
+// writeBatchDocuments writes docs to collection in transactions of up to batchLimit documents,
+// keyed by their DocumentID. A single document, the common case, is written with a point write
+// to skip the transaction round trips. Documents with block and creation times get their native
+// Firestore timestamps set first.
+func writeBatchDocuments[T Document](ctx context.Context, client *firestore.Client, collection string, docs []T) error {
+	for _, doc := range docs {
+		if timestamped, ok := any(doc).(firestoreTimestamped); ok {
+			setFirestoreTimestamps(timestamped)
 		}
-	}()
+	}
+	return runBatchDocuments(ctx, client, collection, docs, "written",
+		func(docRef *firestore.DocumentRef, doc T) error {
+			_, err := docRef.Set(ctx, doc)
+			return err
+		},
+		func(tx *firestore.Transaction, docRef *firestore.DocumentRef, doc T) error {
+			return tx.Set(docRef, doc)
+		})
+}
+
+// deleteBatchDocuments deletes the documents with the DocumentIDs of docs from collection in
+// transactions of up to batchLimit documents. Missing documents are ignored.
+func deleteBatchDocuments[T Document](ctx context.Context, client *firestore.Client, collection string, docs []T) error {
+	return runBatchDocuments(ctx, client, collection, docs, "deleted",
+		func(docRef *firestore.DocumentRef, _ T) error {
+			_, err := docRef.Delete(ctx)
+			return err
+		},
+		func(tx *firestore.Transaction, docRef *firestore.DocumentRef, _ T) error {
+			return tx.Delete(docRef)
+		})
+}
+
+// runBatchDocuments applies single to a lone document, or txOp to each of docs in transactions
+// of up to batchLimit documents.
+func runBatchDocuments[T Document](ctx context.Context, client *firestore.Client, collection string, docs []T, action string,
+	single func(*firestore.DocumentRef, T) error,
+	txOp func(*firestore.Transaction, *firestore.DocumentRef, T) error) error {
+	total := len(docs)
+	if total == 1 {
+		if err := single(client.Collection(collection).Doc(docs[0].DocumentID()), docs[0]); err != nil {
+			return err
+		}
+		log.Printf(`{"level":"info","message":"document %s to firestore","collection":"%s"}`, action, collection)
+		return nil
+	}
 
-	total := len(transfers)
 	for start := 0; start < total; start += batchLimit {
 		end := min(start+batchLimit, total)
-		batch := transfers[start:end]
+		batch := docs[start:end]
 
-		err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-			for _, transfer := range batch {
-				docID := GetDocumentID(transfer.Transaction.Hash, transfer.Transfer.LogIndex)
-				docRef := client.Collection(collectionName).Doc(docID)
-				if err := tx.Set(docRef, transfer); err != nil {
+		err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			for _, doc := range batch {
+				docRef := client.Collection(collection).Doc(doc.DocumentID())
+				if err := txOp(tx, docRef, doc); err != nil {
 					return err
 				}
 			}
@@ -59,11 +189,11 @@ func (f *FirestoreWriter) WriteBatchTransfers(ctx context.Context, transfers []*
 			return err
 		}
 
-		log.Printf(`{"level":"info","message":"batch written to firestore","collection":"%s","range":"%d-%d","size":%d}`,
-			collectionName, start, end, len(batch))
+		log.Printf(`{"level":"info","message":"batch %s to firestore","collection":"%s","range":"%d-%d","size":%d}`,
+			action, collection, start, end, len(batch))
 	}
 
-	log.Printf(`{"level":"info","message":"all batches written to firestore","collection":"%s","total":%d}`,
-		collectionName, total)
+	log.Printf(`{"level":"info","message":"all batches %s to firestore","collection":"%s","total":%d}`,
+		action, collection, total)
 	return nil
 }
@@ -0,0 +1,156 @@
+package function
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	firstSeenTokensCollectionName    = "alchemy_first_seen_tokens"
+	firstSeenAddressesCollectionName = "alchemy_first_seen_addresses"
+)
+
+// FirstSeen records the earliest block and transaction an address or token was ingested in.
+type FirstSeen struct {
+	Address          string    `json:"address"`
+	Network          string    `json:"network"`
+	FirstBlock       int64     `json:"firstBlock"`
+	FirstTransaction string    `json:"firstTransaction"`
+	FirstSeenAt      time.Time `json:"firstSeenAt"`
+}
+
+// DocumentID returns the registry document ID, the network and lowercase address.
+func (f *FirstSeen) DocumentID() string {
+	return firstSeenID(f.Network, f.Address)
+}
+
+func firstSeenID(network, address string) string {
+	return network + "-" + strings.ToLower(address)
+}
+
+// firstSeenKnown caches registry entries this instance has recorded or read, by collection and
+// document ID, so repeated counterparties skip the Firestore transaction.
+var (
+	firstSeenMu    sync.Mutex
+	firstSeenKnown = map[string]int64{}
+)
+
+// RecordFirstSeen updates the first-seen registries with the token contracts and counterparties
+// of transfers and the senders and recipients of mined transactions. An entry only moves to an
+// earlier block, so out-of-order deliveries still converge on the true first sighting.
+func (f *FirestoreWriter) RecordFirstSeen(ctx context.Context, parsed *ParsedWebhook) error {
+	tokens := make(map[string]*FirstSeen)
+	addresses := make(map[string]*FirstSeen)
+	for _, doc := range parsed.Transfers {
+		if !doc.isNative() {
+			observeFirstSeen(tokens, doc.Transfer.Contract, doc.Network, doc.Block.Number, doc.Transaction.Hash)
+		}
+		observeFirstSeen(addresses, doc.Transfer.From, doc.Network, doc.Block.Number, doc.Transaction.Hash)
+		observeFirstSeen(addresses, doc.Transfer.To, doc.Network, doc.Block.Number, doc.Transaction.Hash)
+	}
+	for _, doc := range parsed.Transactions {
+		if doc.State != TransactionStateMined {
+			continue
+		}
+		observeFirstSeen(addresses, doc.From, doc.Network, doc.Block.Number, doc.Hash)
+		observeFirstSeen(addresses, doc.To, doc.Network, doc.Block.Number, doc.Hash)
+	}
+
+	if err := f.recordFirstSeen(ctx, firstSeenTokensCollectionName, tokens); err != nil {
+		return err
+	}
+	return f.recordFirstSeen(ctx, firstSeenAddressesCollectionName, addresses)
+}
+
+// observeFirstSeen keeps the earliest sighting of address within a webhook.
+func observeFirstSeen(seen map[string]*FirstSeen, address, network string, block int64, transaction string) {
+	if !common.IsHexAddress(address) || common.HexToAddress(address) == (common.Address{}) || block == 0 {
+		return
+	}
+	id := firstSeenID(network, address)
+	if existing, ok := seen[id]; ok && existing.FirstBlock <= block {
+		return
+	}
+	seen[id] = &FirstSeen{
+		Address:          strings.ToLower(address),
+		Network:          network,
+		FirstBlock:       block,
+		FirstTransaction: transaction,
+	}
+}
+
+func (f *FirestoreWriter) recordFirstSeen(ctx context.Context, collection string, seen map[string]*FirstSeen) error {
+	for id, entry := range seen {
+		key := collection + "/" + id
+		firstSeenMu.Lock()
+		known, ok := firstSeenKnown[key]
+		firstSeenMu.Unlock()
+		if ok && known <= entry.FirstBlock {
+			continue
+		}
+
+		ref := f.client.Collection(collection).Doc(id)
+		var first int64
+		err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			first = entry.FirstBlock
+			snap, err := tx.Get(ref)
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				var existing FirstSeen
+				if err := snap.DataTo(&existing); err != nil {
+					return err
+				}
+				if existing.FirstBlock <= entry.FirstBlock {
+					first = existing.FirstBlock
+					return nil
+				}
+			}
+			record := *entry
+			record.FirstSeenAt = time.Now().UTC()
+			return tx.Set(ref, record)
+		})
+		if err != nil {
+			return err
+		}
+
+		firstSeenMu.Lock()
+		firstSeenKnown[key] = first
+		firstSeenMu.Unlock()
+	}
+	return nil
+}
+
+// LookupFirstSeen returns the first-seen registry entry of address on network, reading the token
+// registry when token is true and the address registry otherwise. It returns nil when the address
+// has not been seen.
+func LookupFirstSeen(ctx context.Context, network, address string, token bool) (*FirstSeen, error) {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection := firstSeenAddressesCollectionName
+	if token {
+		collection = firstSeenTokensCollectionName
+	}
+	snap, err := client.Collection(collection).Doc(firstSeenID(network, address)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry FirstSeen
+	if err := snap.DataTo(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
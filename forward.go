@@ -0,0 +1,177 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HTTP sink payloads.
+const (
+	// httpPayloadDocuments forwards the parsed documents, one request per kind.
+	httpPayloadDocuments = "documents"
+	// httpPayloadRaw forwards the webhook payload as Alchemy sent it.
+	httpPayloadRaw = "raw"
+)
+
+// httpSink forwards each kind of document as its own POST to every URL of HTTP_SINK_URL, in
+// order, serialized with HTTP_SERIALIZER, or with HTTP_SINK_PAYLOAD=raw the webhook payload as
+// received. Requests go through the "http" ProviderClient, which retries transport errors, 429
+// and 5xx responses, and carry an Idempotency-Key that stays the same across those retries and
+// across Alchemy's redeliveries of the webhook. With HTTP_SINK_SIGNING_KEY, bodies are signed
+// in X-Webhook-Signature as Alchemy signs them, so receivers verify this function instead.
+type httpSink struct {
+	urls       []string
+	payload    string
+	signingKey []byte
+	serializer Serializer
+}
+
+func (*httpSink) Name() string { return sinkHTTP }
+
+// Init reads the target URLs, payload, signing key and serializer configuration.
+func (s *httpSink) Init(context.Context) error {
+	s.urls = parseList(os.Getenv("HTTP_SINK_URL"))
+	if len(s.urls) == 0 {
+		return errors.New("HTTP_SINK_URL must be set")
+	}
+	for _, target := range s.urls {
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid HTTP_SINK_URL %q", target)
+		}
+	}
+	switch s.payload = os.Getenv("HTTP_SINK_PAYLOAD"); s.payload {
+	case "":
+		s.payload = httpPayloadDocuments
+	case httpPayloadDocuments, httpPayloadRaw:
+	default:
+		return fmt.Errorf("invalid HTTP_SINK_PAYLOAD %q", s.payload)
+	}
+	s.signingKey = []byte(os.Getenv("HTTP_SINK_SIGNING_KEY"))
+	serializer, err := sinkSerializer(sinkHTTP)
+	if err != nil {
+		return err
+	}
+	s.serializer = serializer
+	return nil
+}
+
+func (s *httpSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	if s.payload == httpPayloadRaw {
+		return s.forwardRaw(ctx)
+	}
+	if err := forwardDocuments(ctx, s, "transfers", parsed.Transfers); err != nil {
+		return err
+	}
+	if err := forwardDocuments(ctx, s, "events", parsed.Events); err != nil {
+		return err
+	}
+	if err := forwardDocuments(ctx, s, "approvals", parsed.Approvals); err != nil {
+		return err
+	}
+	if err := forwardDocuments(ctx, s, "swaps", parsed.Swaps); err != nil {
+		return err
+	}
+	if err := forwardDocuments(ctx, s, "tombstones", parsed.Tombstones); err != nil {
+		return err
+	}
+	return forwardDocuments(ctx, s, "transactions", parsed.Transactions)
+}
+
+// Close is a no-op: the provider client is shared by the instance.
+func (*httpSink) Close() error { return nil }
+
+// forwardDocuments posts docs as a single request, identified by the batch's idempotency key.
+func forwardDocuments[T Document](ctx context.Context, s *httpSink, kind string, docs []T) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	data, err := s.serializer.Marshal(docs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", kind, err)
+	}
+	header := http.Header{}
+	header.Set("Content-Type", s.serializer.ContentType())
+	header.Set("Idempotency-Key", idempotencyKey(kind, docs))
+	header.Set("X-Document-Type", kind)
+	header.Set("X-Document-Count", strconv.Itoa(len(docs)))
+	header.Set("X-Schema-Version", strconv.Itoa(SchemaVersion))
+	return s.post(ctx, kind, data, header)
+}
+
+// forwardRaw posts the webhook payload of the request being processed, keyed by its event ID.
+// A streamed body is not kept, so its decoded webhook is forwarded re-encoded instead, without
+// fields the webhook struct does not know. Webhooks processed outside a request, such as
+// requeued quarantined logs, have no payload and are not forwarded.
+func (s *httpSink) forwardRaw(ctx context.Context) error {
+	state := pipelineStateFrom(ctx, nil)
+	if state.Webhook == nil {
+		return nil
+	}
+	data := state.Body
+	if data == nil {
+		var err error
+		if data, err = json.Marshal(state.Webhook); err != nil {
+			return fmt.Errorf("failed to marshal webhook: %w", err)
+		}
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Idempotency-Key", "webhook-"+state.Webhook.ID)
+	header.Set("X-Document-Type", "webhook")
+	return s.post(ctx, "webhook", data, header)
+}
+
+// post sends body with header to every URL in turn, signed when a signing key is set, and
+// fails on the first URL that does not accept it.
+func (s *httpSink) post(ctx context.Context, kind string, body []byte, header http.Header) error {
+	if len(s.signingKey) > 0 {
+		h := hmac.New(sha256.New, s.signingKey)
+		h.Write(body)
+		header.Set("X-Webhook-Signature", hex.EncodeToString(h.Sum(nil)))
+	}
+	for _, target := range s.urls {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header = header.Clone()
+
+		resp, err := ProviderFor(sinkHTTP).Do(ctx, req)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s responded with status %d to %s", req.URL.Host, resp.StatusCode, kind)
+		}
+	}
+	return nil
+}
+
+// idempotencyKey derives the key of a batch from its kind and document IDs: the document ID
+// itself for a single document, otherwise a SHA-256 digest of the IDs in order. Redelivering
+// the same documents always yields the same key.
+func idempotencyKey[T Document](kind string, docs []T) string {
+	if len(docs) == 1 {
+		return kind + "-" + docs[0].DocumentID()
+	}
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.DocumentID()
+	}
+	digest := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+	return kind + "-" + hex.EncodeToString(digest[:])
+}
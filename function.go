@@ -10,6 +10,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 )
@@ -20,37 +22,210 @@ func init() {
 
 // AlchemyWebhook is the Cloud Run Function entrypoint for Alchemy webhooks
 func AlchemyWebhook(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func(start time.Time) { recordRequest(recorder.status, start) }(time.Now())
+		w = recorder
+	case http.MethodGet, http.MethodHead:
+		handleProbe(w, r)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	signingKey, ok := loadWebhookConfig(w)
+	if !ok {
+		return
+	}
+	if deferredProcessingEnabled() {
+		deferWebhook(w, r, signingKey, func(w http.ResponseWriter, r *http.Request) { processWebhook(w, r, signingKey) })
+		return
+	}
+	processWebhook(w, r, signingKey)
+}
+
+// loadWebhookConfig applies PIPELINE_CONFIG and returns ALCHEMY_SIGNING_KEY, responding with a
+// configuration error and false when either fails.
+func loadWebhookConfig(w http.ResponseWriter) (string, bool) {
+	if err := LoadPipelineConfig(); err != nil {
+		configError(w, "failed to load PIPELINE_CONFIG", err)
+		return "", false
+	}
+
 	signingKey := os.Getenv("ALCHEMY_SIGNING_KEY")
 	if signingKey == "" {
-		logError("ALCHEMY_SIGNING_KEY environment variable is not set", nil)
-		http.Error(w, "Server configuration error", http.StatusInternalServerError)
-		return
+		configError(w, "ALCHEMY_SIGNING_KEY environment variable is not set", nil)
+		return "", false
 	}
+	return signingKey, true
+}
 
-	body, err := io.ReadAll(r.Body)
+// processWebhook verifies the webhook POSTed in r and runs it through the pipeline.
+func processWebhook(w http.ResponseWriter, r *http.Request, signingKey string) {
+	mapping, err := LoadGraphQLMapping()
+	if err != nil {
+		configError(w, "failed to load GraphQL mapping", err)
+		return
+	}
+	settings, err := loadHandlerSettings()
 	if err != nil {
-		logError("failed to read request body", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		configError(w, "invalid pipeline configuration", err)
+		return
+	}
+
+	state := &PipelineState{Request: r}
+	if err := beforeStage(r.Context(), StageVerify, state); err != nil {
+		hookFailed(w, state, err)
 		return
 	}
 
 	signature := r.Header.Get("x-alchemy-signature")
-	log.Printf(`{"level":"debug","message":"raw webhook received","signature":"%s","body":%s}`, signature, string(body))
+	var webhook *WebhookEvent
+	// Custom GraphQL mappings resolve paths over the whole decoded body, so they are never streamed.
+	if os.Getenv("ENABLE_STREAMING_DECODE") == "true" && mapping == nil {
+		var ok bool
+		if webhook, ok = decodeStreamedWebhook(w, r, signature, signingKey); !ok {
+			return
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logError("failed to read request body", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf(`{"level":"debug","message":"raw webhook received","signature":"%s","body":%s}`, signature, string(body))
+		state.Body = body
 
-	if !verifySignature(body, signature, []byte(signingKey)) {
-		logError("signature validation failed", nil)
-		http.Error(w, "Unauthorized", http.StatusForbidden)
+		valid := verifySignature(body, signature, []byte(signingKey))
+		alertSignatureResult(r.Context(), valid)
+		if !valid {
+			logError("signature validation failed", nil)
+			http.Error(w, "Unauthorized", http.StatusForbidden)
+			return
+		}
+
+		webhook, err = parseWebhookEvent(body, mapping)
+		if err != nil {
+			logError("failed to parse webhook event", err)
+			http.Error(w, "Invalid webhook event format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := settings.strictness.validateMetadata(webhook); err != nil {
+		logError("webhook rejected", err)
+		http.Error(w, "Invalid webhook metadata", http.StatusBadRequest)
+		return
+	}
+	state.Webhook = webhook
+	if err := afterStage(r.Context(), StageVerify, state); err != nil {
+		hookFailed(w, state, err)
+		return
+	}
+	ctx := withPipelineState(r.Context(), state)
+
+	handle := handleWebhook
+	if os.Getenv("ENABLE_IDEMPOTENCY") == "true" {
+		handle = handleWithIdempotency
+	}
+	if os.Getenv("ENABLE_REPLAY_PROTECTION") == "true" {
+		handleWithReplayProtection(w, ctx, webhook, signature, handle)
 		return
 	}
 
-	webhook, err := parseWebhookEvent(body)
+	handle(w, ctx, webhook)
+}
+
+// webhookHandler processes a verified webhook and writes the response.
+// It returns the error behind any non-2xx response.
+type webhookHandler func(w http.ResponseWriter, ctx context.Context, webhook *WebhookEvent) error
+
+// handleWithReplayProtection claims the request signature before processing with next so the
+// same signed body is only processed once across instances. The claim is released when
+// processing fails, allowing Alchemy's retries through.
+func handleWithReplayProtection(w http.ResponseWriter, ctx context.Context, webhook *WebhookEvent, signature string, next webhookHandler) {
+	ttl, err := getReplayTTL()
+	if err != nil {
+		configError(w, "invalid replay protection configuration", err)
+		return
+	}
+
+	store, err := newClaimStore(ctx, replayCollectionName, ttl)
+	if err != nil {
+		configError(w, "failed to create replay store", err)
+		return
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			logError("failed to close replay store", err)
+		}
+	}()
+
+	claimed, err := store.Claim(ctx, signature)
 	if err != nil {
-		logError("failed to parse webhook event", err)
-		http.Error(w, "Invalid webhook event format", http.StatusBadRequest)
+		logError("failed to claim request signature", err)
+		http.Error(w, "Failed to check replay protection", http.StatusInternalServerError)
 		return
 	}
+	if !claimed {
+		log.Printf(`{"level":"warn","message":"replayed request ignored","webhook_id":"%s","event_id":"%s"}`, webhook.WebhookID, webhook.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := next(w, ctx, webhook); err != nil {
+		if err := store.Release(context.WithoutCancel(ctx), signature); err != nil {
+			logError("failed to release request signature", err)
+		}
+	}
+}
+
+// handleWithIdempotency claims the webhook event ID before processing, so an event Alchemy
+// redelivers, even re-signed, runs the pipeline only once. Duplicates get a 200 without being
+// processed. The claim is released when processing fails, allowing Alchemy's retries through.
+func handleWithIdempotency(w http.ResponseWriter, ctx context.Context, webhook *WebhookEvent) error {
+	if webhook.ID == "" {
+		return handleWebhook(w, ctx, webhook)
+	}
+
+	ttl, err := getIdempotencyTTL()
+	if err != nil {
+		return configError(w, "invalid idempotency configuration", err)
+	}
+
+	store, err := newClaimStore(ctx, idempotencyCollectionName, ttl)
+	if err != nil {
+		return configError(w, "failed to create idempotency store", err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			logError("failed to close idempotency store", err)
+		}
+	}()
+
+	claimed, err := store.Claim(ctx, webhook.ID)
+	if err != nil {
+		logError("failed to claim webhook event ID", err)
+		http.Error(w, "Failed to check idempotency", http.StatusInternalServerError)
+		return err
+	}
+	if !claimed {
+		log.Printf(`{"level":"info","message":"duplicate webhook event ignored","webhook_id":"%s","event_id":"%s"}`, webhook.WebhookID, webhook.ID)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
 
-	handleWebhook(w, r.Context(), webhook)
+	err = handleWebhook(w, ctx, webhook)
+	if err != nil {
+		if err := store.Release(context.WithoutCancel(ctx), webhook.ID); err != nil {
+			logError("failed to release webhook event ID", err)
+		}
+	}
+	return err
 }
 
 func verifySignature(body []byte, signature string, signingKey []byte) bool {
@@ -59,7 +234,11 @@ func verifySignature(body []byte, signature string, signingKey []byte) bool {
 	return hex.EncodeToString(h.Sum(nil)) == signature
 }
 
-func parseWebhookEvent(body []byte) (*WebhookEvent, error) {
+// parseWebhookEvent decodes body, using mapping for GRAPHQL webhooks when it is set.
+func parseWebhookEvent(body []byte, mapping *GraphQLMapping) (*WebhookEvent, error) {
+	if mapping != nil {
+		return parseGraphQLWebhookEvent(body, mapping)
+	}
 	var event WebhookEvent
 	if err := json.Unmarshal(body, &event); err != nil {
 		return nil, err
@@ -67,6 +246,13 @@ func parseWebhookEvent(body []byte) (*WebhookEvent, error) {
 	return &event, nil
 }
 
+// configError logs err under message and responds with a server configuration error, returning err.
+func configError(w http.ResponseWriter, message string, err error) error {
+	logError(message, err)
+	http.Error(w, "Server configuration error", http.StatusInternalServerError)
+	return err
+}
+
 func logError(message string, err error) {
 	if err != nil {
 		log.Printf(`{"level":"error","message":"%s","error":"%s"}`, message, err.Error())
@@ -75,60 +261,368 @@ func logError(message string, err error) {
 	}
 }
 
-func handleWebhook(w http.ResponseWriter, ctx context.Context, webhook *WebhookEvent) {
-	transfers, err := ParseTransferEvents(webhook)
+// handleProbe answers unsigned GET and HEAD requests without processing anything: /readyz runs
+// the readiness checks, other paths run the warm-up with ENABLE_WARMUP=true, and otherwise they
+// return 200, so uptime checkers and Alchemy's URL checks neither fail signature verification
+// nor log errors.
+func handleProbe(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/readyz"):
+		handleReadiness(w, r.Context())
+	case os.Getenv("ENABLE_WARMUP") == "true":
+		handleWarmUp(w, r.Context())
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleWebhook processes a verified webhook and writes the response.
+// It returns the error behind any non-2xx response.
+func handleWebhook(w http.ResponseWriter, ctx context.Context, webhook *WebhookEvent) error {
+	start := time.Now()
+	settings, err := loadHandlerSettings()
+	if err != nil {
+		return configError(w, "invalid pipeline configuration", err)
+	}
+	shedder, err := getShedder(ctx, start)
+	if err != nil {
+		return configError(w, "invalid degradation configuration", err)
+	}
+	strictness := settings.strictness
+
+	if !knownWebhookType(webhook.Type) {
+		log.Printf(`{"level":"warn","message":"unknown webhook type","metric":"webhook_unknown_type","webhook_id":"%s","type":"%s","policy":"%s"}`,
+			webhook.WebhookID, webhook.Type, strictness.UnknownType)
+		countMetric("alchemy_webhook_unknown_type_total", 1, "type", webhook.Type, "policy", string(strictness.UnknownType))
+		switch strictness.UnknownType {
+		case UnknownTypeSkip:
+			w.WriteHeader(http.StatusOK)
+			return nil
+		case UnknownTypeReject:
+			http.Error(w, "Unknown webhook type", http.StatusBadRequest)
+			return errUnknownTypeRejected
+		}
+	}
+
+	if os.Getenv("ENABLE_SEQUENCE_TRACKING") == "true" {
+		trackSequence(ctx, webhook)
+	}
+
+	state := pipelineStateFrom(ctx, webhook)
+	if err := beforeStage(ctx, StageDecode, state); err != nil {
+		return hookFailed(w, state, err)
+	}
+	parsed, err := ParseWebhook(webhook, settings.registry)
 	if err != nil {
 		logError("failed to parse transfer events", err)
 		http.Error(w, "Failed to parse transfer events", http.StatusBadRequest)
-		return
+		return err
+	}
+	rejectErr := strictness.applyDecodeFailures(parsed)
+	logParseErrors(webhook, parsed)
+	if rejectErr != nil {
+		logError("webhook rejected", rejectErr)
+		http.Error(w, "Failed to decode webhook logs", http.StatusBadRequest)
+		return rejectErr
+	}
+	state.Parsed = parsed
+	if err := afterStage(ctx, StageDecode, state); err != nil {
+		return hookFailed(w, state, err)
 	}
 
-	if len(transfers) == 0 {
+	if err := beforeStage(ctx, StageFilter, state); err != nil {
+		return hookFailed(w, state, err)
+	}
+	count := len(parsed.Transfers)
+	applyFailedTxPolicy(settings.failedTxPolicy, parsed)
+	if dropped := count - len(parsed.Transfers) - len(parsed.Reverted); dropped > 0 {
+		log.Printf(`{"level":"info","message":"dropped reverted transfers","webhook_id":"%s","count":%d}`, webhook.WebhookID, dropped)
+	}
+	if filtered := applyTransferFilter(settings.filter, parsed); filtered > 0 {
+		log.Printf(`{"level":"info","message":"filtered transfers","webhook_id":"%s","count":%d}`, webhook.WebhookID, filtered)
+	}
+	applyRawLogRetention(settings.rawLogRetention, webhook, parsed)
+
+	if parsed.Empty() {
 		log.Printf(`{"level":"warn","message":"no transfer events found in webhook","webhook_id":"%s"}`, webhook.WebhookID)
 		w.WriteHeader(http.StatusOK)
-		return
+		return nil
 	}
 
-	transfersJSON, _ := json.Marshal(transfers)
-	log.Printf(`{"level":"info","message":"parsed transfer events","webhook_id":"%s","count":%d,"transfers":%s}`,
-		webhook.WebhookID, len(transfers), string(transfersJSON))
+	if err := afterStage(ctx, StageFilter, state); err != nil {
+		return hookFailed(w, state, err)
+	}
 
-	if os.Getenv("ENABLE_PUBSUB") == "true" {
-		if err := publishToPubSub(ctx, transfers); err != nil {
-			logError("failed to publish to Pub/Sub", err)
-			http.Error(w, "Failed to publish to Pub/Sub", http.StatusInternalServerError)
-			return
-		}
+	if err := beforeStage(ctx, StageEnrich, state); err != nil {
+		return hookFailed(w, state, err)
+	}
+	if !shedder.Shed(FeatureEnrichment, webhook.WebhookID) {
+		enrichTransfers(ctx, settings.enrichers, parsed.Transfers)
+		enrichTransfers(ctx, settings.enrichers, parsed.Reverted)
+	}
+	setAmountBuckets(parsed.Transfers)
+	setAmountBuckets(parsed.Reverted)
+	if os.Getenv("ENABLE_FINALITY_TRACKING") == "true" {
+		markPending(parsed.Transfers)
+	}
+	if err := afterStage(ctx, StageEnrich, state); err != nil {
+		return hookFailed(w, state, err)
 	}
 
-	if os.Getenv("ENABLE_FIRESTORE") == "true" {
-		if err := writeToFirestore(ctx, transfers); err != nil {
-			logError("failed to write to Firestore", err)
-			http.Error(w, "Failed to write to Firestore", http.StatusInternalServerError)
-			return
+	if err := beforeStage(ctx, StageRoute, state); err != nil {
+		return hookFailed(w, state, err)
+	}
+	if dropped := applyRules(settings.rules, parsed); dropped > 0 {
+		log.Printf(`{"level":"info","message":"dropped transfers by rule","webhook_id":"%s","count":%d}`, webhook.WebhookID, dropped)
+	}
+	if err := afterStage(ctx, StageRoute, state); err != nil {
+		return hookFailed(w, state, err)
+	}
+	stampTombstones(settings.removedPolicy, settings.removedTTL, parsed.Tombstones)
+
+	transfersJSON, _ := json.Marshal(parsed.Transfers)
+	log.Printf(`{"level":"info","message":"parsed transfer events","webhook_id":"%s","count":%d,"transfers":%s}`,
+		webhook.WebhookID, len(parsed.Transfers), string(transfersJSON))
+	if len(parsed.Events) > 0 {
+		log.Printf(`{"level":"info","message":"parsed registered events","webhook_id":"%s","count":%d}`, webhook.WebhookID, len(parsed.Events))
+	}
+	if len(parsed.Approvals) > 0 {
+		log.Printf(`{"level":"info","message":"parsed approval events","webhook_id":"%s","count":%d}`, webhook.WebhookID, len(parsed.Approvals))
+	}
+	if len(parsed.Swaps) > 0 {
+		log.Printf(`{"level":"info","message":"parsed swap events","webhook_id":"%s","count":%d}`, webhook.WebhookID, len(parsed.Swaps))
+	}
+	if len(parsed.Tombstones) > 0 {
+		log.Printf(`{"level":"info","message":"parsed removed logs","webhook_id":"%s","count":%d}`, webhook.WebhookID, len(parsed.Tombstones))
+	}
+	// Quarantined logs keep raw addresses, so a pseudonymized Firestore sink drops them and they
+	// are dead-lettered as if it were disabled.
+	if len(parsed.Quarantined) > 0 && (!sinkEnabled(sinkFirestore) || settings.pseudonymizer.Covers(sinkFirestore)) {
+		if err := writeDecodeDeadLetter(ctx, parsed); err != nil {
+			logError("failed to dead-letter quarantined logs", err)
 		}
 	}
+	if len(parsed.Transactions) > 0 {
+		log.Printf(`{"level":"info","message":"parsed transactions","webhook_id":"%s","count":%d}`, webhook.WebhookID, len(parsed.Transactions))
+	}
+
+	if err := beforeStage(ctx, StagePersist, state); err != nil {
+		return hookFailed(w, state, err)
+	}
+	if err := deliverToSinks(ctx, shedder, settings, parsed, webhook.WebhookID); err != nil {
+		logError("failed to deliver to sink", err)
+		http.Error(w, "Failed to deliver to sink", http.StatusInternalServerError)
+		return err
+	}
+	if err := afterStage(ctx, StagePersist, state); err != nil {
+		return hookFailed(w, state, err)
+	}
 
 	w.WriteHeader(http.StatusOK)
+	return nil
 }
 
-func publishToPubSub(ctx context.Context, transfers []*TransferDocument) error {
-	publisher, err := NewPubSubPublisher(ctx)
+// publishToPubSub publishes each kind of parsed document as its own message.
+// Reverted transfers routed to their own collection are never published.
+// Transactions go to ALCHEMY_PUBSUB_TRANSACTIONS_TOPIC when set, otherwise to ALCHEMY_PUBSUB_TOPIC.
+func publishToPubSub(ctx context.Context, parsed *ParsedWebhook) error {
+	transactionsTopic := os.Getenv("ALCHEMY_PUBSUB_TRANSACTIONS_TOPIC")
+	if len(parsed.Transactions) > 0 && transactionsTopic != "" {
+		publisher, err := newPublisher(ctx, transactionsTopic)
+		if err != nil {
+			return err
+		}
+		defer closePublisher(publisher)
+		if err := publisher.PublishTransactions(ctx, parsed.Transactions); err != nil {
+			return err
+		}
+	}
+
+	topics, byTopic := groupTransfers(parsed.Transfers, func(doc *TransferDocument) string { return doc.route.topic() })
+	for _, topic := range topics {
+		if topic == "" {
+			continue
+		}
+		if err := publishTransfersTo(ctx, topic, byTopic[topic]); err != nil {
+			return err
+		}
+	}
+	transfers := byTopic[""]
+
+	publishTransactions := len(parsed.Transactions) > 0 && transactionsTopic == ""
+	if len(transfers) == 0 && len(parsed.Events) == 0 && len(parsed.Approvals) == 0 && len(parsed.Swaps) == 0 &&
+		len(parsed.Tombstones) == 0 && !publishTransactions {
+		return nil
+	}
+
+	publisher, err := openPublisher(ctx)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := publisher.Close(); err != nil {
-			log.Printf(`{"level":"error","message":"failed to close pubsub publisher","error":"%s"}`, err.Error())
+	defer closePublisher(publisher)
+
+	if len(transfers) > 0 {
+		if err := publisher.PublishTransfers(ctx, transfers); err != nil {
+			return err
 		}
-	}()
+	}
+	if len(parsed.Events) > 0 {
+		if err := publisher.PublishEvents(ctx, parsed.Events); err != nil {
+			return err
+		}
+	}
+	if len(parsed.Approvals) > 0 {
+		if err := publisher.PublishApprovals(ctx, parsed.Approvals); err != nil {
+			return err
+		}
+	}
+	if len(parsed.Swaps) > 0 {
+		if err := publisher.PublishSwaps(ctx, parsed.Swaps); err != nil {
+			return err
+		}
+	}
+	if len(parsed.Tombstones) > 0 {
+		if err := publisher.PublishTombstones(ctx, parsed.Tombstones); err != nil {
+			return err
+		}
+	}
+	if publishTransactions {
+		return publisher.PublishTransactions(ctx, parsed.Transactions)
+	}
+	return nil
+}
+
+// publishTransfersTo publishes transfers routed by a rule to topic.
+func publishTransfersTo(ctx context.Context, topic string, transfers []*TransferDocument) error {
+	publisher, err := newPublisher(ctx, topic)
+	if err != nil {
+		return err
+	}
+	defer closePublisher(publisher)
 	return publisher.PublishTransfers(ctx, transfers)
 }
 
-func writeToFirestore(ctx context.Context, transfers []*TransferDocument) error {
-	writer, err := NewFirestoreWriter(ctx)
+func closePublisher(publisher Publisher) {
+	if err := publisher.Close(); err != nil {
+		log.Printf(`{"level":"error","message":"failed to close pubsub publisher","error":"%s"}`, err.Error())
+	}
+}
+
+// writeRoutedTransfers writes transfers to collection, or to the collection of the rule that
+// routed them.
+func writeRoutedTransfers(ctx context.Context, writer Writer, collection string, transfers []*TransferDocument) error {
+	collections, byCollection := groupTransfers(transfers, func(doc *TransferDocument) string { return doc.route.collection() })
+	for _, routed := range collections {
+		target := routed
+		if target == "" {
+			target = collection
+		}
+		if err := writeDocuments(ctx, writer, target, byCollection[routed]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeToFirestore writes each kind of parsed document to its own collection through the
+// writer of SetWriterFactory, Firestore by default.
+// Dropped transactions are recorded or deleted according to droppedPolicy, and the documents
+// of removed logs are marked or deleted according to removedPolicy.
+func writeToFirestore(ctx context.Context, parsed *ParsedWebhook, droppedPolicy DroppedTxPolicy, removedPolicy RemovedLogPolicy) error {
+	writer, err := newWriter(ctx)
 	if err != nil {
 		return err
 	}
-	return writer.WriteBatchTransfers(ctx, transfers)
+	// Tombstones go first: a transaction re-included after a reorg can produce the same
+	// document ID, and its fresh document must overwrite the removed one.
+	if len(parsed.Tombstones) > 0 {
+		tombstones := parsed.Tombstones
+		if transferGroupsEnabled() {
+			tombstones = append(transferGroupTombstones(tombstones), tombstones...)
+		}
+		if blockSummariesEnabled() {
+			tombstones = append(blockSummaryTombstones(tombstones), tombstones...)
+		}
+		if err := writer.ApplyTombstones(ctx, removedPolicy, tombstones); err != nil {
+			return err
+		}
+	}
+	if err := writeRoutedTransfers(ctx, writer, collectionName, parsed.Transfers); err != nil {
+		return err
+	}
+	if err := writeRoutedTransfers(ctx, writer, revertedCollectionName, parsed.Reverted); err != nil {
+		return err
+	}
+	if transferGroupsEnabled() && len(parsed.Transfers) > 0 {
+		if err := writeDocuments(ctx, writer, transferGroupsCollectionName, newTransferGroups(parsed.Transfers)); err != nil {
+			return err
+		}
+	}
+	if blockSummariesEnabled() && len(parsed.Transfers) > 0 {
+		if err := writeDocuments(ctx, writer, blockSummariesCollectionName, newBlockSummaries(parsed.Transfers)); err != nil {
+			return err
+		}
+	}
+	if len(parsed.Events) > 0 {
+		if err := writeDocuments(ctx, writer, eventsCollectionName, parsed.Events); err != nil {
+			return err
+		}
+	}
+	if len(parsed.Approvals) > 0 {
+		if err := writeDocuments(ctx, writer, approvalsCollectionName, parsed.Approvals); err != nil {
+			return err
+		}
+	}
+	if len(parsed.Swaps) > 0 {
+		if err := writeDocuments(ctx, writer, swapsCollectionName, parsed.Swaps); err != nil {
+			return err
+		}
+	}
+	if len(parsed.RawLogs) > 0 {
+		if err := writeDocuments(ctx, writer, rawLogsCollectionName, parsed.RawLogs); err != nil {
+			return err
+		}
+	}
+	if len(parsed.Quarantined) > 0 {
+		if err := writeDocuments(ctx, writer, quarantineCollectionName, parsed.Quarantined); err != nil {
+			return err
+		}
+		emitLifecycleEvent(ctx, LifecycleQuarantineGrowth, map[string]any{
+			"webhookId": parsed.Quarantined[0].Alchemy.WebhookID, "count": len(parsed.Quarantined),
+		})
+	}
+	transactions, dropped := splitDroppedTransactions(droppedPolicy, parsed.Transactions)
+	if len(transactions) > 0 {
+		if err := writeDocuments(ctx, writer, transactionsCollectionName, transactions); err != nil {
+			return err
+		}
+	}
+	if len(dropped) > 0 {
+		if err := deleteDocuments(ctx, writer, transactionsCollectionName, dropped); err != nil {
+			return err
+		}
+	}
+
+	// Bridge stitching and the first-seen registry read Firestore, so they need a writer that
+	// implements them.
+	bridges, err := LoadBridges()
+	if err != nil {
+		return err
+	}
+	firstSeen := os.Getenv("ENABLE_FIRST_SEEN") == "true"
+	if len(bridges) == 0 && !firstSeen {
+		return nil
+	}
+	links, ok := writer.(linkWriter)
+	if !ok {
+		log.Printf(`{"level":"warn","message":"writer does not support bridge stitching or first-seen records; skipped"}`)
+		return nil
+	}
+	if len(bridges) > 0 {
+		links.StitchBridges(ctx, bridges, parsed)
+	}
+	if firstSeen {
+		return links.RecordFirstSeen(ctx, parsed)
+	}
+	return nil
 }
@@ -0,0 +1,93 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// singleTransferBody is a GRAPHQL webhook holding one ERC20 Transfer log, the common case.
+const singleTransferBody = `{
+  "webhookId": "wh_test",
+  "id": "whevt_test",
+  "createdAt": "2026-01-02T03:04:05Z",
+  "type": "GRAPHQL",
+  "event": {
+    "network": "ETH_MAINNET",
+    "sequenceNumber": "1",
+    "data": {
+      "block": {
+        "hash": "0x9b2b1c4f6a3d8e7f0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6071",
+        "number": 20000000,
+        "timestamp": 1767323045,
+        "logs": [{
+          "data": "0x00000000000000000000000000000000000000000000000000000000000f4240",
+          "topics": [
+            "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+            "0x000000000000000000000000a9d1e08c7793af67e9d92fe308d5697fb81d3e43",
+            "0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60"
+          ],
+          "index": 7,
+          "removed": false,
+          "account": {"address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"},
+          "transaction": {
+            "hash": "0x5c504ed432cb51138bcf09aa5e8a410dd4a1e204ef84bfed1be16dfba1b22060",
+            "from": {"address": "0xa9d1e08c7793af67e9d92fe308d5697fb81d3e43"},
+            "to": {"address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"},
+            "type": 2,
+            "value": "0x0",
+            "gasPrice": "0x12a05f200",
+            "maxFeePerGas": "0x2540be400",
+            "maxPriorityFeePerGas": "0x3b9aca00",
+            "effectiveGasPrice": "0x12a05f200",
+            "gas": 100000,
+            "status": 1,
+            "gasUsed": 51000
+          }
+        }]
+      }
+    }
+  }
+}`
+
+// singleTransferWebhook returns a fresh copy of the webhook in singleTransferBody.
+func singleTransferWebhook(tb testing.TB) *WebhookEvent {
+	tb.Helper()
+	var webhook WebhookEvent
+	if err := json.Unmarshal([]byte(singleTransferBody), &webhook); err != nil {
+		tb.Fatal(err)
+	}
+	return &webhook
+}
+
+// discardLogs silences the structured logs written during a benchmark.
+func discardLogs(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// BenchmarkHandleWebhookSingleTransfer measures the handler on a one-log webhook with the local
+// sink, so it covers decoding, filtering, routing and delivery without network round trips.
+func BenchmarkHandleWebhookSingleTransfer(b *testing.B) {
+	b.Setenv("SINKS", sinkLocal)
+	b.Setenv("LOCAL_SINK_PATH", filepath.Join(b.TempDir(), "documents.ndjson"))
+	discardLogs(b)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		w := httptest.NewRecorder()
+		if err := handleWebhook(w, ctx, singleTransferWebhook(b)); err != nil {
+			b.Fatal(err)
+		}
+		if w.Code != http.StatusOK {
+			b.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GraphQLMapping locates the block and log fields inside the event.data of GRAPHQL custom webhooks,
+// so the Alchemy query can change shape without changing the parser.
+//
+// Paths are dot-separated keys. A key suffixed with [] iterates an array and flattens the results,
+// and ^ steps up to the object enclosing the current one. Block paths are resolved against
+// event.data; log paths against each log found under Logs. Empty paths keep the default shape.
+type GraphQLMapping struct {
+	BlockHash      string            `json:"blockHash"`
+	BlockNumber    string            `json:"blockNumber"`
+	BlockTimestamp string            `json:"blockTimestamp"`
+	Logs           string            `json:"logs"`
+	Log            GraphQLLogMapping `json:"log"`
+}
+
+// GraphQLLogMapping locates the fields of a single log.
+type GraphQLLogMapping struct {
+	Data                 string `json:"data"`
+	Topics               string `json:"topics"`
+	Index                string `json:"index"`
+	Address              string `json:"address"`
+	TransactionHash      string `json:"transactionHash"`
+	From                 string `json:"from"`
+	To                   string `json:"to"`
+	Value                string `json:"value"`
+	GasPrice             string `json:"gasPrice"`
+	MaxFeePerGas         string `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+	EffectiveGasPrice    string `json:"effectiveGasPrice"`
+	Type                 string `json:"type"`
+	CreatedContract      string `json:"createdContract"`
+	Gas                  string `json:"gas"`
+	Status               string `json:"status"`
+	GasUsed              string `json:"gasUsed"`
+	Removed              string `json:"removed"`
+}
+
+// defaultGraphQLMapping matches the GraphQL query documented in the README.
+var defaultGraphQLMapping = GraphQLMapping{
+	BlockHash:      "block.hash",
+	BlockNumber:    "block.number",
+	BlockTimestamp: "block.timestamp",
+	Logs:           "block.logs[]",
+	Log: GraphQLLogMapping{
+		Data:                 "data",
+		Topics:               "topics",
+		Index:                "index",
+		Address:              "account.address",
+		TransactionHash:      "transaction.hash",
+		From:                 "transaction.from.address",
+		To:                   "transaction.to.address",
+		Value:                "transaction.value",
+		GasPrice:             "transaction.gasPrice",
+		MaxFeePerGas:         "transaction.maxFeePerGas",
+		MaxPriorityFeePerGas: "transaction.maxPriorityFeePerGas",
+		EffectiveGasPrice:    "transaction.effectiveGasPrice",
+		Type:                 "transaction.type",
+		CreatedContract:      "transaction.createdContract.address",
+		Gas:                  "transaction.gas",
+		Status:               "transaction.status",
+		GasUsed:              "transaction.gasUsed",
+		Removed:              "removed",
+	},
+}
+
+var (
+	graphQLMappingOnce sync.Once
+	graphQLMapping     *GraphQLMapping
+	graphQLMappingErr  error
+)
+
+// LoadGraphQLMapping returns the mapping configured through GRAPHQL_MAPPING_FILE (path to a JSON file)
+// or GRAPHQL_MAPPING (inline JSON), merged over the default shape. It is loaded once per instance and
+// is nil when neither variable is set, in which case GRAPHQL webhooks are decoded strictly.
+func LoadGraphQLMapping() (*GraphQLMapping, error) {
+	graphQLMappingOnce.Do(func() {
+		graphQLMapping, graphQLMappingErr = loadGraphQLMapping()
+	})
+	return graphQLMapping, graphQLMappingErr
+}
+
+func loadGraphQLMapping() (*GraphQLMapping, error) {
+	data := []byte(os.Getenv("GRAPHQL_MAPPING"))
+	if path := os.Getenv("GRAPHQL_MAPPING_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GRAPHQL_MAPPING_FILE: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	mapping := defaultGraphQLMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse GraphQL mapping: %w", err)
+	}
+	if mapping.Logs == "" {
+		return nil, fmt.Errorf("GraphQL mapping has no logs path")
+	}
+	return &mapping, nil
+}
+
+// parseGraphQLWebhookEvent decodes a GRAPHQL webhook whose event.data follows mapping instead of
+// the default query shape. Everything outside event.data is decoded as usual, and other webhook
+// types are decoded strictly.
+func parseGraphQLWebhookEvent(body []byte, mapping *GraphQLMapping) (*WebhookEvent, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	if webhookType, _ := raw["type"].(string); webhookType != WebhookTypeGraphQL {
+		return parseWebhookEvent(body, nil)
+	}
+	var data any
+	if event, ok := raw["event"].(map[string]any); ok {
+		data = event["data"]
+		delete(event, "data")
+	}
+
+	rest, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var webhook WebhookEvent
+	if err := json.Unmarshal(rest, &webhook); err != nil {
+		return nil, err
+	}
+
+	root := pathNode{value: data}
+	block := &webhook.Event.Data.Block
+	block.Hash = lookupString(root, mapping.BlockHash)
+	if block.Number, err = lookupInt(root, mapping.BlockNumber); err != nil {
+		return nil, fmt.Errorf("invalid block number: %w", err)
+	}
+	if block.Timestamp, err = lookupInt(root, mapping.BlockTimestamp); err != nil {
+		return nil, fmt.Errorf("invalid block timestamp: %w", err)
+	}
+
+	nodes, err := resolvePath(root, mapping.Logs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logs path: %w", err)
+	}
+	block.Logs = make([]WebhookLog, 0, len(nodes))
+	for i, node := range nodes {
+		log, err := mapGraphQLLog(node, mapping.Log)
+		if err != nil {
+			return nil, fmt.Errorf("log %d: %w", i, err)
+		}
+		block.Logs = append(block.Logs, log)
+	}
+	return &webhook, nil
+}
+
+// mapGraphQLLog builds a WebhookLog from the log object at node.
+func mapGraphQLLog(node pathNode, m GraphQLLogMapping) (WebhookLog, error) {
+	var log WebhookLog
+	log.Data = lookupString(node, m.Data)
+	log.Account.Address = lookupString(node, m.Address)
+	log.Transaction.Hash = lookupString(node, m.TransactionHash)
+	log.Transaction.From.Address = lookupString(node, m.From)
+	log.Transaction.To.Address = lookupString(node, m.To)
+	log.Transaction.Value = lookupString(node, m.Value)
+	log.Transaction.GasPrice = lookupString(node, m.GasPrice)
+	log.Transaction.MaxFeePerGas = lookupString(node, m.MaxFeePerGas)
+	log.Transaction.MaxPriorityFeePerGas = lookupString(node, m.MaxPriorityFeePerGas)
+	log.Transaction.EffectiveGasPrice = lookupString(node, m.EffectiveGasPrice)
+	log.Transaction.CreatedContract.Address = lookupString(node, m.CreatedContract)
+	if lookupString(node, m.Type) != "" {
+		txType, err := lookupInt(node, m.Type)
+		if err != nil {
+			return WebhookLog{}, fmt.Errorf("invalid type: %w", err)
+		}
+		log.Transaction.Type = &txType
+	}
+	if lookupString(node, m.Status) != "" {
+		v, err := lookupInt(node, m.Status)
+		if err != nil {
+			return WebhookLog{}, fmt.Errorf("invalid status: %w", err)
+		}
+		status := int(v)
+		log.Transaction.Status = &status
+	}
+	log.Removed = lookupString(node, m.Removed) == "true"
+
+	topicsPath := m.Topics
+	if !strings.HasSuffix(topicsPath, "[]") {
+		topicsPath += "[]"
+	}
+	topics, err := resolvePath(node, topicsPath)
+	if err != nil {
+		return WebhookLog{}, fmt.Errorf("invalid topics: %w", err)
+	}
+	for _, topic := range topics {
+		log.Topics = append(log.Topics, stringValue(topic.value))
+	}
+
+	ints := []struct {
+		name string
+		path string
+		set  func(int64)
+	}{
+		{"index", m.Index, func(v int64) { log.Index = int(v) }},
+		{"gas", m.Gas, func(v int64) { log.Transaction.Gas = v }},
+		{"gasUsed", m.GasUsed, func(v int64) { log.Transaction.GasUsed = v }},
+	}
+	for _, field := range ints {
+		v, err := lookupInt(node, field.path)
+		if err != nil {
+			return WebhookLog{}, fmt.Errorf("invalid %s: %w", field.name, err)
+		}
+		field.set(v)
+	}
+	return log, nil
+}
+
+// pathNode is a value reached while resolving a path, with the objects enclosing it.
+type pathNode struct {
+	value   any
+	parents []map[string]any
+}
+
+// resolvePath returns every value matching path below node. Missing keys yield no values.
+func resolvePath(node pathNode, path string) ([]pathNode, error) {
+	nodes := []pathNode{node}
+	if path == "" {
+		return nodes, nil
+	}
+	for _, segment := range strings.Split(path, ".") {
+		key, flatten := strings.CutSuffix(segment, "[]")
+		next := make([]pathNode, 0, len(nodes))
+		for _, n := range nodes {
+			switch key {
+			case "":
+			case "^":
+				if len(n.parents) == 0 {
+					return nil, fmt.Errorf("%q steps above the root", path)
+				}
+				last := len(n.parents) - 1
+				n = pathNode{value: n.parents[last], parents: n.parents[:last]}
+			default:
+				object, ok := n.value.(map[string]any)
+				if !ok {
+					continue
+				}
+				value, ok := object[key]
+				if !ok {
+					continue
+				}
+				n = pathNode{value: value, parents: append(n.parents[:len(n.parents):len(n.parents)], object)}
+			}
+			if !flatten {
+				next = append(next, n)
+				continue
+			}
+			items, ok := n.value.([]any)
+			if !ok {
+				continue
+			}
+			for _, item := range items {
+				next = append(next, pathNode{value: item, parents: n.parents})
+			}
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+// lookupString returns the first value at path as a string, or "" when absent.
+func lookupString(node pathNode, path string) string {
+	if path == "" {
+		return ""
+	}
+	nodes, err := resolvePath(node, path)
+	if err != nil || len(nodes) == 0 {
+		return ""
+	}
+	return stringValue(nodes[0].value)
+}
+
+// lookupInt returns the first value at path as an integer, accepting JSON numbers and
+// decimal or 0x-prefixed hex strings. Absent values are 0.
+func lookupInt(node pathNode, path string) (int64, error) {
+	if path == "" {
+		return 0, nil
+	}
+	nodes, err := resolvePath(node, path)
+	if err != nil || len(nodes) == 0 {
+		return 0, err
+	}
+	switch value := nodes[0].value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		if value != math.Trunc(value) {
+			return 0, fmt.Errorf("%v is not an integer", value)
+		}
+		return int64(value), nil
+	case string:
+		if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+			v, err := parseHexUint64(value)
+			return int64(v), err
+		}
+		return strconv.ParseInt(value, 10, 64)
+	default:
+		return 0, fmt.Errorf("unexpected %T value", value)
+	}
+}
+
+// stringValue formats scalar JSON values as strings; other values become "".
+func stringValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,138 @@
+package function
+
+import (
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+)
+
+const transferGroupsCollectionName = "alchemy_transfer_groups"
+
+// TransferLeg is one transfer of a TransferGroupDocument.
+type TransferLeg struct {
+	DocumentID string `json:"documentId"`
+	Contract   string `json:"contract"`
+	Standard   string `json:"standard"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Value      string `json:"value,omitempty"`
+	TokenID    string `json:"tokenId,omitempty"`
+	LogIndex   int    `json:"logIndex"`
+}
+
+// NetFlow is the signed decimal amount of a token an address gained (positive) or lost
+// (negative) over the transfers of a transaction.
+type NetFlow struct {
+	Address  string `json:"address"`
+	Contract string `json:"contract"`
+	Amount   string `json:"amount"`
+}
+
+// TransferGroupDocument aggregates every transfer of one transaction, such as the hops of a
+// multi-hop swap, with the net flow of each token per address. Legs keep the transfer order and
+// net flows only count transfers that carry a value, so ERC721 transfers appear as legs only.
+type TransferGroupDocument struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Block         Block           `json:"block"`
+	Transaction   Transaction     `json:"transaction"`
+	Legs          []TransferLeg   `json:"legs"`
+	NetFlows      []NetFlow       `json:"netFlows"`
+	Network       string          `json:"network"`
+	Alchemy       AlchemyMetadata `json:"alchemy"`
+}
+
+// DocumentID returns the transaction hash, so each transaction has a single group.
+func (d *TransferGroupDocument) DocumentID() string {
+	return d.Transaction.Hash
+}
+
+// transferGroupsEnabled reports whether ENABLE_TRANSFER_GROUPS turns on per-transaction groups.
+func transferGroupsEnabled() bool {
+	return os.Getenv("ENABLE_TRANSFER_GROUPS") == "true"
+}
+
+// newTransferGroups groups transfers by transaction, in order of first appearance.
+func newTransferGroups(transfers []*TransferDocument) []*TransferGroupDocument {
+	var groups []*TransferGroupDocument
+	byHash := make(map[string]*TransferGroupDocument)
+	flows := make(map[*TransferGroupDocument]map[string]*NetFlow)
+	amounts := make(map[*NetFlow]*big.Int)
+	addFlow := func(group *TransferGroupDocument, address, contract string, amount *big.Int) {
+		// Addresses are compared case-insensitively and keep the spelling seen first.
+		key := strings.ToLower(address) + "/" + strings.ToLower(contract)
+		flow, ok := flows[group][key]
+		if !ok {
+			flow = &NetFlow{Address: address, Contract: contract}
+			flows[group][key] = flow
+			amounts[flow] = new(big.Int)
+		}
+		amounts[flow].Add(amounts[flow], amount)
+	}
+	for _, doc := range transfers {
+		group, ok := byHash[doc.Transaction.Hash]
+		if !ok {
+			group = &TransferGroupDocument{
+				SchemaVersion: SchemaVersion,
+				Block:         doc.Block,
+				Transaction:   doc.Transaction,
+				Network:       doc.Network,
+				Alchemy:       doc.Alchemy,
+			}
+			byHash[doc.Transaction.Hash] = group
+			flows[group] = make(map[string]*NetFlow)
+			groups = append(groups, group)
+		}
+		group.Legs = append(group.Legs, TransferLeg{
+			DocumentID: doc.DocumentID(),
+			Contract:   doc.Transfer.Contract,
+			Standard:   doc.Transfer.Standard,
+			From:       doc.Transfer.From,
+			To:         doc.Transfer.To,
+			Value:      bigIntString(doc.Transfer.Value),
+			TokenID:    bigIntString(doc.Transfer.TokenID),
+			LogIndex:   doc.Transfer.LogIndex,
+		})
+		if doc.Transfer.Value == nil {
+			continue
+		}
+		addFlow(group, doc.Transfer.From, doc.Transfer.Contract, new(big.Int).Neg(doc.Transfer.Value))
+		addFlow(group, doc.Transfer.To, doc.Transfer.Contract, doc.Transfer.Value)
+	}
+
+	for _, group := range groups {
+		group.NetFlows = []NetFlow{}
+		for _, flow := range flows[group] {
+			if amount := amounts[flow]; amount.Sign() != 0 {
+				flow.Amount = amount.String()
+				group.NetFlows = append(group.NetFlows, *flow)
+			}
+		}
+		sort.Slice(group.NetFlows, func(i, j int) bool {
+			a, b := group.NetFlows[i], group.NetFlows[j]
+			if a.Address != b.Address {
+				return a.Address < b.Address
+			}
+			return a.Contract < b.Contract
+		})
+	}
+	return groups
+}
+
+// transferGroupTombstones returns a tombstone for the group of every transaction with a
+// removed transfer among tombstones.
+func transferGroupTombstones(tombstones []*Tombstone) []*Tombstone {
+	var groups []*Tombstone
+	seen := make(map[string]bool)
+	for _, tombstone := range tombstones {
+		if tombstone.Kind != KindTransfer || seen[tombstone.TransactionHash] {
+			continue
+		}
+		seen[tombstone.TransactionHash] = true
+		group := *tombstone
+		group.Collection = transferGroupsCollectionName
+		group.ID = tombstone.TransactionHash
+		groups = append(groups, &group)
+	}
+	return groups
+}
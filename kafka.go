@@ -0,0 +1,177 @@
+package function
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// kafkaSink produces one record per transfer to KAFKA_TOPIC on the KAFKA_BROKERS cluster,
+// serialized with KAFKA_SERIALIZER and keyed by the transfer's document ID, <txHash>-<logIndex>,
+// so every record of a transfer, including its tombstone after a reorg, lands on the same
+// partition in order. Headers carry the Pub/Sub message attributes. The producer is idempotent
+// and waits for all in-sync replicas, so a webhook is only acknowledged once its records are
+// durable.
+type kafkaSink struct {
+	client     *kgo.Client
+	serializer Serializer
+}
+
+func (*kafkaSink) Name() string { return sinkKafka }
+
+// Init reads the broker, topic, TLS and SASL configuration and creates the producer.
+func (s *kafkaSink) Init(ctx context.Context) error {
+	brokers := parseList(os.Getenv("KAFKA_BROKERS"))
+	if len(brokers) == 0 {
+		return errors.New("KAFKA_BROKERS must be set")
+	}
+	topic := os.Getenv("KAFKA_TOPIC")
+	if topic == "" {
+		return errors.New("KAFKA_TOPIC must be set")
+	}
+	serializer, err := sinkSerializer(sinkKafka)
+	if err != nil {
+		return err
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(brokers...),
+		kgo.DefaultProduceTopic(topic),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	}
+	if clientID := os.Getenv("KAFKA_CLIENT_ID"); clientID != "" {
+		opts = append(opts, kgo.ClientID(clientID))
+	}
+	tlsConfig, err := kafkaTLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+	mechanism, err := kafkaSASLMechanism()
+	if err != nil {
+		return err
+	}
+	if mechanism != nil {
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to reach KAFKA_BROKERS: %w", err)
+	}
+	s.client, s.serializer = client, serializer
+	return nil
+}
+
+// kafkaTLSConfig returns the TLS configuration enabled by KAFKA_TLS, trusting the system roots
+// or the PEM certificates in KAFKA_TLS_CA_FILE, or nil for plaintext connections.
+func kafkaTLSConfig() (*tls.Config, error) {
+	if os.Getenv("KAFKA_TLS") != "true" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if path := os.Getenv("KAFKA_TLS_CA_FILE"); path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read KAFKA_TLS_CA_FILE: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("KAFKA_TLS_CA_FILE holds no PEM certificates")
+		}
+	}
+	return config, nil
+}
+
+// kafkaSASLMechanism returns the SASL mechanism named by KAFKA_SASL_MECHANISM (plain,
+// scram-sha-256 or scram-sha-512) with KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD, or nil
+// without SASL.
+func kafkaSASLMechanism() (sasl.Mechanism, error) {
+	name := os.Getenv("KAFKA_SASL_MECHANISM")
+	if name == "" {
+		return nil, nil
+	}
+	user, pass := os.Getenv("KAFKA_SASL_USERNAME"), os.Getenv("KAFKA_SASL_PASSWORD")
+	if user == "" || pass == "" {
+		return nil, errors.New("KAFKA_SASL_MECHANISM requires KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD")
+	}
+	switch name {
+	case "plain":
+		return plain.Auth{User: user, Pass: pass}.AsMechanism(), nil
+	case "scram-sha-256":
+		return scram.Auth{User: user, Pass: pass}.AsSha256Mechanism(), nil
+	case "scram-sha-512":
+		return scram.Auth{User: user, Pass: pass}.AsSha512Mechanism(), nil
+	}
+	return nil, fmt.Errorf("invalid KAFKA_SASL_MECHANISM %q", name)
+}
+
+// Write produces the webhook's transfers, then the tombstones of transfers removed by a reorg,
+// and waits until every record is acknowledged.
+func (s *kafkaSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	var records []*kgo.Record
+	for _, doc := range parsed.Transfers {
+		record, err := s.record("transfer", doc, doc.Alchemy, doc.Network)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.Kind != KindTransfer {
+			continue
+		}
+		record, err := s.record("tombstone", tombstone, tombstone.Alchemy, tombstone.Network)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return s.client.ProduceSync(ctx, records...).FirstErr()
+}
+
+// record returns the record of doc, keyed by its document ID.
+func (s *kafkaSink) record(kind string, doc Document, alchemy AlchemyMetadata, network string) (*kgo.Record, error) {
+	value, err := s.serializer.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s %s: %w", kind, doc.DocumentID(), err)
+	}
+	headers := []kgo.RecordHeader{
+		{Key: "type", Value: []byte(kind)},
+		{Key: "webhook_id", Value: []byte(alchemy.WebhookID)},
+		{Key: "event_id", Value: []byte(alchemy.EventID)},
+		{Key: "network", Value: []byte(network)},
+		{Key: "content_type", Value: []byte(s.serializer.ContentType())},
+		{Key: "schema_version", Value: []byte(strconv.Itoa(SchemaVersion))},
+	}
+	return &kgo.Record{Key: []byte(doc.DocumentID()), Value: value, Headers: headers}, nil
+}
+
+// Close flushes and closes the producer.
+func (s *kafkaSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Flush(context.Background())
+	s.client.Close()
+	s.client = nil
+	return err
+}
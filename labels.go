@@ -0,0 +1,124 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// addressLabel is a single row of the address label dataset.
+type addressLabel struct {
+	Address string `json:"address" firestore:"address"`
+	Label   string `json:"label" firestore:"label"`
+}
+
+// LabelEnricher tags transfers whose sender or recipient has a known label, such as an
+// exchange, a bridge or one of our own treasury wallets.
+type LabelEnricher struct {
+	labels map[string]string
+}
+
+// NewLabelEnricher loads address labels from ADDRESS_LABELS_FILE, a JSON array of
+// {"address", "label"} records at a local path or gs://bucket/object, and from the
+// ADDRESS_LABELS_COLLECTION Firestore collection, whose documents hold a label field and use the
+// address as their ID unless they set an address field. Collection labels win over file labels.
+// It returns nil when neither source is configured.
+func NewLabelEnricher(ctx context.Context) (*LabelEnricher, error) {
+	path := os.Getenv("ADDRESS_LABELS_FILE")
+	collection := os.Getenv("ADDRESS_LABELS_COLLECTION")
+	if path == "" && collection == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	if path != "" {
+		data, err := readLabelsFile(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ADDRESS_LABELS_FILE: %w", err)
+		}
+		var records []addressLabel
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("failed to parse address labels: %w", err)
+		}
+		for _, record := range records {
+			labels[strings.ToLower(record.Address)] = record.Label
+		}
+	}
+	if collection != "" {
+		if err := loadLabelsCollection(ctx, collection, labels); err != nil {
+			return nil, fmt.Errorf("failed to load ADDRESS_LABELS_COLLECTION: %w", err)
+		}
+	}
+	return &LabelEnricher{labels: labels}, nil
+}
+
+// readLabelsFile reads path from the local filesystem, or from Cloud Storage for gs:// paths.
+func readLabelsFile(ctx context.Context, path string) ([]byte, error) {
+	object, ok := strings.CutPrefix(path, "gs://")
+	if !ok {
+		return os.ReadFile(path)
+	}
+	bucket, name, ok := strings.Cut(object, "/")
+	if !ok || bucket == "" || name == "" {
+		return nil, fmt.Errorf("invalid Cloud Storage path %q", path)
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	reader, err := client.Bucket(bucket).Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func loadLabelsCollection(ctx context.Context, collection string, labels map[string]string) error {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+	iter := client.Collection(collection).Documents(ctx)
+	defer iter.Stop()
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var record addressLabel
+		if err := snapshot.DataTo(&record); err != nil {
+			return err
+		}
+		if record.Address == "" {
+			record.Address = snapshot.Ref.ID
+		}
+		labels[strings.ToLower(record.Address)] = record.Label
+	}
+}
+
+// Name returns the enricher name.
+func (l *LabelEnricher) Name() string {
+	return "labels"
+}
+
+// Enrich sets FromLabel and ToLabel on transfers with a labeled sender or recipient.
+func (l *LabelEnricher) Enrich(_ context.Context, transfers []*TransferDocument) error {
+	for _, transfer := range transfers {
+		transfer.FromLabel = l.labels[strings.ToLower(transfer.Transfer.From)]
+		transfer.ToLabel = l.labels[strings.ToLower(transfer.Transfer.To)]
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package function
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Formats of the local sink.
+const (
+	localFormatNDJSON = "ndjson"
+	localFormatSQLite = "sqlite"
+)
+
+// localDocument is a document as the local sink writes it: its kind, transfer, event, approval,
+// swap, transaction or tombstone, its document ID and the document itself.
+type localDocument struct {
+	Kind     string          `json:"kind"`
+	ID       string          `json:"id"`
+	Document json.RawMessage `json:"document"`
+
+	// tombstone is set for tombstones, whose removed document the database deletes.
+	tombstone *Tombstone
+}
+
+// localSink writes every document of a webhook to LOCAL_SINK_PATH on the local disk, for running
+// the function and inspecting its output without GCP credentials. Documents are appended to an
+// NDJSON file, one localDocument per line, or, with LOCAL_SINK_FORMAT=sqlite or a .db, .sqlite or
+// .sqlite3 path, upserted into the documents table of a SQLite database by kind and ID, where a
+// tombstone deletes the document it removes. It is meant for development, not production.
+type localSink struct {
+	format     string
+	serializer Serializer
+
+	mu   sync.Mutex
+	file *os.File
+	db   *sql.DB
+}
+
+func (*localSink) Name() string { return sinkLocal }
+
+// Init opens the file or database, creating it and the documents table when missing.
+func (s *localSink) Init(ctx context.Context) error {
+	path := os.Getenv("LOCAL_SINK_PATH")
+	if path == "" {
+		return errors.New("LOCAL_SINK_PATH must be set")
+	}
+	s.format = os.Getenv("LOCAL_SINK_FORMAT")
+	if s.format == "" {
+		s.format = localFormatNDJSON
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".db", ".sqlite", ".sqlite3":
+			s.format = localFormatSQLite
+		}
+	}
+	serializer, err := sinkSerializer(sinkLocal)
+	if err != nil {
+		return err
+	}
+	if serializer.ContentType() != "application/json" {
+		return fmt.Errorf("the %s sink writes JSON and does not support LOCAL_SERIALIZER=%s", sinkLocal, serializer.Name())
+	}
+	s.serializer = serializer
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	switch s.format {
+	case localFormatNDJSON:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open LOCAL_SINK_PATH: %w", err)
+		}
+		s.file = file
+	case localFormatSQLite:
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			return fmt.Errorf("failed to open LOCAL_SINK_PATH: %w", err)
+		}
+		// A single connection serializes writes, as SQLite allows one writer at a time.
+		db.SetMaxOpenConns(1)
+		if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS documents (
+	kind TEXT NOT NULL,
+	id TEXT NOT NULL,
+	document TEXT NOT NULL,
+	written_at TEXT NOT NULL,
+	PRIMARY KEY (kind, id)
+)`); err != nil {
+			db.Close()
+			return fmt.Errorf("failed to create the documents table: %w", err)
+		}
+		s.db = db
+	default:
+		return fmt.Errorf("invalid LOCAL_SINK_FORMAT %q", s.format)
+	}
+	return nil
+}
+
+// Write writes the webhook's documents in one append or one transaction, tombstones first, so a
+// document re-included after a reorg ends up stored.
+func (s *localSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	docs, err := s.documents(parsed)
+	if err != nil || len(docs) == 0 {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		return s.upsert(ctx, docs)
+	}
+
+	var buf []byte
+	for _, doc := range docs {
+		line, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if _, err := s.file.Write(buf); err != nil {
+		return fmt.Errorf("failed to write %s: %w", s.file.Name(), err)
+	}
+	return nil
+}
+
+// documents returns the webhook's documents in the order they are written.
+func (s *localSink) documents(parsed *ParsedWebhook) ([]localDocument, error) {
+	var docs []localDocument
+	add := func(kind string, doc Document) error {
+		data, err := s.serializer.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s %s: %w", kind, doc.DocumentID(), err)
+		}
+		docs = append(docs, localDocument{Kind: kind, ID: doc.DocumentID(), Document: data})
+		return nil
+	}
+	for _, tombstone := range parsed.Tombstones {
+		if err := add("tombstone", tombstone); err != nil {
+			return nil, err
+		}
+		docs[len(docs)-1].tombstone = tombstone
+	}
+	for _, doc := range append(append([]*TransferDocument{}, parsed.Transfers...), parsed.Reverted...) {
+		if err := add(KindTransfer, doc); err != nil {
+			return nil, err
+		}
+	}
+	for _, doc := range parsed.Events {
+		if err := add(KindEvent, doc); err != nil {
+			return nil, err
+		}
+	}
+	for _, doc := range parsed.Approvals {
+		if err := add(KindApproval, doc); err != nil {
+			return nil, err
+		}
+	}
+	for _, doc := range parsed.Swaps {
+		if err := add(KindSwap, doc); err != nil {
+			return nil, err
+		}
+	}
+	for _, doc := range parsed.Transactions {
+		if err := add("transaction", doc); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// upsert writes docs to the documents table in one transaction, deleting the documents
+// tombstones remove.
+func (s *localSink) upsert(ctx context.Context, docs []localDocument) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	writtenAt := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, doc := range docs {
+		if doc.tombstone != nil {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM documents WHERE kind = ? AND id = ?`, doc.tombstone.Kind, doc.tombstone.ID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO documents (kind, id, document, written_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (kind, id) DO UPDATE SET document = excluded.document, written_at = excluded.written_at`,
+			doc.Kind, doc.ID, string(doc.Document), writtenAt); err != nil {
+			return fmt.Errorf("failed to write %s %s: %w", doc.Kind, doc.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the file or database.
+func (s *localSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	if s.db != nil {
+		err = s.db.Close()
+		s.db = nil
+	}
+	return err
+}
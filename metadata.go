@@ -0,0 +1,76 @@
+package function
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxCreatedAtSkew is how far in the future a webhook's createdAt may be before it is reported.
+const maxCreatedAtSkew = 5 * time.Minute
+
+// Alchemy metadata anomalies recorded in AlchemyMetadata.Anomalies.
+const (
+	AnomalyMissingWebhookID    = "missing_webhook_id"
+	AnomalyUnexpectedWebhookID = "unexpected_webhook_id"
+	AnomalyWebhookIDFormat     = "webhook_id_format"
+	AnomalyMissingEventID      = "missing_event_id"
+	AnomalyEventIDFormat       = "event_id_format"
+	AnomalyMissingCreatedAt    = "missing_created_at"
+	AnomalyCreatedAtFuture     = "created_at_future"
+	AnomalyCreatedAtTimezone   = "created_at_timezone"
+)
+
+// checkMetadata validates the Alchemy metadata of webhook: webhookId against the
+// ALCHEMY_WEBHOOK_IDS allowlist when it is set, the wh_/whevt_ ID formats, and that createdAt is
+// present, in UTC and not in the future. Alchemy sends no metadata headers beyond the signature,
+// so expectations come from configuration. Anomalies never reject a webhook; they are
+// logged as data-quality metrics and recorded on its documents.
+func checkMetadata(webhook *WebhookEvent) []string {
+	var anomalies []string
+
+	switch {
+	case webhook.WebhookID == "":
+		anomalies = append(anomalies, AnomalyMissingWebhookID)
+	case !strings.HasPrefix(webhook.WebhookID, "wh_"):
+		anomalies = append(anomalies, AnomalyWebhookIDFormat)
+	}
+	if allowed := os.Getenv("ALCHEMY_WEBHOOK_IDS"); allowed != "" && webhook.WebhookID != "" {
+		expected := false
+		for _, id := range strings.Split(allowed, ",") {
+			if strings.TrimSpace(id) == webhook.WebhookID {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			anomalies = append(anomalies, AnomalyUnexpectedWebhookID)
+		}
+	}
+
+	switch {
+	case webhook.ID == "":
+		anomalies = append(anomalies, AnomalyMissingEventID)
+	case !strings.HasPrefix(webhook.ID, "whevt_"):
+		anomalies = append(anomalies, AnomalyEventIDFormat)
+	}
+
+	if webhook.CreatedAt.IsZero() {
+		anomalies = append(anomalies, AnomalyMissingCreatedAt)
+	} else {
+		if webhook.CreatedAt.After(time.Now().Add(maxCreatedAtSkew)) {
+			anomalies = append(anomalies, AnomalyCreatedAtFuture)
+		}
+		if _, offset := webhook.CreatedAt.Zone(); offset != 0 {
+			anomalies = append(anomalies, AnomalyCreatedAtTimezone)
+		}
+	}
+
+	for _, anomaly := range anomalies {
+		log.Printf(`{"level":"warn","message":"alchemy metadata anomaly","metric":"webhook_metadata_anomaly","anomaly":"%s","webhook_id":"%s","event_id":"%s","created_at":"%s"}`,
+			anomaly, webhook.WebhookID, webhook.ID, webhook.CreatedAt.Format(time.RFC3339))
+		countMetric("alchemy_webhook_metadata_anomaly_total", 1, "anomaly", anomaly)
+	}
+	return anomalies
+}
@@ -0,0 +1,249 @@
+package function
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricFamily describes a metric exported by MetricsHTTP.
+type metricFamily struct {
+	help      string
+	histogram bool
+}
+
+// metricFamilies are the metrics MetricsHTTP exports, by name. The counters named after a log
+// entry's metric field count those entries.
+var metricFamilies = map[string]metricFamily{
+	"alchemy_webhook_requests_total":              {help: "Webhook POST requests by response status."},
+	"alchemy_webhook_request_duration_seconds":    {help: "Duration of webhook POST requests.", histogram: true},
+	"alchemy_sink_writes_total":                   {help: "Sink writes by sink and result, after retries."},
+	"alchemy_sink_write_duration_seconds":         {help: "Duration of sink writes, retries included.", histogram: true},
+	"alchemy_sink_circuit_open_total":             {help: "Sink circuit breakers opened."},
+	"alchemy_dead_letter_stored_total":            {help: "Dead letters stored by sink and reason."},
+	"alchemy_feature_shed_total":                  {help: "Features shed under deadline pressure."},
+	"alchemy_webhook_unknown_type_total":          {help: "Webhooks of an unknown type by type and policy."},
+	"alchemy_webhook_metadata_anomaly_total":      {help: "Alchemy metadata anomalies by anomaly."},
+	"alchemy_webhook_log_parse_error_total":       {help: "Webhook logs that failed to parse by kind."},
+	"alchemy_webhook_sequence_gap_total":          {help: "Webhook sequence gaps detected."},
+	"alchemy_webhook_sequence_out_of_order_total": {help: "Webhooks delivered out of sequence order."},
+	"alchemy_notify_alerts_suppressed_total":      {help: "Transfer alerts held back by NOTIFY_COOLDOWN by sink."},
+	"alchemy_scheduled_job_runs_total":            {help: "Scheduled job runs by job and result."},
+	"alchemy_scheduled_job_leader":                {help: "Whether this replica leads the scheduled jobs."},
+}
+
+// metricBuckets are the upper bounds, in seconds, of the duration histograms.
+var metricBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type metricHistogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// metricRegistry holds this instance's metrics by name and label set.
+type metricRegistry struct {
+	mu         sync.Mutex
+	values     map[string]map[string]float64
+	histograms map[string]map[string]*metricHistogram
+}
+
+var metrics = &metricRegistry{
+	values:     map[string]map[string]float64{},
+	histograms: map[string]map[string]*metricHistogram{},
+}
+
+// metricLabels renders name/value pairs as a Prometheus label set, without the braces.
+func metricLabels(labels []string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escape.Replace(labels[i+1])+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// countMetric adds delta to the counter name with the given name/value label pairs.
+func countMetric(name string, delta float64, labels ...string) {
+	metrics.update(name, labels, func(value float64) float64 { return value + delta })
+}
+
+// setMetric sets the gauge name with the given name/value label pairs to value.
+func setMetric(name string, value float64, labels ...string) {
+	metrics.update(name, labels, func(float64) float64 { return value })
+}
+
+func (m *metricRegistry) update(name string, labels []string, f func(float64) float64) {
+	key := metricLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values[name] == nil {
+		m.values[name] = map[string]float64{}
+	}
+	m.values[name][key] = f(m.values[name][key])
+}
+
+// observeMetric records duration in the histogram name with the given name/value label pairs.
+func observeMetric(name string, duration time.Duration, labels ...string) {
+	key := metricLabels(labels)
+	seconds := duration.Seconds()
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.histograms[name] == nil {
+		metrics.histograms[name] = map[string]*metricHistogram{}
+	}
+	h := metrics.histograms[name][key]
+	if h == nil {
+		h = &metricHistogram{counts: make([]uint64, len(metricBuckets))}
+		metrics.histograms[name][key] = h
+	}
+	for i, bound := range metricBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format, families sorted by name.
+func (m *metricRegistry) writeTo(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	names := make([]string, 0, len(metricFamilies))
+	for name := range metricFamilies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		family := metricFamilies[name]
+		kind := "counter"
+		switch {
+		case family.histogram:
+			kind = "histogram"
+		case !strings.HasSuffix(name, "_total"):
+			kind = "gauge"
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, kind)
+		if family.histogram {
+			for _, key := range sortedKeys(m.histograms[name]) {
+				h := m.histograms[name][key]
+				for i, bound := range metricBuckets {
+					fmt.Fprintf(&b, "%s_bucket{%s} %d\n", name, joinLabels(key, `le="`+formatMetric(bound)+`"`), h.counts[i])
+				}
+				fmt.Fprintf(&b, "%s_bucket{%s} %d\n", name, joinLabels(key, `le="+Inf"`), h.count)
+				fmt.Fprintf(&b, "%s_sum%s %s\n%s_count%s %d\n", name, braced(key), formatMetric(h.sum), name, braced(key), h.count)
+			}
+			continue
+		}
+		for _, key := range sortedKeys(m.values[name]) {
+			fmt.Fprintf(&b, "%s%s %s\n", name, braced(key), formatMetric(m.values[name][key]))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatMetric(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// statusRecorder records the status code written through a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// recordRequest counts a webhook request answered with status after start.
+func recordRequest(status int, start time.Time) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	countMetric("alchemy_webhook_requests_total", 1, "status", strconv.Itoa(status))
+	observeMetric("alchemy_webhook_request_duration_seconds", time.Since(start))
+}
+
+// recordSinkWrite counts a write to sink that returned err after start.
+func recordSinkWrite(sink string, err error, start time.Time) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	countMetric("alchemy_sink_writes_total", 1, "sink", sink, "result", result)
+	observeMetric("alchemy_sink_write_duration_seconds", time.Since(start), "sink", sink)
+}
+
+// MetricsHTTP serves this instance's metrics in the Prometheus text exposition format, for
+// long-running servers to expose on /metrics. With METRICS_USERNAME or METRICS_PASSWORD set,
+// requests must carry both in basic auth.
+func MetricsHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password := os.Getenv("METRICS_USERNAME"), os.Getenv("METRICS_PASSWORD")
+	if username != "" || password != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := metrics.writeTo(w); err != nil {
+		logError("failed to write metrics", err)
+	}
+}
@@ -0,0 +1,74 @@
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricRegistryWriteTo(t *testing.T) {
+	saved := metrics
+	t.Cleanup(func() { metrics = saved })
+	metrics = &metricRegistry{values: map[string]map[string]float64{}, histograms: map[string]map[string]*metricHistogram{}}
+
+	countMetric("alchemy_dead_letter_stored_total", 1, "sink", "pubsub", "reason", "circuit_open")
+	countMetric("alchemy_dead_letter_stored_total", 1, "sink", "pubsub", "reason", "circuit_open")
+	countMetric("alchemy_webhook_unknown_type_total", 1, "type", `odd"type`, "policy", "process")
+	setMetric("alchemy_scheduled_job_leader", 1)
+	observeMetric("alchemy_sink_write_duration_seconds", 300*time.Millisecond, "sink", "firestore")
+
+	var b strings.Builder
+	if err := metrics.writeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE alchemy_dead_letter_stored_total counter\n",
+		`alchemy_dead_letter_stored_total{sink="pubsub",reason="circuit_open"} 2` + "\n",
+		`alchemy_webhook_unknown_type_total{type="odd\"type",policy="process"} 1` + "\n",
+		"# TYPE alchemy_scheduled_job_leader gauge\nalchemy_scheduled_job_leader 1\n",
+		"# TYPE alchemy_sink_write_duration_seconds histogram\n",
+		`alchemy_sink_write_duration_seconds_bucket{sink="firestore",le="0.25"} 0` + "\n",
+		`alchemy_sink_write_duration_seconds_bucket{sink="firestore",le="0.5"} 1` + "\n",
+		`alchemy_sink_write_duration_seconds_bucket{sink="firestore",le="+Inf"} 1` + "\n",
+		`alchemy_sink_write_duration_seconds_count{sink="firestore"} 1` + "\n",
+		"# TYPE alchemy_webhook_sequence_gap_total counter\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestMetricsHTTPBasicAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		auth     bool
+		user     string
+		pass     string
+		want     int
+	}{
+		{"no auth configured", "", "", false, "", "", http.StatusOK},
+		{"missing credentials", "prom", "secret", false, "", "", http.StatusUnauthorized},
+		{"wrong password", "prom", "secret", true, "prom", "guess", http.StatusUnauthorized},
+		{"valid credentials", "prom", "secret", true, "prom", "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("METRICS_USERNAME", tt.username)
+			t.Setenv("METRICS_PASSWORD", tt.password)
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.auth {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+			w := httptest.NewRecorder()
+			MetricsHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
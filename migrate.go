@@ -0,0 +1,134 @@
+package function
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// migration is a versioned schema change embedded in the binary. Its version is the file name
+// without the .sql extension, and migrations apply in version order.
+type migration struct {
+	version string
+	script  string
+}
+
+// MigrationResult reports the schema migrations of a sink applied by Migrate, or found pending
+// by PendingMigrations.
+type MigrationResult struct {
+	Sink    string   `json:"sink"`
+	Applied []string `json:"applied,omitempty"`
+	Pending []string `json:"pending,omitempty"`
+}
+
+// migrationStatement ends a statement of a migration script: a semicolon at the end of a line.
+var migrationStatement = regexp.MustCompile(`;[ \t]*(\r?\n|$)`)
+
+// embeddedMigrations returns the migrations embedded in fsys under dir, in version order.
+func embeddedMigrations(fsys embed.FS, dir string) ([]migration, error) {
+	names, err := fs.Glob(fsys, dir+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	migrations := make([]migration, 0, len(names))
+	for _, name := range names {
+		script, err := fsys.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: strings.TrimSuffix(path.Base(name), ".sql"), script: string(script)})
+	}
+	return migrations, nil
+}
+
+// statements splits the migration's script into its statements, for databases that run one
+// statement per request.
+func (m migration) statements() []string {
+	var statements []string
+	for _, statement := range migrationStatement.Split(m.script, -1) {
+		if statement = strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// pendingVersions returns the versions of migrations not in applied.
+func pendingVersions(migrations []migration, applied map[string]bool) []string {
+	var pending []string
+	for _, m := range migrations {
+		if !applied[m.version] {
+			pending = append(pending, m.version)
+		}
+	}
+	return pending
+}
+
+// Migrate applies the pending embedded schema migrations of sink, postgres or clickhouse,
+// connecting with the sink's configuration. Sinks apply them when they initialize, unless
+// POSTGRES_MIGRATE or CLICKHOUSE_MIGRATE is false; Migrate lets those deployments migrate from a
+// release step instead.
+func Migrate(ctx context.Context, sink string) (MigrationResult, error) {
+	result := MigrationResult{Sink: sink}
+	switch sink {
+	case sinkPostgres:
+		pool, err := openPostgres(ctx)
+		if err != nil {
+			return result, err
+		}
+		defer pool.Close()
+		result.Applied, err = migratePostgres(ctx, pool)
+		return result, err
+	case sinkClickHouse:
+		s := &clickHouseSink{}
+		if err := s.configure(); err != nil {
+			return result, err
+		}
+		var err error
+		result.Applied, err = s.migrate(ctx)
+		return result, err
+	}
+	return result, fmt.Errorf("sink %s has no schema migrations", sink)
+}
+
+// PendingMigrations reports the embedded schema migrations of sink, postgres or clickhouse, not
+// yet applied to its database, without applying them.
+func PendingMigrations(ctx context.Context, sink string) (MigrationResult, error) {
+	result := MigrationResult{Sink: sink}
+	switch sink {
+	case sinkPostgres:
+		pool, err := openPostgres(ctx)
+		if err != nil {
+			return result, err
+		}
+		defer pool.Close()
+		result.Pending, err = pendingPostgresMigrations(ctx, pool)
+		return result, err
+	case sinkClickHouse:
+		s := &clickHouseSink{}
+		if err := s.configure(); err != nil {
+			return result, err
+		}
+		var err error
+		result.Pending, err = s.pendingMigrations(ctx)
+		return result, err
+	}
+	return result, fmt.Errorf("sink %s has no schema migrations", sink)
+}
+
+// warnPendingMigrations logs the pending migrations of a sink that does not migrate on start,
+// so a schema left behind by a deploy shows up before writes fail.
+func warnPendingMigrations(sink string, pending []string) {
+	if len(pending) == 0 {
+		return
+	}
+	log.Printf(`{"level":"warn","message":"%s schema has pending migrations; run cmd/migrate","sink":"%s","pending":"%s"}`,
+		sink, sink, strings.Join(pending, ","))
+}
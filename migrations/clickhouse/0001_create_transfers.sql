@@ -0,0 +1,36 @@
+-- Transfers deduplicated by (network, document_id), keeping the highest version and dropping rows
+-- marked removed (ClickHouse 23.2 or later), partitioned by month of block_timestamp. The database
+-- and table placeholders are replaced by CLICKHOUSE_DATABASE and CLICKHOUSE_TABLE.
+CREATE TABLE IF NOT EXISTS {database}.{table}
+(
+    `document_id` String,
+    `schema_version` UInt16,
+    `network` LowCardinality(String),
+    `block_number` UInt64,
+    `block_hash` String,
+    `block_timestamp` DateTime('UTC'),
+    `transaction_hash` String,
+    `log_index` Int32,
+    `batch_index` Nullable(Int32),
+    `trace_index` Nullable(Int32),
+    `contract` String,
+    `standard` LowCardinality(String),
+    `operator` String,
+    `from_address` String,
+    `to_address` String,
+    `value` Nullable(UInt256),
+    `token_id` Nullable(UInt256),
+    `amount_bucket` LowCardinality(String),
+    `amount_percentile` Nullable(Float64),
+    `reverted` Bool,
+    `finality` LowCardinality(String),
+    `webhook_id` String,
+    `event_id` String,
+    `sequence_number` String,
+    `document` String,
+    `removed` UInt8,
+    `version` UInt64
+)
+ENGINE = ReplacingMergeTree(version, removed)
+PARTITION BY toYYYYMM(block_timestamp)
+ORDER BY (network, document_id);
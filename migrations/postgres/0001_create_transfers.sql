@@ -0,0 +1,36 @@
+-- Transfers keyed by their log: log_index is -1 for native transfers, which have no log, and
+-- entry_index is the batch index of ERC1155 batch entries, the trace index of internal native
+-- transfers and -1 otherwise.
+CREATE TABLE transfers (
+    tx_hash          TEXT        NOT NULL,
+    log_index        INTEGER     NOT NULL,
+    entry_index      INTEGER     NOT NULL DEFAULT -1,
+    document_id      TEXT        NOT NULL UNIQUE,
+    schema_version   INTEGER     NOT NULL,
+    network          TEXT        NOT NULL,
+    block_number     BIGINT      NOT NULL,
+    block_hash       TEXT        NOT NULL,
+    block_timestamp  TIMESTAMPTZ NOT NULL,
+    contract         TEXT        NOT NULL,
+    standard         TEXT        NOT NULL,
+    operator         TEXT,
+    from_address     TEXT        NOT NULL,
+    to_address       TEXT        NOT NULL,
+    value            NUMERIC(78, 0),
+    token_id         NUMERIC(78, 0),
+    reverted         BOOLEAN     NOT NULL DEFAULT false,
+    finality         TEXT,
+    removed          BOOLEAN     NOT NULL DEFAULT false,
+    removed_reason   TEXT,
+    webhook_id       TEXT        NOT NULL,
+    event_id         TEXT        NOT NULL,
+    sequence_number  TEXT,
+    document         JSONB       NOT NULL,
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tx_hash, log_index, entry_index)
+);
+
+CREATE INDEX transfers_contract_block_idx ON transfers (contract, block_number);
+CREATE INDEX transfers_from_address_idx ON transfers (from_address);
+CREATE INDEX transfers_to_address_idx ON transfers (to_address);
+CREATE INDEX transfers_block_timestamp_idx ON transfers (block_timestamp);
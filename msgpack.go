@@ -0,0 +1,74 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	registerSerializer(msgpackSerializer{})
+	msgpack.Register(json.Number(""), encodeMsgpackNumber, nil)
+}
+
+// msgpackSerializer encodes payloads as MessagePack. Structs are encoded directly under their
+// JSON field names, so documents keep the same fields as the JSON payloads, and map keys are
+// sorted so payloads are deterministic.
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) Name() string        { return "msgpack" }
+func (msgpackSerializer) ContentType() string { return "application/msgpack" }
+
+func (msgpackSerializer) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackSerializer) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// encodeMsgpackNumber encodes the numbers of redacted payloads, which are decoded from JSON as
+// json.Number, as integers when they are and as floats otherwise, rather than as strings.
+func encodeMsgpackNumber(enc *msgpack.Encoder, v reflect.Value) error {
+	n := json.Number(v.String())
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return enc.EncodeInt(i)
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return enc.EncodeUint(u)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("msgpack: invalid number %q: %w", n, err)
+	}
+	return enc.EncodeFloat64(f)
+}
+
+// EncodeMsgpack encodes t in its serialized form, with amounts as decimal strings like its JSON.
+func (t Transfer) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.Encode(t.wire())
+}
+
+// DecodeMsgpack decodes t from its serialized form.
+func (t *Transfer) DecodeMsgpack(dec *msgpack.Decoder) error {
+	var aux transferJSON
+	if err := dec.Decode(&aux); err != nil {
+		return err
+	}
+	t.setWire(aux)
+	return nil
+}
@@ -0,0 +1,90 @@
+package function
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// NativeContract is the synthetic contract of native currency transfers, which have no token
+// contract, so they share the transfers collection with token transfers.
+const NativeContract = "native"
+
+// nativeTransfersEnabled reports whether native currency movements become transfer documents.
+func nativeTransfersEnabled() bool {
+	return os.Getenv("ENABLE_NATIVE_TRANSFERS") == "true"
+}
+
+// isNative reports whether doc is a native currency transfer.
+func (d *TransferDocument) isNative() bool {
+	return d.Transfer.Standard == StandardNative
+}
+
+// nativeDocumentID returns the document ID of a native transfer. The external value transfer
+// of a transaction is <hash>-native; internal transfers add their trace index.
+func nativeDocumentID(txHash string, traceIndex *int) string {
+	if traceIndex == nil {
+		return txHash + "-native"
+	}
+	return fmt.Sprintf("%s-internal-%d", txHash, *traceIndex)
+}
+
+// parseNativeLogTransfers returns a native transfer for the value of every transaction among
+// logs that moved a non-zero value, once per transaction. The value goes to the created contract
+// for contract creations.
+func parseNativeLogTransfers(webhook *WebhookEvent, removed bool) []*TransferDocument {
+	seen := make(map[string]bool)
+	var documents []*TransferDocument
+	for _, log := range webhook.Event.Data.Block.Logs {
+		if log.Removed != removed || log.Transaction.Hash == "" || seen[log.Transaction.Hash] {
+			continue
+		}
+		seen[log.Transaction.Hash] = true
+		value, ok := parseQuantity(log.Transaction.Value)
+		if !ok || value.Sign() == 0 {
+			continue
+		}
+		to := log.Transaction.To.Address
+		if to == "" {
+			to = log.Transaction.CreatedContract.Address
+		}
+		documents = append(documents, newTransferDocument(webhook, log, Transfer{
+			Contract: NativeContract,
+			Standard: StandardNative,
+			From:     checksumAddress(log.Transaction.From.Address),
+			To:       checksumAddress(to),
+			Value:    value,
+		}))
+	}
+	return documents
+}
+
+// parseNativeActivityEntry maps an external or internal ETH movement of an address activity
+// entry into a native transfer. Internal entries take their position among the internal entries
+// of the transaction as trace index.
+func parseNativeActivityEntry(entry ActivityEntry, internalIndex int) (Transfer, error) {
+	value, ok := parseQuantity(entry.RawContract.RawValue)
+	if !ok {
+		return Transfer{}, fmt.Errorf("%w: invalid %s rawValue %q", ErrMalformedTransfer, entry.Category, entry.RawContract.RawValue)
+	}
+	transfer := Transfer{
+		Contract: NativeContract,
+		Standard: StandardNative,
+		From:     checksumAddress(entry.FromAddress),
+		To:       checksumAddress(entry.ToAddress),
+		Value:    value,
+	}
+	if entry.Category == "internal" {
+		transfer.TraceIndex = &internalIndex
+	}
+	return transfer, nil
+}
+
+// checksumAddress returns the checksummed form of address, or "" when it is empty.
+func checksumAddress(address string) string {
+	if address == "" {
+		return ""
+	}
+	return common.HexToAddress(address).Hex()
+}
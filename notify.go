@@ -0,0 +1,487 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultNotifyMaxTransfers keeps a message readable; further transfers are counted only.
+	defaultNotifyMaxTransfers = 10
+	// discordContentLimit is the most characters a Discord message holds.
+	discordContentLimit = 2000
+	// alertCooldownSweepSize is how many cool-downs a suppressor keeps before it drops the expired
+	// ones that have nothing suppressed.
+	alertCooldownSweepSize = 10000
+)
+
+// defaultExplorers maps networks to their block explorer, for transaction and address links.
+// NOTIFY_EXPLORER_URLS adds networks or overrides these.
+var defaultExplorers = map[string]string{
+	"ETH_MAINNET":          "https://etherscan.io",
+	"ETH_SEPOLIA":          "https://sepolia.etherscan.io",
+	"ETH_HOLESKY":          "https://holesky.etherscan.io",
+	"MATIC_MAINNET":        "https://polygonscan.com",
+	"MATIC_AMOY":           "https://amoy.polygonscan.com",
+	"ARB_MAINNET":          "https://arbiscan.io",
+	"ARB_SEPOLIA":          "https://sepolia.arbiscan.io",
+	"OPT_MAINNET":          "https://optimistic.etherscan.io",
+	"OPT_SEPOLIA":          "https://sepolia-optimism.etherscan.io",
+	"BASE_MAINNET":         "https://basescan.org",
+	"BASE_SEPOLIA":         "https://sepolia.basescan.org",
+	"BNB_MAINNET":          "https://bscscan.com",
+	"AVAX_MAINNET":         "https://snowtrace.io",
+	"ZKSYNC_MAINNET":       "https://explorer.zksync.io",
+	"LINEA_MAINNET":        "https://lineascan.build",
+	"SCROLL_MAINNET":       "https://scrollscan.com",
+	"BLAST_MAINNET":        "https://blastscan.io",
+	"POLYGONZKEVM_MAINNET": "https://zkevm.polygonscan.com",
+}
+
+// Notification is a transfer as notification messages show it. Token is the token symbol, or the
+// standard and shortened contract when the token metadata enricher has not found it; Amount is
+// the value in whole tokens when the decimals are known, and the token ID of NFTs; From and To
+// are the address label, ENS name or shortened address. The URLs link to the block explorer of
+// the network and are empty for networks without one. Suppressed counts the alerts like it held
+// back by NOTIFY_COOLDOWN since the last one sent.
+type Notification struct {
+	Network    string
+	Token      string
+	Amount     string
+	From       string
+	To         string
+	TxURL      string
+	FromURL    string
+	ToURL      string
+	Suppressed int
+	Transfer   *TransferDocument
+}
+
+// NotificationBatch is the message about a webhook's transfers: its network and block, the count
+// of its transfers, the notifications of those listed and how many more are not. A digest batch
+// holds transfers of any networks and blocks, and Network and Block are those of the first.
+type NotificationBatch struct {
+	Network   string
+	Block     int64
+	Count     int
+	More      int
+	Digest    bool
+	Transfers []Notification
+}
+
+// Header returns the first line of a message about the batch.
+func (b NotificationBatch) Header() string {
+	noun := "transfers"
+	if b.Count == 1 {
+		noun = "transfer"
+	}
+	if b.Digest {
+		return fmt.Sprintf("Digest of %d held %s", b.Count, noun)
+	}
+	return fmt.Sprintf("%d %s on %s in block %d", b.Count, noun, b.Network, b.Block)
+}
+
+// newNotificationBatch returns the batch listing up to limit of transfers, which must not be empty,
+// with the alerts suppressed before each.
+func newNotificationBatch(transfers []*TransferDocument, limit int, explorers map[string]string, suppressed map[*TransferDocument]int) NotificationBatch {
+	shown := transfers[:min(len(transfers), limit)]
+	batch := NotificationBatch{
+		Network:   transfers[0].Network,
+		Block:     transfers[0].Block.Number,
+		Count:     len(transfers),
+		More:      len(transfers) - len(shown),
+		Transfers: make([]Notification, len(shown)),
+	}
+	for i, doc := range shown {
+		batch.Transfers[i] = newNotification(doc, explorers[doc.Network])
+		batch.Transfers[i].Suppressed = suppressed[doc]
+	}
+	return batch
+}
+
+// alertSuppressor holds back repeated alerts about the same rule, sender and token within a
+// cool-down, so an airdrop or a sweep does not flood a channel. Cool-downs are kept per sink and
+// instance.
+type alertSuppressor struct {
+	cooldown time.Duration
+
+	mu     sync.Mutex
+	alerts map[string]alertCooldown
+}
+
+// alertCooldown is the last alert sent for a key and the count held back since.
+type alertCooldown struct {
+	sentAt     time.Time
+	suppressed int
+}
+
+// newAlertSuppressor returns the suppressor of NOTIFY_COOLDOWN, or nil when it is unset.
+func newAlertSuppressor() (*alertSuppressor, error) {
+	value := os.Getenv("NOTIFY_COOLDOWN")
+	if value == "" {
+		return nil, nil
+	}
+	cooldown, err := time.ParseDuration(value)
+	if err != nil || cooldown < 0 {
+		return nil, fmt.Errorf("invalid NOTIFY_COOLDOWN %q", value)
+	}
+	if cooldown == 0 {
+		return nil, nil
+	}
+	return &alertSuppressor{cooldown: cooldown, alerts: map[string]alertCooldown{}}, nil
+}
+
+// alertKey identifies the alerts about doc that suppress each other: its routing rule, sender and
+// token on its network.
+func alertKey(doc *TransferDocument) string {
+	rule := ""
+	if doc.route != nil {
+		rule = doc.route.Name
+	}
+	token := "native"
+	if !doc.isNative() {
+		token = strings.ToLower(doc.Transfer.Contract)
+	}
+	return doc.Network + "/" + rule + "/" + strings.ToLower(doc.Transfer.From) + "/" + token
+}
+
+// admit returns the transfers to alert on now, with the count of alerts suppressed before each,
+// and undo, which restores the cool-downs when the alert could not be sent, so the redelivered
+// webhook alerts again. A nil suppressor admits every transfer.
+func (s *alertSuppressor) admit(transfers []*TransferDocument, now time.Time) (admitted []*TransferDocument, suppressed map[*TransferDocument]int, undo func()) {
+	if s == nil {
+		return transfers, nil, func() {}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.alerts) >= alertCooldownSweepSize {
+		for key, alert := range s.alerts {
+			if alert.suppressed == 0 && now.Sub(alert.sentAt) >= s.cooldown {
+				delete(s.alerts, key)
+			}
+		}
+	}
+	previous := map[string]alertCooldown{}
+	suppressed = map[*TransferDocument]int{}
+	for _, doc := range transfers {
+		key := alertKey(doc)
+		alert, seen := s.alerts[key]
+		if _, ok := previous[key]; !ok {
+			previous[key] = alert
+		}
+		if seen && now.Sub(alert.sentAt) < s.cooldown {
+			alert.suppressed++
+			s.alerts[key] = alert
+			continue
+		}
+		admitted = append(admitted, doc)
+		suppressed[doc] = alert.suppressed
+		s.alerts[key] = alertCooldown{sentAt: now}
+	}
+	undo = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for key, alert := range previous {
+			if alert.sentAt.IsZero() && alert.suppressed == 0 {
+				delete(s.alerts, key)
+			} else {
+				s.alerts[key] = alert
+			}
+		}
+	}
+	return admitted, suppressed, undo
+}
+
+// logSuppressedAlerts logs how many of a webhook's transfers sink held back.
+func logSuppressedAlerts(sink string, count int) {
+	if count > 0 {
+		log.Printf(`{"level":"info","message":"suppressed repeated alerts","sink":"%s","count":%d}`, sink, count)
+		countMetric("alchemy_notify_alerts_suppressed_total", float64(count), "sink", sink)
+	}
+}
+
+// loadExplorers returns defaultExplorers with the network=url pairs of NOTIFY_EXPLORER_URLS.
+func loadExplorers() (map[string]string, error) {
+	explorers := make(map[string]string, len(defaultExplorers))
+	for network, url := range defaultExplorers {
+		explorers[network] = url
+	}
+	for _, item := range parseList(os.Getenv("NOTIFY_EXPLORER_URLS")) {
+		network, url, ok := strings.Cut(item, "=")
+		if !ok || network == "" || !strings.HasPrefix(url, "http") {
+			return nil, fmt.Errorf("invalid NOTIFY_EXPLORER_URLS entry %q", item)
+		}
+		explorers[strings.TrimSpace(network)] = strings.TrimSuffix(strings.TrimSpace(url), "/")
+	}
+	return explorers, nil
+}
+
+// newNotification returns the notification of doc, linking to explorer when it is set.
+func newNotification(doc *TransferDocument, explorer string) Notification {
+	n := Notification{
+		Network:  doc.Network,
+		Token:    notificationToken(doc),
+		Amount:   notificationAmount(doc),
+		From:     notificationParty(doc.Transfer.From, doc.FromLabel, doc.FromENS),
+		To:       notificationParty(doc.Transfer.To, doc.ToLabel, doc.ToENS),
+		Transfer: doc,
+	}
+	if explorer != "" {
+		n.TxURL = explorer + "/tx/" + doc.Transaction.Hash
+		n.FromURL = explorer + "/address/" + doc.Transfer.From
+		n.ToURL = explorer + "/address/" + doc.Transfer.To
+	}
+	return n
+}
+
+func notificationToken(doc *TransferDocument) string {
+	switch {
+	case doc.isNative():
+		return nativeSymbol(doc.Network)
+	case doc.Token != nil && doc.Token.Symbol != "":
+		return doc.Token.Symbol
+	}
+	return doc.Transfer.Standard + " " + shortAddress(doc.Transfer.Contract)
+}
+
+// nativeSymbol returns the symbol of the native currency of network.
+func nativeSymbol(network string) string {
+	chain, _, _ := strings.Cut(network, "_")
+	switch chain {
+	case "MATIC":
+		return "POL"
+	case "BNB", "AVAX":
+		return chain
+	case "ETH", "ARB", "OPT", "BASE", "ZKSYNC", "LINEA", "SCROLL", "BLAST", "POLYGONZKEVM":
+		return "ETH"
+	}
+	return "native"
+}
+
+// notificationAmount returns the value scaled by the token's decimals, trailing zeros trimmed,
+// prefixed with the token ID for ERC1155, or the token ID alone for ERC721.
+func notificationAmount(doc *TransferDocument) string {
+	amount := ""
+	if doc.Transfer.Value != nil {
+		amount = formatUnits(doc.Transfer.Value, transferDecimals(doc))
+	}
+	if doc.Transfer.TokenID != nil {
+		if amount == "" {
+			return "#" + doc.Transfer.TokenID.String()
+		}
+		return amount + " × #" + doc.Transfer.TokenID.String()
+	}
+	return amount
+}
+
+// formatUnits returns value in whole units of decimals as an exact decimal string.
+func formatUnits(value *big.Int, decimals int) string {
+	if decimals <= 0 {
+		return value.String()
+	}
+	digits := new(big.Int).Abs(value).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if value.Sign() < 0 {
+		whole = "-" + whole
+	}
+	if fraction == "" {
+		return whole
+	}
+	return whole + "." + fraction
+}
+
+// notificationParty returns the label, ENS name or shortened address of address.
+func notificationParty(address, label, ens string) string {
+	switch {
+	case label != "":
+		return label
+	case ens != "":
+		return ens
+	}
+	return shortAddress(address)
+}
+
+// shortAddress returns address as 0x1234…abcd.
+func shortAddress(address string) string {
+	if len(address) < 12 {
+		return address
+	}
+	return address[:6] + "…" + address[len(address)-4:]
+}
+
+// chatSink posts a message listing a webhook's transfers, reverted ones excluded, to a Slack or
+// Discord incoming webhook. Messages list up to NOTIFY_MAX_TRANSFERS transfers and count the
+// rest. Notification preferences pick the transfers alerted on, and repeated alerts are held back
+// under NOTIFY_COOLDOWN. Posts go through the
+// ProviderClient of the sink's name, which retries transport errors, 429 and 5xx responses. Chat
+// webhooks do not deduplicate, so a redelivered webhook is announced again.
+type chatSink struct {
+	name   string
+	urlEnv string
+	// format returns the JSON body of the message about batch.
+	format func(batch NotificationBatch) any
+
+	url          string
+	maxTransfers int
+	explorers    map[string]string
+	suppressor   *alertSuppressor
+}
+
+func (s *chatSink) Name() string { return s.name }
+
+// Init reads the webhook URL, transfer cap, cool-down and explorer configuration.
+func (s *chatSink) Init(context.Context) error {
+	s.url = os.Getenv(s.urlEnv)
+	if s.url == "" {
+		return fmt.Errorf("%s must be set", s.urlEnv)
+	}
+	s.maxTransfers = max(envInt("NOTIFY_MAX_TRANSFERS", defaultNotifyMaxTransfers), 1)
+	explorers, err := loadExplorers()
+	if err != nil {
+		return err
+	}
+	s.explorers = explorers
+	s.suppressor, err = newAlertSuppressor()
+	return err
+}
+
+// Write posts one message for the transfers the notification preferences want alerted on now,
+// or nothing when there are none or all of them are suppressed.
+func (s *chatSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	transfers, err := applyNotificationPreferences(ctx, s.name, parsed.Transfers)
+	if err != nil {
+		return err
+	}
+	admitted, suppressed, undo := s.suppressor.admit(transfers, time.Now())
+	logSuppressedAlerts(s.name, len(transfers)-len(admitted))
+	if len(admitted) == 0 {
+		return nil
+	}
+	if err := s.notify(ctx, newNotificationBatch(admitted, s.maxTransfers, s.explorers, suppressed)); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// notify posts the message about batch.
+func (s *chatSink) notify(ctx context.Context, batch NotificationBatch) error {
+	body, err := json.Marshal(s.format(batch))
+	if err != nil {
+		return err
+	}
+	return postChatMessage(ctx, s.name, s.url, body)
+}
+
+// Close is a no-op: the provider client is shared by the instance.
+func (*chatSink) Close() error { return nil }
+
+// postChatMessage posts body to url through the named provider.
+func postChatMessage(ctx context.Context, provider, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ProviderFor(provider).Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with status %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// newSlackSink returns the sink posting to the Slack incoming webhook at SLACK_WEBHOOK_URL, with
+// the transfers in mrkdwn.
+func newSlackSink() *chatSink {
+	return &chatSink{name: sinkSlack, urlEnv: "SLACK_WEBHOOK_URL", format: slackMessage}
+}
+
+func slackMessage(batch NotificationBatch) any {
+	link := func(text, url string) string {
+		text = slackEscape(text)
+		if url == "" {
+			return text
+		}
+		return "<" + url + "|" + text + ">"
+	}
+	lines := []string{"*" + slackEscape(batch.Header()) + "*"}
+	for _, n := range batch.Transfers {
+		line := fmt.Sprintf("• *%s %s* %s → %s", slackEscape(n.Amount), slackEscape(n.Token), link(n.From, n.FromURL), link(n.To, n.ToURL))
+		if n.TxURL != "" {
+			line += " · " + link("tx", n.TxURL)
+		}
+		if n.Suppressed > 0 {
+			line += fmt.Sprintf(" _(+%d suppressed)_", n.Suppressed)
+		}
+		lines = append(lines, line)
+	}
+	if batch.More > 0 {
+		lines = append(lines, fmt.Sprintf("_and %d more_", batch.More))
+	}
+	return map[string]any{"text": strings.Join(lines, "\n"), "unfurl_links": false}
+}
+
+// slackEscape escapes the characters Slack's mrkdwn reserves for links and mentions.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// newDiscordSink returns the sink posting to the Discord webhook at DISCORD_WEBHOOK_URL, with the
+// transfers in Discord markdown, link previews suppressed and mentions disabled.
+func newDiscordSink() *chatSink {
+	return &chatSink{name: sinkDiscord, urlEnv: "DISCORD_WEBHOOK_URL", format: discordMessage}
+}
+
+func discordMessage(batch NotificationBatch) any {
+	link := func(text, url string) string {
+		text = discordEscape(text)
+		if url == "" {
+			return text
+		}
+		return "[" + text + "](<" + url + ">)"
+	}
+	content := "**" + discordEscape(batch.Header()) + "**"
+	more := batch.More
+	for i, n := range batch.Transfers {
+		line := fmt.Sprintf("\n• **%s %s** %s → %s", discordEscape(n.Amount), discordEscape(n.Token), link(n.From, n.FromURL), link(n.To, n.ToURL))
+		if n.TxURL != "" {
+			line += " · " + link("tx", n.TxURL)
+		}
+		if n.Suppressed > 0 {
+			line += fmt.Sprintf(" *(+%d suppressed)*", n.Suppressed)
+		}
+		// Leave room for the count of the transfers that do not fit.
+		if len(content)+len(line) > discordContentLimit-32 {
+			more += len(batch.Transfers) - i
+			break
+		}
+		content += line
+	}
+	if more > 0 {
+		content += fmt.Sprintf("\n*and %d more*", more)
+	}
+	return map[string]any{"content": content, "allowed_mentions": map[string]any{"parse": []string{}}}
+}
+
+// discordEscape escapes Discord markdown characters.
+func discordEscape(text string) string {
+	return strings.NewReplacer("\\", "\\\\", "*", "\\*", "_", "\\_", "~", "\\~", "`", "\\`", "|", "\\|", "[", "\\[", "]", "\\]").Replace(text)
+}
@@ -0,0 +1,65 @@
+package function
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertSuppressorAdmit(t *testing.T) {
+	transfer := func(from, contract string) *TransferDocument {
+		doc := &TransferDocument{Network: "ETH_MAINNET"}
+		doc.Transfer.From, doc.Transfer.Contract, doc.Transfer.Standard = from, contract, "ERC20"
+		return doc
+	}
+	const (
+		distributor = "0x1111111111111111111111111111111111111111"
+		usdc        = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+		weth        = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"
+	)
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &alertSuppressor{cooldown: time.Minute, alerts: map[string]alertCooldown{}}
+	tests := []struct {
+		name       string
+		at         time.Duration
+		transfers  []*TransferDocument
+		admitted   int
+		suppressed []int
+	}{
+		{"first alert", 0, []*TransferDocument{transfer(distributor, usdc)}, 1, []int{0}},
+		{"repeat within the cool-down", 10 * time.Second, []*TransferDocument{transfer(distributor, usdc), transfer(distributor, usdc)}, 0, nil},
+		{"other token", 20 * time.Second, []*TransferDocument{transfer(distributor, weth)}, 1, []int{0}},
+		{"after the cool-down", 61 * time.Second, []*TransferDocument{transfer(distributor, usdc), transfer(distributor, usdc)}, 1, []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admitted, suppressed, _ := s.admit(tt.transfers, start.Add(tt.at))
+			if len(admitted) != tt.admitted {
+				t.Fatalf("admitted %d transfers, want %d", len(admitted), tt.admitted)
+			}
+			for i, doc := range admitted {
+				if suppressed[doc] != tt.suppressed[i] {
+					t.Errorf("transfer %d: %d suppressed, want %d", i, suppressed[doc], tt.suppressed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAlertSuppressorUndo(t *testing.T) {
+	doc := &TransferDocument{Network: "ETH_MAINNET"}
+	doc.Transfer.Standard = StandardNative
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &alertSuppressor{cooldown: time.Minute, alerts: map[string]alertCooldown{}}
+
+	_, _, undo := s.admit([]*TransferDocument{doc, doc}, now)
+	undo()
+	admitted, _, _ := s.admit([]*TransferDocument{doc}, now.Add(time.Second))
+	if len(admitted) != 1 {
+		t.Errorf("admitted %d transfers after a failed alert, want 1", len(admitted))
+	}
+
+	var none *alertSuppressor
+	if admitted, _, _ := none.admit([]*TransferDocument{doc, doc}, now); len(admitted) != 2 {
+		t.Errorf("nil suppressor admitted %d transfers, want 2", len(admitted))
+	}
+}
@@ -0,0 +1,92 @@
+package function
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Lifecycle event types published to ALCHEMY_OPS_TOPIC.
+const (
+	LifecycleColdStart        = "cold_start"
+	LifecycleWarmUpComplete   = "warm_up_complete"
+	LifecycleWarmUpFailed     = "warm_up_failed"
+	LifecycleSequenceGap      = "sequence_gap"
+	LifecycleOutOfOrder       = "sequence_out_of_order"
+	LifecycleQuarantineGrowth = "quarantine_growth"
+	LifecycleCircuitOpened    = "circuit_opened"
+	LifecycleCircuitHalfOpen  = "circuit_half_open"
+	LifecycleCircuitClosed    = "circuit_closed"
+	LifecycleDeadLetterStored = "dead_letter_stored"
+)
+
+// instanceID identifies this function instance in lifecycle events.
+var instanceID = newInstanceID()
+
+func init() {
+	if os.Getenv("ALCHEMY_OPS_TOPIC") != "" {
+		go emitLifecycleEvent(context.Background(), LifecycleColdStart, nil)
+	}
+}
+
+// LifecycleEvent is an instance lifecycle or pipeline health signal for platform automation.
+type LifecycleEvent struct {
+	Type     string         `json:"type"`
+	Instance string         `json:"instance"`
+	Revision string         `json:"revision,omitempty"`
+	Time     time.Time      `json:"time"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+// PublishLifecycleEvent publishes a LifecycleEvent to Pub/Sub as a single message.
+func (p *PubSubPublisher) PublishLifecycleEvent(ctx context.Context, event *LifecycleEvent) error {
+	data, err := p.serializer.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lifecycle event: %w", err)
+	}
+
+	attributes := map[string]string{"type": "lifecycle", "event": event.Type, "instance": event.Instance, "count": "1"}
+	return p.publish(ctx, data, attributes)
+}
+
+// emitLifecycleEvent publishes eventType to ALCHEMY_OPS_TOPIC when it is set.
+// Ops events are best effort: failures are logged and never affect webhook processing.
+func emitLifecycleEvent(ctx context.Context, eventType string, details map[string]any) {
+	topicID := os.Getenv("ALCHEMY_OPS_TOPIC")
+	if topicID == "" {
+		return
+	}
+
+	publisher, err := newPublisher(ctx, topicID)
+	if err != nil {
+		logError("failed to create ops publisher", err)
+		return
+	}
+	defer func() {
+		if err := publisher.Close(); err != nil {
+			logError("failed to close ops publisher", err)
+		}
+	}()
+
+	event := &LifecycleEvent{
+		Type:     eventType,
+		Instance: instanceID,
+		Revision: os.Getenv("K_REVISION"),
+		Time:     time.Now().UTC(),
+		Details:  details,
+	}
+	if err := publisher.PublishLifecycleEvent(ctx, event); err != nil {
+		logError("failed to publish lifecycle event", err)
+	}
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,345 @@
+package function
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	defaultOutboxCollection = "alchemy_outbox"
+	defaultOutboxBatchSize  = 500
+	defaultOutboxRetention  = 168 * time.Hour
+	// outboxClaim is how long a sweeper holds an entry it is publishing before another may.
+	outboxClaim = time.Minute
+	// outboxMaxEntryBytes bounds the documents of an entry, keeping it under the 1 MiB Firestore
+	// document limit with room for its other fields.
+	outboxMaxEntryBytes = 900 << 10
+)
+
+func init() {
+	functions.HTTP("PublishOutbox", PublishOutboxHTTP)
+}
+
+// errOutboxNotPersisted fails the pubsub sink in outbox mode when the firestore sink did not
+// write the webhook, so its messages are not published before its documents are stored.
+var errOutboxNotPersisted = errors.New("outbox entry not written: the firestore sink failed")
+
+// outboxEnabled reports whether PUBSUB_OUTBOX is set, making the pubsub sink record its messages
+// in the outbox collection for PublishOutbox to publish rather than publishing them itself.
+func outboxEnabled() bool {
+	return os.Getenv("PUBSUB_OUTBOX") == "true"
+}
+
+func outboxCollection() string {
+	if collection := os.Getenv("OUTBOX_COLLECTION"); collection != "" {
+		return collection
+	}
+	return defaultOutboxCollection
+}
+
+// OutboxDocument is a webhook's pending Pub/Sub messages: the documents the pubsub sink received,
+// as JSON by kind, and whether PublishOutbox has published them. A webhook whose documents are
+// too large for one Firestore document is recorded as Parts entries, each with some of them.
+type OutboxDocument struct {
+	WebhookID    string     `json:"webhookId,omitempty"`
+	EventID      string     `json:"eventId,omitempty"`
+	Network      string     `json:"network,omitempty"`
+	Documents    string     `json:"documents"`
+	Part         int        `json:"part,omitempty"`
+	Parts        int        `json:"parts,omitempty"`
+	Published    bool       `json:"published"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	PublishedAt  *time.Time `json:"publishedAt,omitempty"`
+	ClaimedUntil *time.Time `json:"claimedUntil,omitempty"`
+	Attempts     int        `json:"attempts"`
+	LastError    string     `json:"lastError,omitempty"`
+	ExpireAt     *time.Time `json:"expireAt,omitempty"`
+}
+
+// DocumentID returns the Alchemy event ID, followed by the part of a webhook recorded in several
+// entries, or a digest of the documents when the event is unknown, so a redelivered webhook finds
+// its entries.
+func (d *OutboxDocument) DocumentID() string {
+	if d.EventID != "" && d.Parts > 1 {
+		return fmt.Sprintf("%s-%d", d.EventID, d.Part)
+	}
+	if d.EventID != "" {
+		return d.EventID
+	}
+	sum := sha256.Sum256([]byte(d.Documents))
+	return hex.EncodeToString(sum[:16])
+}
+
+// outboxDocuments is the JSON of an outbox entry's documents, by kind. Topics holds the topic a
+// routing rule sends each transfer to, in the order of Transfers, when any rule does.
+type outboxDocuments struct {
+	Transfers    []*TransferDocument    `json:"transfers,omitempty"`
+	Topics       []string               `json:"topics,omitempty"`
+	Events       []*EventDocument       `json:"events,omitempty"`
+	Approvals    []*ApprovalDocument    `json:"approvals,omitempty"`
+	Swaps        []*SwapDocument        `json:"swaps,omitempty"`
+	Transactions []*TransactionDocument `json:"transactions,omitempty"`
+	Tombstones   []*Tombstone           `json:"tombstones,omitempty"`
+}
+
+// parsed returns the documents as the ParsedWebhook publishToPubSub expects, with the transfers
+// routed to their topics.
+func (d *outboxDocuments) parsed() *ParsedWebhook {
+	for i, topic := range d.Topics {
+		if topic != "" && i < len(d.Transfers) {
+			d.Transfers[i].route = &Rule{RuleConfig: RuleConfig{Topic: topic}}
+		}
+	}
+	return &ParsedWebhook{
+		Transfers:    d.Transfers,
+		Events:       d.Events,
+		Approvals:    d.Approvals,
+		Swaps:        d.Swaps,
+		Transactions: d.Transactions,
+		Tombstones:   d.Tombstones,
+	}
+}
+
+// count returns the number of documents of d.
+func (d *outboxDocuments) count() int {
+	return len(d.Transfers) + len(d.Events) + len(d.Approvals) + len(d.Swaps) + len(d.Transactions) + len(d.Tombstones)
+}
+
+// halves splits d into its first half of documents, in the order of its fields, and the rest.
+func (d *outboxDocuments) halves() (outboxDocuments, outboxDocuments) {
+	var first, second outboxDocuments
+	n := d.count() / 2
+	first.Transfers, second.Transfers = splitAt(d.Transfers, &n)
+	if d.Topics != nil {
+		first.Topics, second.Topics = d.Topics[:len(first.Transfers)], d.Topics[len(first.Transfers):]
+	}
+	first.Events, second.Events = splitAt(d.Events, &n)
+	first.Approvals, second.Approvals = splitAt(d.Approvals, &n)
+	first.Swaps, second.Swaps = splitAt(d.Swaps, &n)
+	first.Transactions, second.Transactions = splitAt(d.Transactions, &n)
+	first.Tombstones, second.Tombstones = splitAt(d.Tombstones, &n)
+	return first, second
+}
+
+// splitAt splits s after up to *n elements, taking them from *n.
+func splitAt[T any](s []T, n *int) ([]T, []T) {
+	k := min(*n, len(s))
+	*n -= k
+	return s[:k], s[k:]
+}
+
+// marshalOutboxParts encodes documents as JSON, halving them until every part fits in an entry.
+// A single document too large for an entry fails.
+func marshalOutboxParts(documents outboxDocuments) ([]string, error) {
+	data, err := json.Marshal(documents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outbox entry: %w", err)
+	}
+	if len(data) <= outboxMaxEntryBytes {
+		return []string{string(data)}, nil
+	}
+	if documents.count() < 2 {
+		return nil, fmt.Errorf("outbox entry of %d bytes holds a single document and exceeds %d bytes", len(data), outboxMaxEntryBytes)
+	}
+	first, second := documents.halves()
+	parts, err := marshalOutboxParts(first)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := marshalOutboxParts(second)
+	if err != nil {
+		return nil, err
+	}
+	return append(parts, rest...), nil
+}
+
+// writeOutbox records the messages of parsed in the outbox collection, unpublished, in as many
+// entries as their size needs. An entry the webhook already has, published or not, is kept, so a
+// redelivered webhook is not published twice, and one whose earlier delivery stored only some of
+// its entries gets the rest.
+func writeOutbox(ctx context.Context, parsed *ParsedWebhook) error {
+	documents := outboxDocuments{
+		Transfers:    parsed.Transfers,
+		Events:       parsed.Events,
+		Approvals:    parsed.Approvals,
+		Swaps:        parsed.Swaps,
+		Transactions: parsed.Transactions,
+		Tombstones:   parsed.Tombstones,
+	}
+	for i, doc := range parsed.Transfers {
+		if topic := doc.route.topic(); topic != "" {
+			if documents.Topics == nil {
+				documents.Topics = make([]string, len(parsed.Transfers))
+			}
+			documents.Topics[i] = topic
+		}
+	}
+	parts, err := marshalOutboxParts(documents)
+	if err != nil {
+		return err
+	}
+
+	writer, err := newWriter(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for i, data := range parts {
+		doc := &OutboxDocument{Documents: data, CreatedAt: now}
+		if len(parts) > 1 {
+			doc.Part, doc.Parts = i+1, len(parts)
+		}
+		if webhook := pipelineStateFrom(ctx, nil).Webhook; webhook != nil {
+			doc.WebhookID, doc.EventID, doc.Network = webhook.WebhookID, webhook.ID, webhook.Event.Network
+		}
+		created, err := writer.CreateDocument(ctx, outboxCollection(), doc)
+		if err != nil {
+			return fmt.Errorf("failed to write outbox entry %s: %w", doc.DocumentID(), err)
+		}
+		if created {
+			log.Printf(`{"level":"info","message":"recorded pubsub messages in outbox","id":"%s"}`, doc.DocumentID())
+		} else {
+			log.Printf(`{"level":"info","message":"outbox entry already recorded","id":"%s"}`, doc.DocumentID())
+		}
+	}
+	return nil
+}
+
+// OutboxResult reports the outcome of PublishOutbox.
+type OutboxResult struct {
+	Published int `json:"published"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+}
+
+// PublishOutbox publishes up to OUTBOX_BATCH_SIZE (default 500) unpublished outbox entries to
+// Pub/Sub, as the pubsub sink would have, and marks them published with an ExpireAt
+// OUTBOX_RETENTION (default 168h) later. Each entry is claimed in a transaction first, so
+// overlapping runs skip it. An entry that fails to publish records the error and is retried on
+// the next run; the others still go out.
+func PublishOutbox(ctx context.Context) (OutboxResult, error) {
+	var result OutboxResult
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return result, err
+	}
+	limit := max(envInt("OUTBOX_BATCH_SIZE", defaultOutboxBatchSize), 1)
+	retention := envDuration("OUTBOX_RETENTION", defaultOutboxRetention)
+
+	var failures []error
+	iter := client.Collection(outboxCollection()).Where("Published", "==", false).Limit(limit).Documents(ctx)
+	defer iter.Stop()
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		claimed, err := claimOutboxEntry(ctx, client, snapshot.Ref)
+		if err != nil {
+			return result, err
+		}
+		if !claimed {
+			result.Skipped++
+			continue
+		}
+
+		if err := publishOutboxEntry(ctx, snapshot); err != nil {
+			result.Failed++
+			failures = append(failures, fmt.Errorf("entry %s: %w", snapshot.Ref.ID, err))
+			if _, updateErr := snapshot.Ref.Update(ctx, []firestore.Update{
+				{Path: "Attempts", Value: firestore.Increment(1)},
+				{Path: "LastError", Value: err.Error()},
+				{Path: "ClaimedUntil", Value: firestore.Delete},
+			}); updateErr != nil {
+				return result, updateErr
+			}
+			continue
+		}
+		now := time.Now().UTC()
+		expireAt := now.Add(retention)
+		if _, err := snapshot.Ref.Update(ctx, []firestore.Update{
+			{Path: "Published", Value: true},
+			{Path: "PublishedAt", Value: now},
+			{Path: "ExpireAt", Value: expireAt},
+			{Path: "Attempts", Value: firestore.Increment(1)},
+			{Path: "ClaimedUntil", Value: firestore.Delete},
+		}); err != nil {
+			return result, err
+		}
+		result.Published++
+	}
+
+	log.Printf(`{"level":"info","message":"published outbox entries","published":%d,"failed":%d,"skipped":%d}`,
+		result.Published, result.Failed, result.Skipped)
+	if len(failures) > 0 {
+		return result, sinkErrors(failures)
+	}
+	return result, nil
+}
+
+// claimOutboxEntry claims the unpublished entry at ref for outboxClaim, reporting false when it
+// was published or another run holds it.
+func claimOutboxEntry(ctx context.Context, client *firestore.Client, ref *firestore.DocumentRef) (bool, error) {
+	claimed := false
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var doc OutboxDocument
+		if err := snapshot.DataTo(&doc); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if doc.Published || (doc.ClaimedUntil != nil && doc.ClaimedUntil.After(now)) {
+			return nil
+		}
+		claimed = true
+		return tx.Update(ref, []firestore.Update{{Path: "ClaimedUntil", Value: now.Add(outboxClaim)}})
+	})
+	return claimed, err
+}
+
+func publishOutboxEntry(ctx context.Context, snapshot *firestore.DocumentSnapshot) error {
+	var doc OutboxDocument
+	if err := snapshot.DataTo(&doc); err != nil {
+		return err
+	}
+	var documents outboxDocuments
+	if err := json.Unmarshal([]byte(doc.Documents), &documents); err != nil {
+		return fmt.Errorf("failed to unmarshal outbox entry: %w", err)
+	}
+	return publishToPubSub(ctx, documents.parsed())
+}
+
+// PublishOutboxHTTP is the Cloud Run Function entrypoint that runs PublishOutbox, meant to be
+// invoked on a schedule by Cloud Scheduler. Deploy it without unauthenticated access.
+func PublishOutboxHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := PublishOutbox(r.Context())
+	if err != nil {
+		logError("failed to publish outbox", err)
+		http.Error(w, "Failed to publish outbox", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logError("failed to write outbox result", err)
+	}
+}
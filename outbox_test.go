@@ -0,0 +1,136 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMarshalOutboxParts(t *testing.T) {
+	// Each transfer encodes to a little over 200 KiB, so four fit in an entry and five do not.
+	transfer := func(i int) *TransferDocument {
+		return &TransferDocument{FromLabel: fmt.Sprintf("%d-%s", i, strings.Repeat("x", 200<<10))}
+	}
+	transfers := func(n int) []*TransferDocument {
+		docs := make([]*TransferDocument, n)
+		for i := range docs {
+			docs[i] = transfer(i)
+		}
+		return docs
+	}
+	routed := transfers(9)
+	topics := []string{"a", "", "b", "", "", "c", "", "d", ""}
+
+	tests := []struct {
+		name      string
+		documents outboxDocuments
+		parts     int
+		wantErr   bool
+	}{
+		{"small", outboxDocuments{Transfers: transfers(1), Events: []*EventDocument{{Network: "ETH_MAINNET"}}}, 1, false},
+		{"at the limit", outboxDocuments{Transfers: transfers(4)}, 1, false},
+		{"over the limit", outboxDocuments{Transfers: transfers(5)}, 2, false},
+		{"routed transfers", outboxDocuments{Transfers: routed, Topics: topics}, 3, false},
+		{
+			"mixed kinds",
+			outboxDocuments{Transfers: transfers(5), Events: []*EventDocument{{Network: "ETH_MAINNET"}}, Tombstones: []*Tombstone{{ID: "t1"}, {ID: "t2"}}},
+			2, false,
+		},
+		{"single oversized document", outboxDocuments{Transfers: []*TransferDocument{{FromLabel: strings.Repeat("x", outboxMaxEntryBytes)}}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := marshalOutboxParts(tt.documents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(parts) != tt.parts {
+				t.Fatalf("%d parts, want %d", len(parts), tt.parts)
+			}
+
+			var joined outboxDocuments
+			for i, part := range parts {
+				if len(part) > outboxMaxEntryBytes {
+					t.Errorf("part %d holds %d bytes, over %d", i+1, len(part), outboxMaxEntryBytes)
+				}
+				var documents outboxDocuments
+				if err := json.Unmarshal([]byte(part), &documents); err != nil {
+					t.Fatal(err)
+				}
+				if documents.Topics != nil && len(documents.Topics) != len(documents.Transfers) {
+					t.Errorf("part %d has %d topics for %d transfers", i+1, len(documents.Topics), len(documents.Transfers))
+				}
+				if tt.documents.Topics != nil && documents.Topics == nil && len(documents.Transfers) > 0 {
+					t.Errorf("part %d lost the topics of its transfers", i+1)
+				}
+				joined.Transfers = append(joined.Transfers, documents.Transfers...)
+				joined.Topics = append(joined.Topics, documents.Topics...)
+				joined.Events = append(joined.Events, documents.Events...)
+				joined.Tombstones = append(joined.Tombstones, documents.Tombstones...)
+			}
+			if joined.count() != tt.documents.count() {
+				t.Errorf("parts hold %d documents, want %d", joined.count(), tt.documents.count())
+			}
+			for i, doc := range joined.Transfers {
+				if doc.FromLabel != tt.documents.Transfers[i].FromLabel {
+					t.Errorf("transfer %d is out of order", i)
+				}
+			}
+			if tt.documents.Topics != nil && !slices.Equal(joined.Topics, tt.documents.Topics) {
+				t.Errorf("topics = %v, want %v", joined.Topics, tt.documents.Topics)
+			}
+		})
+	}
+}
+
+func TestOutboxDocumentsHalves(t *testing.T) {
+	documents := outboxDocuments{
+		Transfers:  []*TransferDocument{{FromLabel: "0"}, {FromLabel: "1"}, {FromLabel: "2"}},
+		Topics:     []string{"a", "", "b"},
+		Events:     []*EventDocument{{Network: "ETH_MAINNET"}},
+		Tombstones: []*Tombstone{{ID: "t1"}, {ID: "t2"}},
+	}
+	first, second := documents.halves()
+	if len(first.Transfers) != 3 || len(second.Transfers) != 0 {
+		t.Errorf("transfers split %d/%d, want 3/0", len(first.Transfers), len(second.Transfers))
+	}
+	if !slices.Equal(first.Topics, documents.Topics) || len(second.Topics) != 0 {
+		t.Errorf("topics split %v/%v, want %v/[]", first.Topics, second.Topics, documents.Topics)
+	}
+	if len(second.Events) != 1 || len(second.Tombstones) != 2 {
+		t.Errorf("second half holds %d events and %d tombstones, want 1 and 2", len(second.Events), len(second.Tombstones))
+	}
+
+	documents.Tombstones = append(documents.Tombstones, &Tombstone{ID: "t3"}, &Tombstone{ID: "t4"}, &Tombstone{ID: "t5"})
+	first, second = documents.halves()
+	if first.count() != 4 || second.count() != 5 {
+		t.Errorf("halves hold %d and %d documents, want 4 and 5", first.count(), second.count())
+	}
+	if len(first.Topics) != len(first.Transfers) || len(second.Topics) != len(second.Transfers) {
+		t.Errorf("topics %d/%d misaligned with transfers %d/%d", len(first.Topics), len(second.Topics), len(first.Transfers), len(second.Transfers))
+	}
+}
+
+func TestOutboxDocumentID(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  OutboxDocument
+		want string
+	}{
+		{"single entry", OutboxDocument{EventID: "whevt_1", Documents: "{}"}, "whevt_1"},
+		{"part of several", OutboxDocument{EventID: "whevt_1", Documents: "{}", Part: 2, Parts: 3}, "whevt_1-2"},
+		{"unknown event", OutboxDocument{Documents: "{}"}, "44136fa355b3678a1146ad16f7e8649e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.doc.DocumentID(); got != tt.want {
+				t.Errorf("DocumentID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
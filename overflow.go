@@ -0,0 +1,83 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// errDocumentCap is the error recorded on the dead letters of documents beyond the cap.
+var errDocumentCap = errors.New("MAX_DOCUMENTS_PER_WEBHOOK exceeded")
+
+// getMaxDocuments returns the per-webhook cap on documents written to each sink from
+// MAX_DOCUMENTS_PER_WEBHOOK; 0 means unlimited.
+func getMaxDocuments() (int, error) {
+	value := os.Getenv("MAX_DOCUMENTS_PER_WEBHOOK")
+	if value == "" {
+		return 0, nil
+	}
+	maxDocuments, err := strconv.Atoi(value)
+	if err != nil || maxDocuments < 0 {
+		return 0, fmt.Errorf("invalid MAX_DOCUMENTS_PER_WEBHOOK %q", value)
+	}
+	return maxDocuments, nil
+}
+
+// documentCount returns how many documents parsed holds for the sinks. Quarantined logs are not
+// counted: they are kept in the quarantine collection or as a decode dead letter.
+func (p *ParsedWebhook) documentCount() int {
+	return len(p.Transfers) + len(p.Reverted) + len(p.Events) + len(p.Approvals) + len(p.Swaps) +
+		len(p.Transactions) + len(p.Tombstones) + len(p.RawLogs)
+}
+
+// capDocuments splits parsed into the first maxDocuments documents, taken kind by kind in a fixed
+// order so every sink keeps the same ones, and the overflow beyond them, which is nil when parsed
+// is within the cap. parsed itself is left unchanged, since sinks share it.
+func capDocuments(parsed *ParsedWebhook, maxDocuments int) (kept, overflow *ParsedWebhook) {
+	if maxDocuments == 0 || parsed.documentCount() <= maxDocuments {
+		return parsed, nil
+	}
+	kept, overflow = &ParsedWebhook{Quarantined: parsed.Quarantined, Errors: parsed.Errors}, &ParsedWebhook{}
+	remaining := maxDocuments
+	kept.Transfers, overflow.Transfers = splitDocuments(parsed.Transfers, &remaining)
+	kept.Reverted, overflow.Reverted = splitDocuments(parsed.Reverted, &remaining)
+	kept.Events, overflow.Events = splitDocuments(parsed.Events, &remaining)
+	kept.Approvals, overflow.Approvals = splitDocuments(parsed.Approvals, &remaining)
+	kept.Swaps, overflow.Swaps = splitDocuments(parsed.Swaps, &remaining)
+	kept.Transactions, overflow.Transactions = splitDocuments(parsed.Transactions, &remaining)
+	kept.Tombstones, overflow.Tombstones = splitDocuments(parsed.Tombstones, &remaining)
+	kept.RawLogs, overflow.RawLogs = splitDocuments(parsed.RawLogs, &remaining)
+	return kept, overflow
+}
+
+// splitDocuments keeps up to *remaining of docs, counting them off *remaining, and returns the rest.
+func splitDocuments[T any](docs []T, remaining *int) (kept, rest []T) {
+	n := min(len(docs), *remaining)
+	*remaining -= n
+	if n == len(docs) {
+		return docs, nil
+	}
+	return docs[:n:n], docs[n:]
+}
+
+// deadLetterOverflow stores the documents of parsed beyond maxDocuments as the sink's overflow
+// dead letter and returns the documents left for the sink to write.
+func deadLetterOverflow(ctx context.Context, sink string, parsed *ParsedWebhook, maxDocuments int) (*ParsedWebhook, error) {
+	kept, overflow := capDocuments(parsed, maxDocuments)
+	if overflow == nil {
+		return parsed, nil
+	}
+	if err := writeDeadLetter(ctx, sink, DeadLetterOverflow, errDocumentCap, overflow); err != nil {
+		return nil, fmt.Errorf("failed to dead-letter overflow documents: %w", err)
+	}
+	webhookID := ""
+	if webhook := pipelineStateFrom(ctx, nil).Webhook; webhook != nil {
+		webhookID = webhook.WebhookID
+	}
+	log.Printf(`{"level":"warn","message":"dead-lettered overflow documents","webhook_id":"%s","sink":"%s","overflow":%d,"max":%d}`,
+		webhookID, sink, overflow.documentCount(), maxDocuments)
+	return kept, nil
+}
@@ -0,0 +1,70 @@
+package function
+
+import "testing"
+
+func TestCapDocuments(t *testing.T) {
+	parsed := &ParsedWebhook{
+		Transfers:   []*TransferDocument{{}, {}, {}},
+		Events:      []*EventDocument{{}},
+		Swaps:       []*SwapDocument{{}, {}},
+		Tombstones:  []*Tombstone{{}},
+		Quarantined: []*QuarantineDocument{{}},
+	}
+	tests := []struct {
+		name      string
+		max       int
+		kept      [4]int // transfers, events, swaps, tombstones
+		overflow  [4]int
+		overflows bool
+	}{
+		{"unlimited", 0, [4]int{3, 1, 2, 1}, [4]int{}, false},
+		{"within the cap", 7, [4]int{3, 1, 2, 1}, [4]int{}, false},
+		{"within transfers", 2, [4]int{2, 0, 0, 0}, [4]int{1, 1, 2, 1}, true},
+		{"across kinds", 5, [4]int{3, 1, 1, 0}, [4]int{0, 0, 1, 1}, true},
+		{"last document", 6, [4]int{3, 1, 2, 0}, [4]int{0, 0, 0, 1}, true},
+	}
+	counts := func(p *ParsedWebhook) [4]int {
+		if p == nil {
+			return [4]int{}
+		}
+		return [4]int{len(p.Transfers), len(p.Events), len(p.Swaps), len(p.Tombstones)}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, overflow := capDocuments(parsed, tt.max)
+			if (overflow != nil) != tt.overflows {
+				t.Fatalf("overflow = %v, want overflow %v", overflow, tt.overflows)
+			}
+			if got := counts(kept); got != tt.kept {
+				t.Errorf("kept %v, want %v", got, tt.kept)
+			}
+			if got := counts(overflow); got != tt.overflow {
+				t.Errorf("overflow %v, want %v", got, tt.overflow)
+			}
+			if len(kept.Quarantined) != 1 {
+				t.Errorf("kept %d quarantined logs, want 1", len(kept.Quarantined))
+			}
+			if len(parsed.Transfers) != 3 || len(parsed.Tombstones) != 1 {
+				t.Error("capDocuments changed the shared documents")
+			}
+		})
+	}
+}
+
+func TestDeadLetterDocumentID(t *testing.T) {
+	tests := []struct {
+		sink, reason string
+		want         string
+	}{
+		{"bigquery", DeadLetterSinkFailed, "bigquery-whevt_1"},
+		{"bigquery", DeadLetterCircuitOpen, "bigquery-whevt_1"},
+		{"bigquery", DeadLetterOverflow, "bigquery-overflow-whevt_1"},
+		{"", DeadLetterDecodeFailed, "decode_failed-whevt_1"},
+	}
+	for _, tt := range tests {
+		doc := &DeadLetterDocument{Sink: tt.sink, Reason: tt.reason, EventID: "whevt_1"}
+		if got := doc.DocumentID(); got != tt.want {
+			t.Errorf("DocumentID(%s, %s) = %s, want %s", tt.sink, tt.reason, got, tt.want)
+		}
+	}
+}
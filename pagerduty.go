@@ -0,0 +1,238 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	// defaultPagerDutySinkFailures pages once a sink has failed several webhooks in a row, after
+	// its retries, rather than on a single failure Alchemy's redelivery absorbs.
+	defaultPagerDutySinkFailures      = 5
+	defaultPagerDutySignatureFailures = 20
+	defaultPagerDutySignatureWindow   = 5 * time.Minute
+	pagerDutyProvider                 = "pagerduty"
+)
+
+// pagerDutyAlerter triggers PagerDuty incidents through the Events API v2 when a sink fails
+// PAGERDUTY_SINK_FAILURE_THRESHOLD webhooks in a row, or PAGERDUTY_SIGNATURE_FAILURE_THRESHOLD
+// requests fail signature validation within PAGERDUTY_SIGNATURE_FAILURE_WINDOW, and resolves
+// them once the sink writes again or a window passes below the threshold. Counts are kept per
+// instance; events carry a deduplication key per condition, so every instance reporting the same
+// condition updates one incident. Alerts are best effort: failures to send them are logged and
+// never affect webhook processing.
+type pagerDutyAlerter struct {
+	url        string
+	routingKey string
+	severity   string
+	source     string
+	dedupKey   string
+
+	sinkThreshold      int
+	signatureThreshold int
+	signatureWindow    time.Duration
+
+	mu                   sync.Mutex
+	sinkFailures         map[string]int
+	sinkTriggered        map[string]bool
+	signatureStart       time.Time
+	signatureFailures    int
+	signatureTriggeredAt time.Time
+}
+
+var (
+	pagerDutyOnce sync.Once
+	pagerDuty     *pagerDutyAlerter
+	pagerDutyErr  error
+)
+
+// getPagerDutyAlerter returns the alerter configured by PAGERDUTY_ROUTING_KEY, once per instance,
+// or nil when it is unset. An invalid configuration disables alerts and fails the readiness check.
+func getPagerDutyAlerter() (*pagerDutyAlerter, error) {
+	pagerDutyOnce.Do(func() {
+		pagerDuty, pagerDutyErr = newPagerDutyAlerter()
+		if pagerDutyErr != nil {
+			logError("invalid PagerDuty configuration; alerts are disabled", pagerDutyErr)
+		}
+	})
+	return pagerDuty, pagerDutyErr
+}
+
+func newPagerDutyAlerter() (*pagerDutyAlerter, error) {
+	routingKey := os.Getenv("PAGERDUTY_ROUTING_KEY")
+	if routingKey == "" {
+		return nil, nil
+	}
+	a := &pagerDutyAlerter{
+		url:                os.Getenv("PAGERDUTY_EVENTS_URL"),
+		routingKey:         routingKey,
+		severity:           os.Getenv("PAGERDUTY_SEVERITY"),
+		source:             os.Getenv("K_SERVICE"),
+		dedupKey:           os.Getenv("PAGERDUTY_DEDUP_PREFIX"),
+		sinkThreshold:      envInt("PAGERDUTY_SINK_FAILURE_THRESHOLD", defaultPagerDutySinkFailures),
+		signatureThreshold: envInt("PAGERDUTY_SIGNATURE_FAILURE_THRESHOLD", defaultPagerDutySignatureFailures),
+		signatureWindow:    envDuration("PAGERDUTY_SIGNATURE_FAILURE_WINDOW", defaultPagerDutySignatureWindow),
+		sinkFailures:       map[string]int{},
+		sinkTriggered:      map[string]bool{},
+	}
+	if a.url == "" {
+		a.url = defaultPagerDutyEventsURL
+	}
+	switch a.severity {
+	case "":
+		a.severity = "error"
+	case "critical", "error", "warning", "info":
+	default:
+		return nil, fmt.Errorf("invalid PAGERDUTY_SEVERITY %q (want critical, error, warning or info)", a.severity)
+	}
+	if a.source == "" {
+		a.source = "alchemy-webhook"
+	}
+	if a.dedupKey == "" {
+		a.dedupKey = a.source
+	}
+	if a.sinkThreshold < 1 || a.signatureThreshold < 1 || a.signatureWindow <= 0 {
+		return nil, errors.New("PAGERDUTY_SINK_FAILURE_THRESHOLD, PAGERDUTY_SIGNATURE_FAILURE_THRESHOLD and PAGERDUTY_SIGNATURE_FAILURE_WINDOW must be positive")
+	}
+	return a, nil
+}
+
+// alertSinkResult records the outcome of a webhook's write to sink, triggering the sink's
+// incident on the failure that reaches the threshold and resolving it on the next success.
+func alertSinkResult(ctx context.Context, sink string, err error) {
+	a, alertErr := getPagerDutyAlerter()
+	if alertErr != nil || a == nil {
+		return
+	}
+	a.mu.Lock()
+	var action string
+	var failures int
+	if err != nil {
+		a.sinkFailures[sink]++
+		failures = a.sinkFailures[sink]
+		if failures >= a.sinkThreshold && !a.sinkTriggered[sink] {
+			a.sinkTriggered[sink], action = true, "trigger"
+		}
+	} else {
+		a.sinkFailures[sink] = 0
+		if a.sinkTriggered[sink] {
+			a.sinkTriggered[sink], action = false, "resolve"
+		}
+	}
+	a.mu.Unlock()
+
+	switch action {
+	case "trigger":
+		a.send(ctx, "trigger", "sink/"+sink, &pagerDutyPayload{
+			Summary:   fmt.Sprintf("%s: sink %s failed %d webhooks in a row", a.source, sink, failures),
+			Component: sink,
+			Class:     "sink_failure",
+			Details:   map[string]any{"sink": sink, "failures": failures, "error": err.Error(), "instance": instanceID},
+		})
+	case "resolve":
+		a.send(ctx, "resolve", "sink/"+sink, nil)
+	}
+}
+
+// alertSignatureResult records the outcome of a request's signature validation, triggering the
+// signature incident on the failure that reaches the threshold within the window and resolving
+// it on a valid request in a later window that is still below the threshold.
+func alertSignatureResult(ctx context.Context, valid bool) {
+	a, err := getPagerDutyAlerter()
+	if err != nil || a == nil {
+		return
+	}
+	now := time.Now()
+	a.mu.Lock()
+	if now.Sub(a.signatureStart) >= a.signatureWindow {
+		a.signatureStart, a.signatureFailures = now, 0
+	}
+	var action string
+	switch {
+	case !valid:
+		a.signatureFailures++
+		if a.signatureFailures >= a.signatureThreshold && a.signatureTriggeredAt.IsZero() {
+			a.signatureTriggeredAt, action = now, "trigger"
+		}
+	case !a.signatureTriggeredAt.IsZero() && a.signatureStart.After(a.signatureTriggeredAt) && a.signatureFailures < a.signatureThreshold:
+		a.signatureTriggeredAt, action = time.Time{}, "resolve"
+	}
+	failures := a.signatureFailures
+	a.mu.Unlock()
+
+	switch action {
+	case "trigger":
+		a.send(ctx, "trigger", "signature-failures", &pagerDutyPayload{
+			Summary:   fmt.Sprintf("%s: %d webhook signature failures within %s", a.source, failures, a.signatureWindow),
+			Component: "signature",
+			Class:     "signature_failure",
+			Details:   map[string]any{"failures": failures, "window": a.signatureWindow.String(), "instance": instanceID},
+		})
+	case "resolve":
+		a.send(ctx, "resolve", "signature-failures", nil)
+	}
+}
+
+// pagerDutyPayload is the payload of a trigger event.
+type pagerDutyPayload struct {
+	Summary   string         `json:"summary"`
+	Source    string         `json:"source"`
+	Severity  string         `json:"severity"`
+	Timestamp string         `json:"timestamp"`
+	Component string         `json:"component,omitempty"`
+	Group     string         `json:"group,omitempty"`
+	Class     string         `json:"class,omitempty"`
+	Details   map[string]any `json:"custom_details,omitempty"`
+}
+
+// send enqueues an event with the deduplication key of condition through the pagerduty
+// provider, logging any failure.
+func (a *pagerDutyAlerter) send(ctx context.Context, action, condition string, payload *pagerDutyPayload) {
+	event := map[string]any{
+		"routing_key":  a.routingKey,
+		"event_action": action,
+		"dedup_key":    a.dedupKey + "/" + condition,
+	}
+	if payload != nil {
+		payload.Source = a.source
+		payload.Severity = a.severity
+		payload.Timestamp = time.Now().UTC().Format(time.RFC3339)
+		payload.Group = os.Getenv("K_REVISION")
+		event["payload"] = payload
+	}
+	if err := a.post(context.WithoutCancel(ctx), event); err != nil {
+		logError("failed to send PagerDuty "+action+" event for "+condition, err)
+	}
+}
+
+func (a *pagerDutyAlerter) post(ctx context.Context, event map[string]any) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ProviderFor(pagerDutyProvider).Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s responded with status %d: %s", pagerDutyProvider, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -2,60 +2,104 @@ package function
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"math/big"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 // Block represents blockchain block information.
+// Time is Timestamp as a native Firestore timestamp, set when the document is written to
+// Firestore and absent from every other output.
 type Block struct {
-	Hash      string `json:"hash"`
-	Number    int64  `json:"number"`
-	Timestamp int64  `json:"timestamp"`
+	Hash      string     `json:"hash"`
+	Number    int64      `json:"number"`
+	Timestamp int64      `json:"timestamp"`
+	Time      *time.Time `json:"-" firestore:",omitempty"`
 }
 
 // Transaction represents blockchain transaction information.
+// Type and TypeName are set when the webhook reports the transaction type. ContractCreation marks
+// transactions without a recipient, with the deployed contract in CreatedContract when reported.
+// The EIP-1559 fee caps are only set for type 2 and later transactions. FeeWei is the decimal
+// fee paid, gasUsed × effectiveGasPrice, falling back to gasPrice when no effective price is reported.
 type Transaction struct {
-	Hash     string `json:"hash"`
-	From     string `json:"from"`
-	To       string `json:"to"`
-	Value    string `json:"value"`
-	GasPrice string `json:"gasPrice"`
-	Gas      int64  `json:"gas"`
-	Status   int    `json:"status"`
-	GasUsed  int64  `json:"gasUsed"`
+	Hash                 string `json:"hash"`
+	From                 string `json:"from"`
+	To                   string `json:"to"`
+	Type                 *int   `json:"type,omitempty"`
+	TypeName             string `json:"typeName,omitempty"`
+	ContractCreation     bool   `json:"contractCreation,omitempty"`
+	CreatedContract      string `json:"createdContract,omitempty"`
+	Value                string `json:"value"`
+	GasPrice             string `json:"gasPrice"`
+	MaxFeePerGas         string `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas,omitempty"`
+	EffectiveGasPrice    string `json:"effectiveGasPrice,omitempty"`
+	Gas                  int64  `json:"gas"`
+	Status               int    `json:"status"`
+	GasUsed              int64  `json:"gasUsed"`
+	FeeWei               string `json:"feeWei,omitempty"`
+
+	// statusKnown is set when the payload reported the status; Status is 0 otherwise.
+	statusKnown bool
+}
+
+// failed reports whether the transaction is known to have reverted, with a reported status of 0.
+// Transactions whose payload carries no status are not.
+func (t Transaction) failed() bool {
+	return t.statusKnown && t.Status == 0
 }
 
-// Transfer represents ERC20 transfer event information.
+// Token standards reported in Transfer.Standard.
+const (
+	StandardERC20   = "ERC20"
+	StandardERC721  = "ERC721"
+	StandardERC1155 = "ERC1155"
+	StandardNative  = "NATIVE"
+)
+
+// Transfer represents ERC20, ERC721 or ERC1155 transfer event information.
+// Value is nil for ERC721 transfers, which carry a TokenID instead.
+// ERC1155 transfers carry both, plus the operator; entries expanded from a
+// TransferBatch event also carry their position in the batch.
+// Native currency transfers use NativeContract as contract; internal ones carry a trace index.
 type Transfer struct {
-	Contract string   `json:"contract"`
-	From     string   `json:"from"`
-	To       string   `json:"to"`
-	Value    *big.Int `json:"value"`
-	LogIndex int      `json:"logIndex"`
+	Contract   string   `json:"contract"`
+	Standard   string   `json:"standard"`
+	Operator   string   `json:"operator"`
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Value      *big.Int `json:"value"`
+	TokenID    *big.Int `json:"tokenId"`
+	LogIndex   int      `json:"logIndex"`
+	BatchIndex *int     `json:"batchIndex"`
+	TraceIndex *int     `json:"traceIndex"`
 }
 
-// transferJSON is used for JSON serialization of Transfer.
+// transferJSON is the serialized form of Transfer, in JSON and MessagePack.
 type transferJSON struct {
-	Contract string `json:"contract"`
-	From     string `json:"from"`
-	To       string `json:"to"`
-	Value    string `json:"value"`
-	LogIndex int    `json:"logIndex"`
+	Contract   string `json:"contract"`
+	Standard   string `json:"standard"`
+	Operator   string `json:"operator,omitempty"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Value      string `json:"value,omitempty"`
+	TokenID    string `json:"tokenId,omitempty"`
+	LogIndex   int    `json:"logIndex"`
+	BatchIndex *int   `json:"batchIndex,omitempty"`
+	TraceIndex *int   `json:"traceIndex,omitempty"`
 }
 
 func (t Transfer) MarshalJSON() ([]byte, error) {
-	return json.Marshal(transferJSON{
-		Contract: t.Contract,
-		From:     t.From,
-		To:       t.To,
-		Value:    t.Value.String(),
-		LogIndex: t.LogIndex,
-	})
+	return json.Marshal(t.wire())
 }
 
 func (t *Transfer) UnmarshalJSON(data []byte) error {
@@ -63,32 +107,114 @@ func (t *Transfer) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &aux); err != nil {
 		return err
 	}
+	t.setWire(aux)
+	return nil
+}
+
+// wire returns t in its serialized form, with amounts as decimal strings.
+func (t Transfer) wire() transferJSON {
+	return transferJSON{
+		Contract:   t.Contract,
+		Standard:   t.Standard,
+		Operator:   t.Operator,
+		From:       t.From,
+		To:         t.To,
+		Value:      bigIntString(t.Value),
+		TokenID:    bigIntString(t.TokenID),
+		LogIndex:   t.LogIndex,
+		BatchIndex: t.BatchIndex,
+		TraceIndex: t.TraceIndex,
+	}
+}
+
+// setWire sets t from its serialized form.
+func (t *Transfer) setWire(aux transferJSON) {
 	t.Contract = aux.Contract
+	t.Standard = aux.Standard
+	t.Operator = aux.Operator
 	t.From = aux.From
 	t.To = aux.To
 	t.LogIndex = aux.LogIndex
+	t.BatchIndex = aux.BatchIndex
+	t.TraceIndex = aux.TraceIndex
 	if aux.Value != "" {
 		t.Value = new(big.Int)
 		t.Value.SetString(aux.Value, 10)
 	}
-	return nil
+	if aux.TokenID != "" {
+		t.TokenID = new(big.Int)
+		t.TokenID.SetString(aux.TokenID, 10)
+	}
+}
+
+// bigIntString returns the decimal form of v, or "" when v is nil.
+func bigIntString(v *big.Int) string {
+	if v == nil {
+		return ""
+	}
+	return v.String()
 }
 
 // AlchemyMetadata represents Alchemy-specific metadata.
+// CreatedAtTime is CreatedAt as a native Firestore timestamp, set like Block.Time.
 type AlchemyMetadata struct {
-	WebhookID      string `json:"webhookId"`
-	EventID        string `json:"eventId"`
-	SequenceNumber string `json:"sequenceNumber"`
-	CreatedAt      string `json:"createdAt"`
+	WebhookID      string     `json:"webhookId"`
+	EventID        string     `json:"eventId"`
+	SequenceNumber string     `json:"sequenceNumber"`
+	CreatedAt      string     `json:"createdAt"`
+	CreatedAtTime  *time.Time `json:"-" firestore:",omitempty"`
+	Anomalies      []string   `json:"anomalies,omitempty"`
 }
 
 // TransferDocument represents the complete document structure.
 type TransferDocument struct {
-	Block       Block           `json:"block"`
-	Transaction Transaction     `json:"transaction"`
-	Transfer    Transfer        `json:"transfer"`
-	Network     string          `json:"network"`
-	Alchemy     AlchemyMetadata `json:"alchemy"`
+	SchemaVersion    int             `json:"schemaVersion"`
+	Block            Block           `json:"block"`
+	Transaction      Transaction     `json:"transaction"`
+	Transfer         Transfer        `json:"transfer"`
+	Network          string          `json:"network"`
+	Alchemy          AlchemyMetadata `json:"alchemy"`
+	Reverted         bool            `json:"reverted,omitempty"`
+	Attribution      *Attribution    `json:"attribution,omitempty"`
+	Token            *TokenMetadata  `json:"token,omitempty"`
+	FromENS          string          `json:"fromEns,omitempty"`
+	ToENS            string          `json:"toEns,omitempty"`
+	FromLabel        string          `json:"fromLabel,omitempty"`
+	ToLabel          string          `json:"toLabel,omitempty"`
+	Finality         string          `json:"finality,omitempty"`
+	ConfirmedAt      *time.Time      `json:"confirmedAt,omitempty"`
+	Bridge           *BridgeLink     `json:"bridge,omitempty"`
+	RawLog           *RawLog         `json:"rawLog,omitempty"`
+	AmountBucket     string          `json:"amountBucket,omitempty"`
+	AmountPercentile *float64        `json:"amountPercentile,omitempty"`
+
+	// route is the rule that routed the transfer, if any.
+	route *Rule
+}
+
+// DocumentID returns the idempotent document ID of the transfer.
+// Entries expanded from an ERC1155 TransferBatch are suffixed with their batch index.
+func (d *TransferDocument) DocumentID() string {
+	if d.isNative() {
+		return nativeDocumentID(d.Transaction.Hash, d.Transfer.TraceIndex)
+	}
+	id := GetDocumentID(d.Transaction.Hash, d.Transfer.LogIndex)
+	if d.Transfer.BatchIndex != nil {
+		id = fmt.Sprintf("%s-%d", id, *d.Transfer.BatchIndex)
+	}
+	return id
+}
+
+// blockTime returns the time of the transfer's block, or the webhook's creation time for
+// payloads without block timestamps, such as address activity.
+func (d *TransferDocument) blockTime() time.Time {
+	if d.Block.Timestamp != 0 {
+		return time.Unix(d.Block.Timestamp, 0).UTC()
+	}
+	if createdAt, err := time.Parse(time.RFC3339, d.Alchemy.CreatedAt); err == nil {
+		return createdAt.UTC()
+	}
+	return time.Now().UTC()
 }
 
 // WebhookLog represents a single log entry in the webhook event.
@@ -96,6 +222,7 @@ type WebhookLog struct {
 	Data    string   `json:"data"`
 	Topics  []string `json:"topics"`
 	Index   int      `json:"index"`
+	Removed bool     `json:"removed"`
 	Account struct {
 		Address string `json:"address"`
 	} `json:"account"`
@@ -107,11 +234,18 @@ type WebhookLog struct {
 		To struct {
 			Address string `json:"address"`
 		} `json:"to"`
-		Value    string `json:"value"`
-		GasPrice string `json:"gasPrice"`
-		Gas      int64  `json:"gas"`
-		Status   int    `json:"status"`
-		GasUsed  int64  `json:"gasUsed"`
+		CreatedContract struct {
+			Address string `json:"address"`
+		} `json:"createdContract"`
+		Type                 *int64 `json:"type"`
+		Value                string `json:"value"`
+		GasPrice             string `json:"gasPrice"`
+		MaxFeePerGas         string `json:"maxFeePerGas"`
+		MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+		EffectiveGasPrice    string `json:"effectiveGasPrice"`
+		Gas                  int64  `json:"gas"`
+		Status               *int   `json:"status"`
+		GasUsed              int64  `json:"gasUsed"`
 	} `json:"transaction"`
 }
 
@@ -130,15 +264,57 @@ type WebhookEvent struct {
 				Logs      []WebhookLog `json:"logs"`
 			} `json:"block"`
 		} `json:"data"`
-		SequenceNumber string `json:"sequenceNumber"`
-		Network        string `json:"network"`
+		SequenceNumber string              `json:"sequenceNumber"`
+		Network        string              `json:"network"`
+		Activity       []ActivityEntry     `json:"activity"`
+		Transaction    *WebhookTransaction `json:"transaction"`
 	} `json:"event"`
+
+	// anomalies lists the metadata inconsistencies found by checkMetadata.
+	anomalies []string
 }
 
+// Kinds of log-derived document, reported in quarantine and tombstone records.
+const (
+	KindTransfer = "transfer"
+	KindApproval = "approval"
+	KindSwap     = "swap"
+	KindEvent    = "event"
+)
+
+// Webhook types reported in WebhookEvent.Type.
+const (
+	WebhookTypeGraphQL         = "GRAPHQL"
+	WebhookTypeAddressActivity = "ADDRESS_ACTIVITY"
+	WebhookTypeNFTActivity     = "NFT_ACTIVITY"
+	WebhookTypeMinedTx         = "MINED_TRANSACTION"
+	WebhookTypeDroppedTx       = "DROPPED_TRANSACTION"
+)
+
 // ERC20 Transfer event ABI definition.
 const transferEventABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"}]`
 
-var parsedTransferABI abi.ABI
+// ERC1155 TransferSingle and TransferBatch event ABI definitions.
+const erc1155EventABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"operator","type":"address"},{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"id","type":"uint256"},{"indexed":false,"name":"value","type":"uint256"}],"name":"TransferSingle","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"name":"operator","type":"address"},{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"ids","type":"uint256[]"},{"indexed":false,"name":"values","type":"uint256[]"}],"name":"TransferBatch","type":"event"}]`
+
+var (
+	parsedTransferABI abi.ABI
+	parsedERC1155ABI  abi.ABI
+)
+
+// Event signature hashes expected in topics[0].
+var (
+	transferEventTopic       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	transferSingleEventTopic = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	transferBatchEventTopic  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+)
+
+var (
+	// ErrNotTransfer is returned for logs whose topics[0] is not the Transfer event signature.
+	ErrNotTransfer = errors.New("log is not a transfer event")
+	// ErrMalformedTransfer is returned for Transfer logs whose topics or data cannot be decoded.
+	ErrMalformedTransfer = errors.New("malformed Transfer event")
+)
 
 func init() {
 	var err error
@@ -146,6 +322,10 @@ func init() {
 	if err != nil {
 		panic("failed to parse transfer event ABI: " + err.Error())
 	}
+	parsedERC1155ABI, err = abi.JSON(strings.NewReader(erc1155EventABI))
+	if err != nil {
+		panic("failed to parse ERC1155 event ABI: " + err.Error())
+	}
 }
 
 // decodedTransferEvent holds the decoded value from Transfer event data.
@@ -153,76 +333,429 @@ type decodedTransferEvent struct {
 	Value *big.Int
 }
 
-// ParseTransferEvents parses all webhook logs into TransferDocuments.
-func ParseTransferEvents(webhook *WebhookEvent) ([]*TransferDocument, error) {
+// decodedTransferSingleEvent holds the decoded id and value from TransferSingle event data.
+type decodedTransferSingleEvent struct {
+	ID    *big.Int `abi:"id"`
+	Value *big.Int `abi:"value"`
+}
+
+// decodedTransferBatchEvent holds the decoded ids and values from TransferBatch event data.
+type decodedTransferBatchEvent struct {
+	IDs    []*big.Int `abi:"ids"`
+	Values []*big.Int `abi:"values"`
+}
+
+// ParsedWebhook holds the documents parsed from a webhook, by kind.
+// Reverted holds transfers from reverted transactions routed to their own collection,
+// Quarantined the logs that matched a supported topics[0] but failed to decode, and
+// Tombstones the documents of logs removed by a chain reorganization. Errors lists every
+// log or activity entry that failed to parse, whether quarantined or skipped.
+type ParsedWebhook struct {
+	Transfers    []*TransferDocument
+	Reverted     []*TransferDocument
+	Events       []*EventDocument
+	Transactions []*TransactionDocument
+	Approvals    []*ApprovalDocument
+	Swaps        []*SwapDocument
+	Quarantined  []*QuarantineDocument
+	Tombstones   []*Tombstone
+	RawLogs      []*RawLogDocument
+	Errors       []LogError
+}
+
+// LogError reports a log or activity entry that failed to parse, by its index in the payload.
+// Quarantined is set when the log was kept in the quarantine collection rather than skipped.
+type LogError struct {
+	Index       int    `json:"index"`
+	Kind        string `json:"kind"`
+	Reason      string `json:"reason"`
+	Quarantined bool   `json:"quarantined"`
+}
+
+// logParseErrors logs every per-log parse error of parsed with the webhook_log_parse_error metric.
+func logParseErrors(webhook *WebhookEvent, parsed *ParsedWebhook) {
+	for _, e := range parsed.Errors {
+		log.Printf(`{"level":"warn","message":"failed to parse webhook log","metric":"webhook_log_parse_error","webhook_id":"%s","index":%d,"kind":"%s","quarantined":%t,"reason":"%s"}`,
+			webhook.WebhookID, e.Index, e.Kind, e.Quarantined, e.Reason)
+		countMetric("alchemy_webhook_log_parse_error_total", 1, "kind", e.Kind, "quarantined", strconv.FormatBool(e.Quarantined))
+	}
+}
+
+// Empty reports whether no documents were parsed.
+func (p *ParsedWebhook) Empty() bool {
+	return len(p.Transfers) == 0 && len(p.Reverted) == 0 && len(p.Events) == 0 &&
+		len(p.Transactions) == 0 && len(p.Approvals) == 0 && len(p.Swaps) == 0 &&
+		len(p.Quarantined) == 0 && len(p.Tombstones) == 0
+}
+
+// TransferEvents holds the transfers parsed from a webhook and the logs that failed to parse.
+type TransferEvents struct {
+	Transfers []*TransferDocument
+	Errors    []LogError
+}
+
+// ParseTransferEvents parses all transfers in the webhook into TransferDocuments, reporting
+// every log that failed to parse instead of dropping it silently.
+func ParseTransferEvents(webhook *WebhookEvent) (*TransferEvents, error) {
+	parsed, err := ParseWebhook(webhook, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferEvents{Transfers: parsed.Transfers, Errors: parsed.Errors}, nil
+}
+
+// ParseWebhook parses a webhook according to its type. GRAPHQL custom webhooks go through
+// ParseWebhookLogs; ADDRESS_ACTIVITY and NFT_ACTIVITY webhooks are normalized into TransferDocuments;
+// MINED_TRANSACTION and DROPPED_TRANSACTION webhooks yield a TransactionDocument.
+func ParseWebhook(webhook *WebhookEvent, registry *EventDecoderRegistry) (*ParsedWebhook, error) {
+	switch webhook.Type {
+	case WebhookTypeAddressActivity:
+		return ParseAddressActivity(webhook)
+	case WebhookTypeNFTActivity:
+		return ParseNFTActivity(webhook)
+	case WebhookTypeMinedTx:
+		return parsedTransaction(ParseMinedTransaction(webhook))
+	case WebhookTypeDroppedTx:
+		return parsedTransaction(ParseDroppedTransaction(webhook))
+	default:
+		return ParseWebhookLogs(webhook, registry)
+	}
+}
+
+func parsedTransaction(tx *TransactionDocument, err error) (*ParsedWebhook, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &ParsedWebhook{Transactions: []*TransactionDocument{tx}}, nil
+}
+
+// ParseWebhookLogs parses all webhook logs, dispatching each by topics[0]: transfer events become
+// TransferDocuments, approval events ApprovalDocuments, Uniswap swaps SwapDocuments, and events
+// with a decoder in registry become EventDocuments.
+// Logs matching neither are skipped, and matching logs that fail to decode are quarantined;
+// registry may be nil. Logs marked removed by a chain reorganization become Tombstones for the
+// documents they previously produced.
+func ParseWebhookLogs(webhook *WebhookEvent, registry *EventDecoderRegistry) (*ParsedWebhook, error) {
 	logs := webhook.Event.Data.Block.Logs
-	documents := make([]*TransferDocument, 0, len(logs))
+	parsed := &ParsedWebhook{Transfers: make([]*TransferDocument, 0, len(logs))}
+	removed := &ParsedWebhook{}
+
+	// Malformed removed logs are only reported: there is nothing left to decode them into.
+	quarantine := func(index int, kind string, err error) {
+		quarantined := !logs[index].Removed
+		parsed.Errors = append(parsed.Errors, LogError{Index: index, Kind: kind, Reason: err.Error(), Quarantined: quarantined})
+		if quarantined {
+			parsed.Quarantined = append(parsed.Quarantined, newQuarantineDocument(webhook, logs[index], kind, err))
+		}
+	}
 
 	for i := range logs {
-		doc, err := parseLogEntry(webhook, i)
+		target := parsed
+		if logs[i].Removed {
+			target = removed
+		}
+
+		docs, err := parseLogEntry(webhook, i)
+		if err == nil {
+			target.Transfers = append(target.Transfers, docs...)
+			continue
+		}
+		if !errors.Is(err, ErrNotTransfer) {
+			quarantine(i, KindTransfer, err)
+			continue
+		}
+
+		approval, err := parseApprovalEntry(webhook, i)
+		if err == nil {
+			target.Approvals = append(target.Approvals, approval)
+			continue
+		}
+		if !errors.Is(err, ErrNotApproval) {
+			quarantine(i, KindApproval, err)
+			continue
+		}
+
+		swap, err := parseSwapEntry(webhook, i)
+		if err == nil {
+			target.Swaps = append(target.Swaps, swap)
+			continue
+		}
+		if !errors.Is(err, ErrNotSwap) {
+			quarantine(i, KindSwap, err)
+			continue
+		}
+
+		event, err := parseRegisteredEvent(webhook, registry, i)
 		if err != nil {
-			continue // Skip non-Transfer events
+			quarantine(i, KindEvent, err)
+		} else if event != nil {
+			target.Events = append(target.Events, event)
 		}
-		documents = append(documents, doc)
 	}
 
-	return documents, nil
+	if nativeTransfersEnabled() {
+		parsed.Transfers = append(parsed.Transfers, parseNativeLogTransfers(webhook, false)...)
+		removed.Transfers = append(removed.Transfers, parseNativeLogTransfers(webhook, true)...)
+	}
+
+	parsed.Tombstones = newTombstones(removed)
+	return parsed, nil
+}
+
+// parseRegisteredEvent decodes a log with its registered decoder.
+// It returns a nil document when no decoder matches the log's topics[0].
+func parseRegisteredEvent(webhook *WebhookEvent, registry *EventDecoderRegistry, index int) (*EventDocument, error) {
+	log := webhook.Event.Data.Block.Logs[index]
+	if len(log.Topics) == 0 {
+		return nil, nil
+	}
+	decoder, ok := registry.LookupAt(common.HexToHash(log.Topics[0]), webhook.CreatedAt)
+	if !ok {
+		return nil, nil
+	}
+	event, err := decoder.Decode(log)
+	if err != nil {
+		return nil, err
+	}
+	return newEventDocument(webhook, log, event), nil
 }
 
-// parseLogEntry parses a single log entry into a TransferDocument.
-func parseLogEntry(webhook *WebhookEvent, index int) (*TransferDocument, error) {
+// parseLogEntry parses a single log entry into TransferDocuments.
+// Most logs yield one document; an ERC1155 TransferBatch yields one per id/value pair.
+func parseLogEntry(webhook *WebhookEvent, index int) ([]*TransferDocument, error) {
 	logs := webhook.Event.Data.Block.Logs
 	if index >= len(logs) {
 		return nil, fmt.Errorf("log index out of range")
 	}
 
 	log := logs[index]
-	if len(log.Topics) < 3 {
-		return nil, fmt.Errorf("invalid topics length")
+	if len(log.Topics) == 0 {
+		return nil, ErrNotTransfer
 	}
 
-	var decoded decodedTransferEvent
-	if err := parsedTransferABI.UnpackIntoInterface(&decoded, "Transfer", common.FromHex(log.Data)); err != nil {
+	transfers, err := decodeTransferLog(log)
+	if err != nil {
 		return nil, err
 	}
 
-	block := webhook.Event.Data.Block
+	documents := make([]*TransferDocument, 0, len(transfers))
+	for _, transfer := range transfers {
+		documents = append(documents, newTransferDocument(webhook, log, transfer))
+	}
+	return documents, nil
+}
+
+// decodeTransferLog decodes a transfer log of any supported standard, dispatching by topics[0].
+func decodeTransferLog(log WebhookLog) ([]Transfer, error) {
+	if len(log.Topics) == 0 {
+		return nil, ErrNotTransfer
+	}
+	switch common.HexToHash(log.Topics[0]) {
+	case transferEventTopic:
+		return decodeTransfer(log)
+	case transferSingleEventTopic:
+		return decodeTransferSingle(log)
+	case transferBatchEventTopic:
+		return decodeTransferBatch(log)
+	default:
+		return nil, ErrNotTransfer
+	}
+}
+
+// decodeTransfer decodes an ERC20 or ERC721 Transfer log, told apart by topic count.
+func decodeTransfer(log WebhookLog) ([]Transfer, error) {
+	transfer := Transfer{
+		Contract: log.Account.Address,
+		LogIndex: log.Index,
+	}
+	switch len(log.Topics) {
+	case 3:
+		var decoded decodedTransferEvent
+		if err := parsedTransferABI.UnpackIntoInterface(&decoded, "Transfer", common.FromHex(log.Data)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedTransfer, err)
+		}
+		transfer.Standard = StandardERC20
+		transfer.Value = decoded.Value
+	case 4:
+		// ERC721 indexes tokenId, leaving the data empty.
+		if len(common.FromHex(log.Data)) != 0 {
+			return nil, fmt.Errorf("%w: unexpected data on ERC721 transfer", ErrMalformedTransfer)
+		}
+		transfer.Standard = StandardERC721
+		transfer.TokenID = common.HexToHash(log.Topics[3]).Big()
+	default:
+		return nil, fmt.Errorf("%w: expected 3 or 4 topics, got %d", ErrMalformedTransfer, len(log.Topics))
+	}
+	transfer.From = common.HexToAddress(log.Topics[1]).Hex()
+	transfer.To = common.HexToAddress(log.Topics[2]).Hex()
+	return []Transfer{transfer}, nil
+}
+
+// decodeTransferSingle decodes an ERC1155 TransferSingle log.
+func decodeTransferSingle(log WebhookLog) ([]Transfer, error) {
+	if len(log.Topics) != 4 {
+		return nil, fmt.Errorf("%w: expected 4 topics on TransferSingle, got %d", ErrMalformedTransfer, len(log.Topics))
+	}
+	var decoded decodedTransferSingleEvent
+	if err := parsedERC1155ABI.UnpackIntoInterface(&decoded, "TransferSingle", common.FromHex(log.Data)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedTransfer, err)
+	}
+
+	transfer := newERC1155Transfer(log)
+	transfer.TokenID = decoded.ID
+	transfer.Value = decoded.Value
+	return []Transfer{transfer}, nil
+}
+
+// decodeTransferBatch decodes an ERC1155 TransferBatch log, expanding it into one transfer per id/value pair.
+func decodeTransferBatch(log WebhookLog) ([]Transfer, error) {
+	if len(log.Topics) != 4 {
+		return nil, fmt.Errorf("%w: expected 4 topics on TransferBatch, got %d", ErrMalformedTransfer, len(log.Topics))
+	}
+	var decoded decodedTransferBatchEvent
+	if err := parsedERC1155ABI.UnpackIntoInterface(&decoded, "TransferBatch", common.FromHex(log.Data)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedTransfer, err)
+	}
+	if len(decoded.IDs) != len(decoded.Values) {
+		return nil, fmt.Errorf("%w: %d ids but %d values on TransferBatch", ErrMalformedTransfer, len(decoded.IDs), len(decoded.Values))
+	}
+
+	transfers := make([]Transfer, 0, len(decoded.IDs))
+	for i := range decoded.IDs {
+		batchIndex := i
+		transfer := newERC1155Transfer(log)
+		transfer.TokenID = decoded.IDs[i]
+		transfer.Value = decoded.Values[i]
+		transfer.BatchIndex = &batchIndex
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
+
+func newERC1155Transfer(log WebhookLog) Transfer {
+	return Transfer{
+		Contract: log.Account.Address,
+		Standard: StandardERC1155,
+		Operator: common.HexToAddress(log.Topics[1]).Hex(),
+		From:     common.HexToAddress(log.Topics[2]).Hex(),
+		To:       common.HexToAddress(log.Topics[3]).Hex(),
+		LogIndex: log.Index,
+	}
+}
+
+// newTransferDocument combines a decoded transfer with the block, transaction and webhook metadata of its log.
+func newTransferDocument(webhook *WebhookEvent, log WebhookLog, transfer Transfer) *TransferDocument {
 	return &TransferDocument{
-		Block: Block{
-			Hash:      block.Hash,
-			Number:    block.Number,
-			Timestamp: block.Timestamp,
-		},
-		Transaction: Transaction{
-			Hash:     log.Transaction.Hash,
-			From:     log.Transaction.From.Address,
-			To:       log.Transaction.To.Address,
-			Value:    hexToDecimal(log.Transaction.Value),
-			GasPrice: log.Transaction.GasPrice,
-			Gas:      log.Transaction.Gas,
-			Status:   log.Transaction.Status,
-			GasUsed:  log.Transaction.GasUsed,
-		},
-		Transfer: Transfer{
-			Contract: log.Account.Address,
-			From:     common.HexToAddress(log.Topics[1]).Hex(),
-			To:       common.HexToAddress(log.Topics[2]).Hex(),
-			Value:    decoded.Value,
-			LogIndex: log.Index,
-		},
-		Network: webhook.Event.Network,
-		Alchemy: AlchemyMetadata{
-			WebhookID:      webhook.WebhookID,
-			EventID:        webhook.ID,
-			SequenceNumber: webhook.Event.SequenceNumber,
-			CreatedAt:      webhook.CreatedAt.Format(time.RFC3339),
-		},
-	}, nil
-}
-
-// hexToDecimal converts a hex string to its decimal representation.
+		SchemaVersion: SchemaVersion,
+		Block:         newBlock(webhook),
+		Transaction:   newTransaction(log),
+		Transfer:      transfer,
+		Network:       webhook.Event.Network,
+		Alchemy:       newAlchemyMetadata(webhook),
+	}
+}
+
+func newBlock(webhook *WebhookEvent) Block {
+	block := webhook.Event.Data.Block
+	return Block{
+		Hash:      block.Hash,
+		Number:    block.Number,
+		Timestamp: block.Timestamp,
+	}
+}
+
+func newTransaction(log WebhookLog) Transaction {
+	tx := Transaction{
+		Hash:                 log.Transaction.Hash,
+		From:                 log.Transaction.From.Address,
+		To:                   log.Transaction.To.Address,
+		Value:                hexToDecimal(log.Transaction.Value),
+		GasPrice:             log.Transaction.GasPrice,
+		MaxFeePerGas:         log.Transaction.MaxFeePerGas,
+		MaxPriorityFeePerGas: log.Transaction.MaxPriorityFeePerGas,
+		EffectiveGasPrice:    log.Transaction.EffectiveGasPrice,
+		Gas:                  log.Transaction.Gas,
+		GasUsed:              log.Transaction.GasUsed,
+		FeeWei:               feeWei(log.Transaction.GasUsed, log.Transaction.EffectiveGasPrice, log.Transaction.GasPrice),
+		ContractCreation:     log.Transaction.Hash != "" && log.Transaction.To.Address == "",
+		CreatedContract:      log.Transaction.CreatedContract.Address,
+	}
+	if status := log.Transaction.Status; status != nil {
+		tx.Status, tx.statusKnown = *status, true
+	}
+	if t := log.Transaction.Type; t != nil {
+		txType := int(*t)
+		tx.Type = &txType
+		tx.TypeName = transactionTypeName(txType)
+	}
+	return tx
+}
+
+// Transaction type names reported in TypeName.
+const (
+	TxTypeLegacy     = "legacy"
+	TxTypeAccessList = "access-list"
+	TxTypeEIP1559    = "eip-1559"
+	TxTypeBlob       = "blob"
+	TxTypeSetCode    = "set-code"
+)
+
+// transactionTypeName names an EIP-2718 transaction type, or returns "" for unknown types.
+func transactionTypeName(txType int) string {
+	switch txType {
+	case 0:
+		return TxTypeLegacy
+	case 1:
+		return TxTypeAccessList
+	case 2:
+		return TxTypeEIP1559
+	case 3:
+		return TxTypeBlob
+	case 4:
+		return TxTypeSetCode
+	default:
+		return ""
+	}
+}
+
+// feeWei returns the decimal fee of a transaction that used gasUsed gas, priced at effectiveGasPrice
+// or, when that is absent, gasPrice. It returns "" when gas usage or the price is unknown.
+func feeWei(gasUsed int64, effectiveGasPrice, gasPrice string) string {
+	price := effectiveGasPrice
+	if price == "" {
+		price = gasPrice
+	}
+	if gasUsed <= 0 || price == "" {
+		return ""
+	}
+	value, ok := parseQuantity(price)
+	if !ok {
+		return ""
+	}
+	return value.Mul(value, big.NewInt(gasUsed)).String()
+}
+
+// parseQuantity parses a 0x- or 0X-prefixed hex or decimal integer of any size.
+func parseQuantity(s string) (*big.Int, bool) {
+	if hex := trimHexPrefix(s); hex != s {
+		return new(big.Int).SetString(hex, 16)
+	}
+	return new(big.Int).SetString(s, 10)
+}
+
+func newAlchemyMetadata(webhook *WebhookEvent) AlchemyMetadata {
+	return AlchemyMetadata{
+		WebhookID:      webhook.WebhookID,
+		EventID:        webhook.ID,
+		SequenceNumber: webhook.Event.SequenceNumber,
+		CreatedAt:      webhook.CreatedAt.Format(time.RFC3339),
+		Anomalies:      webhook.anomalies,
+	}
+}
+
+// hexToDecimal converts a 0x- or 0X-prefixed hex string to its decimal representation.
 func hexToDecimal(hex string) string {
-	hex = strings.TrimPrefix(hex, "0x")
+	hex = trimHexPrefix(hex)
 	if hex == "" {
 		return "0"
 	}
@@ -231,6 +764,28 @@ func hexToDecimal(hex string) string {
 	return value.String()
 }
 
+// parseHexUint64 parses a 0x- or 0X-prefixed hex quantity, tolerating leading zeros.
+func parseHexUint64(hex string) (uint64, error) {
+	return strconv.ParseUint(trimHexPrefix(hex), 16, 64)
+}
+
+// parseHexBig parses a 0x- or 0X-prefixed hex integer of any size, tolerating leading zeros.
+func parseHexBig(hex string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(trimHexPrefix(hex), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid hex integer %q", hex)
+	}
+	return value, nil
+}
+
+// trimHexPrefix removes the 0x prefix of hex, in either case.
+func trimHexPrefix(hex string) string {
+	if len(hex) >= 2 && hex[0] == '0' && (hex[1] == 'x' || hex[1] == 'X') {
+		return hex[2:]
+	}
+	return hex
+}
+
 // GetDocumentID generates a document ID from transaction hash and log index.
 func GetDocumentID(txHash string, logIndex int) string {
 	return fmt.Sprintf("%s-%d", txHash, logIndex)
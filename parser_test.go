@@ -0,0 +1,279 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+func BenchmarkParseWebhookSingleTransfer(b *testing.B) {
+	webhook := singleTransferWebhook(b)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		parsed, err := ParseWebhook(webhook, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(parsed.Transfers) != 1 {
+			b.Fatalf("got %d transfers, want 1", len(parsed.Transfers))
+		}
+	}
+}
+
+// BenchmarkDecodeTransferLog measures decoding one ERC20 Transfer log with the ABI compiled at
+// init; compare BenchmarkCompileTransferABI for the cost it no longer pays per log.
+func BenchmarkDecodeTransferLog(b *testing.B) {
+	log := singleTransferWebhook(b).Event.Data.Block.Logs[0]
+
+	b.ReportAllocs()
+	for b.Loop() {
+		transfers, err := decodeTransferLog(log)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(transfers) != 1 {
+			b.Fatalf("got %d transfers, want 1", len(transfers))
+		}
+	}
+}
+
+func BenchmarkCompileTransferABI(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := abi.JSON(strings.NewReader(transferEventABI)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeTransferLog(t *testing.T) {
+	const tokenTopic = "0x000000000000000000000000000000000000000000000000000000000000002a"
+	const approvalTopic = "0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925"
+
+	tests := []struct {
+		name     string
+		edit     func(log *WebhookLog)
+		standard string
+		value    string
+		tokenID  string
+		err      error
+	}{
+		{
+			name:     "ERC20",
+			edit:     func(*WebhookLog) {},
+			standard: StandardERC20,
+			value:    "1000000",
+		},
+		{
+			name: "ERC721",
+			edit: func(log *WebhookLog) {
+				log.Topics = append(log.Topics, tokenTopic)
+				log.Data = "0x"
+			},
+			standard: StandardERC721,
+			tokenID:  "42",
+		},
+		{
+			name: "no topics",
+			edit: func(log *WebhookLog) { log.Topics = nil },
+			err:  ErrNotTransfer,
+		},
+		{
+			name: "other event",
+			edit: func(log *WebhookLog) { log.Topics[0] = approvalTopic },
+			err:  ErrNotTransfer,
+		},
+		{
+			name: "truncated data",
+			edit: func(log *WebhookLog) { log.Data = "0x01" },
+			err:  ErrMalformedTransfer,
+		},
+		{
+			name: "missing topic",
+			edit: func(log *WebhookLog) { log.Topics = log.Topics[:2] },
+			err:  ErrMalformedTransfer,
+		},
+		{
+			name: "ERC721 with data",
+			edit: func(log *WebhookLog) { log.Topics = append(log.Topics, tokenTopic) },
+			err:  ErrMalformedTransfer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := singleTransferWebhook(t).Event.Data.Block.Logs[0]
+			tt.edit(&log)
+			transfers, err := decodeTransferLog(log)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transfers) != 1 {
+				t.Fatalf("got %d transfers, want 1", len(transfers))
+			}
+			transfer := transfers[0]
+			if transfer.Standard != tt.standard {
+				t.Errorf("Standard = %q, want %q", transfer.Standard, tt.standard)
+			}
+			if got := fmt.Sprint(transfer.Value); tt.value != "" && got != tt.value {
+				t.Errorf("Value = %s, want %s", got, tt.value)
+			}
+			if got := fmt.Sprint(transfer.TokenID); tt.tokenID != "" && got != tt.tokenID {
+				t.Errorf("TokenID = %s, want %s", got, tt.tokenID)
+			}
+			if transfer.From != "0xA9D1e08C7793af67e9d92fe308d5697FB81d3E43" {
+				t.Errorf("From = %s", transfer.From)
+			}
+			if transfer.LogIndex != 7 {
+				t.Errorf("LogIndex = %d, want 7", transfer.LogIndex)
+			}
+		})
+	}
+}
+
+func TestParseWebhookLogs(t *testing.T) {
+	tests := []struct {
+		name        string
+		edit        func(log *WebhookLog)
+		transfers   int
+		tombstones  int
+		quarantined int
+		errors      int
+	}{
+		{"transfer", func(*WebhookLog) {}, 1, 0, 0, 0},
+		{"removed transfer", func(log *WebhookLog) { log.Removed = true }, 0, 1, 0, 0},
+		{"malformed transfer", func(log *WebhookLog) { log.Data = "0x01" }, 0, 0, 1, 1},
+		{"malformed removed transfer", func(log *WebhookLog) { log.Data, log.Removed = "0x01", true }, 0, 0, 0, 1},
+		{"unknown event", func(log *WebhookLog) { log.Topics = log.Topics[:1]; log.Topics[0] = "0x01" }, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook := singleTransferWebhook(t)
+			tt.edit(&webhook.Event.Data.Block.Logs[0])
+			parsed, err := ParseWebhookLogs(webhook, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(parsed.Transfers); got != tt.transfers {
+				t.Errorf("got %d transfers, want %d", got, tt.transfers)
+			}
+			if got := len(parsed.Tombstones); got != tt.tombstones {
+				t.Errorf("got %d tombstones, want %d", got, tt.tombstones)
+			}
+			if got := len(parsed.Quarantined); got != tt.quarantined {
+				t.Errorf("got %d quarantined logs, want %d", got, tt.quarantined)
+			}
+			if got := len(parsed.Errors); got != tt.errors {
+				t.Errorf("got %d errors, want %d", got, tt.errors)
+			}
+			if tt.tombstones == 1 && parsed.Tombstones[0].ID != GetDocumentID(webhook.Event.Data.Block.Logs[0].Transaction.Hash, 7) {
+				t.Errorf("tombstone ID = %s", parsed.Tombstones[0].ID)
+			}
+		})
+	}
+}
+
+func TestParseHexUint64(t *testing.T) {
+	tests := []struct {
+		hex     string
+		want    uint64
+		wantErr bool
+	}{
+		{"0x0", 0, false},
+		{"0x1312d00", 20000000, false},
+		{"0X1312D00", 20000000, false},
+		{"0x0001", 1, false},
+		{"1312d00", 20000000, false},
+		{"0x", 0, true},
+		{"0xzz", 0, true},
+		{"0x10000000000000000", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hex, func(t *testing.T) {
+			got, err := parseHexUint64(tt.hex)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHexBig(t *testing.T) {
+	tests := []struct {
+		hex     string
+		want    string
+		wantErr bool
+	}{
+		{"0x0", "0", false},
+		{"0X0F4240", "1000000", false},
+		{"0x10000000000000000", "18446744073709551616", false},
+		{"f4240", "1000000", false},
+		{"0x", "", true},
+		{"0xzz", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hex, func(t *testing.T) {
+			got, err := parseHexBig(tt.hex)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHexToDecimal(t *testing.T) {
+	tests := map[string]string{
+		"":     "0",
+		"0x":   "0",
+		"0x0":  "0",
+		"0xff": "255",
+		"0XFF": "255",
+		"0x00000000000000000000000000000000000000000000000000000000000f4240": "1000000",
+	}
+	for hex, want := range tests {
+		if got := hexToDecimal(hex); got != want {
+			t.Errorf("hexToDecimal(%q) = %q, want %q", hex, got, want)
+		}
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		ok   bool
+	}{
+		{"0x3b9aca00", "1000000000", true},
+		{"0X3B9ACA00", "1000000000", true},
+		{"1000000000", "1000000000", true},
+		{"0x", "", false},
+		{"0xzz", "", false},
+		{"1e9", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, ok := parseQuantity(tt.s)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
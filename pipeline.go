@@ -0,0 +1,122 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Stage names a step of the webhook pipeline, run in the order declared.
+type Stage string
+
+const (
+	// StageVerify authenticates the request and decodes its body into a WebhookEvent.
+	StageVerify Stage = "verify"
+	// StageDecode decodes the webhook's logs, activity or transaction into documents.
+	StageDecode Stage = "decode"
+	// StageFilter applies the reverted transaction policy, transfer filters, raw log retention
+	// and the document cap.
+	StageFilter Stage = "filter"
+	// StageEnrich runs the enrichers and marks transfers pending finality.
+	StageEnrich Stage = "enrich"
+	// StageRoute applies the routing rules.
+	StageRoute Stage = "route"
+	// StagePersist delivers the documents to Pub/Sub, Firestore and the registered sinks.
+	StagePersist Stage = "persist"
+)
+
+// ErrSkipWebhook is returned by a hook to acknowledge the webhook with a 200 without running
+// the rest of the pipeline.
+var ErrSkipWebhook = errors.New("webhook skipped by hook")
+
+// PipelineState is the state a hook sees. Request is set for HTTP requests; Body is set once the
+// verify stage has read it (it stays nil for streamed bodies), Webhook once the request is verified
+// and Parsed once the webhook is decoded. Hooks may modify Webhook and Parsed in place.
+type PipelineState struct {
+	Request *http.Request
+	Body    []byte
+	Webhook *WebhookEvent
+	Parsed  *ParsedWebhook
+}
+
+// Hook runs before or after a pipeline stage. An error fails the request with a 500 so Alchemy
+// retries, except ErrSkipWebhook.
+type Hook func(ctx context.Context, stage Stage, state *PipelineState) error
+
+var (
+	hooksMu     sync.RWMutex
+	beforeHooks = make(map[Stage][]Hook)
+	afterHooks  = make(map[Stage][]Hook)
+)
+
+// RegisterBeforeHook adds hook to the hooks run before stage, typically from an init function of
+// a program embedding the pipeline. Hooks of a stage run in registration order.
+func RegisterBeforeHook(stage Stage, hook Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	beforeHooks[stage] = append(beforeHooks[stage], hook)
+}
+
+// RegisterAfterHook adds hook to the hooks run after stage completes.
+func RegisterAfterHook(stage Stage, hook Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	afterHooks[stage] = append(afterHooks[stage], hook)
+}
+
+type pipelineStateKey struct{}
+
+// withPipelineState returns ctx carrying state from AlchemyWebhook to the webhook handlers.
+func withPipelineState(ctx context.Context, state *PipelineState) context.Context {
+	return context.WithValue(ctx, pipelineStateKey{}, state)
+}
+
+// pipelineStateFrom returns the state carried by ctx, or a new state for webhook.
+func pipelineStateFrom(ctx context.Context, webhook *WebhookEvent) *PipelineState {
+	if state, ok := ctx.Value(pipelineStateKey{}).(*PipelineState); ok {
+		return state
+	}
+	return &PipelineState{Webhook: webhook}
+}
+
+// beforeStage runs the hooks registered before stage.
+func beforeStage(ctx context.Context, stage Stage, state *PipelineState) error {
+	return runHooks(ctx, beforeHooks, stage, state)
+}
+
+// afterStage runs the hooks registered after stage.
+func afterStage(ctx context.Context, stage Stage, state *PipelineState) error {
+	return runHooks(ctx, afterHooks, stage, state)
+}
+
+func runHooks(ctx context.Context, hooks map[Stage][]Hook, stage Stage, state *PipelineState) error {
+	hooksMu.RLock()
+	registered := hooks[stage]
+	hooksMu.RUnlock()
+	for _, hook := range registered {
+		if err := hook(ctx, stage, state); err != nil {
+			return fmt.Errorf("%s hook: %w", stage, err)
+		}
+	}
+	return nil
+}
+
+// hookFailed writes the response for a hook error. A skip is acknowledged with a 200 and
+// reported as success.
+func hookFailed(w http.ResponseWriter, state *PipelineState, err error) error {
+	if errors.Is(err, ErrSkipWebhook) {
+		webhookID := ""
+		if state.Webhook != nil {
+			webhookID = state.Webhook.WebhookID
+		}
+		log.Printf(`{"level":"info","message":"webhook skipped by hook","webhook_id":"%s","error":"%s"}`, webhookID, err.Error())
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	logError("pipeline hook failed", err)
+	http.Error(w, "Pipeline hook failed", http.StatusInternalServerError)
+	return err
+}
@@ -0,0 +1,159 @@
+package function
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const revertedCollectionName = "alchemy_stream_reverted"
+
+const defaultRemovedLogTTL = 7 * 24 * time.Hour
+
+// FailedTxPolicy controls how transfers from reverted transactions (status 0) are persisted.
+type FailedTxPolicy string
+
+const (
+	// FailedTxKeep persists reverted transfers like successful ones.
+	FailedTxKeep FailedTxPolicy = "keep"
+	// FailedTxDrop discards reverted transfers.
+	FailedTxDrop FailedTxPolicy = "drop"
+	// FailedTxTag persists reverted transfers with reverted set to true.
+	FailedTxTag FailedTxPolicy = "tag"
+	// FailedTxRoute tags reverted transfers and writes them only to the reverted Firestore collection.
+	FailedTxRoute FailedTxPolicy = "route"
+)
+
+// getFailedTxPolicy returns the policy configured in FAILED_TX_POLICY, defaulting to keep.
+func getFailedTxPolicy() (FailedTxPolicy, error) {
+	switch policy := FailedTxPolicy(os.Getenv("FAILED_TX_POLICY")); policy {
+	case "":
+		return FailedTxKeep, nil
+	case FailedTxKeep, FailedTxDrop, FailedTxTag, FailedTxRoute:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid FAILED_TX_POLICY %q", policy)
+	}
+}
+
+// DroppedTxPolicy controls how DROPPED_TRANSACTION webhooks are persisted to Firestore.
+type DroppedTxPolicy string
+
+const (
+	// DroppedTxRecord writes dropped transactions, flagging any earlier document for the hash as dropped.
+	DroppedTxRecord DroppedTxPolicy = "record"
+	// DroppedTxDelete deletes any earlier document for the hash instead of recording the drop.
+	DroppedTxDelete DroppedTxPolicy = "delete"
+)
+
+// getDroppedTxPolicy returns the policy configured in DROPPED_TX_POLICY, defaulting to record.
+func getDroppedTxPolicy() (DroppedTxPolicy, error) {
+	switch policy := DroppedTxPolicy(os.Getenv("DROPPED_TX_POLICY")); policy {
+	case "":
+		return DroppedTxRecord, nil
+	case DroppedTxRecord, DroppedTxDelete:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid DROPPED_TX_POLICY %q", policy)
+	}
+}
+
+// splitDroppedTransactions separates the transactions to write from the dropped ones whose
+// documents should be deleted under policy.
+func splitDroppedTransactions(policy DroppedTxPolicy, transactions []*TransactionDocument) (write, remove []*TransactionDocument) {
+	if policy != DroppedTxDelete {
+		return transactions, nil
+	}
+	for _, transaction := range transactions {
+		if transaction.State == TransactionStateDropped {
+			remove = append(remove, transaction)
+		} else {
+			write = append(write, transaction)
+		}
+	}
+	return write, remove
+}
+
+// RemovedLogPolicy controls what happens to documents whose logs were removed by a chain reorganization.
+type RemovedLogPolicy string
+
+const (
+	// RemovedLogMark keeps the documents with removed set to true.
+	RemovedLogMark RemovedLogPolicy = "mark"
+	// RemovedLogDelete deletes the documents.
+	RemovedLogDelete RemovedLogPolicy = "delete"
+	// RemovedLogSoftDelete marks the documents removed with an ExpireAt for a Firestore TTL
+	// policy, and records their tombstones in the tombstones collection until the same time.
+	RemovedLogSoftDelete RemovedLogPolicy = "soft-delete"
+)
+
+// getRemovedLogPolicy returns the policy configured in REMOVED_LOG_POLICY, defaulting to mark.
+func getRemovedLogPolicy() (RemovedLogPolicy, error) {
+	switch policy := RemovedLogPolicy(os.Getenv("REMOVED_LOG_POLICY")); policy {
+	case "":
+		return RemovedLogMark, nil
+	case RemovedLogMark, RemovedLogDelete, RemovedLogSoftDelete:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid REMOVED_LOG_POLICY %q", policy)
+	}
+}
+
+// getRemovedLogTTL returns how long soft-deleted documents and their tombstones are kept from
+// REMOVED_LOG_TTL, defaulting to 7 days.
+func getRemovedLogTTL() (time.Duration, error) {
+	value := os.Getenv("REMOVED_LOG_TTL")
+	if value == "" {
+		return defaultRemovedLogTTL, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid REMOVED_LOG_TTL %q: %w", value, err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("invalid REMOVED_LOG_TTL %q: must be positive", value)
+	}
+	return ttl, nil
+}
+
+// applyFailedTxPolicy splits parsed transfers into those for the regular sinks and those routed
+// to the reverted collection.
+func applyFailedTxPolicy(policy FailedTxPolicy, parsed *ParsedWebhook) {
+	if policy == FailedTxKeep {
+		return
+	}
+
+	kept := make([]*TransferDocument, 0, len(parsed.Transfers))
+	var routed []*TransferDocument
+	for _, transfer := range parsed.Transfers {
+		if !transfer.Transaction.failed() {
+			kept = append(kept, transfer)
+			continue
+		}
+		switch policy {
+		case FailedTxTag:
+			transfer.Reverted = true
+			kept = append(kept, transfer)
+		case FailedTxRoute:
+			transfer.Reverted = true
+			routed = append(routed, transfer)
+		}
+	}
+	parsed.Transfers = kept
+	parsed.Reverted = append(parsed.Reverted, routed...)
+
+	// Point tombstones of reverted transfers at where the policy put their documents.
+	tombstones := parsed.Tombstones[:0]
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.reverted {
+			switch policy {
+			case FailedTxDrop:
+				continue
+			case FailedTxRoute:
+				tombstone.Collection = revertedCollectionName
+			}
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	parsed.Tombstones = tombstones
+}
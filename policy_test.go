@@ -0,0 +1,133 @@
+package function
+
+import (
+	"slices"
+	"testing"
+)
+
+// policyTransfer returns a transfer document in transaction hash, with status nil when the
+// payload reported none.
+func policyTransfer(hash string, status *int) *TransferDocument {
+	doc := &TransferDocument{}
+	doc.Transaction.Hash = hash
+	if status != nil {
+		doc.Transaction.Status, doc.Transaction.statusKnown = *status, true
+	}
+	return doc
+}
+
+func TestApplyFailedTxPolicy(t *testing.T) {
+	succeeded, reverted := 1, 0
+	tests := []struct {
+		policy     FailedTxPolicy
+		transfers  []string
+		reverted   []string
+		tagged     []string
+		tombstones []string
+		collection string
+	}{
+		{FailedTxKeep, []string{"ok", "failed", "unknown"}, nil, nil, []string{"ok", "failed"}, collectionName},
+		{FailedTxDrop, []string{"ok", "unknown"}, nil, nil, []string{"ok"}, ""},
+		{FailedTxTag, []string{"ok", "failed", "unknown"}, nil, []string{"failed"}, []string{"ok", "failed"}, collectionName},
+		{FailedTxRoute, []string{"ok", "unknown"}, []string{"failed"}, []string{"failed"}, []string{"ok", "failed"}, revertedCollectionName},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			parsed := &ParsedWebhook{
+				Transfers: []*TransferDocument{
+					policyTransfer("ok", &succeeded),
+					policyTransfer("failed", &reverted),
+					policyTransfer("unknown", nil),
+				},
+				Tombstones: []*Tombstone{
+					{Collection: collectionName, ID: "ok"},
+					{Collection: collectionName, ID: "failed", reverted: true},
+				},
+			}
+			applyFailedTxPolicy(tt.policy, parsed)
+
+			assertHashes(t, "transfers", parsed.Transfers, tt.transfers)
+			assertHashes(t, "reverted", parsed.Reverted, tt.reverted)
+			var tagged []string
+			for _, transfer := range append(parsed.Transfers, parsed.Reverted...) {
+				if transfer.Reverted {
+					tagged = append(tagged, transfer.Transaction.Hash)
+				}
+			}
+			assertStrings(t, "tagged", tagged, tt.tagged)
+
+			var tombstones []string
+			for _, tombstone := range parsed.Tombstones {
+				tombstones = append(tombstones, tombstone.ID)
+				if tombstone.ID == "failed" && tombstone.Collection != tt.collection {
+					t.Errorf("reverted tombstone collection = %s, want %s", tombstone.Collection, tt.collection)
+				}
+			}
+			assertStrings(t, "tombstones", tombstones, tt.tombstones)
+		})
+	}
+}
+
+func TestGetFailedTxPolicy(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    FailedTxPolicy
+		wantErr bool
+	}{
+		{"", FailedTxKeep, false},
+		{"drop", FailedTxDrop, false},
+		{"route", FailedTxRoute, false},
+		{"ignore", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("FAILED_TX_POLICY", tt.env)
+			got, err := getFailedTxPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitDroppedTransactions(t *testing.T) {
+	transactions := []*TransactionDocument{
+		{State: TransactionStateMined},
+		{State: TransactionStateDropped},
+	}
+	tests := []struct {
+		policy DroppedTxPolicy
+		write  int
+		remove int
+	}{
+		{DroppedTxRecord, 2, 0},
+		{DroppedTxDelete, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			write, remove := splitDroppedTransactions(tt.policy, transactions)
+			if len(write) != tt.write || len(remove) != tt.remove {
+				t.Errorf("got %d to write and %d to remove, want %d and %d", len(write), len(remove), tt.write, tt.remove)
+			}
+		})
+	}
+}
+
+func assertHashes(t *testing.T, name string, transfers []*TransferDocument, want []string) {
+	t.Helper()
+	var got []string
+	for _, transfer := range transfers {
+		got = append(got, transfer.Transaction.Hash)
+	}
+	assertStrings(t, name, got, want)
+}
+
+func assertStrings(t *testing.T, name string, got, want []string) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
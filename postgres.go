@@ -0,0 +1,297 @@
+package function
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// defaultPostgresMaxConns keeps the connections of a scaled-out deployment, instances times
+	// this, within the limits of small Cloud SQL tiers.
+	defaultPostgresMaxConns = 4
+	defaultPostgresIdleTime = 5 * time.Minute
+
+	// postgresMigrationLock is the advisory lock key held while migrating, so instances starting
+	// together apply each migration once.
+	postgresMigrationLock = 0x616c6368656d79
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// postgresUpsert inserts a transfer or updates the row of the same log.
+const postgresUpsert = `INSERT INTO transfers (
+	tx_hash, log_index, entry_index, document_id, schema_version, network, block_number, block_hash,
+	block_timestamp, contract, standard, operator, from_address, to_address, value, token_id,
+	reverted, finality, webhook_id, event_id, sequence_number, document
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+ON CONFLICT (tx_hash, log_index, entry_index) DO UPDATE SET
+	document_id = EXCLUDED.document_id,
+	schema_version = EXCLUDED.schema_version,
+	network = EXCLUDED.network,
+	block_number = EXCLUDED.block_number,
+	block_hash = EXCLUDED.block_hash,
+	block_timestamp = EXCLUDED.block_timestamp,
+	contract = EXCLUDED.contract,
+	standard = EXCLUDED.standard,
+	operator = EXCLUDED.operator,
+	from_address = EXCLUDED.from_address,
+	to_address = EXCLUDED.to_address,
+	value = EXCLUDED.value,
+	token_id = EXCLUDED.token_id,
+	reverted = EXCLUDED.reverted,
+	finality = EXCLUDED.finality,
+	removed = false,
+	removed_reason = NULL,
+	webhook_id = EXCLUDED.webhook_id,
+	event_id = EXCLUDED.event_id,
+	sequence_number = EXCLUDED.sequence_number,
+	document = EXCLUDED.document,
+	updated_at = now()`
+
+// postgresRemove marks the transfer of a tombstone removed.
+const postgresRemove = `UPDATE transfers SET removed = true, removed_reason = $2, updated_at = now()
+WHERE document_id = $1`
+
+// postgresSink upserts transfers, reverted ones included, into the transfers table of the
+// database at POSTGRES_URL, keyed by (tx_hash, log_index) and the entry within the log. Transfers
+// removed by a reorg are marked removed. Init applies the embedded migrations.
+type postgresSink struct {
+	pool *pgxpool.Pool
+}
+
+func (*postgresSink) Name() string { return sinkPostgres }
+
+// Init opens the connection pool and migrates the schema, unless POSTGRES_MIGRATE is false, in
+// which case pending migrations are only logged.
+func (s *postgresSink) Init(ctx context.Context) error {
+	pool, err := openPostgres(ctx)
+	if err != nil {
+		return err
+	}
+	if os.Getenv("POSTGRES_MIGRATE") == "false" {
+		pending, err := pendingPostgresMigrations(ctx, pool)
+		if err != nil {
+			pool.Close()
+			return err
+		}
+		warnPendingMigrations(sinkPostgres, pending)
+	} else if _, err := migratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return err
+	}
+	s.pool = pool
+	return nil
+}
+
+// openPostgres opens a connection pool to the database at POSTGRES_URL.
+func openPostgres(ctx context.Context) (*pgxpool.Pool, error) {
+	config, err := postgresPoolConfig()
+	if err != nil {
+		return nil, err
+	}
+	return pgxpool.NewWithConfig(context.WithoutCancel(ctx), config)
+}
+
+// postgresPoolConfig parses POSTGRES_URL, a connection URL or DSN. With POSTGRES_INSTANCE, the
+// connection goes through the Unix socket Cloud Run mounts for that Cloud SQL instance at
+// /cloudsql/<instance>. POSTGRES_MAX_CONNS caps the connections of each instance.
+func postgresPoolConfig() (*pgxpool.Config, error) {
+	url := os.Getenv("POSTGRES_URL")
+	if url == "" {
+		return nil, errors.New("POSTGRES_URL must be set")
+	}
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid POSTGRES_URL: %w", err)
+	}
+	if instance := os.Getenv("POSTGRES_INSTANCE"); instance != "" {
+		config.ConnConfig.Host = path.Join("/cloudsql", instance)
+		config.ConnConfig.Port = 5432
+		config.ConnConfig.TLSConfig = nil
+		config.ConnConfig.Fallbacks = nil
+	}
+	config.MaxConns = int32(max(envInt("POSTGRES_MAX_CONNS", defaultPostgresMaxConns), 1))
+	config.MinConns = 0
+	config.MaxConnIdleTime = defaultPostgresIdleTime
+	return config, nil
+}
+
+// migratePostgres applies the embedded migrations not yet recorded in schema_migrations, in
+// version order, each in its own transaction, and returns the versions it applied.
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) ([]string, error) {
+	migrations, err := embeddedMigrations(postgresMigrations, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", postgresMigrationLock); err != nil {
+		return nil, err
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", postgresMigrationLock)
+
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version TEXT PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`); err != nil {
+		return nil, err
+	}
+	applied, err := appliedPostgresMigrations(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.script); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version)
+			return err
+		})
+		if err != nil {
+			return versions, fmt.Errorf("postgres migration %s: %w", m.version, err)
+		}
+		versions = append(versions, m.version)
+	}
+	return versions, nil
+}
+
+// pendingPostgresMigrations returns the versions of the embedded migrations not recorded in
+// schema_migrations, all of them when the table does not exist yet.
+func pendingPostgresMigrations(ctx context.Context, pool *pgxpool.Pool) ([]string, error) {
+	migrations, err := embeddedMigrations(postgresMigrations, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	var tracked bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&tracked); err != nil {
+		return nil, err
+	}
+	applied := map[string]bool{}
+	if tracked {
+		if applied, err = appliedPostgresMigrations(ctx, conn.Conn()); err != nil {
+			return nil, err
+		}
+	}
+	return pendingVersions(migrations, applied), nil
+}
+
+// appliedPostgresMigrations returns the versions recorded in schema_migrations.
+func appliedPostgresMigrations(ctx context.Context, conn *pgx.Conn) (map[string]bool, error) {
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// Write applies the webhook's tombstones and upserts its transfers in a single transaction.
+// Tombstones go first, so a transfer re-included after a reorg ends up not removed.
+func (s *postgresSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	batch := &pgx.Batch{}
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.Kind != KindTransfer {
+			continue
+		}
+		reason := tombstone.Reason
+		if reason == "" {
+			reason = TombstoneReorg
+		}
+		batch.Queue(postgresRemove, tombstone.DocumentID(), reason)
+	}
+	for _, doc := range append(append([]*TransferDocument{}, parsed.Transfers...), parsed.Reverted...) {
+		args, err := postgresTransferArgs(doc)
+		if err != nil {
+			return fmt.Errorf("encode transfer %s: %w", doc.DocumentID(), err)
+		}
+		batch.Queue(postgresUpsert, args...)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// postgresTransferArgs returns the arguments of postgresUpsert for doc.
+func postgresTransferArgs(doc *TransferDocument) ([]any, error) {
+	document, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	logIndex, entryIndex := doc.Transfer.LogIndex, -1
+	switch {
+	case doc.isNative():
+		logIndex = -1
+		if doc.Transfer.TraceIndex != nil {
+			entryIndex = *doc.Transfer.TraceIndex
+		}
+	case doc.Transfer.BatchIndex != nil:
+		entryIndex = *doc.Transfer.BatchIndex
+	}
+	return []any{
+		doc.Transaction.Hash, logIndex, entryIndex, doc.DocumentID(), doc.SchemaVersion, doc.Network,
+		doc.Block.Number, doc.Block.Hash, doc.blockTime(), doc.Transfer.Contract, doc.Transfer.Standard,
+		nullString(doc.Transfer.Operator), doc.Transfer.From, doc.Transfer.To,
+		numeric(doc.Transfer.Value), numeric(doc.Transfer.TokenID), doc.Reverted, nullString(doc.Finality),
+		doc.Alchemy.WebhookID, doc.Alchemy.EventID, nullString(doc.Alchemy.SequenceNumber), document,
+	}, nil
+}
+
+// numeric returns v as a NUMERIC parameter, NULL when v is nil.
+func numeric(v *big.Int) pgtype.Numeric {
+	if v == nil {
+		return pgtype.Numeric{}
+	}
+	return pgtype.Numeric{Int: v, Valid: true}
+}
+
+// nullString returns s as a TEXT parameter, NULL when s is empty.
+func nullString(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
+
+// Close closes the connection pool.
+func (s *postgresSink) Close() error {
+	if s.pool != nil {
+		s.pool.Close()
+		s.pool = nil
+	}
+	return nil
+}
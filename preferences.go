@@ -0,0 +1,499 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	defaultNotifyDigestCollection = "alchemy_notify_digest"
+	defaultNotifyDigestBatchSize  = 500
+	defaultNotifyPreferencesTTL   = time.Minute
+)
+
+// Notification delivery modes of NotificationPreferences.
+const (
+	// NotifyInstant alerts on a transfer as soon as it arrives.
+	NotifyInstant = "instant"
+	// NotifyDigest holds a transfer for the next digest sent by SendNotificationDigests.
+	NotifyDigest = "digest"
+)
+
+func init() {
+	functions.HTTP("SendNotificationDigests", SendNotificationDigestsHTTP)
+}
+
+// NotificationPreferences are the alert settings of a watched address, stored in the
+// NOTIFY_PREFERENCES_COLLECTION document named by the lowercase address. Channels lists the
+// notification sinks that may alert on its transfers, all of them when empty. Thresholds maps a
+// lowercase token contract, native or * to the smallest amount, in whole tokens when the decimals
+// are known, worth an alert. Delivery is NotifyInstant, the default, or NotifyDigest. Alerts
+// that fall within QuietHours are held for the first digest after they end.
+type NotificationPreferences struct {
+	Channels   []string          `json:"channels,omitempty" firestore:"channels"`
+	Thresholds map[string]string `json:"thresholds,omitempty" firestore:"thresholds"`
+	Delivery   string            `json:"delivery,omitempty" firestore:"delivery"`
+	QuietHours *QuietHours       `json:"quietHours,omitempty" firestore:"quietHours"`
+}
+
+// QuietHours is a daily period, from Start to End as HH:MM in the IANA TimeZone (UTC when
+// empty), that may span midnight.
+type QuietHours struct {
+	Start    string `json:"start" firestore:"start"`
+	End      string `json:"end" firestore:"end"`
+	TimeZone string `json:"timeZone,omitempty" firestore:"timeZone"`
+}
+
+// addressPreferences is a parsed NotificationPreferences.
+type addressPreferences struct {
+	channels   []string
+	thresholds map[string]*big.Rat
+	digest     bool
+	// quietStart and quietEnd are minutes into the day in quietZone; equal when there are no
+	// quiet hours.
+	quietStart, quietEnd int
+	quietZone            *time.Location
+}
+
+func parseNotificationPreferences(p NotificationPreferences) (*addressPreferences, error) {
+	parsed := &addressPreferences{channels: p.Channels, thresholds: make(map[string]*big.Rat, len(p.Thresholds))}
+	switch p.Delivery {
+	case "", NotifyInstant:
+	case NotifyDigest:
+		parsed.digest = true
+	default:
+		return nil, fmt.Errorf("invalid delivery %q (want %s or %s)", p.Delivery, NotifyInstant, NotifyDigest)
+	}
+	for token, value := range p.Thresholds {
+		threshold, ok := new(big.Rat).SetString(value)
+		if !ok || threshold.Sign() < 0 {
+			return nil, fmt.Errorf("invalid threshold %q for %s", value, token)
+		}
+		parsed.thresholds[strings.ToLower(token)] = threshold
+	}
+	if p.QuietHours != nil {
+		var err error
+		if parsed.quietStart, err = minuteOfDay(p.QuietHours.Start); err != nil {
+			return nil, err
+		}
+		if parsed.quietEnd, err = minuteOfDay(p.QuietHours.End); err != nil {
+			return nil, err
+		}
+		if parsed.quietZone, err = time.LoadLocation(p.QuietHours.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid quiet hours time zone: %w", err)
+		}
+	}
+	return parsed, nil
+}
+
+// minuteOfDay parses HH:MM into minutes since midnight.
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quiet hours time %q (want HH:MM)", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// notificationValue returns the value of doc in whole tokens when its decimals are known, in base
+// units otherwise, or nil for NFTs without one.
+func notificationValue(doc *TransferDocument) *big.Rat {
+	if doc.Transfer.Value == nil {
+		return nil
+	}
+	value := new(big.Rat).SetInt(doc.Transfer.Value)
+	if decimals := transferDecimals(doc); decimals > 0 {
+		value.Quo(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	}
+	return value
+}
+
+// deliver returns whether the address wants an alert about doc on sink, and, when it does,
+// whether it is held for a digest and not before when.
+func (p *addressPreferences) deliver(sink string, doc *TransferDocument, now time.Time) (alert, digest bool, notBefore time.Time) {
+	if len(p.channels) > 0 && !slices.Contains(p.channels, sink) {
+		return false, false, time.Time{}
+	}
+	token := "native"
+	if !doc.isNative() {
+		token = strings.ToLower(doc.Transfer.Contract)
+	}
+	threshold, ok := p.thresholds[token]
+	if !ok {
+		threshold, ok = p.thresholds["*"]
+	}
+	if value := notificationValue(doc); ok && value != nil && value.Cmp(threshold) < 0 {
+		return false, false, time.Time{}
+	}
+	if end, quiet := p.quietUntil(now); quiet {
+		return true, true, end
+	}
+	return true, p.digest, time.Time{}
+}
+
+// quietUntil reports whether now falls within the quiet hours, and when they end.
+func (p *addressPreferences) quietUntil(now time.Time) (time.Time, bool) {
+	if p.quietStart == p.quietEnd {
+		return time.Time{}, false
+	}
+	local := now.In(p.quietZone)
+	minute := local.Hour()*60 + local.Minute()
+	var quiet bool
+	if p.quietStart < p.quietEnd {
+		quiet = minute >= p.quietStart && minute < p.quietEnd
+	} else {
+		quiet = minute >= p.quietStart || minute < p.quietEnd
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, p.quietZone)
+	end := midnight.Add(time.Duration(p.quietEnd) * time.Minute)
+	if !end.After(local) {
+		end = midnight.AddDate(0, 0, 1).Add(time.Duration(p.quietEnd) * time.Minute)
+	}
+	return end, true
+}
+
+// preferenceStore reads the NotificationPreferences of addresses from Firestore, caching them,
+// absent ones included, for NOTIFY_PREFERENCES_TTL.
+type preferenceStore struct {
+	collection string
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedPreferences
+}
+
+type cachedPreferences struct {
+	preferences *addressPreferences
+	loadedAt    time.Time
+}
+
+var (
+	preferenceStoreOnce sync.Once
+	preferences         *preferenceStore
+)
+
+// getPreferenceStore returns the store of NOTIFY_PREFERENCES_COLLECTION, once per instance, or
+// nil when it is unset.
+func getPreferenceStore() *preferenceStore {
+	preferenceStoreOnce.Do(func() {
+		if collection := os.Getenv("NOTIFY_PREFERENCES_COLLECTION"); collection != "" {
+			preferences = &preferenceStore{
+				collection: collection,
+				ttl:        envDuration("NOTIFY_PREFERENCES_TTL", defaultNotifyPreferencesTTL),
+				entries:    map[string]cachedPreferences{},
+			}
+		}
+	})
+	return preferences
+}
+
+// load returns the preferences of addresses that have them, reading those not cached.
+// Malformed preferences are logged and ignored.
+func (s *preferenceStore) load(ctx context.Context, addresses []string) (map[string]*addressPreferences, error) {
+	now := time.Now()
+	found := make(map[string]*addressPreferences, len(addresses))
+	var missing []string
+	s.mu.Lock()
+	for _, address := range addresses {
+		if entry, ok := s.entries[address]; ok && now.Sub(entry.loadedAt) < s.ttl {
+			if entry.preferences != nil {
+				found[address] = entry.preferences
+			}
+		} else if !slices.Contains(missing, address) {
+			missing = append(missing, address)
+		}
+	}
+	s.mu.Unlock()
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Collection(s.collection)
+	refs := make([]*firestore.DocumentRef, len(missing))
+	for i, address := range missing {
+		refs[i] = collection.Doc(address)
+	}
+	snapshots, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification preferences: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, snapshot := range snapshots {
+		var parsed *addressPreferences
+		if snapshot.Exists() {
+			var p NotificationPreferences
+			err := snapshot.DataTo(&p)
+			if err == nil {
+				parsed, err = parseNotificationPreferences(p)
+			}
+			if err != nil {
+				logError("ignoring malformed notification preferences of "+missing[i], err)
+				parsed = nil
+			}
+		}
+		s.entries[missing[i]] = cachedPreferences{preferences: parsed, loadedAt: now}
+		if parsed != nil {
+			found[missing[i]] = parsed
+		}
+	}
+	return found, nil
+}
+
+// applyNotificationPreferences returns the transfers sink alerts on now under the preferences
+// of their senders and recipients, and holds those they want in a digest. A transfer whose
+// parties have no preferences is alerted on; otherwise it is alerted on when any of them wants an
+// instant alert, held when any wants it later, and dropped when none wants it on sink.
+func applyNotificationPreferences(ctx context.Context, sink string, transfers []*TransferDocument) ([]*TransferDocument, error) {
+	store := getPreferenceStore()
+	if store == nil || len(transfers) == 0 {
+		return transfers, nil
+	}
+	addresses := make([]string, 0, 2*len(transfers))
+	for _, doc := range transfers {
+		addresses = append(addresses, strings.ToLower(doc.Transfer.From), strings.ToLower(doc.Transfer.To))
+	}
+	loaded, err := store.load(ctx, addresses)
+	if err != nil {
+		return nil, err
+	}
+	if len(loaded) == 0 {
+		return transfers, nil
+	}
+
+	now := time.Now().UTC()
+	var instant []*TransferDocument
+	var held []Document
+	for _, doc := range transfers {
+		alert, digest, notBefore := deliverTransfer(loaded, sink, doc, now)
+		switch {
+		case alert && !digest:
+			instant = append(instant, doc)
+		case alert:
+			entry, err := newDigestEntry(sink, doc, notBefore, now)
+			if err != nil {
+				return nil, err
+			}
+			held = append(held, entry)
+		}
+	}
+	if len(held) > 0 {
+		writer, err := newWriter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := writer.WriteDocuments(ctx, digestCollection(), held); err != nil {
+			return nil, fmt.Errorf("failed to hold transfers for the notification digest: %w", err)
+		}
+		log.Printf(`{"level":"info","message":"held transfers for the notification digest","sink":"%s","count":%d}`, sink, len(held))
+	}
+	return instant, nil
+}
+
+// deliverTransfer combines the preferences of the sender and recipient of doc found in loaded.
+func deliverTransfer(loaded map[string]*addressPreferences, sink string, doc *TransferDocument, now time.Time) (alert, digest bool, notBefore time.Time) {
+	var parties []*addressPreferences
+	for _, address := range []string{strings.ToLower(doc.Transfer.From), strings.ToLower(doc.Transfer.To)} {
+		if p, ok := loaded[address]; ok {
+			parties = append(parties, p)
+		}
+	}
+	if len(parties) == 0 {
+		return true, false, time.Time{}
+	}
+	for _, p := range parties {
+		partyAlert, partyDigest, partyNotBefore := p.deliver(sink, doc, now)
+		switch {
+		case !partyAlert:
+		case !partyDigest:
+			return true, false, time.Time{}
+		case !alert || partyNotBefore.Before(notBefore):
+			alert, digest, notBefore = true, true, partyNotBefore
+		}
+	}
+	return alert, digest, notBefore
+}
+
+func digestCollection() string {
+	if collection := os.Getenv("NOTIFY_DIGEST_COLLECTION"); collection != "" {
+		return collection
+	}
+	return defaultNotifyDigestCollection
+}
+
+// DigestEntry is a transfer held for the next notification digest of a sink: the transfer as
+// JSON, when it was held and, for transfers held over quiet hours, the time it may be sent.
+type DigestEntry struct {
+	Sink      string     `json:"sink"`
+	Transfer  string     `json:"transfer"`
+	HeldAt    time.Time  `json:"heldAt"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+
+	id string
+}
+
+// DocumentID returns the sink and transfer document ID, so a redelivered webhook holds the
+// transfer once.
+func (e *DigestEntry) DocumentID() string {
+	return e.id
+}
+
+func newDigestEntry(sink string, doc *TransferDocument, notBefore, now time.Time) (*DigestEntry, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal held transfer: %w", err)
+	}
+	entry := &DigestEntry{Sink: sink, Transfer: string(data), HeldAt: now, id: sink + "-" + doc.DocumentID()}
+	if !notBefore.IsZero() {
+		entry.NotBefore = &notBefore
+	}
+	return entry, nil
+}
+
+// notifier is implemented by the notification sinks that can send digests.
+type notifier interface {
+	notify(ctx context.Context, batch NotificationBatch) error
+}
+
+// DigestResult reports the outcome of SendNotificationDigests.
+type DigestResult struct {
+	Sent      int `json:"sent"`
+	Transfers int `json:"transfers"`
+	Failed    int `json:"failed"`
+}
+
+// SendNotificationDigests sends each enabled notification sink one digest of up to
+// NOTIFY_DIGEST_BATCH_SIZE (default 500) of the transfers held for it that are due, oldest
+// first, and deletes them. A sink that fails keeps its transfers for the next run; the others
+// still send theirs. Runs must not overlap, or a digest may be sent twice.
+func SendNotificationDigests(ctx context.Context) (DigestResult, error) {
+	var result DigestResult
+	enabled, err := enabledSinks()
+	if err != nil {
+		return result, err
+	}
+	var failures []error
+	for _, entry := range enabled {
+		if _, ok := entry.sink.(notifier); !ok {
+			continue
+		}
+		sent, err := sendDigest(ctx, entry)
+		if err != nil {
+			result.Failed++
+			failures = append(failures, fmt.Errorf("sink %s: %w", entry.sink.Name(), err))
+			continue
+		}
+		if sent > 0 {
+			result.Sent++
+			result.Transfers += sent
+		}
+	}
+	log.Printf(`{"level":"info","message":"sent notification digests","sent":%d,"transfers":%d,"failed":%d}`,
+		result.Sent, result.Transfers, result.Failed)
+	if len(failures) > 0 {
+		return result, sinkErrors(failures)
+	}
+	return result, nil
+}
+
+// sendDigest sends the digest of the sink of entry and returns how many transfers it held.
+func sendDigest(ctx context.Context, entry *sinkEntry) (int, error) {
+	if err := entry.init(ctx); err != nil {
+		return 0, err
+	}
+	explorers, err := loadExplorers()
+	if err != nil {
+		return 0, err
+	}
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	sink := entry.sink.Name()
+	now := time.Now().UTC()
+	var due []heldTransfer
+	iter := client.Collection(digestCollection()).Where("Sink", "==", sink).Documents(ctx)
+	defer iter.Stop()
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		held := heldTransfer{entry: &DigestEntry{id: snapshot.Ref.ID}, doc: &TransferDocument{}}
+		if err := snapshot.DataTo(held.entry); err != nil {
+			return 0, err
+		}
+		if held.entry.NotBefore != nil && held.entry.NotBefore.After(now) {
+			continue
+		}
+		if err := json.Unmarshal([]byte(held.entry.Transfer), held.doc); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal held transfer %s: %w", snapshot.Ref.ID, err)
+		}
+		due = append(due, held)
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+	slices.SortStableFunc(due, func(a, b heldTransfer) int { return a.entry.HeldAt.Compare(b.entry.HeldAt) })
+	due = due[:min(len(due), max(envInt("NOTIFY_DIGEST_BATCH_SIZE", defaultNotifyDigestBatchSize), 1))]
+	entries, transfers := make([]*DigestEntry, len(due)), make([]*TransferDocument, len(due))
+	for i, held := range due {
+		entries[i], transfers[i] = held.entry, held.doc
+	}
+
+	batch := newNotificationBatch(transfers, max(envInt("NOTIFY_MAX_TRANSFERS", defaultNotifyMaxTransfers), 1), explorers, nil)
+	batch.Digest = true
+	if err := entry.sink.(notifier).notify(ctx, batch); err != nil {
+		return 0, err
+	}
+	if err := deleteBatchDocuments(ctx, client, digestCollection(), entries); err != nil {
+		return 0, fmt.Errorf("failed to delete sent digest entries: %w", err)
+	}
+	return len(entries), nil
+}
+
+// heldTransfer is a due DigestEntry with its transfer.
+type heldTransfer struct {
+	entry *DigestEntry
+	doc   *TransferDocument
+}
+
+// SendNotificationDigestsHTTP is the Cloud Run Function entrypoint that runs
+// SendNotificationDigests, meant to be invoked by Cloud Scheduler. It responds with the
+// DigestResult as JSON, or 500 when a sink failed.
+func SendNotificationDigestsHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := SendNotificationDigests(r.Context())
+	if err != nil {
+		logError("failed to send notification digests", err)
+		http.Error(w, "Failed to send notification digests", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logError("failed to write digest result", err)
+	}
+}
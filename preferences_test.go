@@ -0,0 +1,122 @@
+package function
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestParseNotificationPreferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       NotificationPreferences
+		wantErr bool
+	}{
+		{"empty", NotificationPreferences{}, false},
+		{"full", NotificationPreferences{
+			Channels:   []string{sinkSlack},
+			Thresholds: map[string]string{"*": "100", "native": "0.5"},
+			Delivery:   NotifyDigest,
+			QuietHours: &QuietHours{Start: "22:00", End: "07:30", TimeZone: "Europe/Berlin"},
+		}, false},
+		{"unknown delivery", NotificationPreferences{Delivery: "hourly"}, true},
+		{"negative threshold", NotificationPreferences{Thresholds: map[string]string{"*": "-1"}}, true},
+		{"malformed threshold", NotificationPreferences{Thresholds: map[string]string{"*": "lots"}}, true},
+		{"malformed quiet hours", NotificationPreferences{QuietHours: &QuietHours{Start: "10pm", End: "07:00"}}, true},
+		{"unknown time zone", NotificationPreferences{QuietHours: &QuietHours{Start: "22:00", End: "07:00", TimeZone: "Mars/Olympus"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseNotificationPreferences(tt.p); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddressPreferencesDeliver(t *testing.T) {
+	const usdc = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+	decimals := 6
+	transfer := func(units int64) *TransferDocument {
+		doc := &TransferDocument{Token: &TokenMetadata{Decimals: &decimals}}
+		doc.Transfer.Contract, doc.Transfer.Standard, doc.Transfer.Value = usdc, "ERC20", big.NewInt(units)
+		return doc
+	}
+	preferences := func(p NotificationPreferences) *addressPreferences {
+		parsed, err := parseNotificationPreferences(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// 23:30 in Berlin, within quiet hours ending at 07:00 there.
+	night := time.Date(2026, 1, 2, 22, 30, 0, 0, time.UTC)
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	morning := time.Date(2026, 1, 3, 7, 0, 0, 0, berlin)
+	quiet := &QuietHours{Start: "22:00", End: "07:00", TimeZone: "Europe/Berlin"}
+
+	tests := []struct {
+		name      string
+		p         NotificationPreferences
+		doc       *TransferDocument
+		at        time.Time
+		alert     bool
+		digest    bool
+		notBefore time.Time
+	}{
+		{"no preferences", NotificationPreferences{}, transfer(1), night, true, false, time.Time{}},
+		{"other channel", NotificationPreferences{Channels: []string{sinkTelegram}}, transfer(1), night, false, false, time.Time{}},
+		{"below the token threshold", NotificationPreferences{Thresholds: map[string]string{usdc: "1000"}}, transfer(999_990_000), night, false, false, time.Time{}},
+		{"at the token threshold", NotificationPreferences{Thresholds: map[string]string{usdc: "1000"}}, transfer(1_000_000_000), night, true, false, time.Time{}},
+		{"below the default threshold", NotificationPreferences{Thresholds: map[string]string{"*": "0.5"}}, transfer(400_000), night, false, false, time.Time{}},
+		{"digest", NotificationPreferences{Delivery: NotifyDigest}, transfer(1), night, true, true, time.Time{}},
+		{"within quiet hours", NotificationPreferences{QuietHours: quiet}, transfer(1), night, true, true, morning},
+		{"after quiet hours", NotificationPreferences{QuietHours: quiet}, transfer(1), morning, true, false, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert, digest, notBefore := preferences(tt.p).deliver(sinkSlack, tt.doc, tt.at)
+			if alert != tt.alert || digest != tt.digest || !notBefore.Equal(tt.notBefore) {
+				t.Errorf("deliver = %v, %v, %v, want %v, %v, %v", alert, digest, notBefore, tt.alert, tt.digest, tt.notBefore)
+			}
+		})
+	}
+}
+
+func TestDeliverTransfer(t *testing.T) {
+	const (
+		sender    = "0x1111111111111111111111111111111111111111"
+		recipient = "0x2222222222222222222222222222222222222222"
+	)
+	doc := &TransferDocument{}
+	doc.Transfer.From, doc.Transfer.To, doc.Transfer.Standard = sender, recipient, StandardNative
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	instant := &addressPreferences{}
+	digest := &addressPreferences{digest: true}
+	muted := &addressPreferences{channels: []string{sinkTelegram}}
+	quiet := &addressPreferences{quietStart: 11 * 60, quietEnd: 13 * 60, quietZone: time.UTC}
+
+	tests := []struct {
+		name      string
+		loaded    map[string]*addressPreferences
+		alert     bool
+		digest    bool
+		notBefore time.Time
+	}{
+		{"neither party", nil, true, false, time.Time{}},
+		{"instant wins over digest", map[string]*addressPreferences{sender: digest, recipient: instant}, true, false, time.Time{}},
+		{"digest wins over muted", map[string]*addressPreferences{sender: muted, recipient: digest}, true, true, time.Time{}},
+		{"earliest digest", map[string]*addressPreferences{sender: quiet, recipient: digest}, true, true, time.Time{}},
+		{"quiet hours", map[string]*addressPreferences{sender: quiet}, true, true, later},
+		{"both muted", map[string]*addressPreferences{sender: muted, recipient: muted}, false, false, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert, digest, notBefore := deliverTransfer(tt.loaded, sinkSlack, doc, now)
+			if alert != tt.alert || digest != tt.digest || !notBefore.Equal(tt.notBefore) {
+				t.Errorf("deliverTransfer = %v, %v, %v, want %v, %v, %v", alert, digest, notBefore, tt.alert, tt.digest, tt.notBefore)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package function
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func init() {
+	registerSerializer(protobufSerializer{})
+}
+
+// protobufSerializer encodes payloads as a google.protobuf.Value, the well-known type for
+// JSON-like data, so consumers decode them with the standard protobuf libraries without
+// generated document types. Payloads carry the same fields as the JSON ones, and numbers,
+// which google.protobuf.Value holds as doubles, are exact up to 2^53; amounts stay decimal
+// strings.
+type protobufSerializer struct{}
+
+func (protobufSerializer) Name() string        { return "protobuf" }
+func (protobufSerializer) ContentType() string { return "application/x-protobuf" }
+
+func (protobufSerializer) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value structpb.Value
+	if err := protojson.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(&value)
+}
+
+func (protobufSerializer) Unmarshal(data []byte, v any) error {
+	var value structpb.Value
+	if err := proto.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	data, err := protojson.Marshal(&value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,281 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultProviderTimeout     = 10 * time.Second
+	defaultProviderMaxAttempts = 3
+	defaultProviderBaseBackoff = 200 * time.Millisecond
+	defaultProviderMaxBackoff  = 5 * time.Second
+)
+
+// ProviderClient wraps outbound HTTP calls to an enrichment provider (RPC, prices, ENS, screening)
+// with per-provider rate limiting, retries with jitter, and health counters.
+type ProviderClient struct {
+	name        string
+	httpClient  *http.Client
+	limiter     *rate.Limiter
+	global      *distributedLimiter
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+
+	requests  atomic.Int64
+	failures  atomic.Int64
+	retries   atomic.Int64
+	lastError atomic.Value // string
+}
+
+// ProviderHealth is a snapshot of a provider's call counters.
+type ProviderHealth struct {
+	Provider  string `json:"provider"`
+	Requests  int64  `json:"requests"`
+	Failures  int64  `json:"failures"`
+	Retries   int64  `json:"retries"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+var (
+	providersMu sync.Mutex
+	providers   = map[string]*ProviderClient{}
+)
+
+// ProviderFor returns the shared client for the named provider, creating it on first use.
+// Sharing the client per name keeps rate limits global to the instance.
+//
+// Each provider is configured through PROVIDER_<NAME>_* environment variables:
+// RATE_LIMIT (requests per second, 0 for unlimited), BURST, MAX_ATTEMPTS and TIMEOUT.
+// GLOBAL_RATE_LIMIT additionally caps calls per GLOBAL_WINDOW across all instances.
+func ProviderFor(name string) *ProviderClient {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if p, ok := providers[name]; ok {
+		return p
+	}
+	p := newProviderClient(name)
+	providers[name] = p
+	return p
+}
+
+func newProviderClient(name string) *ProviderClient {
+	prefix := "PROVIDER_" + strings.ToUpper(name) + "_"
+
+	limit := rate.Inf
+	if rps := envFloat(prefix+"RATE_LIMIT", 0); rps > 0 {
+		limit = rate.Limit(rps)
+	}
+	burst := envInt(prefix+"BURST", 1)
+
+	return &ProviderClient{
+		name:        name,
+		httpClient:  &http.Client{Timeout: envDuration(prefix+"TIMEOUT", defaultProviderTimeout)},
+		limiter:     rate.NewLimiter(limit, max(burst, 1)),
+		global:      newDistributedLimiter(name, prefix),
+		maxAttempts: max(envInt(prefix+"MAX_ATTEMPTS", defaultProviderMaxAttempts), 1),
+		baseBackoff: defaultProviderBaseBackoff,
+		maxBackoff:  defaultProviderMaxBackoff,
+	}
+}
+
+// Do sends req, retrying transport errors, 429 and 5xx responses with jittered exponential backoff.
+// The request body must be replayable (set GetBody, as http.NewRequest does for in-memory readers).
+func (p *ProviderClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		if attempt > 1 {
+			p.retries.Add(1)
+			if err := sleepContext(ctx, backoffDelay(attempt-1, p.baseBackoff, p.maxBackoff)); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		if p.global != nil {
+			if err := p.global.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		attemptReq := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		} else if attempt > 1 && req.Body != nil {
+			break
+		}
+
+		p.requests.Add(1)
+		start := time.Now()
+		resp, err := p.httpClient.Do(attemptReq)
+		latency := time.Since(start)
+		// Transport errors quote the URL, whose path can hold credentials such as RPC API keys
+		// and bot tokens; only the scheme and host are kept.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = fmt.Errorf("%s %s://%s: %w", urlErr.Op, attemptReq.URL.Scheme, attemptReq.URL.Host, urlErr.Err)
+		}
+
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			p.logCall(attempt, resp.StatusCode, latency, nil)
+			return resp, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s responded with status %d", p.name, resp.StatusCode)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			p.logCall(attempt, resp.StatusCode, latency, err)
+		} else {
+			p.logCall(attempt, 0, latency, err)
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	p.failures.Add(1)
+	if lastErr == nil {
+		lastErr = errors.New("request body is not replayable")
+	}
+	p.lastError.Store(lastErr.Error())
+	return nil, fmt.Errorf("%s request failed: %w", p.name, lastErr)
+}
+
+// PostJSON posts in as JSON to url and decodes a 2xx JSON response into out.
+func (p *ProviderClient) PostJSON(ctx context.Context, url string, header http.Header, in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", p.name, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		p.failures.Add(1)
+		err := fmt.Errorf("%s responded with status %d", p.name, resp.StatusCode)
+		p.lastError.Store(err.Error())
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Health returns the provider's current call counters.
+func (p *ProviderClient) Health() ProviderHealth {
+	lastError, _ := p.lastError.Load().(string)
+	return ProviderHealth{
+		Provider:  p.name,
+		Requests:  p.requests.Load(),
+		Failures:  p.failures.Load(),
+		Retries:   p.retries.Load(),
+		LastError: lastError,
+	}
+}
+
+// ProvidersHealth returns the health counters of every provider used by this instance, sorted
+// by provider name. CheckReadiness reports them.
+func ProvidersHealth() []ProviderHealth {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	health := make([]ProviderHealth, 0, len(providers))
+	for _, p := range providers {
+		health = append(health, p.Health())
+	}
+	slices.SortFunc(health, func(a, b ProviderHealth) int { return strings.Compare(a.Provider, b.Provider) })
+	return health
+}
+
+func (p *ProviderClient) logCall(attempt, statusCode int, latency time.Duration, err error) {
+	if err != nil {
+		log.Printf(`{"level":"warn","message":"provider call failed","provider":"%s","attempt":%d,"status":%d,"latency_ms":%d,"error":"%s"}`,
+			p.name, attempt, statusCode, latency.Milliseconds(), err.Error())
+		return
+	}
+	log.Printf(`{"level":"debug","message":"provider call","provider":"%s","attempt":%d,"status":%d,"latency_ms":%d}`,
+		p.name, attempt, statusCode, latency.Milliseconds())
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// backoffDelay returns a full-jitter exponential delay for the given retry number (starting at 1).
+func backoffDelay(retry int, base, maxDelay time.Duration) time.Duration {
+	delay := base << (retry - 1)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	return time.Duration(rand.Int64N(int64(delay)) + 1)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,204 @@
+package function
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Pseudonymizer replaces addresses in documents with HMAC-SHA256 tokens before they reach selected sinks.
+// Tokens are stable for a given key, so flows between addresses can still be analyzed.
+type Pseudonymizer struct {
+	key   []byte
+	sinks map[string]bool
+}
+
+// NewPseudonymizer creates a pseudonymizer for the sinks listed in PSEUDONYMIZE_SINKS (comma-separated),
+// keyed by PSEUDONYMIZE_KEY. Mount the key from Secret Manager with --set-secrets.
+// It returns nil when no sinks are configured.
+func NewPseudonymizer() (*Pseudonymizer, error) {
+	sinks := parseList(os.Getenv("PSEUDONYMIZE_SINKS"))
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	key := os.Getenv("PSEUDONYMIZE_KEY")
+	if key == "" {
+		return nil, errors.New("PSEUDONYMIZE_KEY environment variable is not set")
+	}
+
+	p := &Pseudonymizer{key: []byte(key), sinks: make(map[string]bool, len(sinks))}
+	for _, sink := range sinks {
+		p.sinks[sink] = true
+	}
+	return p, nil
+}
+
+// Address returns the pseudonymous token for addr, formatted as an address so downstream schemas keep working.
+// Empty values stay empty.
+func (p *Pseudonymizer) Address(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	h := hmac.New(sha256.New, p.key)
+	h.Write([]byte(strings.ToLower(addr)))
+	return common.BytesToAddress(h.Sum(nil)[:common.AddressLength]).Hex()
+}
+
+// Covers reports whether documents written to sink are pseudonymized.
+func (p *Pseudonymizer) Covers(sink string) bool {
+	return p != nil && p.sinks[sink]
+}
+
+// Apply returns a copy of parsed with every document kind pseudonymized when sink is configured,
+// or parsed unchanged otherwise. Quarantined logs and retained raw logs are dropped, since their
+// topics and data carry the raw addresses and quarantined logs must keep them to be decoded
+// again. They are dead-lettered instead when the Firestore sink is pseudonymized.
+func (p *Pseudonymizer) Apply(sink string, parsed *ParsedWebhook) *ParsedWebhook {
+	if !p.Covers(sink) {
+		return parsed
+	}
+	return &ParsedWebhook{
+		Transfers:    p.Transfers(sink, parsed.Transfers),
+		Reverted:     p.Transfers(sink, parsed.Reverted),
+		Events:       p.Events(sink, parsed.Events),
+		Transactions: p.Transactions(sink, parsed.Transactions),
+		Approvals:    p.Approvals(sink, parsed.Approvals),
+		Swaps:        p.Swaps(sink, parsed.Swaps),
+		Tombstones:   parsed.Tombstones,
+	}
+}
+
+// Approvals returns copies of approvals with owner and spender pseudonymized and their raw log
+// dropped when sink is configured, or approvals unchanged otherwise.
+func (p *Pseudonymizer) Approvals(sink string, approvals []*ApprovalDocument) []*ApprovalDocument {
+	if !p.Covers(sink) {
+		return approvals
+	}
+	out := make([]*ApprovalDocument, 0, len(approvals))
+	for _, approval := range approvals {
+		doc := *approval
+		doc.RawLog = nil
+		doc.Approval.Owner = p.Address(doc.Approval.Owner)
+		doc.Approval.Spender = p.Address(doc.Approval.Spender)
+		doc.Transaction.From = p.Address(doc.Transaction.From)
+		doc.Transaction.To = p.Address(doc.Transaction.To)
+		out = append(out, &doc)
+	}
+	return out
+}
+
+// Swaps returns copies of swaps with sender and recipient pseudonymized and their raw log
+// dropped when sink is configured, or swaps unchanged otherwise.
+func (p *Pseudonymizer) Swaps(sink string, swaps []*SwapDocument) []*SwapDocument {
+	if !p.Covers(sink) {
+		return swaps
+	}
+	out := make([]*SwapDocument, 0, len(swaps))
+	for _, swap := range swaps {
+		doc := *swap
+		doc.RawLog = nil
+		doc.Swap.Sender = p.Address(doc.Swap.Sender)
+		doc.Swap.Recipient = p.Address(doc.Swap.Recipient)
+		doc.Transaction.From = p.Address(doc.Transaction.From)
+		doc.Transaction.To = p.Address(doc.Transaction.To)
+		out = append(out, &doc)
+	}
+	return out
+}
+
+// Transactions returns copies of transactions with sender and recipient pseudonymized
+// when sink is configured, or transactions unchanged otherwise.
+func (p *Pseudonymizer) Transactions(sink string, transactions []*TransactionDocument) []*TransactionDocument {
+	if !p.Covers(sink) {
+		return transactions
+	}
+	out := make([]*TransactionDocument, 0, len(transactions))
+	for _, transaction := range transactions {
+		doc := *transaction
+		doc.From = p.Address(doc.From)
+		doc.To = p.Address(doc.To)
+		out = append(out, &doc)
+	}
+	return out
+}
+
+// Transfers returns copies of transfers with sender and recipient addresses pseudonymized and
+// their ENS names and raw log dropped when sink is configured, or transfers unchanged otherwise.
+// Contract addresses are kept.
+func (p *Pseudonymizer) Transfers(sink string, transfers []*TransferDocument) []*TransferDocument {
+	if !p.Covers(sink) {
+		return transfers
+	}
+	out := make([]*TransferDocument, 0, len(transfers))
+	for _, transfer := range transfers {
+		doc := *transfer
+		doc.RawLog = nil
+		doc.Transaction.From = p.Address(doc.Transaction.From)
+		doc.Transaction.To = p.Address(doc.Transaction.To)
+		doc.Transfer.Operator = p.Address(doc.Transfer.Operator)
+		doc.Transfer.From = p.Address(doc.Transfer.From)
+		doc.Transfer.To = p.Address(doc.Transfer.To)
+		doc.FromENS = ""
+		doc.ToENS = ""
+		out = append(out, &doc)
+	}
+	return out
+}
+
+// Events returns copies of events with transaction addresses and address-valued fields, including
+// those nested in arrays and tuples, pseudonymized and their raw log dropped when sink is
+// configured, or events unchanged otherwise. Contract addresses are kept.
+func (p *Pseudonymizer) Events(sink string, events []*EventDocument) []*EventDocument {
+	if !p.Covers(sink) {
+		return events
+	}
+	out := make([]*EventDocument, 0, len(events))
+	for _, event := range events {
+		doc := *event
+		doc.RawLog = nil
+		doc.Transaction.From = p.Address(doc.Transaction.From)
+		doc.Transaction.To = p.Address(doc.Transaction.To)
+		doc.Event.Fields = p.value(event.Event.Fields).(map[string]any)
+		out = append(out, &doc)
+	}
+	return out
+}
+
+// value returns a copy of a decoded event value with every address in it pseudonymized,
+// descending into arrays and tuples.
+func (p *Pseudonymizer) value(value any) any {
+	switch value := value.(type) {
+	case string:
+		if common.IsHexAddress(value) && len(value) == 2+2*common.AddressLength {
+			return p.Address(value)
+		}
+	case []any:
+		items := make([]any, len(value))
+		for i, item := range value {
+			items[i] = p.value(item)
+		}
+		return items
+	case map[string]any:
+		fields := make(map[string]any, len(value))
+		for name, item := range value {
+			fields[name] = p.value(item)
+		}
+		return fields
+	}
+	return value
+}
+
+// parseList splits a comma-separated list, trimming spaces and dropping empty items.
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
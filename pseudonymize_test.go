@@ -0,0 +1,61 @@
+package function
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPseudonymizerEvents(t *testing.T) {
+	const (
+		owner   = "0x1111111111111111111111111111111111111111"
+		spender = "0x2222222222222222222222222222222222222222"
+	)
+	p := &Pseudonymizer{key: []byte("test-key"), sinks: map[string]bool{sinkBigQuery: true}}
+	tests := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{"address", owner, p.Address(owner)},
+		{"non-address string", "0x1234", "0x1234"},
+		{"number", "42", "42"},
+		{"array", []any{owner, "42"}, []any{p.Address(owner), "42"}},
+		{"tuple", map[string]any{"owner": owner, "amount": "1"}, map[string]any{"owner": p.Address(owner), "amount": "1"}},
+		{
+			"array of tuples",
+			[]any{map[string]any{"spender": spender, "path": []any{owner, spender}}},
+			[]any{map[string]any{"spender": p.Address(spender), "path": []any{p.Address(owner), p.Address(spender)}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &EventDocument{Event: DecodedEvent{Fields: map[string]any{"value": tt.value}}}
+			got := p.Events(sinkBigQuery, []*EventDocument{event})
+			if !reflect.DeepEqual(got[0].Event.Fields["value"], tt.want) {
+				t.Errorf("value = %v, want %v", got[0].Event.Fields["value"], tt.want)
+			}
+			if !reflect.DeepEqual(event.Event.Fields["value"], tt.value) {
+				t.Error("Events changed the shared event")
+			}
+		})
+	}
+}
+
+func TestPseudonymizerApplyQuarantined(t *testing.T) {
+	p := &Pseudonymizer{key: []byte("test-key"), sinks: map[string]bool{sinkFirestore: true}}
+	parsed := &ParsedWebhook{Quarantined: []*QuarantineDocument{{}}}
+	tests := []struct {
+		sink string
+		want int
+	}{
+		{sinkFirestore, 0},
+		{sinkBigQuery, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sink, func(t *testing.T) {
+			if got := len(p.Apply(tt.sink, parsed).Quarantined); got != tt.want {
+				t.Errorf("%d quarantined logs, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,41 +2,157 @@ package function
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/pubsub/v2"
+	"cloud.google.com/go/pubsub/v2/apiv1/pubsubpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // PubSubPublisher handles publishing webhook events to Google Cloud Pub/Sub.
+// With envelope set, documents are published in a MessageEnvelope instead of a bare array, and
+// with perTransfer set, every transfer is published as its own message. With orderingKey set,
+// messages carry the ordering key it names. Batches encoding to more than maxMessageBytes are
+// split into several messages.
 type PubSubPublisher struct {
-	client    *pubsub.Client
-	publisher *pubsub.Publisher
+	publisher       *pubsub.Publisher
+	serializer      Serializer
+	envelope        bool
+	perTransfer     bool
+	orderingKey     string
+	maxMessageBytes int
+}
+
+// defaultPubSubMaxMessageBytes keeps messages clear of the 10MB Pub/Sub limit, which also counts
+// attributes and the ordering key.
+const defaultPubSubMaxMessageBytes = 9 << 20
+
+// Ordering keys of PUBSUB_ORDERING_KEY.
+const (
+	// OrderingKeyWebhook orders the messages of each Alchemy webhook ID.
+	OrderingKeyWebhook = "webhook"
+	// OrderingKeyNetwork orders the messages of each network.
+	OrderingKeyNetwork = "network"
+	// OrderingKeyContract orders the transfer messages of each token contract.
+	OrderingKeyContract = "contract"
+)
+
+// getPubSubOrderingKey returns the ordering key of PUBSUB_ORDERING_KEY, or "" when messages are
+// published without one.
+func getPubSubOrderingKey() (string, error) {
+	switch key := os.Getenv("PUBSUB_ORDERING_KEY"); key {
+	case "", OrderingKeyWebhook, OrderingKeyNetwork, OrderingKeyContract:
+		return key, nil
+	default:
+		return "", fmt.Errorf("invalid PUBSUB_ORDERING_KEY %q (want %s, %s or %s)", key, OrderingKeyWebhook, OrderingKeyNetwork, OrderingKeyContract)
+	}
+}
+
+// Publisher publishes each kind of parsed document to a topic. PubSubPublisher is the
+// production implementation.
+type Publisher interface {
+	PublishTransfers(ctx context.Context, transfers []*TransferDocument) error
+	PublishEvents(ctx context.Context, events []*EventDocument) error
+	PublishApprovals(ctx context.Context, approvals []*ApprovalDocument) error
+	PublishSwaps(ctx context.Context, swaps []*SwapDocument) error
+	PublishTombstones(ctx context.Context, tombstones []*Tombstone) error
+	PublishTransactions(ctx context.Context, transactions []*TransactionDocument) error
+	PublishLifecycleEvent(ctx context.Context, event *LifecycleEvent) error
+	Close() error
+}
+
+// PublisherFactory opens the Publisher of a topic.
+type PublisherFactory func(ctx context.Context, topicID string) (Publisher, error)
+
+// newPublisher opens the publishers of the pubsub sink, lifecycle events, the enrichment worker
+// and finality tracking; openPubSubPublisher unless replaced.
+var newPublisher PublisherFactory = openPubSubPublisher
+
+// publisherReplaced reports whether SetPublisherFactory has replaced newPublisher.
+var publisherReplaced bool
+
+func openPubSubPublisher(ctx context.Context, topicID string) (Publisher, error) {
+	publisher, err := NewPubSubPublisherForTopic(ctx, topicID)
+	if err != nil {
+		return nil, err
+	}
+	return publisher, nil
+}
+
+// SetPublisherFactory replaces the Pub/Sub publishers of the pubsub sink, lifecycle events, the
+// enrichment worker and finality tracking, e.g. with an in-memory publisher in tests; nil
+// restores the Pub/Sub publisher. The pubsub sink then neither creates the Pub/Sub client nor
+// verifies topics. It is not safe to call while serving requests.
+func SetPublisherFactory(factory PublisherFactory) {
+	if factory == nil {
+		newPublisher, publisherReplaced = openPubSubPublisher, false
+		return
+	}
+	newPublisher, publisherReplaced = factory, true
 }
 
 // NewPubSubPublisher creates a new Pub/Sub publisher.
 func NewPubSubPublisher(ctx context.Context) (*PubSubPublisher, error) {
-	projectID := getProjectID()
-	if projectID == "" {
-		return nil, errors.New("project ID not found (GCP_PROJECT or GOOGLE_CLOUD_PROJECT)")
+	topicID := os.Getenv("ALCHEMY_PUBSUB_TOPIC")
+	if topicID == "" {
+		return nil, errors.New("ALCHEMY_PUBSUB_TOPIC environment variable is not set")
 	}
+	return NewPubSubPublisherForTopic(ctx, topicID)
+}
 
+// openPublisher opens the Publisher of ALCHEMY_PUBSUB_TOPIC.
+func openPublisher(ctx context.Context) (Publisher, error) {
 	topicID := os.Getenv("ALCHEMY_PUBSUB_TOPIC")
 	if topicID == "" {
 		return nil, errors.New("ALCHEMY_PUBSUB_TOPIC environment variable is not set")
 	}
+	return newPublisher(ctx, topicID)
+}
 
-	client, err := pubsub.NewClient(ctx, projectID)
+// NewPubSubPublisherForTopic creates a new Pub/Sub publisher for the given topic, encoding
+// messages with the serializer configured in PUBSUB_SERIALIZER, in envelopes when
+// PUBSUB_ENVELOPE is set, splitting transfers as PUBSUB_MESSAGE_MODE says and batches larger
+// than PUBSUB_MAX_MESSAGE_BYTES (default 9MiB).
+func NewPubSubPublisherForTopic(ctx context.Context, topicID string) (*PubSubPublisher, error) {
+	serializer, err := sinkSerializer(sinkPubSub)
 	if err != nil {
 		return nil, err
 	}
+	mode, err := getPubSubMessageMode()
+	if err != nil {
+		return nil, err
+	}
+	orderingKey, err := getPubSubOrderingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := pubsubClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each call waits for the messages it publishes, so flush them immediately
+	// instead of holding them for the default bundling delay.
+	publisher := client.Publisher(topicID)
+	publisher.PublishSettings.CountThreshold = 1
+	publisher.EnableMessageOrdering = orderingKey != ""
 
 	return &PubSubPublisher{
-		client:    client,
-		publisher: client.Publisher(topicID),
+		publisher:       publisher,
+		serializer:      serializer,
+		envelope:        os.Getenv("PUBSUB_ENVELOPE") == "true",
+		perTransfer:     mode == PubSubMessagePerTransfer,
+		orderingKey:     orderingKey,
+		maxMessageBytes: max(envInt("PUBSUB_MAX_MESSAGE_BYTES", defaultPubSubMaxMessageBytes), 1),
 	}, nil
 }
 
@@ -47,42 +163,304 @@ func getProjectID() string {
 	return os.Getenv("GOOGLE_CLOUD_PROJECT")
 }
 
-// PublishTransfers publishes an array of TransferDocuments to Pub/Sub as a single message.
+// PublishTransfers publishes an array of TransferDocuments to Pub/Sub as a single message, as
+// one message per transfer under PUBSUB_MESSAGE_MODE=per-transfer, as one message per set of
+// filter attributes when PUBSUB_FILTER_ATTRIBUTES is enabled, or as one message per contract
+// under PUBSUB_ORDERING_KEY=contract.
 func (p *PubSubPublisher) PublishTransfers(ctx context.Context, transfers []*TransferDocument) error {
-	data, err := json.Marshal(transfers)
+	if p.perTransfer && len(transfers) > 0 {
+		return p.publishTransferMessages(ctx, transfers)
+	}
+	if filterAttributesEnabled() && len(transfers) > 0 {
+		return p.publishFilterableTransfers(ctx, transfers)
+	}
+	if p.orderingKey == OrderingKeyContract && len(transfers) > 0 {
+		return p.publishContractTransfers(ctx, transfers)
+	}
+	attributes := map[string]string{"type": "transfers", "count": "0"}
+	if len(transfers) > 0 {
+		attributes = buildAttributes("transfers", transfers[0].Alchemy, transfers[0].Network, len(transfers))
+	}
+	messages, err := marshalMessages(p, transfers, attributes)
 	if err != nil {
 		return fmt.Errorf("failed to marshal transfers: %w", err)
 	}
+	return p.publishAll(ctx, messages)
+}
 
-	result := p.publisher.Publish(ctx, &pubsub.Message{
-		Data:       data,
-		Attributes: buildAttributes(transfers),
-	})
+// PublishEvents publishes an array of EventDocuments decoded through the registry as a single
+// message, or as one message per set of filter attributes when PUBSUB_FILTER_ATTRIBUTES is enabled.
+func (p *PubSubPublisher) PublishEvents(ctx context.Context, events []*EventDocument) error {
+	if filterAttributesEnabled() && len(events) > 0 {
+		return p.publishFilterableEvents(ctx, events)
+	}
+	attributes := map[string]string{"type": "events", "count": "0"}
+	if len(events) > 0 {
+		attributes = buildAttributes("events", events[0].Alchemy, events[0].Network, len(events))
+	}
+	messages, err := marshalMessages(p, events, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal events: %w", err)
+	}
+	return p.publishAll(ctx, messages)
+}
 
-	messageID, err := result.Get(ctx)
+// PublishApprovals publishes an array of ApprovalDocuments as a single message.
+func (p *PubSubPublisher) PublishApprovals(ctx context.Context, approvals []*ApprovalDocument) error {
+	attributes := map[string]string{"type": "approvals", "count": "0"}
+	if len(approvals) > 0 {
+		attributes = buildAttributes("approvals", approvals[0].Alchemy, approvals[0].Network, len(approvals))
+	}
+	messages, err := marshalMessages(p, approvals, attributes)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to marshal approvals: %w", err)
 	}
+	return p.publishAll(ctx, messages)
+}
 
-	log.Printf(`{"level":"info","message":"published transfers to pubsub","message_id":"%s","count":%d}`, messageID, len(transfers))
-	return nil
+// PublishSwaps publishes an array of SwapDocuments as a single message.
+func (p *PubSubPublisher) PublishSwaps(ctx context.Context, swaps []*SwapDocument) error {
+	attributes := map[string]string{"type": "swaps", "count": "0"}
+	if len(swaps) > 0 {
+		attributes = buildAttributes("swaps", swaps[0].Alchemy, swaps[0].Network, len(swaps))
+	}
+	messages, err := marshalMessages(p, swaps, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal swaps: %w", err)
+	}
+	return p.publishAll(ctx, messages)
 }
 
-func buildAttributes(transfers []*TransferDocument) map[string]string {
-	if len(transfers) == 0 {
-		return map[string]string{"count": "0"}
+// PublishTombstones publishes Tombstones for documents removed by a chain reorganization as a single message.
+func (p *PubSubPublisher) PublishTombstones(ctx context.Context, tombstones []*Tombstone) error {
+	attributes := map[string]string{"type": "tombstones", "count": "0"}
+	if len(tombstones) > 0 {
+		attributes = buildAttributes("tombstones", tombstones[0].Alchemy, tombstones[0].Network, len(tombstones))
+	}
+	messages, err := marshalMessages(p, tombstones, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tombstones: %w", err)
 	}
-	first := transfers[0]
+	return p.publishAll(ctx, messages)
+}
+
+// PublishTransactions publishes an array of TransactionDocuments as a single message.
+func (p *PubSubPublisher) PublishTransactions(ctx context.Context, transactions []*TransactionDocument) error {
+	attributes := map[string]string{"type": "transactions", "count": "0"}
+	if len(transactions) > 0 {
+		attributes = buildAttributes("transactions", transactions[0].Alchemy, transactions[0].Network, len(transactions))
+	}
+	messages, err := marshalMessages(p, transactions, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transactions: %w", err)
+	}
+	return p.publishAll(ctx, messages)
+}
+
+// marshal encodes docs, the documents of a message with attributes, as a bare array or in a
+// MessageEnvelope, which is announced by the format attribute.
+func (p *PubSubPublisher) marshal(docs any, attributes map[string]string) ([]byte, error) {
+	if !p.envelope {
+		return p.serializer.Marshal(docs)
+	}
+	attributes["format"] = formatEnvelope
+	return p.serializer.Marshal(newMessageEnvelope(docs, attributes))
+}
+
+// marshalMessages encodes docs, the documents of a message with attributes, as one message, or,
+// when it would exceed maxMessageBytes, as several messages of consecutive documents in order,
+// each with its own count and with part, from 1, and total attributes. A single document over the
+// limit cannot be split and fails.
+func marshalMessages[T Document](p *PubSubPublisher, docs []T, attributes map[string]string) ([]*pubsub.Message, error) {
+	messages, err := splitMessages(p, docs, attributes)
+	if err != nil || len(messages) == 1 {
+		return messages, err
+	}
+	for i, message := range messages {
+		message.Attributes["part"] = strconv.Itoa(i + 1)
+		message.Attributes["total"] = strconv.Itoa(len(messages))
+	}
+	log.Printf(`{"level":"info","message":"split oversize pubsub batch","type":"%s","count":%d,"parts":%d}`,
+		attributes["type"], len(docs), len(messages))
+	return messages, nil
+}
+
+// splitMessages encodes docs as one message with a copy of attributes, or splits them evenly into
+// as many parts as their size calls for and encodes each part the same way.
+func splitMessages[T Document](p *PubSubPublisher, docs []T, attributes map[string]string) ([]*pubsub.Message, error) {
+	part := maps.Clone(attributes)
+	if len(docs) > 0 {
+		part["count"] = strconv.Itoa(len(docs))
+	}
+	data, err := p.marshal(docs, part)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= p.maxMessageBytes {
+		return []*pubsub.Message{{Data: data, Attributes: part}}, nil
+	}
+	if len(docs) < 2 {
+		return nil, fmt.Errorf("message of %d bytes exceeds PUBSUB_MAX_MESSAGE_BYTES %d and holds a single document", len(data), p.maxMessageBytes)
+	}
+
+	parts := min(len(data)/p.maxMessageBytes+1, len(docs))
+	var messages []*pubsub.Message
+	for i := range parts {
+		chunk, err := splitMessages(p, docs[i*len(docs)/parts:(i+1)*len(docs)/parts], attributes)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, chunk...)
+	}
+	return messages, nil
+}
+
+func (p *PubSubPublisher) publish(ctx context.Context, data []byte, attributes map[string]string) error {
+	return p.publishAll(ctx, []*pubsub.Message{{Data: data, Attributes: attributes}})
+}
+
+// publishAll publishes messages without waiting in between, then waits for every one of them and
+// returns the first error. A failure pauses the message's ordering key in the publisher, so it is
+// resumed for the retry, which publishes the failed message and those after it again.
+func (p *PubSubPublisher) publishAll(ctx context.Context, messages []*pubsub.Message) error {
+	results := make([]*pubsub.PublishResult, len(messages))
+	for i, message := range messages {
+		message.Attributes["content_type"] = p.serializer.ContentType()
+		for name, value := range schemaAttributes() {
+			message.Attributes[name] = value
+		}
+		message.OrderingKey = p.messageOrderingKey(message.Attributes)
+		results[i] = p.publisher.Publish(ctx, message)
+	}
+
+	var firstErr error
+	for i, result := range results {
+		messageID, err := result.Get(ctx)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if key := messages[i].OrderingKey; key != "" {
+				p.publisher.ResumePublish(key)
+			}
+			continue
+		}
+		log.Printf(`{"level":"info","message":"published %s to pubsub","message_id":"%s","count":%s}`,
+			messages[i].Attributes["type"], messageID, messages[i].Attributes["count"])
+	}
+	return firstErr
+}
+
+// messageOrderingKey returns the ordering key of a message with attributes: its webhook ID,
+// network or contract, or "" when it has none, such as messages carrying transfers of several
+// contracts, which are then not ordered.
+func (p *PubSubPublisher) messageOrderingKey(attributes map[string]string) string {
+	switch p.orderingKey {
+	case OrderingKeyWebhook:
+		return attributes["webhook_id"]
+	case OrderingKeyNetwork:
+		return attributes["network"]
+	case OrderingKeyContract:
+		if contract := attributes["contract"]; contract != "" {
+			return attributes["network"] + "/" + contract
+		}
+	}
+	return ""
+}
+
+func buildAttributes(kind string, alchemy AlchemyMetadata, network string, count int) map[string]string {
 	return map[string]string{
-		"webhook_id": first.Alchemy.WebhookID,
-		"event_id":   first.Alchemy.EventID,
-		"network":    first.Network,
-		"count":      fmt.Sprintf("%d", len(transfers)),
+		"type":       kind,
+		"webhook_id": alchemy.WebhookID,
+		"event_id":   alchemy.EventID,
+		"network":    network,
+		"count":      fmt.Sprintf("%d", count),
 	}
 }
 
-// Close closes the Pub/Sub client.
+// Close stops the publisher. The Pub/Sub client is shared by the instance and stays open.
 func (p *PubSubPublisher) Close() error {
 	p.publisher.Stop()
-	return p.client.Close()
+	return nil
+}
+
+// pubSubTopics returns the topics the pubsub sink publishes to: ALCHEMY_PUBSUB_TOPIC,
+// ALCHEMY_PUBSUB_TRANSACTIONS_TOPIC when set, and the topics routing rules name.
+func pubSubTopics() ([]string, error) {
+	topics := []string{os.Getenv("ALCHEMY_PUBSUB_TOPIC")}
+	if topics[0] == "" {
+		return nil, errors.New("ALCHEMY_PUBSUB_TOPIC environment variable is not set")
+	}
+	if topic := os.Getenv("ALCHEMY_PUBSUB_TRANSACTIONS_TOPIC"); topic != "" {
+		topics = append(topics, topic)
+	}
+	rules, err := LoadRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if topic := rule.topic(); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	slices.Sort(topics)
+	return slices.Compact(topics), nil
+}
+
+// verifyPubSubTopics checks that every one of topics exists and accepts messages from
+// PUBSUB_REGION, returning warnings about topics it cannot fully verify.
+func verifyPubSubTopics(ctx context.Context, topics []string) ([]string, error) {
+	var warnings []string
+	for _, topicID := range topics {
+		warning, err := verifyPubSubTopic(ctx, topicID)
+		if err != nil {
+			return warnings, err
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings, nil
+}
+
+// verifyPubSubTopic checks that topicID exists and that its message storage policy allows
+// publishing from PUBSUB_REGION. It returns an error when publishing is bound to fail, and a
+// warning when the topic cannot be read, which needs pubsub.topics.get beyond the publisher
+// role, or when messages are stored away from PUBSUB_REGION.
+func verifyPubSubTopic(ctx context.Context, topicID string) (string, error) {
+	client, err := pubsubClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	name := topicID
+	if !strings.HasPrefix(name, "projects/") {
+		name = fmt.Sprintf("projects/%s/topics/%s", getProjectID(), topicID)
+	}
+	topic, err := client.TopicAdminClient.GetTopic(ctx, &pubsubpb.GetTopicRequest{Topic: name})
+	switch status.Code(err) {
+	case codes.OK:
+	case codes.NotFound:
+		return "", fmt.Errorf("Pub/Sub topic %s does not exist", name)
+	case codes.PermissionDenied:
+		return fmt.Sprintf("cannot verify Pub/Sub topic %s: permission pubsub.topics.get denied", name), nil
+	default:
+		return "", fmt.Errorf("failed to get Pub/Sub topic %s: %w", name, err)
+	}
+
+	policy := topic.GetMessageStoragePolicy()
+	allowed := policy.GetAllowedPersistenceRegions()
+	region := os.Getenv("PUBSUB_REGION")
+	if len(allowed) == 0 || slices.Contains(allowed, region) {
+		return "", nil
+	}
+	regions := strings.Join(allowed, ", ")
+	switch {
+	case region == "" && policy.GetEnforceInTransit():
+		return fmt.Sprintf("Pub/Sub topic %s only accepts messages published in %s; set PUBSUB_REGION to verify this instance's region", name, regions), nil
+	case region == "":
+		return "", nil
+	case policy.GetEnforceInTransit():
+		return "", fmt.Errorf("Pub/Sub topic %s only accepts messages published in %s, not PUBSUB_REGION %s", name, regions, region)
+	}
+	return fmt.Sprintf("Pub/Sub topic %s stores messages published in PUBSUB_REGION %s in one of %s", name, region, regions), nil
 }
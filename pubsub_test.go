@@ -0,0 +1,110 @@
+package function
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// BenchmarkMarshalMessagesSingleTransfer measures encoding the one message a single transfer is
+// published as.
+func BenchmarkMarshalMessagesSingleTransfer(b *testing.B) {
+	parsed, err := ParseWebhook(singleTransferWebhook(b), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	transfers := parsed.Transfers
+	p := &PubSubPublisher{serializer: jsonSerializer{}, maxMessageBytes: defaultPubSubMaxMessageBytes}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		attributes := buildAttributes("transfers", transfers[0].Alchemy, transfers[0].Network, len(transfers))
+		messages, err := marshalMessages(p, transfers, attributes)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(messages) != 1 {
+			b.Fatalf("got %d messages, want 1", len(messages))
+		}
+	}
+}
+
+func TestMarshalMessages(t *testing.T) {
+	parsed, err := ParseWebhook(singleTransferWebhook(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfers := make([]*TransferDocument, 10)
+	for i := range transfers {
+		transfer := *parsed.Transfers[0]
+		transfer.Transfer.LogIndex = i
+		transfers[i] = &transfer
+	}
+	size, err := json.Marshal(transfers[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		docs     int
+		maxBytes int
+		counts   []int
+		wantErr  bool
+	}{
+		{"fits", 10, defaultPubSubMaxMessageBytes, []int{10}, false},
+		{"splits in two", 10, len(size) * 6, []int{5, 5}, false},
+		{"splits unevenly", 7, len(size) * 3, []int{2, 2, 3}, false},
+		{"one document per message", 3, len(size) + 1, []int{1, 1, 1}, false},
+		{"single oversize document", 1, len(size) - 1, nil, true},
+		{"oversize document in a batch", 2, len(size) - 1, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PubSubPublisher{serializer: jsonSerializer{}, maxMessageBytes: tt.maxBytes}
+			docs := transfers[:tt.docs]
+			attributes := buildAttributes("transfers", docs[0].Alchemy, docs[0].Network, len(docs))
+			messages, err := marshalMessages(p, docs, attributes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(messages) != len(tt.counts) {
+				t.Fatalf("got %d messages, want %d", len(messages), len(tt.counts))
+			}
+
+			next := 0
+			for i, message := range messages {
+				if len(message.Data) > tt.maxBytes {
+					t.Errorf("message %d has %d bytes, over %d", i, len(message.Data), tt.maxBytes)
+				}
+				var got []*TransferDocument
+				if err := json.Unmarshal(message.Data, &got); err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != tt.counts[i] || message.Attributes["count"] != strconv.Itoa(tt.counts[i]) {
+					t.Errorf("message %d holds %d documents with count %s, want %d", i, len(got), message.Attributes["count"], tt.counts[i])
+				}
+				for _, doc := range got {
+					if doc.Transfer.LogIndex != next {
+						t.Errorf("message %d holds log %d, want %d", i, doc.Transfer.LogIndex, next)
+					}
+					next++
+				}
+
+				part, total := message.Attributes["part"], message.Attributes["total"]
+				if len(messages) == 1 {
+					if part != "" || total != "" {
+						t.Errorf("unsplit message has part %q and total %q", part, total)
+					}
+					continue
+				}
+				if part != strconv.Itoa(i+1) || total != strconv.Itoa(len(messages)) {
+					t.Errorf("message %d is part %s of %s, want %d of %d", i, part, total, i+1, len(messages))
+				}
+			}
+			if attributes["count"] != strconv.Itoa(tt.docs) {
+				t.Errorf("caller attributes changed: count = %s", attributes["count"])
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+const quarantineCollectionName = "alchemy_quarantine"
+
+// QuarantineDocument records a log that matched a supported topics[0] but failed to decode,
+// with enough of its webhook to decode it again once the decoder is fixed.
+type QuarantineDocument struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Block         Block           `json:"block"`
+	Log           WebhookLog      `json:"log"`
+	Kind          string          `json:"kind"`
+	Error         string          `json:"error"`
+	Network       string          `json:"network"`
+	Alchemy       AlchemyMetadata `json:"alchemy"`
+	QuarantinedAt time.Time       `json:"quarantinedAt"`
+}
+
+// DocumentID returns the idempotent document ID of the quarantined log.
+func (d *QuarantineDocument) DocumentID() string {
+	return GetDocumentID(d.Log.Transaction.Hash, d.Log.Index)
+}
+
+// newQuarantineDocument records log of webhook as rejected by the kind decoder with err.
+func newQuarantineDocument(webhook *WebhookEvent, log WebhookLog, kind string, err error) *QuarantineDocument {
+	return &QuarantineDocument{
+		SchemaVersion: SchemaVersion,
+		Block:         newBlock(webhook),
+		Log:           log,
+		Kind:          kind,
+		Error:         err.Error(),
+		Network:       webhook.Event.Network,
+		Alchemy:       newAlchemyMetadata(webhook),
+		QuarantinedAt: time.Now().UTC(),
+	}
+}
+
+// webhookEvent rebuilds a single-log GRAPHQL webhook from the quarantined log.
+func (d *QuarantineDocument) webhookEvent() *WebhookEvent {
+	webhook := &WebhookEvent{
+		WebhookID: d.Alchemy.WebhookID,
+		ID:        d.Alchemy.EventID,
+		Type:      WebhookTypeGraphQL,
+		anomalies: d.Alchemy.Anomalies,
+	}
+	webhook.CreatedAt, _ = time.Parse(time.RFC3339, d.Alchemy.CreatedAt)
+	webhook.Event.SequenceNumber = d.Alchemy.SequenceNumber
+	webhook.Event.Network = d.Network
+	webhook.Event.Data.Block.Hash = d.Block.Hash
+	webhook.Event.Data.Block.Number = d.Block.Number
+	webhook.Event.Data.Block.Timestamp = d.Block.Timestamp
+	webhook.Event.Data.Block.Logs = []WebhookLog{d.Log}
+	return webhook
+}
+
+// RequeueResult reports the outcome of RequeueQuarantined.
+type RequeueResult struct {
+	Requeued  int `json:"requeued"`
+	Remaining int `json:"remaining"`
+}
+
+// RequeueQuarantined decodes every quarantined log again with the current decoders, typically after
+// a decoder fix. Logs that now decode are delivered to the enabled sinks like a live webhook and
+// removed from the quarantine; logs that still fail stay with their latest error.
+func RequeueQuarantined(ctx context.Context) (RequeueResult, error) {
+	var result RequeueResult
+
+	settings, err := loadHandlerSettings()
+	if err != nil {
+		return result, err
+	}
+
+	writer, err := NewFirestoreWriter(ctx)
+	if err != nil {
+		return result, err
+	}
+	iter := writer.client.Collection(quarantineCollectionName).Documents(ctx)
+	defer iter.Stop()
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		var doc QuarantineDocument
+		if err := snapshot.DataTo(&doc); err != nil {
+			return result, err
+		}
+		webhook := doc.webhookEvent()
+		parsed, err := ParseWebhookLogs(webhook, settings.registry)
+		if err != nil {
+			return result, err
+		}
+		if len(parsed.Quarantined) > 0 {
+			result.Remaining++
+			if _, err := snapshot.Ref.Update(ctx, []firestore.Update{{Path: "Error", Value: parsed.Quarantined[0].Error}}); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		applyFailedTxPolicy(settings.failedTxPolicy, parsed)
+		applyTransferFilter(settings.filter, parsed)
+		applyRawLogRetention(settings.rawLogRetention, webhook, parsed)
+		enrichTransfers(ctx, settings.enrichers, parsed.Transfers)
+		enrichTransfers(ctx, settings.enrichers, parsed.Reverted)
+		setAmountBuckets(parsed.Transfers)
+		setAmountBuckets(parsed.Reverted)
+		if os.Getenv("ENABLE_FINALITY_TRACKING") == "true" {
+			markPending(parsed.Transfers)
+		}
+		applyRules(settings.rules, parsed)
+		if err := deliverToSinks(ctx, nil, settings, parsed, webhook.WebhookID); err != nil {
+			return result, err
+		}
+		if _, err := snapshot.Ref.Delete(ctx); err != nil {
+			return result, err
+		}
+		result.Requeued++
+	}
+
+	log.Printf(`{"level":"info","message":"requeued quarantined logs","requeued":%d,"remaining":%d}`,
+		result.Requeued, result.Remaining)
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	rateLimitsCollectionName = "alchemy_rate_limits"
+	defaultGlobalWindow      = time.Second
+	defaultGlobalShards      = 4
+)
+
+// distributedLimiter caps calls to a provider across every function instance with fixed-window
+// counters in Firestore. Each window's quota is split over several counter documents (shards)
+// so concurrent instances rarely contend on the same document.
+type distributedLimiter struct {
+	provider string
+	limit    int
+	window   time.Duration
+	shards   int
+}
+
+// rateLimitCounter is the document stored per provider, window and shard.
+// ExpireAt is meant to back a Firestore TTL policy on the collection.
+type rateLimitCounter struct {
+	Count    int       `firestore:"count"`
+	ExpireAt time.Time `firestore:"ExpireAt"`
+}
+
+// newDistributedLimiter reads PROVIDER_<NAME>_GLOBAL_RATE_LIMIT (calls per window across instances),
+// GLOBAL_WINDOW and GLOBAL_SHARDS from prefix. It returns nil when no global limit is set.
+func newDistributedLimiter(name, prefix string) *distributedLimiter {
+	limit := envInt(prefix+"GLOBAL_RATE_LIMIT", 0)
+	if limit <= 0 {
+		return nil
+	}
+	return &distributedLimiter{
+		provider: name,
+		limit:    limit,
+		window:   envDuration(prefix+"GLOBAL_WINDOW", defaultGlobalWindow),
+		shards:   min(max(envInt(prefix+"GLOBAL_SHARDS", defaultGlobalShards), 1), limit),
+	}
+}
+
+// Wait blocks until a call fits in the global quota of the current window. Shards are tried from a
+// random offset; when all are full it sleeps until the next window. Firestore errors are logged and
+// let the call through, leaving the instance's local limiter in charge.
+func (l *distributedLimiter) Wait(ctx context.Context) error {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		logError("global rate limiter unavailable", err)
+		return nil
+	}
+
+	for {
+		windowStart := time.Now().Truncate(l.window)
+		offset := rand.IntN(l.shards)
+		for i := range l.shards {
+			shard := (offset + i) % l.shards
+			taken, err := l.take(ctx, client, windowStart, shard)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				logError("global rate limiter unavailable", err)
+				return nil
+			}
+			if taken {
+				return nil
+			}
+		}
+
+		delay := time.Until(windowStart.Add(l.window)) + time.Duration(rand.Int64N(int64(l.window)/10+1))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// capacity returns the shard's share of the limit; the remainder goes to the first shards.
+func (l *distributedLimiter) capacity(shard int) int {
+	capacity := l.limit / l.shards
+	if shard < l.limit%l.shards {
+		capacity++
+	}
+	return capacity
+}
+
+// take increments the shard's counter for the window when it is below the shard's share of the limit.
+func (l *distributedLimiter) take(ctx context.Context, client *firestore.Client, windowStart time.Time, shard int) (bool, error) {
+	capacity := l.capacity(shard)
+	id := fmt.Sprintf("%s-%d-%d", l.provider, windowStart.UnixMilli(), shard)
+	ref := client.Collection(rateLimitsCollectionName).Doc(id)
+
+	taken := false
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		taken = false
+		counter := rateLimitCounter{ExpireAt: windowStart.Add(l.window + time.Hour)}
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := snap.DataTo(&counter); err != nil {
+				return err
+			}
+		}
+		if counter.Count >= capacity {
+			return nil
+		}
+		counter.Count++
+		taken = true
+		return tx.Set(ref, counter)
+	})
+	return taken, err
+}
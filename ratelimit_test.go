@@ -0,0 +1,60 @@
+package function
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewDistributedLimiter(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		want   bool
+		shards int
+		window time.Duration
+	}{
+		{"unset", nil, false, 0, 0},
+		{"defaults", map[string]string{"GLOBAL_RATE_LIMIT": "100"}, true, defaultGlobalShards, defaultGlobalWindow},
+		{"more shards than calls", map[string]string{"GLOBAL_RATE_LIMIT": "2", "GLOBAL_SHARDS": "8"}, true, 2, defaultGlobalWindow},
+		{"no shards", map[string]string{"GLOBAL_RATE_LIMIT": "10", "GLOBAL_SHARDS": "0"}, true, 1, defaultGlobalWindow},
+		{"window", map[string]string{"GLOBAL_RATE_LIMIT": "10", "GLOBAL_WINDOW": "1m"}, true, defaultGlobalShards, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"GLOBAL_RATE_LIMIT", "GLOBAL_SHARDS", "GLOBAL_WINDOW"} {
+				t.Setenv("PROVIDER_TEST_"+key, tt.env[key])
+			}
+			l := newDistributedLimiter("test", "PROVIDER_TEST_")
+			if (l != nil) != tt.want {
+				t.Fatalf("limiter = %v, want one %v", l, tt.want)
+			}
+			if l != nil && (l.shards != tt.shards || l.window != tt.window) {
+				t.Errorf("shards = %d, window = %v, want %d, %v", l.shards, l.window, tt.shards, tt.window)
+			}
+		})
+	}
+}
+
+func TestDistributedLimiterCapacity(t *testing.T) {
+	tests := []struct {
+		limit  int
+		shards int
+		want   []int
+	}{
+		{8, 4, []int{2, 2, 2, 2}},
+		{10, 4, []int{3, 3, 2, 2}},
+		{3, 3, []int{1, 1, 1}},
+		{5, 1, []int{5}},
+	}
+	for _, tt := range tests {
+		l := &distributedLimiter{limit: tt.limit, shards: tt.shards}
+		var got []int
+		for shard := range tt.shards {
+			got = append(got, l.capacity(shard))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("limit %d over %d shards: capacities %v, want %v", tt.limit, tt.shards, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package function
+
+import (
+	"fmt"
+	"os"
+)
+
+const rawLogsCollectionName = "alchemy_raw_logs"
+
+// RawLogRetention controls whether the raw log behind each log-derived document is retained.
+type RawLogRetention string
+
+const (
+	// RawLogRetentionOff retains no raw logs.
+	RawLogRetentionOff RawLogRetention = "off"
+	// RawLogRetentionDocument stores the raw log on each document as rawLog.
+	RawLogRetentionDocument RawLogRetention = "document"
+	// RawLogRetentionCollection writes the raw logs to the raw logs Firestore collection, keyed by the
+	// ID of the document decoded from them.
+	RawLogRetentionCollection RawLogRetention = "collection"
+)
+
+// getRawLogRetention returns the retention configured in RAW_LOG_RETENTION, defaulting to off.
+func getRawLogRetention() (RawLogRetention, error) {
+	switch retention := RawLogRetention(os.Getenv("RAW_LOG_RETENTION")); retention {
+	case "":
+		return RawLogRetentionOff, nil
+	case RawLogRetentionOff, RawLogRetentionDocument, RawLogRetentionCollection:
+		return retention, nil
+	default:
+		return "", fmt.Errorf("invalid RAW_LOG_RETENTION %q", retention)
+	}
+}
+
+// RawLog is a log exactly as the webhook delivered it, enough to decode it again.
+type RawLog struct {
+	Address string   `json:"address"`
+	Data    string   `json:"data"`
+	Topics  []string `json:"topics"`
+}
+
+// RawLogDocument stores the raw log of a document in the raw logs collection.
+type RawLogDocument struct {
+	SchemaVersion   int    `json:"schemaVersion"`
+	ID              string `json:"id"`
+	Kind            string `json:"kind"`
+	Block           Block  `json:"block"`
+	TransactionHash string `json:"transactionHash"`
+	LogIndex        int    `json:"logIndex"`
+	Network         string `json:"network"`
+	Log             RawLog `json:"log"`
+}
+
+// DocumentID returns the ID of the document decoded from the log.
+func (d *RawLogDocument) DocumentID() string {
+	return d.ID
+}
+
+// applyRawLogRetention attaches the raw webhook log behind every transfer, approval, swap and
+// event in parsed according to retention. Documents that do not come from a webhook log, such
+// as native and address activity transfers, are left alone.
+func applyRawLogRetention(retention RawLogRetention, webhook *WebhookEvent, parsed *ParsedWebhook) {
+	logs := webhook.Event.Data.Block.Logs
+	if retention == RawLogRetentionOff || len(logs) == 0 {
+		return
+	}
+	byIndex := make(map[int]*RawLog, len(logs))
+	for _, log := range logs {
+		if !log.Removed {
+			byIndex[log.Index] = &RawLog{Address: log.Account.Address, Data: log.Data, Topics: log.Topics}
+		}
+	}
+
+	retain := func(kind string, doc Document, block Block, transaction Transaction, logIndex int, network string) *RawLog {
+		raw := byIndex[logIndex]
+		if raw == nil || retention == RawLogRetentionDocument {
+			return raw
+		}
+		parsed.RawLogs = append(parsed.RawLogs, &RawLogDocument{
+			SchemaVersion:   SchemaVersion,
+			ID:              doc.DocumentID(),
+			Kind:            kind,
+			Block:           block,
+			TransactionHash: transaction.Hash,
+			LogIndex:        logIndex,
+			Network:         network,
+			Log:             *raw,
+		})
+		return nil
+	}
+	for _, transfers := range [][]*TransferDocument{parsed.Transfers, parsed.Reverted} {
+		for _, doc := range transfers {
+			if !doc.isNative() {
+				doc.RawLog = retain(KindTransfer, doc, doc.Block, doc.Transaction, doc.Transfer.LogIndex, doc.Network)
+			}
+		}
+	}
+	for _, doc := range parsed.Approvals {
+		doc.RawLog = retain(KindApproval, doc, doc.Block, doc.Transaction, doc.Approval.LogIndex, doc.Network)
+	}
+	for _, doc := range parsed.Swaps {
+		doc.RawLog = retain(KindSwap, doc, doc.Block, doc.Transaction, doc.Swap.LogIndex, doc.Network)
+	}
+	for _, doc := range parsed.Events {
+		doc.RawLog = retain(KindEvent, doc, doc.Block, doc.Transaction, doc.Event.LogIndex, doc.Network)
+	}
+}
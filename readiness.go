@@ -0,0 +1,146 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Readiness check statuses reported in ReadinessCheck.Status.
+const (
+	ReadinessOK   = "ok"
+	ReadinessWarn = "warn"
+	ReadinessFail = "fail"
+)
+
+// ReadinessCheck is the outcome of one readiness check.
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessReport is the outcome of CheckReadiness. Ready is false when any check failed;
+// warnings do not affect it. Providers holds the call counters of the enrichment providers used
+// since start-up and never affects Ready.
+type ReadinessReport struct {
+	Ready     bool             `json:"ready"`
+	Checks    []ReadinessCheck `json:"checks"`
+	Providers []ProviderHealth `json:"providers,omitempty"`
+}
+
+// CheckReadiness reports whether the instance is configured to process webhooks: the
+// configuration loads, every enabled sink initializes and the Pub/Sub topics it publishes to,
+// ALCHEMY_OPS_TOPIC included, exist and accept messages from PUBSUB_REGION. Unlike WarmUp it
+// runs every check instead of stopping at the first failure, and verifies the topics on every
+// call, so a topic deleted after start-up is reported.
+func CheckReadiness(ctx context.Context) ReadinessReport {
+	report := ReadinessReport{Ready: true}
+	add := func(name string, err error, warnings ...string) {
+		check := ReadinessCheck{Name: name, Status: ReadinessOK}
+		switch {
+		case err != nil:
+			check.Status, check.Message = ReadinessFail, err.Error()
+			report.Ready = false
+		case len(warnings) > 0:
+			check.Status, check.Message = ReadinessWarn, strings.Join(warnings, "; ")
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	add("config", checkConfiguration())
+
+	enabled, err := enabledSinks()
+	if err == nil {
+		_, _, err = getSinkFanOut()
+	}
+	add("sinks", err)
+	for _, entry := range enabled {
+		breaker, err := getSinkBreaker(entry.sink.Name())
+		if err != nil {
+			add("sink:"+entry.sink.Name(), err)
+			continue
+		}
+		var warnings []string
+		if state := breaker.circuit(); state != circuitClosed {
+			warnings = append(warnings, "circuit "+state)
+		}
+		add("sink:"+entry.sink.Name(), entry.init(ctx), warnings...)
+	}
+
+	if sinkEnabled(sinkPubSub) && os.Getenv("PUBSUB_VERIFY_TOPICS") != "false" {
+		topics, err := pubSubTopics()
+		var warnings []string
+		if err == nil {
+			warnings, err = verifyPubSubTopics(ctx, topics)
+		}
+		add("pubsub_topics", err, warnings...)
+	}
+	if topicID := os.Getenv("ALCHEMY_OPS_TOPIC"); topicID != "" {
+		warnings, err := verifyPubSubTopics(ctx, []string{topicID})
+		add("ops_topic", err, warnings...)
+	}
+	report.Providers = ProvidersHealth()
+	return report
+}
+
+// checkConfiguration loads the pipeline config, signing key, GraphQL mapping and the pipeline
+// settings WarmUp loads, plus the PagerDuty and deferred processing settings, returning the
+// first error.
+func checkConfiguration() error {
+	if err := LoadPipelineConfig(); err != nil {
+		return err
+	}
+	if os.Getenv("ALCHEMY_SIGNING_KEY") == "" {
+		return errors.New("ALCHEMY_SIGNING_KEY environment variable is not set")
+	}
+	if _, err := LoadGraphQLMapping(); err != nil {
+		return err
+	}
+	if _, err := loadHandlerSettings(); err != nil {
+		return err
+	}
+	if _, err := getPagerDutyAlerter(); err != nil {
+		return err
+	}
+	if deferredProcessingEnabled() {
+		if _, err := getDeferredTarget(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleReadiness answers unsigned GET /readyz requests with 200 when the instance is ready and
+// 503 otherwise. The body lists each check's name and status and each provider's counters only;
+// failures, warnings and providers' last errors are logged with their messages, which may name
+// internal resources.
+func handleReadiness(w http.ResponseWriter, ctx context.Context) {
+	report := CheckReadiness(ctx)
+	for i, check := range report.Checks {
+		if check.Status != ReadinessOK {
+			log.Printf(`{"level":"warn","message":"readiness check %s: %s","check":"%s","status":"%s"}`,
+				check.Name, check.Message, check.Name, check.Status)
+		}
+		report.Checks[i].Message = ""
+	}
+	for i, health := range report.Providers {
+		if health.LastError != "" {
+			log.Printf(`{"level":"warn","message":"provider %s: %d of %d requests failed, last error: %s","provider":"%s","requests":%d,"failures":%d,"retries":%d}`,
+				health.Provider, health.Failures, health.Requests, health.LastError, health.Provider, health.Requests, health.Failures, health.Retries)
+		}
+		report.Providers[i].LastError = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logError("failed to write readiness report", err)
+	}
+}
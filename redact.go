@@ -0,0 +1,123 @@
+package function
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+// RedactionRules drop or hash document fields of a sink at serialization time. Fields are
+// dotted JSON paths within each document, such as alchemy.webhookId or transfer.from.
+type RedactionRules struct {
+	Drop [][]string
+	Hash [][]string
+	key  []byte
+}
+
+// getRedactionRules returns the rules configured for sink in <SINK>_REDACT_DROP and
+// <SINK>_REDACT_HASH (comma-separated field paths), or nil when neither is set. Hashed fields
+// become hex HMAC-SHA256 digests keyed by PSEUDONYMIZE_KEY.
+func getRedactionRules(sink string) (*RedactionRules, error) {
+	prefix := strings.ToUpper(sink) + "_REDACT_"
+	drop := parseList(os.Getenv(prefix + "DROP"))
+	hash := parseList(os.Getenv(prefix + "HASH"))
+	if len(drop) == 0 && len(hash) == 0 {
+		return nil, nil
+	}
+
+	rules := &RedactionRules{}
+	for _, field := range drop {
+		rules.Drop = append(rules.Drop, strings.Split(field, "."))
+	}
+	for _, field := range hash {
+		rules.Hash = append(rules.Hash, strings.Split(field, "."))
+	}
+	if len(rules.Hash) > 0 {
+		key := os.Getenv("PSEUDONYMIZE_KEY")
+		if key == "" {
+			return nil, errors.New(prefix + "HASH requires PSEUDONYMIZE_KEY")
+		}
+		rules.key = []byte(key)
+	}
+	return rules, nil
+}
+
+// redactingSerializer applies redaction rules before encoding with the wrapped serializer.
+type redactingSerializer struct {
+	Serializer
+	rules *RedactionRules
+}
+
+func (s redactingSerializer) Marshal(v any) ([]byte, error) {
+	value, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(*MessageEnvelope); ok {
+		redactEnvelope(s.rules, value.(map[string]any))
+	} else {
+		s.rules.apply(value)
+	}
+	return s.Serializer.Marshal(value)
+}
+
+// apply drops and hashes the fields of the rules in value, a JSON-decoded document or array.
+func (r *RedactionRules) apply(value any) {
+	if r == nil {
+		return
+	}
+	for _, path := range r.Drop {
+		redactField(value, path, func(map[string]any, string) any { return nil })
+	}
+	for _, path := range r.Hash {
+		redactField(value, path, r.hash)
+	}
+}
+
+// hash returns the hex HMAC-SHA256 digest of a field value; strings are hashed as is,
+// other values by their JSON encoding.
+func (r *RedactionRules) hash(doc map[string]any, field string) any {
+	var data []byte
+	switch v := doc[field].(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return v
+		}
+		data = []byte(v)
+	default:
+		data, _ = json.Marshal(v)
+	}
+	h := hmac.New(sha256.New, r.key)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// redactField replaces the field at path in every document of value with the result of
+// redact, removing it when redact returns nil. Arrays apply the path to each element.
+func redactField(value any, path []string, redact func(doc map[string]any, field string) any) {
+	switch v := value.(type) {
+	case []any:
+		for _, item := range v {
+			redactField(item, path, redact)
+		}
+	case map[string]any:
+		if len(path) > 1 {
+			redactField(v[path[0]], path[1:], redact)
+			return
+		}
+		if _, ok := v[path[0]]; !ok {
+			return
+		}
+		if redacted := redact(v, path[0]); redacted != nil {
+			v[path[0]] = redacted
+		} else {
+			delete(v, path[0])
+		}
+	}
+}
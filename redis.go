@@ -0,0 +1,140 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisStream = "alchemy:transfers"
+	// defaultRedisStreamMaxLen bounds the stream's memory while leaving consumers that fall
+	// behind room to catch up.
+	defaultRedisStreamMaxLen = 100000
+)
+
+// redisSink appends one entry per transfer to the Redis stream REDIS_STREAM on the server at
+// REDIS_URL, serialized with REDIS_SERIALIZER in the entry's data field, so consumers can tail
+// transfers with XREAD or consumer groups without Pub/Sub subscriptions. Each XADD trims the
+// stream to about REDIS_STREAM_MAXLEN entries, or not at all when it is 0; trimming is
+// approximate, so Redis only drops whole nodes, which keeps XADD cheap.
+type redisSink struct {
+	client     *redis.Client
+	stream     string
+	maxLen     int64
+	serializer Serializer
+}
+
+func (*redisSink) Name() string { return sinkRedis }
+
+// Init reads the connection and stream configuration and checks that the server is reachable.
+func (s *redisSink) Init(ctx context.Context) error {
+	rawURL := os.Getenv("REDIS_URL")
+	if rawURL == "" {
+		return errors.New("REDIS_URL must be set")
+	}
+	options, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	serializer, err := sinkSerializer(sinkRedis)
+	if err != nil {
+		return err
+	}
+	maxLen := envInt("REDIS_STREAM_MAXLEN", defaultRedisStreamMaxLen)
+	if maxLen < 0 {
+		return fmt.Errorf("invalid REDIS_STREAM_MAXLEN %d", maxLen)
+	}
+	stream := os.Getenv("REDIS_STREAM")
+	if stream == "" {
+		stream = defaultRedisStream
+	}
+
+	client := redis.NewClient(options)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to reach REDIS_URL: %w", err)
+	}
+	s.client, s.stream, s.maxLen, s.serializer = client, stream, int64(maxLen), serializer
+	return nil
+}
+
+// Write appends the webhook's transfers, then the tombstones of transfers removed by a reorg, in
+// one pipeline, and fails when any XADD does.
+func (s *redisSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	var entries []*redis.XAddArgs
+	for _, doc := range parsed.Transfers {
+		entry, err := s.entry("transfer", doc, doc.Alchemy, doc.Network)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.Kind != KindTransfer {
+			continue
+		}
+		entry, err := s.entry("tombstone", tombstone, tombstone.Alchemy, tombstone.Network)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	cmds, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			pipe.XAdd(ctx, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry returns the XADD arguments of doc, with its document ID and the Pub/Sub message
+// attributes as fields next to the serialized data.
+func (s *redisSink) entry(kind string, doc Document, alchemy AlchemyMetadata, network string) (*redis.XAddArgs, error) {
+	data, err := s.serializer.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s %s: %w", kind, doc.DocumentID(), err)
+	}
+	return &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: s.maxLen > 0,
+		Values: []any{
+			"type", kind,
+			"id", doc.DocumentID(),
+			"webhook_id", alchemy.WebhookID,
+			"event_id", alchemy.EventID,
+			"network", network,
+			"content_type", s.serializer.ContentType(),
+			"schema_version", strconv.Itoa(SchemaVersion),
+			"data", data,
+		},
+	}, nil
+}
+
+// Close closes the connection pool.
+func (s *redisSink) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
@@ -0,0 +1,217 @@
+package function
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const tombstonesCollectionName = "alchemy_tombstones"
+
+// Reasons reported in Tombstone.Reason.
+const (
+	// TombstoneReorg marks a document whose log was removed by a chain reorganization.
+	TombstoneReorg = "reorg"
+	// TombstoneOrphaned marks a pending transfer whose block left the canonical chain.
+	TombstoneOrphaned = "orphaned"
+)
+
+// Tombstone identifies a document invalidated by a chain reorganization. ExpireAt is set under
+// RemovedLogSoftDelete, when the document and the tombstone record expire.
+type Tombstone struct {
+	SchemaVersion   int             `json:"schemaVersion"`
+	Collection      string          `json:"collection"`
+	ID              string          `json:"id"`
+	Kind            string          `json:"kind"`
+	Reason          string          `json:"reason"`
+	Block           Block           `json:"block"`
+	TransactionHash string          `json:"transactionHash"`
+	LogIndex        int             `json:"logIndex"`
+	Network         string          `json:"network"`
+	Alchemy         AlchemyMetadata `json:"alchemy"`
+	ExpireAt        *time.Time      `json:"expireAt,omitempty"`
+
+	// reverted reports a transfer from a reverted transaction, whose collection depends on
+	// the failed transaction policy.
+	reverted bool
+	// transfer is the removed transfer, checked against the transfer filter and rules.
+	transfer *TransferDocument
+}
+
+// DocumentID returns the ID of the removed document.
+func (t *Tombstone) DocumentID() string {
+	return t.ID
+}
+
+// newTombstones builds a Tombstone for every document parsed from removed logs.
+func newTombstones(removed *ParsedWebhook) []*Tombstone {
+	var tombstones []*Tombstone
+	for _, doc := range removed.Transfers {
+		tombstone := newTombstone(collectionName, KindTransfer, doc, doc.Block, doc.Transaction, doc.Transfer.LogIndex, doc.Network, doc.Alchemy)
+		tombstone.reverted = doc.Transaction.failed()
+		tombstone.transfer = doc
+		tombstones = append(tombstones, tombstone)
+	}
+	for _, doc := range removed.Approvals {
+		tombstones = append(tombstones, newTombstone(approvalsCollectionName, KindApproval, doc, doc.Block, doc.Transaction, doc.Approval.LogIndex, doc.Network, doc.Alchemy))
+	}
+	for _, doc := range removed.Swaps {
+		tombstones = append(tombstones, newTombstone(swapsCollectionName, KindSwap, doc, doc.Block, doc.Transaction, doc.Swap.LogIndex, doc.Network, doc.Alchemy))
+	}
+	for _, doc := range removed.Events {
+		tombstones = append(tombstones, newTombstone(eventsCollectionName, KindEvent, doc, doc.Block, doc.Transaction, doc.Event.LogIndex, doc.Network, doc.Alchemy))
+	}
+	return tombstones
+}
+
+func newTombstone(collection, kind string, doc Document, block Block, transaction Transaction, logIndex int, network string, alchemy AlchemyMetadata) *Tombstone {
+	return &Tombstone{
+		SchemaVersion:   SchemaVersion,
+		Collection:      collection,
+		ID:              doc.DocumentID(),
+		Kind:            kind,
+		Reason:          TombstoneReorg,
+		Block:           block,
+		TransactionHash: transaction.Hash,
+		LogIndex:        logIndex,
+		Network:         network,
+		Alchemy:         alchemy,
+	}
+}
+
+// stampTombstones sets the ExpireAt of tombstones that have none to ttl from now under
+// RemovedLogSoftDelete, so published tombstones carry it too.
+func stampTombstones(policy RemovedLogPolicy, ttl time.Duration, tombstones []*Tombstone) {
+	if policy != RemovedLogSoftDelete || len(tombstones) == 0 {
+		return
+	}
+	expireAt := time.Now().UTC().Add(ttl)
+	for _, tombstone := range tombstones {
+		if tombstone.ExpireAt == nil {
+			tombstone.ExpireAt = &expireAt
+		}
+	}
+}
+
+// tombstoneRecord stores a tombstone in the tombstones collection, keyed by the collection and
+// ID of the removed document.
+type tombstoneRecord Tombstone
+
+// DocumentID returns the ID of the tombstone record.
+func (r *tombstoneRecord) DocumentID() string {
+	return r.Collection + "-" + r.ID
+}
+
+// ApplyTombstones marks the documents named by tombstones as removed, or deletes them under
+// RemovedLogDelete. Marking updates Removed and RemovedAt fields, leaving the rest of the
+// document intact, and skips documents that were never written. Under RemovedLogSoftDelete it
+// also updates RemovedReason and an ExpireAt REMOVED_LOG_TTL from now, and writes the
+// tombstones, with the same ExpireAt, to the tombstones collection.
+func (f *FirestoreWriter) ApplyTombstones(ctx context.Context, policy RemovedLogPolicy, tombstones []*Tombstone) error {
+	if policy == RemovedLogSoftDelete {
+		ttl, err := getRemovedLogTTL()
+		if err != nil {
+			return err
+		}
+		stampTombstones(policy, ttl, tombstones)
+	}
+	now := time.Now().UTC()
+
+	byCollection := make(map[string][]*Tombstone)
+	var collections []string
+	for _, tombstone := range tombstones {
+		if _, ok := byCollection[tombstone.Collection]; !ok {
+			collections = append(collections, tombstone.Collection)
+		}
+		byCollection[tombstone.Collection] = append(byCollection[tombstone.Collection], tombstone)
+	}
+
+	for _, collection := range collections {
+		docs := byCollection[collection]
+		if policy == RemovedLogDelete {
+			if err := deleteBatchDocuments(ctx, f.client, collection, docs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := markRemovedDocuments(ctx, f.client, collection, docs, now); err != nil {
+			return err
+		}
+	}
+
+	if policy != RemovedLogSoftDelete {
+		return nil
+	}
+	return recordTombstones(ctx, f.client, tombstones)
+}
+
+// recordTombstones writes tombstones to the tombstones collection.
+func recordTombstones(ctx context.Context, client *firestore.Client, tombstones []*Tombstone) error {
+	records := make([]*tombstoneRecord, 0, len(tombstones))
+	for _, tombstone := range tombstones {
+		records = append(records, (*tombstoneRecord)(tombstone))
+	}
+	return writeBatchDocuments(ctx, client, tombstonesCollectionName, records)
+}
+
+// markRemovedDocuments marks the documents of collection named by tombstones as removed at now,
+// with a point update for a single document and otherwise in transactions of up to batchLimit
+// documents. Documents that do not exist, such as those of logs that were filtered out or routed
+// elsewhere, are skipped rather than created with only the removal fields.
+func markRemovedDocuments(ctx context.Context, client *firestore.Client, collection string, tombstones []*Tombstone, now time.Time) error {
+	if len(tombstones) == 1 {
+		_, err := client.Collection(collection).Doc(tombstones[0].DocumentID()).Update(ctx, removedMark(tombstones[0], now))
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		log.Printf(`{"level":"info","message":"document marked removed in firestore","collection":"%s"}`, collection)
+		return nil
+	}
+
+	for start := 0; start < len(tombstones); start += batchLimit {
+		end := min(start+batchLimit, len(tombstones))
+		batch := tombstones[start:end]
+		err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			refs := make([]*firestore.DocumentRef, len(batch))
+			for i, tombstone := range batch {
+				refs[i] = client.Collection(collection).Doc(tombstone.DocumentID())
+			}
+			snapshots, err := tx.GetAll(refs)
+			if err != nil {
+				return err
+			}
+			for i, snapshot := range snapshots {
+				if !snapshot.Exists() {
+					continue
+				}
+				if err := tx.Update(refs[i], removedMark(batch[i], now)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf(`{"level":"info","message":"batch marked removed in firestore","collection":"%s","range":"%d-%d","size":%d}`,
+			collection, start, end, len(batch))
+	}
+	return nil
+}
+
+// removedMark returns the fields updated in the document named by tombstone when it is marked
+// removed at now.
+func removedMark(tombstone *Tombstone, now time.Time) []firestore.Update {
+	mark := []firestore.Update{{Path: "Removed", Value: true}, {Path: "RemovedAt", Value: now}}
+	if tombstone.ExpireAt != nil {
+		mark = append(mark,
+			firestore.Update{Path: "RemovedReason", Value: tombstone.Reason},
+			firestore.Update{Path: "ExpireAt", Value: *tombstone.ExpireAt})
+	}
+	return mark
+}
@@ -0,0 +1,171 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultSinkMaxAttempts = 1
+	// defaultGCPSinkMaxAttempts retries the transient ABORTED and UNAVAILABLE errors of the
+	// Firestore and Pub/Sub sinks within the request, rather than failing the whole webhook for
+	// Alchemy to redeliver.
+	defaultGCPSinkMaxAttempts    = 3
+	defaultSinkInitialBackoff    = 100 * time.Millisecond
+	defaultSinkMaxBackoff        = 5 * time.Second
+	defaultSinkBackoffMultiplier = 2.0
+)
+
+// defaultSinkRetryableCodes are the transient gRPC codes retried unless RETRYABLE_CODES is set.
+var defaultSinkRetryableCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted}
+
+// RetryPolicy controls how a failed sink delivery is retried. The delay before retry n is
+// InitialBackoff × Multiplier^(n-1), capped at MaxBackoff, with full jitter. Only errors whose
+// gRPC code is in RetryableCodes are retried; errors without a gRPC status have code Unknown.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	RetryableCodes []codes.Code
+}
+
+// RetryPolicies holds the retry policy of every sink by name.
+type RetryPolicies map[string]RetryPolicy
+
+// For returns the retry policy of sink, or a single attempt for unknown sinks.
+func (r RetryPolicies) For(sink string) RetryPolicy {
+	if policy, ok := r[sink]; ok {
+		return policy
+	}
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// getRetryPolicies returns the retry policies of the registered sinks.
+func getRetryPolicies() (RetryPolicies, error) {
+	registered := registeredSinks()
+	policies := make(RetryPolicies, len(registered))
+	for _, sink := range registered {
+		name := sink.Name()
+		policy, err := getRetryPolicy(name)
+		if err != nil {
+			return nil, err
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+// getRetryPolicy reads the retry policy of sink from SINK_<NAME>_* environment variables:
+// MAX_ATTEMPTS (default 3 for firestore and pubsub, 1, no retries, otherwise), INITIAL_BACKOFF (100ms), MAX_BACKOFF (5s),
+// BACKOFF_MULTIPLIER (2) and RETRYABLE_CODES, a comma-separated list of gRPC code names
+// (UNAVAILABLE, DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED and ABORTED by default).
+func getRetryPolicy(sink string) (RetryPolicy, error) {
+	prefix := "SINK_" + strings.ToUpper(strings.ReplaceAll(sink, "-", "_")) + "_"
+	policy := RetryPolicy{
+		MaxAttempts:    defaultSinkMaxAttempts,
+		InitialBackoff: defaultSinkInitialBackoff,
+		MaxBackoff:     defaultSinkMaxBackoff,
+		Multiplier:     defaultSinkBackoffMultiplier,
+		RetryableCodes: defaultSinkRetryableCodes,
+	}
+	if sink == sinkFirestore || sink == sinkPubSub {
+		policy.MaxAttempts = defaultGCPSinkMaxAttempts
+	}
+
+	if v := os.Getenv(prefix + "MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return RetryPolicy{}, fmt.Errorf("invalid %sMAX_ATTEMPTS %q", prefix, v)
+		}
+		policy.MaxAttempts = n
+	}
+	for key, target := range map[string]*time.Duration{"INITIAL_BACKOFF": &policy.InitialBackoff, "MAX_BACKOFF": &policy.MaxBackoff} {
+		if v := os.Getenv(prefix + key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return RetryPolicy{}, fmt.Errorf("invalid %s%s %q", prefix, key, v)
+			}
+			*target = d
+		}
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		return RetryPolicy{}, fmt.Errorf("%sMAX_BACKOFF %s is shorter than INITIAL_BACKOFF %s", prefix, policy.MaxBackoff, policy.InitialBackoff)
+	}
+	if v := os.Getenv(prefix + "BACKOFF_MULTIPLIER"); v != "" {
+		m, err := strconv.ParseFloat(v, 64)
+		if err != nil || m < 1 {
+			return RetryPolicy{}, fmt.Errorf("invalid %sBACKOFF_MULTIPLIER %q", prefix, v)
+		}
+		policy.Multiplier = m
+	}
+	if v := os.Getenv(prefix + "RETRYABLE_CODES"); v != "" {
+		policy.RetryableCodes = nil
+		for _, name := range strings.Split(v, ",") {
+			var code codes.Code
+			if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(strings.TrimSpace(name))))); err != nil {
+				return RetryPolicy{}, fmt.Errorf("invalid %sRETRYABLE_CODES entry %q", prefix, name)
+			}
+			policy.RetryableCodes = append(policy.RetryableCodes, code)
+		}
+	}
+	return policy, nil
+}
+
+// Do calls deliver until it succeeds, fails with a non-retryable error, ctx is done or the
+// attempts are exhausted, returning the last error. A retry whose backoff would end past the
+// deadline of ctx is not attempted, so the request fails with the sink's error while there is
+// still time to respond.
+func (p RetryPolicy) Do(ctx context.Context, sink string, deliver func() error) error {
+	var err error
+	for attempt := 1; attempt <= p.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := p.delay(attempt - 1)
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+				return err
+			}
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
+			}
+		}
+		err = deliver()
+		if err == nil || !p.retryable(err) || attempt == p.MaxAttempts {
+			return err
+		}
+		log.Printf(`{"level":"warn","message":"sink delivery failed, retrying","sink":"%s","attempt":%d,"error":"%s"}`,
+			sink, attempt, err.Error())
+	}
+	return err
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	code := status.Code(err)
+	for _, retryable := range p.RetryableCodes {
+		if code == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the jittered backoff before the given retry (starting at 1).
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for range retry - 1 {
+		delay *= p.Multiplier
+		if delay >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	delay = min(delay, float64(p.MaxBackoff))
+	return time.Duration(rand.Int64N(int64(delay)) + 1)
+}
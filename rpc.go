@@ -0,0 +1,74 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// rpcProvider is the ProviderClient name used for the primary Ethereum JSON-RPC endpoint,
+// tuned through PROVIDER_RPC_* environment variables.
+const rpcProvider = "rpc"
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// RPCError is an error returned by the node for a JSON-RPC call, such as a reverted eth_call.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// rpcCall calls method on the endpoint pool of network and decodes its result into out. An empty
+// network uses the default endpoints, ALCHEMY_RPC_URL followed by RPC_FALLBACK_URLS.
+func rpcCall(ctx context.Context, network, method string, params []any, out any) error {
+	pool, err := rpcPoolFor(network)
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		params = []any{}
+	}
+
+	var resp rpcResponse
+	req := rpcRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params}
+	if err := pool.call(ctx, req, &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s failed: %w", method, resp.Error)
+	}
+	if err := json.Unmarshal(resp.Result, out); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// ethCall performs an eth_call of data on contract on network at the latest block.
+// A call the node rejects, such as a revert, returns no data and no error.
+func ethCall(ctx context.Context, network, contract string, data []byte) ([]byte, error) {
+	call := map[string]string{"to": contract, "data": hexutil.Encode(data)}
+	var result hexutil.Bytes
+	err := rpcCall(ctx, network, "eth_call", []any{call, "latest"}, &result)
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return nil, nil
+	}
+	return result, err
+}
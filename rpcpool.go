@@ -0,0 +1,192 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRPCEndpointCooldown = 30 * time.Second
+
+// rpcEndpoint is one node URL of an rpcPool with its passive health state.
+type rpcEndpoint struct {
+	url      string
+	provider *ProviderClient
+
+	mu             sync.Mutex
+	failures       int
+	unhealthyUntil time.Time
+	lastError      string
+}
+
+// rpcPool holds the endpoints of a network in priority order. A call goes to the first healthy
+// endpoint and fails over to the next one when the endpoint fails. A failed endpoint is skipped
+// for the cooldown and then tried again by the next call, which restores it on success.
+type rpcPool struct {
+	network   string
+	endpoints []*rpcEndpoint
+	cooldown  time.Duration
+}
+
+// RPCEndpointHealth is a snapshot of an RPC endpoint's health. Network is empty for the default
+// endpoints. Host omits the URL path and query, which usually carry the API key.
+type RPCEndpointHealth struct {
+	Network             string `json:"network,omitempty"`
+	Host                string `json:"host"`
+	Provider            string `json:"provider"`
+	Healthy             bool   `json:"healthy"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	LastError           string `json:"lastError,omitempty"`
+}
+
+var (
+	rpcPoolsMu sync.Mutex
+	rpcPools   = map[string]*rpcPool{}
+)
+
+// rpcEndpointURLs returns the endpoints configured for network, primary first:
+// RPC_ENDPOINTS_<NETWORK> when set, otherwise ALCHEMY_RPC_URL followed by RPC_FALLBACK_URLS.
+func rpcEndpointURLs(network string) []string {
+	if network != "" {
+		if value := os.Getenv("RPC_ENDPOINTS_" + strings.ToUpper(network)); value != "" {
+			return parseList(value)
+		}
+	}
+	var urls []string
+	if primary := os.Getenv("ALCHEMY_RPC_URL"); primary != "" {
+		urls = append(urls, primary)
+	}
+	return append(urls, parseList(os.Getenv("RPC_FALLBACK_URLS"))...)
+}
+
+// rpcConfigured reports whether any RPC endpoint is configured, for any network.
+func rpcConfigured() bool {
+	if os.Getenv("ALCHEMY_RPC_URL") != "" || os.Getenv("RPC_FALLBACK_URLS") != "" {
+		return true
+	}
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "RPC_ENDPOINTS_") {
+			return true
+		}
+	}
+	return false
+}
+
+// rpcPoolFor returns the shared pool of network, creating it on first use. Networks without
+// RPC_ENDPOINTS_<NETWORK> share the default pool, keyed by "". The endpoint at
+// position i uses the ProviderClient "rpc" for the primary and "rpc<i>" for fallbacks, so each
+// provider keeps its own rate limit and is tuned through PROVIDER_RPC_* or PROVIDER_RPC<i>_*.
+func rpcPoolFor(network string) (*rpcPool, error) {
+	if os.Getenv("RPC_ENDPOINTS_"+strings.ToUpper(network)) == "" {
+		network = ""
+	}
+	rpcPoolsMu.Lock()
+	defer rpcPoolsMu.Unlock()
+
+	if pool, ok := rpcPools[network]; ok {
+		return pool, nil
+	}
+	urls := rpcEndpointURLs(network)
+	if len(urls) == 0 {
+		return nil, errors.New("no RPC endpoint configured: set ALCHEMY_RPC_URL or RPC_ENDPOINTS_<NETWORK>")
+	}
+	pool := &rpcPool{network: network, cooldown: envDuration("RPC_ENDPOINT_COOLDOWN", defaultRPCEndpointCooldown)}
+	for i, endpointURL := range urls {
+		name := rpcProvider
+		if i > 0 {
+			name += strconv.Itoa(i)
+		}
+		pool.endpoints = append(pool.endpoints, &rpcEndpoint{url: endpointURL, provider: ProviderFor(name)})
+	}
+	rpcPools[network] = pool
+	return pool, nil
+}
+
+// call posts req to the first healthy endpoint, failing over in order. When every endpoint is
+// cooling down they are all tried anyway, since a stale failure beats no answer.
+func (p *rpcPool) call(ctx context.Context, req rpcRequest, resp *rpcResponse) error {
+	now := time.Now()
+	var candidates, cooling []*rpcEndpoint
+	for _, endpoint := range p.endpoints {
+		if endpoint.healthy(now) {
+			candidates = append(candidates, endpoint)
+		} else {
+			cooling = append(cooling, endpoint)
+		}
+	}
+	candidates = append(candidates, cooling...)
+
+	var errs []error
+	for _, endpoint := range candidates {
+		*resp = rpcResponse{}
+		err := endpoint.provider.PostJSON(ctx, endpoint.url, nil, req, resp)
+		if err == nil {
+			endpoint.recordSuccess()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		endpoint.recordFailure(err, p.cooldown)
+		log.Printf(`{"level":"warn","message":"rpc endpoint failed","network":"%s","provider":"%s","error":"%s"}`,
+			p.network, endpoint.provider.name, err.Error())
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("all RPC endpoints failed: %w", errors.Join(errs...))
+}
+
+func (e *rpcEndpoint) healthy(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !now.Before(e.unhealthyUntil)
+}
+
+func (e *rpcEndpoint) recordSuccess() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = 0
+	e.unhealthyUntil = time.Time{}
+}
+
+func (e *rpcEndpoint) recordFailure(err error, cooldown time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures++
+	e.unhealthyUntil = time.Now().Add(cooldown)
+	e.lastError = err.Error()
+}
+
+// RPCEndpointsHealth returns the health of every RPC endpoint used by this instance.
+func RPCEndpointsHealth() []RPCEndpointHealth {
+	rpcPoolsMu.Lock()
+	defer rpcPoolsMu.Unlock()
+
+	now := time.Now()
+	var health []RPCEndpointHealth
+	for _, pool := range rpcPools {
+		for _, endpoint := range pool.endpoints {
+			host := endpoint.url
+			if parsed, err := url.Parse(endpoint.url); err == nil {
+				host = parsed.Host
+			}
+			endpoint.mu.Lock()
+			health = append(health, RPCEndpointHealth{
+				Network:             pool.network,
+				Host:                host,
+				Provider:            endpoint.provider.name,
+				Healthy:             !now.Before(endpoint.unhealthyUntil),
+				ConsecutiveFailures: endpoint.failures,
+				LastError:           endpoint.lastError,
+			})
+			endpoint.mu.Unlock()
+		}
+	}
+	return health
+}
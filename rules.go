@@ -0,0 +1,304 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"google.golang.org/api/iterator"
+)
+
+// Rule actions.
+const (
+	// RuleDrop discards matching transfers before any sink.
+	RuleDrop = "drop"
+	// RuleRoute sends matching transfers only to the rule's sinks, topic and collection.
+	RuleRoute = "route"
+)
+
+// RuleConfig describes a routing rule. When is a CEL expression over doc, the transfer document
+// as its JSON map, that must evaluate to a bool. A route rule limits the sinks a matching transfer
+// is delivered to (all sinks when empty) and overrides its Pub/Sub topic and Firestore collection
+// when set.
+type RuleConfig struct {
+	Name       string   `json:"name" firestore:"name"`
+	When       string   `json:"when" firestore:"when"`
+	Action     string   `json:"action" firestore:"action"`
+	Sinks      []string `json:"sinks,omitempty" firestore:"sinks"`
+	Topic      string   `json:"topic,omitempty" firestore:"topic"`
+	Collection string   `json:"collection,omitempty" firestore:"collection"`
+}
+
+// Rule is a compiled RuleConfig.
+type Rule struct {
+	RuleConfig
+	program cel.Program
+}
+
+var (
+	rulesOnce sync.Once
+	rules     []*Rule
+	rulesErr  error
+)
+
+// LoadRules returns the rules configured as a JSON array in RULES or the file at RULES_FILE,
+// followed by the documents of the RULES_COLLECTION Firestore collection in document ID order,
+// compiled once per instance. It returns nil when no source is set.
+func LoadRules() ([]*Rule, error) {
+	rulesOnce.Do(func() {
+		rules, rulesErr = loadRules(context.Background())
+	})
+	return rules, rulesErr
+}
+
+func loadRules(ctx context.Context) ([]*Rule, error) {
+	data := []byte(os.Getenv("RULES"))
+	if path := os.Getenv("RULES_FILE"); path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RULES_FILE: %w", err)
+		}
+	}
+	var configs []RuleConfig
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("failed to parse rules: %w", err)
+		}
+	}
+	if collection := os.Getenv("RULES_COLLECTION"); collection != "" {
+		loaded, err := loadRulesCollection(ctx, collection)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load RULES_COLLECTION: %w", err)
+		}
+		configs = append(configs, loaded...)
+	}
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	env, err := cel.NewEnv(cel.Variable("doc", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		return nil, err
+	}
+	compiled := make([]*Rule, 0, len(configs))
+	for _, config := range configs {
+		rule, err := compileRule(env, config)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+func loadRulesCollection(ctx context.Context, collection string) ([]RuleConfig, error) {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iter := client.Collection(collection).Documents(ctx)
+	defer iter.Stop()
+	var configs []RuleConfig
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return configs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var config RuleConfig
+		if err := snapshot.DataTo(&config); err != nil {
+			return nil, err
+		}
+		if config.Name == "" {
+			config.Name = snapshot.Ref.ID
+		}
+		configs = append(configs, config)
+	}
+}
+
+// compileRule validates config and compiles its expression in env.
+func compileRule(env *cel.Env, config RuleConfig) (*Rule, error) {
+	if config.Name == "" {
+		return nil, errors.New("rule name is required")
+	}
+	switch config.Action {
+	case RuleDrop:
+	case RuleRoute:
+		if len(config.Sinks) == 0 && config.Topic == "" && config.Collection == "" {
+			return nil, fmt.Errorf("route rule %s sets no sinks, topic or collection", config.Name)
+		}
+		for _, sink := range config.Sinks {
+			if !knownSink(sink) {
+				return nil, fmt.Errorf("rule %s routes to unknown sink %q", config.Name, sink)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("rule %s has invalid action %q", config.Name, config.Action)
+	}
+
+	ast, issues := env.Compile(config.When)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("rule %s: %w", config.Name, issues.Err())
+	}
+	if output := ast.OutputType(); output != cel.BoolType && output != cel.DynType {
+		return nil, fmt.Errorf("rule %s: expression must evaluate to a bool, not %s", config.Name, output)
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", config.Name, err)
+	}
+	return &Rule{RuleConfig: config, program: program}, nil
+}
+
+// knownSink reports whether name is a registered sink.
+func knownSink(name string) bool {
+	return lookupSink(name) != nil
+}
+
+// match returns the first rule whose expression holds for doc. Rules that fail to evaluate,
+// for example on a field the document does not have, are logged and treated as not matching.
+func match(rules []*Rule, doc *TransferDocument) *Rule {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	for _, rule := range rules {
+		out, _, err := rule.program.Eval(map[string]any{"doc": fields})
+		if err != nil {
+			log.Printf(`{"level":"warn","message":"failed to evaluate rule","rule":"%s","document_id":"%s","error":"%s"}`,
+				rule.Name, doc.DocumentID(), err.Error())
+			continue
+		}
+		if matched, ok := out.Value().(bool); ok && matched {
+			return rule
+		}
+	}
+	return nil
+}
+
+// applyRules matches every transfer of parsed against rules, removing transfers and their
+// tombstones matched by a drop rule and recording the route of the rest. It returns how many
+// transfers were dropped.
+func applyRules(rules []*Rule, parsed *ParsedWebhook) int {
+	if len(rules) == 0 {
+		return 0
+	}
+	route := func(transfers []*TransferDocument) []*TransferDocument {
+		kept := transfers[:0]
+		for _, doc := range transfers {
+			rule := match(rules, doc)
+			if rule != nil && rule.Action == RuleDrop {
+				continue
+			}
+			doc.route = rule
+			kept = append(kept, doc)
+		}
+		return kept
+	}
+	count := len(parsed.Transfers) + len(parsed.Reverted)
+	parsed.Transfers = route(parsed.Transfers)
+	parsed.Reverted = route(parsed.Reverted)
+
+	tombstones := parsed.Tombstones[:0]
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.transfer != nil {
+			rule := match(rules, tombstone.transfer)
+			if rule != nil && rule.Action == RuleDrop {
+				continue
+			}
+			tombstone.transfer.route = rule
+			if rule.collection() != "" {
+				tombstone.Collection = rule.collection()
+			}
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	parsed.Tombstones = tombstones
+	return count - len(parsed.Transfers) - len(parsed.Reverted)
+}
+
+// allows reports whether a transfer routed by r may be delivered to sink.
+func (r *Rule) allows(sink string) bool {
+	return r == nil || len(r.Sinks) == 0 || slices.Contains(r.Sinks, sink)
+}
+
+// topic returns the Pub/Sub topic a transfer routed by r is published to, or "" for the default.
+func (r *Rule) topic() string {
+	if r == nil {
+		return ""
+	}
+	return r.Topic
+}
+
+// collection returns the Firestore collection a transfer routed by r is written to, or "" for
+// the default.
+func (r *Rule) collection() string {
+	if r == nil {
+		return ""
+	}
+	return r.Collection
+}
+
+// routeToSink returns parsed without the transfers, and their tombstones, that rules route away
+// from sink, or parsed unchanged when none are.
+func routeToSink(sink string, parsed *ParsedWebhook) *ParsedWebhook {
+	allowed := func(doc *TransferDocument) bool { return doc.route.allows(sink) }
+	if !slices.ContainsFunc(parsed.Transfers, func(doc *TransferDocument) bool { return !allowed(doc) }) &&
+		!slices.ContainsFunc(parsed.Reverted, func(doc *TransferDocument) bool { return !allowed(doc) }) &&
+		!slices.ContainsFunc(parsed.Tombstones, func(t *Tombstone) bool { return t.transfer != nil && !allowed(t.transfer) }) {
+		return parsed
+	}
+
+	routed := *parsed
+	routed.Transfers = nil
+	for _, doc := range parsed.Transfers {
+		if allowed(doc) {
+			routed.Transfers = append(routed.Transfers, doc)
+		}
+	}
+	routed.Reverted = nil
+	for _, doc := range parsed.Reverted {
+		if allowed(doc) {
+			routed.Reverted = append(routed.Reverted, doc)
+		}
+	}
+	routed.Tombstones = nil
+	for _, tombstone := range parsed.Tombstones {
+		if tombstone.transfer == nil || allowed(tombstone.transfer) {
+			routed.Tombstones = append(routed.Tombstones, tombstone)
+		}
+	}
+	return &routed
+}
+
+// groupTransfers splits transfers by the destination key returns, in order of first appearance.
+// A single transfer, the common case, is returned as its own group without copying.
+func groupTransfers(transfers []*TransferDocument, key func(*TransferDocument) string) ([]string, map[string][]*TransferDocument) {
+	if len(transfers) == 1 {
+		k := key(transfers[0])
+		return []string{k}, map[string][]*TransferDocument{k: transfers}
+	}
+	var keys []string
+	groups := make(map[string][]*TransferDocument)
+	for _, doc := range transfers {
+		k := key(doc)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], doc)
+	}
+	return keys, groups
+}
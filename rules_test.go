@@ -0,0 +1,18 @@
+package function
+
+import "testing"
+
+func BenchmarkGroupTransfersSingle(b *testing.B) {
+	parsed, err := ParseWebhook(singleTransferWebhook(b), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		keys, groups := groupTransfers(parsed.Transfers, func(doc *TransferDocument) string { return doc.route.collection() })
+		if len(keys) != 1 || len(groups[keys[0]]) != 1 {
+			b.Fatalf("got keys %q, want a single group", keys)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultLeaseCollection = "alchemy_leases"
+	defaultLeaseName       = "scheduler"
+	defaultLeaseDuration   = 30 * time.Second
+)
+
+// scheduledJobRunners are the scheduled entry points a server can run itself, by name.
+var scheduledJobRunners = map[string]func(context.Context) error{
+	"PublishOutbox": func(ctx context.Context) error {
+		_, err := PublishOutbox(ctx)
+		return err
+	},
+	"ConfirmTransfers": func(ctx context.Context) error {
+		_, err := ConfirmTransfers(ctx)
+		return err
+	},
+	"SendNotificationDigests": func(ctx context.Context) error {
+		_, err := SendNotificationDigests(ctx)
+		return err
+	},
+}
+
+// ScheduledJob is a scheduled entry point run every Interval by RunScheduledJobs.
+type ScheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func(context.Context) error
+}
+
+// ScheduledJobs returns the jobs of SCHEDULED_JOBS, comma-separated name=interval pairs such as
+// PublishOutbox=1m, naming PublishOutbox, ConfirmTransfers or SendNotificationDigests.
+func ScheduledJobs() ([]ScheduledJob, error) {
+	var jobs []ScheduledJob
+	for _, item := range parseList(os.Getenv("SCHEDULED_JOBS")) {
+		name, value, _ := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		run, ok := scheduledJobRunners[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown SCHEDULED_JOBS job %q", name)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid SCHEDULED_JOBS interval %q for %s", value, name)
+		}
+		if slices.ContainsFunc(jobs, func(job ScheduledJob) bool { return job.Name == name }) {
+			return nil, fmt.Errorf("duplicate SCHEDULED_JOBS job %q", name)
+		}
+		jobs = append(jobs, ScheduledJob{Name: name, Interval: interval, Run: run})
+	}
+	return jobs, nil
+}
+
+// LeaseDocument is the leader lease stored in LEADER_LEASE_COLLECTION.
+type LeaseDocument struct {
+	Holder   string    `firestore:"Holder"`
+	ExpireAt time.Time `firestore:"ExpireAt"`
+}
+
+// availableTo reports whether holder may take or renew the lease at now.
+func (d *LeaseDocument) availableTo(holder string, now time.Time) bool {
+	return d.Holder == holder || !d.ExpireAt.After(now)
+}
+
+// leaderLease is one replica's claim on a Firestore lease document.
+type leaderLease struct {
+	client   *firestore.Client
+	ref      *firestore.DocumentRef
+	holder   string
+	duration time.Duration
+}
+
+// newLeaderLease returns the lease LEADER_LEASE_NAME (default scheduler) in
+// LEADER_LEASE_COLLECTION (default alchemy_leases), held for LEADER_LEASE_DURATION (default 30s)
+// at a time under the host name and a random suffix.
+func newLeaderLease(ctx context.Context) (*leaderLease, error) {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection := os.Getenv("LEADER_LEASE_COLLECTION")
+	if collection == "" {
+		collection = defaultLeaseCollection
+	}
+	name := os.Getenv("LEADER_LEASE_NAME")
+	if name == "" {
+		name = defaultLeaseName
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &leaderLease{
+		client:   client,
+		ref:      client.Collection(collection).Doc(name),
+		holder:   host + "-" + newInstanceID(),
+		duration: envDuration("LEADER_LEASE_DURATION", defaultLeaseDuration),
+	}, nil
+}
+
+// acquire takes the lease, or renews it when this replica holds it, in a transaction, reporting
+// false when another replica holds an unexpired lease.
+func (l *leaderLease) acquire(ctx context.Context) (bool, error) {
+	acquired := false
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		acquired = false
+		now := time.Now().UTC()
+		snapshot, err := tx.Get(l.ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if snapshot.Exists() {
+			var doc LeaseDocument
+			if err := snapshot.DataTo(&doc); err != nil {
+				return err
+			}
+			if !doc.availableTo(l.holder, now) {
+				return nil
+			}
+		}
+		acquired = true
+		return tx.Set(l.ref, LeaseDocument{Holder: l.holder, ExpireAt: now.Add(l.duration)})
+	})
+	return acquired, err
+}
+
+// release deletes the lease when this replica holds it, so another one takes over without
+// waiting for it to expire.
+func (l *leaderLease) release(ctx context.Context) error {
+	return l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(l.ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil
+			}
+			return err
+		}
+		var doc LeaseDocument
+		if err := snapshot.DataTo(&doc); err != nil {
+			return err
+		}
+		if doc.Holder != l.holder {
+			return nil
+		}
+		return tx.Delete(l.ref)
+	})
+}
+
+// RunScheduledJobs runs jobs every Interval while this replica holds the Firestore leader lease,
+// so replicas of a long-running server run them once between them, until ctx is done. The lease
+// is renewed every third of LEADER_LEASE_DURATION. A replica that fails to renew it stops leading
+// and cancels its running jobs; one that acquires it runs each job an interval later. Runs of a
+// job never overlap on one replica, and a failed run is logged and retried on the next interval.
+// The lease is released on return.
+func RunScheduledJobs(ctx context.Context, jobs []ScheduledJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	lease, err := newLeaderLease(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open leader lease: %w", err)
+	}
+
+	var stopJobs func()
+	stopLeading := func() {
+		if stopJobs != nil {
+			stopJobs()
+			stopJobs = nil
+			setMetric("alchemy_scheduled_job_leader", 0)
+			log.Printf(`{"level":"info","message":"stopped leading scheduled jobs","holder":"%s"}`, lease.holder)
+		}
+	}
+	defer func() {
+		stopLeading()
+		if err := lease.release(context.Background()); err != nil {
+			logError("failed to release leader lease", err)
+		}
+	}()
+
+	ticker := time.NewTicker(lease.duration / 3)
+	defer ticker.Stop()
+	for {
+		leading, err := lease.acquire(ctx)
+		if err != nil && ctx.Err() == nil {
+			logError("failed to acquire leader lease", err)
+		}
+		switch {
+		case leading && stopJobs == nil:
+			log.Printf(`{"level":"info","message":"leading scheduled jobs","holder":"%s","jobs":%d}`, lease.holder, len(jobs))
+			stopJobs = startScheduledJobs(ctx, jobs)
+			setMetric("alchemy_scheduled_job_leader", 1)
+		case !leading:
+			stopLeading()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// startScheduledJobs runs each of jobs in its own goroutine and returns a function that stops
+// them and waits for their runs to return.
+func startScheduledJobs(ctx context.Context, jobs []ScheduledJob) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runScheduledJob(ctx, job)
+		}()
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// runScheduledJob runs job every Interval until ctx is done.
+func runScheduledJob(ctx context.Context, job ScheduledJob) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := job.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf(`{"level":"error","message":"scheduled job failed","job":"%s","error":"%s"}`, job.Name, err.Error())
+			countMetric("alchemy_scheduled_job_runs_total", 1, "job", job.Name, "result", "error")
+			continue
+		}
+		countMetric("alchemy_scheduled_job_runs_total", 1, "job", job.Name, "result", "success")
+	}
+}
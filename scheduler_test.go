@@ -0,0 +1,64 @@
+package function
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{"unset", "", map[string]time.Duration{}, false},
+		{"jobs", "PublishOutbox=1m, ConfirmTransfers = 5m", map[string]time.Duration{"PublishOutbox": time.Minute, "ConfirmTransfers": 5 * time.Minute}, false},
+		{"unknown job", "Rollups=1h", nil, true},
+		{"missing interval", "PublishOutbox", nil, true},
+		{"zero interval", "PublishOutbox=0s", nil, true},
+		{"duplicate job", "PublishOutbox=1m,PublishOutbox=2m", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SCHEDULED_JOBS", tt.value)
+			jobs, err := ScheduledJobs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(jobs) != len(tt.want) {
+				t.Fatalf("%d jobs, want %d", len(jobs), len(tt.want))
+			}
+			for _, job := range jobs {
+				if job.Interval != tt.want[job.Name] || job.Run == nil {
+					t.Errorf("job %s every %v, want every %v", job.Name, job.Interval, tt.want[job.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestLeaseDocumentAvailableTo(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		doc    LeaseDocument
+		holder string
+		want   bool
+	}{
+		{"held by us", LeaseDocument{Holder: "a", ExpireAt: now.Add(time.Second)}, "a", true},
+		{"held by another", LeaseDocument{Holder: "b", ExpireAt: now.Add(time.Second)}, "a", false},
+		{"expired", LeaseDocument{Holder: "b", ExpireAt: now}, "a", true},
+		{"empty", LeaseDocument{}, "a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.doc.availableTo(tt.holder, now); got != tt.want {
+				t.Errorf("availableTo = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
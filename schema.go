@@ -0,0 +1,121 @@
+package function
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersion is the version of the document schema this function emits, carried by every
+// document as schemaVersion and by every Pub/Sub message as the schema_version attribute. Bump it
+// when a change would break existing readers, and register a SchemaMigration from the previous
+// version so older documents can still be upgraded.
+const SchemaVersion = 1
+
+// MinReaderSchemaVersion is the oldest schema version a reader may support and still read
+// documents of SchemaVersion, because every change since that version only added fields. It is
+// published as the schema_min_version attribute.
+const MinReaderSchemaVersion = 1
+
+// ErrUnsupportedSchemaVersion is returned for documents and messages too new to be read.
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+// SchemaMigration upgrades a JSON-decoded document from one schema version to the next.
+type SchemaMigration interface {
+	// From is the schema version the migration upgrades from, producing version From()+1.
+	From() int
+	// Migrate rewrites doc in place.
+	Migrate(doc map[string]any) error
+}
+
+// schemaMigrations holds the registered migrations by the version they upgrade from.
+var schemaMigrations = map[int]SchemaMigration{}
+
+// RegisterSchemaMigration registers m, replacing any migration from the same version.
+func RegisterSchemaMigration(m SchemaMigration) {
+	schemaMigrations[m.From()] = m
+}
+
+// MigrateDocument upgrades a JSON-decoded document to SchemaVersion in place, applying the
+// registered migration of every version in between. Documents without schemaVersion predate
+// versioning and are read as version 1.
+func MigrateDocument(doc map[string]any) error {
+	version := 1
+	if value, ok := doc["schemaVersion"].(float64); ok {
+		version = int(value)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: document has version %d, newest supported is %d", ErrUnsupportedSchemaVersion, version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		migration, ok := schemaMigrations[version]
+		if !ok {
+			return fmt.Errorf("no schema migration from version %d", version)
+		}
+		if err := migration.Migrate(doc); err != nil {
+			return fmt.Errorf("schema migration from version %d: %w", version, err)
+		}
+	}
+	doc["schemaVersion"] = SchemaVersion
+	return nil
+}
+
+// migratePayload upgrades every document of a JSON array payload to SchemaVersion.
+func migratePayload(data []byte) ([]byte, error) {
+	var docs []map[string]any
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if err := MigrateDocument(doc); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(docs)
+}
+
+// schemaAttributes returns the Pub/Sub attributes announcing the schema of published documents.
+func schemaAttributes() map[string]string {
+	return map[string]string{
+		"schema_version":     strconv.Itoa(SchemaVersion),
+		"schema_min_version": strconv.Itoa(MinReaderSchemaVersion),
+	}
+}
+
+// NegotiateSchema returns the schema version of a message from its attributes, failing when a
+// reader of SchemaVersion cannot read it. Messages newer than SchemaVersion are accepted while
+// their schema_min_version allows it. Messages without schema_version predate versioning and
+// are read as version 1, and messages without schema_min_version are only readable at their own
+// version.
+func NegotiateSchema(attributes map[string]string) (int, error) {
+	version, err := schemaAttribute(attributes, "schema_version", 1)
+	if err != nil {
+		return 0, err
+	}
+	if version <= SchemaVersion {
+		return version, nil
+	}
+	minVersion, err := schemaAttribute(attributes, "schema_min_version", version)
+	if err != nil {
+		return 0, err
+	}
+	if minVersion > SchemaVersion {
+		return 0, fmt.Errorf("%w: message has version %d readable from %d, newest supported is %d",
+			ErrUnsupportedSchemaVersion, version, minVersion, SchemaVersion)
+	}
+	return version, nil
+}
+
+// schemaAttribute parses the version in the named attribute, returning fallback when it is absent.
+func schemaAttribute(attributes map[string]string, name string, fallback int) (int, error) {
+	value, ok := attributes[name]
+	if !ok {
+		return fallback, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid %s attribute %q", name, value)
+	}
+	return version, nil
+}
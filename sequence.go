@@ -0,0 +1,148 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const sequencesCollectionName = "alchemy_sequences"
+
+// Sequence observations reported by SequenceTracker.Observe.
+const (
+	SequenceInOrder    = "in_order"
+	SequenceFirst      = "first"
+	SequenceGap        = "gap"
+	SequenceDuplicate  = "duplicate"
+	SequenceOutOfOrder = "out_of_order"
+)
+
+// SequenceTracker records the last sequenceNumber seen for each webhook ID in Firestore, so
+// gaps and out-of-order deliveries are detected across function instances.
+type SequenceTracker struct {
+	client *firestore.Client
+}
+
+// sequenceRecord is the document stored per webhook ID. Sequence numbers are kept as decimal
+// strings because Alchemy's values can exceed the int64 range of Firestore integers.
+type sequenceRecord struct {
+	LastSequence string    `firestore:"lastSequence"`
+	UpdatedAt    time.Time `firestore:"updatedAt"`
+}
+
+// SequenceObservation describes how a delivery's sequenceNumber relates to the last one seen.
+// Missing is the number of skipped sequence numbers for SequenceGap.
+type SequenceObservation struct {
+	Status   string
+	Last     string
+	Missing  string
+	Sequence string
+}
+
+// NewSequenceTracker creates a new sequence tracker on the instance's shared Firestore client.
+func NewSequenceTracker(ctx context.Context) (*SequenceTracker, error) {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &SequenceTracker{client: client}, nil
+}
+
+// Observe records sequence for webhookID and classifies it against the last sequence seen.
+// The stored value only moves forward, so late deliveries do not hide later gaps.
+func (s *SequenceTracker) Observe(ctx context.Context, webhookID, sequence string) (SequenceObservation, error) {
+	observation := SequenceObservation{Sequence: sequence}
+	current, ok := new(big.Int).SetString(sequence, 10)
+	if !ok {
+		return observation, fmt.Errorf("invalid sequence number %q", sequence)
+	}
+
+	ref := s.client.Collection(sequencesCollectionName).Doc(webhookID)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		observation = SequenceObservation{Sequence: sequence}
+		record := sequenceRecord{LastSequence: sequence, UpdatedAt: time.Now().UTC()}
+
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			observation.Status = SequenceFirst
+			return tx.Create(ref, record)
+		}
+		if err != nil {
+			return err
+		}
+		var existing sequenceRecord
+		if err := snap.DataTo(&existing); err != nil {
+			return err
+		}
+		observation.Last = existing.LastSequence
+		last, ok := new(big.Int).SetString(existing.LastSequence, 10)
+		if !ok {
+			observation.Status = SequenceFirst
+			return tx.Set(ref, record)
+		}
+
+		switch next := new(big.Int).Add(last, big.NewInt(1)); current.Cmp(next) {
+		case 0:
+			observation.Status = SequenceInOrder
+		case 1:
+			observation.Status = SequenceGap
+			observation.Missing = new(big.Int).Sub(current, next).String()
+		default:
+			if current.Cmp(last) == 0 {
+				observation.Status = SequenceDuplicate
+			} else {
+				observation.Status = SequenceOutOfOrder
+			}
+			return nil
+		}
+		return tx.Set(ref, record)
+	})
+	if err != nil {
+		return observation, err
+	}
+	return observation, nil
+}
+
+// trackSequence observes the webhook's sequenceNumber and logs gaps and out-of-order deliveries.
+// Tracking only reports on delivery health, so its failures are logged and never fail the request.
+func trackSequence(ctx context.Context, webhook *WebhookEvent) {
+	if webhook.Event.SequenceNumber == "" {
+		return
+	}
+	tracker, err := NewSequenceTracker(ctx)
+	if err != nil {
+		logError("failed to create sequence tracker", err)
+		return
+	}
+	observation, err := tracker.Observe(ctx, webhook.WebhookID, webhook.Event.SequenceNumber)
+	if err != nil {
+		logError("failed to track sequence number", err)
+		return
+	}
+
+	switch observation.Status {
+	case SequenceGap:
+		log.Printf(`{"level":"warn","message":"webhook sequence gap detected","metric":"webhook_sequence_gap","webhook_id":"%s","event_id":"%s","last_sequence":"%s","sequence":"%s","missing":"%s"}`,
+			webhook.WebhookID, webhook.ID, observation.Last, observation.Sequence, observation.Missing)
+		countMetric("alchemy_webhook_sequence_gap_total", 1)
+		emitLifecycleEvent(ctx, LifecycleSequenceGap, map[string]any{
+			"webhookId": webhook.WebhookID, "lastSequence": observation.Last, "sequence": observation.Sequence, "missing": observation.Missing,
+		})
+	case SequenceOutOfOrder:
+		log.Printf(`{"level":"warn","message":"webhook delivered out of order","metric":"webhook_sequence_out_of_order","webhook_id":"%s","event_id":"%s","last_sequence":"%s","sequence":"%s"}`,
+			webhook.WebhookID, webhook.ID, observation.Last, observation.Sequence)
+		countMetric("alchemy_webhook_sequence_out_of_order_total", 1)
+		emitLifecycleEvent(ctx, LifecycleOutOfOrder, map[string]any{
+			"webhookId": webhook.WebhookID, "lastSequence": observation.Last, "sequence": observation.Sequence,
+		})
+	case SequenceDuplicate:
+		log.Printf(`{"level":"info","message":"webhook sequence redelivered","webhook_id":"%s","event_id":"%s","sequence":"%s"}`,
+			webhook.WebhookID, webhook.ID, observation.Sequence)
+	}
+}
@@ -0,0 +1,111 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Serializer encodes documents into message payloads for a sink.
+type Serializer interface {
+	// Name identifies the serializer in configuration.
+	Name() string
+	// ContentType is the MIME type of the encoded payload.
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+}
+
+// payloadDecoder is implemented by serializers whose payloads can be read back, such as by the
+// enrichment worker.
+type payloadDecoder interface {
+	Unmarshal(data []byte, v any) error
+}
+
+// serializers holds the available serializers by name.
+var serializers = map[string]Serializer{}
+
+func registerSerializer(s Serializer) {
+	serializers[s.Name()] = s
+}
+
+func init() {
+	registerSerializer(jsonSerializer{})
+}
+
+// SerializerFor returns the serializer registered under name.
+func SerializerFor(name string) (Serializer, error) {
+	s, ok := serializers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported serializer %q", name)
+	}
+	return s, nil
+}
+
+// decoderForContentType returns the decoder of the registered serializer of contentType, JSON
+// when it is empty, or false when no serializer can read it.
+func decoderForContentType(contentType string) (payloadDecoder, bool) {
+	if contentType == "" {
+		return jsonSerializer{}, true
+	}
+	for _, s := range serializers {
+		if s.ContentType() == contentType {
+			decoder, ok := s.(payloadDecoder)
+			return decoder, ok
+		}
+	}
+	return nil, false
+}
+
+// sinkSerializer returns the serializer configured for sink in <SINK>_SERIALIZER,
+// defaulting to JSON, wrapped with the sink's redaction rules when any are configured.
+func sinkSerializer(sink string) (Serializer, error) {
+	var serializer Serializer = jsonSerializer{}
+	if name := os.Getenv(strings.ToUpper(sink) + "_SERIALIZER"); name != "" {
+		s, err := SerializerFor(name)
+		if err != nil {
+			return nil, err
+		}
+		serializer = s
+	}
+
+	rules, err := getRedactionRules(sink)
+	if err != nil {
+		return nil, err
+	}
+	if rules != nil {
+		serializer = redactingSerializer{Serializer: serializer, rules: rules}
+	}
+	return serializer, nil
+}
+
+// jsonSerializer encodes payloads as JSON.
+type jsonSerializer struct{}
+
+func (jsonSerializer) Name() string        { return "json" }
+func (jsonSerializer) ContentType() string { return "application/json" }
+
+func (jsonSerializer) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// jsonValue returns v as JSON would decode it, maps, slices and scalars with numbers as
+// json.Number, so schemaless encoders see the same fields as the JSON payloads.
+func jsonValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
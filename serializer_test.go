@@ -0,0 +1,64 @@
+package function
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSerializersRoundTrip(t *testing.T) {
+	parsed, err := ParseWebhook(singleTransferWebhook(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(parsed.Transfers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"json", "application/json"},
+		{"msgpack", "application/msgpack"},
+		{"protobuf", "application/x-protobuf"},
+		{"avro", "application/avro"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serializer, err := SerializerFor(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := serializer.ContentType(); got != tt.contentType {
+				t.Errorf("ContentType() = %q, want %q", got, tt.contentType)
+			}
+			data, err := serializer.Marshal(parsed.Transfers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder, ok := decoderForContentType(tt.contentType)
+			if !ok {
+				t.Fatalf("no decoder for %s", tt.contentType)
+			}
+			var transfers []*TransferDocument
+			if err := decoder.Unmarshal(data, &transfers); err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(transfers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("round trip = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSerializerForUnknown(t *testing.T) {
+	if _, err := SerializerFor("thrift"); err == nil {
+		t.Error("SerializerFor(thrift) succeeded, want an error")
+	}
+}
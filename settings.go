@@ -0,0 +1,88 @@
+package function
+
+import (
+	"sync"
+	"time"
+)
+
+// handlerSettings is the pipeline configuration read from the environment: the policies,
+// transforms, decoders and rules every webhook is processed with.
+type handlerSettings struct {
+	failedTxPolicy  FailedTxPolicy
+	removedPolicy   RemovedLogPolicy
+	removedTTL      time.Duration
+	maxDocuments    int
+	strictness      *Strictness
+	pseudonymizer   *Pseudonymizer
+	encryptor       *FieldEncryptor
+	enrichers       []Enricher
+	registry        *EventDecoderRegistry
+	retries         RetryPolicies
+	filter          *TransferFilter
+	rawLogRetention RawLogRetention
+	rules           []*Rule
+}
+
+var (
+	handlerSettingsOnce sync.Once
+	cachedSettings      *handlerSettings
+	cachedSettingsErr   error
+)
+
+// loadHandlerSettings returns the pipeline configuration, read once per instance after
+// PIPELINE_CONFIG is applied, so requests do not parse the environment again.
+func loadHandlerSettings() (*handlerSettings, error) {
+	handlerSettingsOnce.Do(func() {
+		cachedSettings, cachedSettingsErr = newHandlerSettings()
+	})
+	return cachedSettings, cachedSettingsErr
+}
+
+func newHandlerSettings() (*handlerSettings, error) {
+	s := &handlerSettings{}
+	var err error
+	if s.failedTxPolicy, err = getFailedTxPolicy(); err != nil {
+		return nil, err
+	}
+	if s.removedPolicy, err = getRemovedLogPolicy(); err != nil {
+		return nil, err
+	}
+	if s.removedTTL, err = getRemovedLogTTL(); err != nil {
+		return nil, err
+	}
+	if s.maxDocuments, err = getMaxDocuments(); err != nil {
+		return nil, err
+	}
+	if s.strictness, err = getStrictness(); err != nil {
+		return nil, err
+	}
+	if s.pseudonymizer, err = NewPseudonymizer(); err != nil {
+		return nil, err
+	}
+	if s.encryptor, err = LoadFieldEncryptor(); err != nil {
+		return nil, err
+	}
+	if s.enrichers, err = LoadEnrichers(); err != nil {
+		return nil, err
+	}
+	if s.registry, err = LoadEventDecoderRegistry(); err != nil {
+		return nil, err
+	}
+	if s.retries, err = getRetryPolicies(); err != nil {
+		return nil, err
+	}
+	if s.filter, err = getTransferFilter(); err != nil {
+		return nil, err
+	}
+	if s.rawLogRetention, err = getRawLogRetention(); err != nil {
+		return nil, err
+	}
+	if s.rules, err = LoadRules(); err != nil {
+		return nil, err
+	}
+	// Bridges are only validated here; stitching loads them, parsed once, when it runs.
+	if _, err := LoadBridges(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
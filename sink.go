@@ -0,0 +1,518 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Names of the built-in sinks.
+const (
+	sinkPubSub        = "pubsub"
+	sinkFirestore     = "firestore"
+	sinkBigQuery      = "bigquery"
+	sinkHTTP          = "http"
+	sinkGCS           = "gcs"
+	sinkPostgres      = "postgres"
+	sinkClickHouse    = "clickhouse"
+	sinkKafka         = "kafka"
+	sinkRedis         = "redis"
+	sinkElasticsearch = "elasticsearch"
+	sinkDynamoDB      = "dynamodb"
+	sinkLocal         = "local"
+	sinkSlack         = "slack"
+	sinkDiscord       = "discord"
+	sinkTelegram      = "telegram"
+)
+
+// Sink is a destination for processed webhooks. Init is called once before the sink's first
+// Write, and again after a failed Init. Write receives every processed webhook and Close
+// releases what Init acquired. Name identifies the sink in SINKS, PSEUDONYMIZE_SINKS,
+// SINK_<NAME>_* retry policies and routing rules.
+type Sink interface {
+	Name() string
+	Init(ctx context.Context) error
+	Write(ctx context.Context, parsed *ParsedWebhook) error
+	Close() error
+}
+
+// sinkEntry is a registered sink with its initialization state.
+type sinkEntry struct {
+	sink Sink
+
+	mu          sync.Mutex
+	initialized bool
+}
+
+var (
+	sinksMu sync.RWMutex
+	sinks   = map[string]*sinkEntry{}
+	// sinkNames holds the registered sink names in registration order.
+	sinkNames []string
+)
+
+func init() {
+	RegisterSink(pubSubSink{})
+	RegisterSink(&firestoreSink{})
+	RegisterSink(&bigQuerySink{})
+	RegisterSink(&httpSink{})
+	RegisterSink(&archiveSink{})
+	RegisterSink(&postgresSink{})
+	RegisterSink(&clickHouseSink{})
+	RegisterSink(&kafkaSink{})
+	RegisterSink(&redisSink{})
+	RegisterSink(&elasticsearchSink{})
+	RegisterSink(&dynamoDBSink{})
+	RegisterSink(&localSink{})
+	RegisterSink(newSlackSink())
+	RegisterSink(newDiscordSink())
+	RegisterSink(&telegramSink{})
+}
+
+// RegisterSink adds sink to the registry under its name, replacing any sink of the same name,
+// built-in ones included, typically from an init function of a program embedding the pipeline.
+// A sink error fails the request so Alchemy retries under SinkPolicyAll, the default; under
+// SinkPolicyBestEffort it does only when no sink wrote the webhook, and the documents of the
+// failed sinks are dead-lettered.
+func RegisterSink(sink Sink) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	if _, ok := sinks[sink.Name()]; !ok {
+		sinkNames = append(sinkNames, sink.Name())
+	}
+	sinks[sink.Name()] = &sinkEntry{sink: sink}
+}
+
+// registeredSinks returns every registered sink in registration order.
+func registeredSinks() []Sink {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	registered := make([]Sink, 0, len(sinkNames))
+	for _, name := range sinkNames {
+		registered = append(registered, sinks[name].sink)
+	}
+	return registered
+}
+
+// lookupSink returns the registry entry of the named sink, or nil.
+func lookupSink(name string) *sinkEntry {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	return sinks[name]
+}
+
+// enabledSinks returns the sinks every webhook is written to, in order. SINKS lists them by
+// name; without it, pubsub, firestore and bigquery follow ENABLE_PUBSUB, ENABLE_FIRESTORE and
+// ENABLE_BIGQUERY, http, gcs, postgres, clickhouse, kafka, redis, elasticsearch, dynamodb,
+// local, slack and discord are enabled by HTTP_SINK_URL, ARCHIVE_BUCKET, POSTGRES_URL,
+// CLICKHOUSE_URL, KAFKA_BROKERS, REDIS_URL, ELASTICSEARCH_URL, DYNAMODB_TABLE, LOCAL_SINK_PATH,
+// SLACK_WEBHOOK_URL and DISCORD_WEBHOOK_URL and every other registered sink is enabled.
+func enabledSinks() ([]*sinkEntry, error) {
+	if value := os.Getenv("SINKS"); value != "" {
+		var enabled []*sinkEntry
+		for _, name := range parseList(value) {
+			entry := lookupSink(name)
+			if entry == nil {
+				return nil, fmt.Errorf("unknown sink %q in SINKS", name)
+			}
+			enabled = append(enabled, entry)
+		}
+		return enabled, nil
+	}
+
+	var enabled []*sinkEntry
+	for _, sink := range registeredSinks() {
+		switch sink.Name() {
+		case sinkPubSub:
+			if os.Getenv("ENABLE_PUBSUB") != "true" {
+				continue
+			}
+		case sinkFirestore:
+			if os.Getenv("ENABLE_FIRESTORE") != "true" {
+				continue
+			}
+		case sinkBigQuery:
+			if os.Getenv("ENABLE_BIGQUERY") != "true" {
+				continue
+			}
+		case sinkHTTP:
+			if os.Getenv("HTTP_SINK_URL") == "" {
+				continue
+			}
+		case sinkGCS:
+			if os.Getenv("ARCHIVE_BUCKET") == "" {
+				continue
+			}
+		case sinkPostgres:
+			if os.Getenv("POSTGRES_URL") == "" {
+				continue
+			}
+		case sinkClickHouse:
+			if os.Getenv("CLICKHOUSE_URL") == "" {
+				continue
+			}
+		case sinkKafka:
+			if os.Getenv("KAFKA_BROKERS") == "" {
+				continue
+			}
+		case sinkRedis:
+			if os.Getenv("REDIS_URL") == "" {
+				continue
+			}
+		case sinkElasticsearch:
+			if os.Getenv("ELASTICSEARCH_URL") == "" {
+				continue
+			}
+		case sinkDynamoDB:
+			if os.Getenv("DYNAMODB_TABLE") == "" {
+				continue
+			}
+		case sinkLocal:
+			if os.Getenv("LOCAL_SINK_PATH") == "" {
+				continue
+			}
+		case sinkSlack:
+			if os.Getenv("SLACK_WEBHOOK_URL") == "" {
+				continue
+			}
+		case sinkDiscord:
+			if os.Getenv("DISCORD_WEBHOOK_URL") == "" {
+				continue
+			}
+		case sinkTelegram:
+			if os.Getenv("TELEGRAM_BOT_TOKEN") == "" {
+				continue
+			}
+		}
+		enabled = append(enabled, lookupSink(sink.Name()))
+	}
+	return enabled, nil
+}
+
+// sinkEnabled reports whether the named sink is enabled.
+func sinkEnabled(name string) bool {
+	enabled, err := enabledSinks()
+	if err != nil {
+		return false
+	}
+	for _, entry := range enabled {
+		if entry.sink.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// init initializes the sink unless it already is.
+func (e *sinkEntry) init(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.initialized {
+		return nil
+	}
+	if err := e.sink.Init(ctx); err != nil {
+		return fmt.Errorf("sink %s: init: %w", e.sink.Name(), err)
+	}
+	e.initialized = true
+	return nil
+}
+
+// InitSinks initializes every enabled sink, so configuration errors surface before the first
+// webhook.
+func InitSinks(ctx context.Context) error {
+	enabled, err := enabledSinks()
+	if err != nil {
+		return err
+	}
+	for _, entry := range enabled {
+		if err := entry.init(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseSinks closes every initialized sink, for programs embedding the pipeline to call on exit.
+// Closed sinks are initialized again before their next Write.
+func CloseSinks() error {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	var firstErr error
+	for _, name := range sinkNames {
+		entry := sinks[name]
+		entry.mu.Lock()
+		if entry.initialized {
+			if err := entry.sink.Close(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("sink %s: close: %w", name, err)
+			}
+			entry.initialized = false
+		}
+		entry.mu.Unlock()
+	}
+	return firstErr
+}
+
+// Sink failure policies, set by SINK_FAILURE_POLICY.
+const (
+	// SinkPolicyAll fails the request when any sink fails, so Alchemy redelivers the webhook to
+	// every sink.
+	SinkPolicyAll = "all"
+	// SinkPolicyBestEffort fails the request only when no sink wrote the webhook; the documents of
+	// the others are dead-lettered.
+	SinkPolicyBestEffort = "best-effort"
+)
+
+// getSinkFanOut returns the sink failure policy set by SINK_FAILURE_POLICY, SinkPolicyAll by
+// default, and the most sinks written at once, SINK_CONCURRENCY, unlimited when 0.
+func getSinkFanOut() (string, int, error) {
+	policy := os.Getenv("SINK_FAILURE_POLICY")
+	switch policy {
+	case "":
+		policy = SinkPolicyAll
+	case SinkPolicyAll, SinkPolicyBestEffort:
+	default:
+		return "", 0, fmt.Errorf("invalid SINK_FAILURE_POLICY %q (want %s or %s)", policy, SinkPolicyAll, SinkPolicyBestEffort)
+	}
+	concurrency := envInt("SINK_CONCURRENCY", 0)
+	if concurrency < 0 {
+		return "", 0, fmt.Errorf("invalid SINK_CONCURRENCY %d", concurrency)
+	}
+	return policy, concurrency, nil
+}
+
+// deliverToSinks writes parsed to every enabled sink concurrently, up to SINK_CONCURRENCY at
+// once, without the transfers rules route elsewhere, pseudonymized and encrypted per sink name
+// and retried under the sink's retry policy. Sinks named in SHED_ORDER are skipped when shedder
+// sheds them. Every sink runs to completion, and the failures are joined into the returned
+// error, or logged under SINK_FAILURE_POLICY=best-effort when another sink wrote the webhook.
+func deliverToSinks(ctx context.Context, shedder *Shedder, settings *handlerSettings, parsed *ParsedWebhook, webhookID string) error {
+	enabled, err := enabledSinks()
+	if err != nil {
+		return err
+	}
+	policy, concurrency, err := getSinkFanOut()
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(enabled))
+	wrote := make([]bool, len(enabled))
+	undelivered := make([]*ParsedWebhook, len(enabled))
+	deliver := func(i int) {
+		undelivered[i], errs[i] = deliverToSink(ctx, enabled[i], settings, parsed)
+		wrote[i] = errs[i] == nil
+	}
+	// In outbox mode the pubsub sink records its messages only once the other sinks are done,
+	// and not when the firestore sink failed, so they are never published before the documents
+	// are stored.
+	outbox, persisted := -1, true
+	var group errgroup.Group
+	if concurrency > 0 {
+		group.SetLimit(concurrency)
+	}
+	for i, entry := range enabled {
+		if shedder.Shed(entry.sink.Name(), webhookID) {
+			continue
+		}
+		if entry.sink.Name() == sinkPubSub && outboxEnabled() {
+			outbox = i
+			continue
+		}
+		group.Go(func() error {
+			deliver(i)
+			if entry.sink.Name() == sinkFirestore && errs[i] != nil {
+				persisted = false
+			}
+			return nil
+		})
+	}
+	group.Wait()
+	if outbox >= 0 {
+		if persisted {
+			deliver(outbox)
+		} else {
+			errs[outbox] = fmt.Errorf("sink %s: %w", sinkPubSub, errOutboxNotPersisted)
+		}
+	}
+
+	var failed sinkErrors
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if policy != SinkPolicyBestEffort || !slices.Contains(wrote, true) {
+		return failed
+	}
+
+	// The webhook is acknowledged without the failed sinks, so their documents are dead-lettered
+	// rather than lost; one that can't be fails the webhook for Alchemy to redeliver.
+	var lost sinkErrors
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if undelivered[i] == nil {
+			lost = append(lost, err)
+			continue
+		}
+		if dlErr := writeDeadLetter(ctx, enabled[i].sink.Name(), DeadLetterSinkFailed, err, undelivered[i]); dlErr != nil {
+			lost = append(lost, fmt.Errorf("%w: %w", err, dlErr))
+		}
+	}
+	if len(lost) > 0 {
+		return lost
+	}
+	logError("failed to deliver to some sinks; their documents were dead-lettered", failed)
+	return nil
+}
+
+// sinkErrors holds the failures of a webhook's sinks, joined on one line so they log as one entry.
+type sinkErrors []error
+
+func (e sinkErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e sinkErrors) Unwrap() []error { return e }
+
+// deliverToSink initializes the sink of entry if needed and writes parsed to it, or, while the
+// sink's circuit is open, stores the documents it would have received as a dead letter. Documents
+// beyond MAX_DOCUMENTS_PER_WEBHOOK are likewise dead-lettered, as the sink would have received
+// them, and the rest written. When the sink fails to initialize or write, it also returns the
+// documents it did not write, for the caller to dead-letter.
+func deliverToSink(ctx context.Context, entry *sinkEntry, settings *handlerSettings, parsed *ParsedWebhook) (*ParsedWebhook, error) {
+	sink := entry.sink
+	breaker, err := getSinkBreaker(sink.Name())
+	if err != nil {
+		return nil, err
+	}
+	delivered, err := settings.encryptor.Apply(ctx, sink.Name(), settings.pseudonymizer.Apply(sink.Name(), routeToSink(sink.Name(), parsed)))
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: %w", sink.Name(), err)
+	}
+	if !breaker.allow(ctx) {
+		if err := writeDeadLetter(ctx, sink.Name(), DeadLetterCircuitOpen, errCircuitOpen, delivered); err != nil {
+			return nil, fmt.Errorf("sink %s: %w: %w", sink.Name(), errCircuitOpen, err)
+		}
+		return nil, nil
+	}
+	if delivered, err = deadLetterOverflow(ctx, sink.Name(), delivered, settings.maxDocuments); err != nil {
+		return nil, fmt.Errorf("sink %s: %w", sink.Name(), err)
+	}
+	if err := entry.init(ctx); err != nil {
+		breaker.record(ctx, err)
+		alertSinkResult(ctx, sink.Name(), err)
+		return delivered, err
+	}
+	start := time.Now()
+	err = settings.retries.For(sink.Name()).Do(ctx, sink.Name(), func() error { return sink.Write(ctx, delivered) })
+	recordSinkWrite(sink.Name(), err, start)
+	breaker.record(ctx, err)
+	alertSinkResult(ctx, sink.Name(), err)
+	if err != nil {
+		return delivered, fmt.Errorf("sink %s: %w", sink.Name(), err)
+	}
+	return nil, nil
+}
+
+// pubSubSink publishes each kind of document as its own message to ALCHEMY_PUBSUB_TOPIC.
+type pubSubSink struct{}
+
+func (pubSubSink) Name() string { return sinkPubSub }
+
+// Init checks the serializer, message mode and ordering key, creates the shared Pub/Sub client and, unless
+// PUBSUB_VERIFY_TOPICS is false, verifies the topics it publishes to, so a missing topic fails
+// the warm-up instead of the first publish. With a publisher set by SetPublisherFactory, only
+// the configuration is checked.
+func (pubSubSink) Init(ctx context.Context) error {
+	if _, err := sinkSerializer(sinkPubSub); err != nil {
+		return err
+	}
+	if _, err := getPubSubMessageMode(); err != nil {
+		return err
+	}
+	if _, err := getPubSubOrderingKey(); err != nil {
+		return err
+	}
+	if outboxEnabled() && !writerReplaced {
+		if _, err := firestoreClient(ctx); err != nil {
+			return err
+		}
+	}
+	if publisherReplaced {
+		return nil
+	}
+	if _, err := pubsubClient(ctx); err != nil {
+		return err
+	}
+	if os.Getenv("PUBSUB_VERIFY_TOPICS") == "false" {
+		return nil
+	}
+	topics, err := pubSubTopics()
+	if err != nil {
+		return err
+	}
+	warnings, err := verifyPubSubTopics(ctx, topics)
+	for _, warning := range warnings {
+		log.Printf(`{"level":"warn","message":"%s"}`, warning)
+	}
+	return err
+}
+
+// Write publishes parsed, or, under PUBSUB_OUTBOX, records it in the outbox for PublishOutbox.
+func (pubSubSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	if outboxEnabled() {
+		return writeOutbox(ctx, parsed)
+	}
+	return publishToPubSub(ctx, parsed)
+}
+
+// Close is a no-op: the Pub/Sub client is shared by the instance.
+func (pubSubSink) Close() error { return nil }
+
+// firestoreSink writes each kind of document to its own Firestore collection, applying the
+// dropped transaction and removed log policies read by Init.
+type firestoreSink struct {
+	droppedPolicy DroppedTxPolicy
+	removedPolicy RemovedLogPolicy
+}
+
+func (*firestoreSink) Name() string { return sinkFirestore }
+
+// Init reads the write policies and creates the shared Firestore client, unless a writer was set
+// by SetWriterFactory.
+func (s *firestoreSink) Init(ctx context.Context) error {
+	var err error
+	if s.droppedPolicy, err = getDroppedTxPolicy(); err != nil {
+		return err
+	}
+	if s.removedPolicy, err = getRemovedLogPolicy(); err != nil {
+		return err
+	}
+	if writerReplaced {
+		return nil
+	}
+	_, err = firestoreClient(ctx)
+	return err
+}
+
+func (s *firestoreSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	return writeToFirestore(ctx, parsed, s.droppedPolicy, s.removedPolicy)
+}
+
+// Close is a no-op: the Firestore client is shared by the instance.
+func (*firestoreSink) Close() error { return nil }
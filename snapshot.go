@@ -0,0 +1,543 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	holderSnapshotsCollectionName = "alchemy_holder_snapshots"
+	defaultSnapshotNetwork        = "ETH_MAINNET"
+)
+
+// Snapshot sources and outputs, selected with SNAPSHOT_SOURCE and SNAPSHOT_OUTPUT.
+const (
+	SnapshotFirestore = "firestore"
+	SnapshotBigQuery  = "bigquery"
+	SnapshotParquet   = "parquet"
+)
+
+// HolderSnapshotResult reports the outcome of BuildHolderSnapshot.
+type HolderSnapshotResult struct {
+	Network     string `json:"network"`
+	Contract    string `json:"contract"`
+	BlockNumber int64  `json:"blockNumber"`
+	Transfers   int    `json:"transfers"`
+	Holders     int    `json:"holders"`
+	// Negative counts holders whose computed balance is below zero, which means the transfer
+	// history does not reach back to the token's first transfers. They are left out.
+	Negative int    `json:"negative"`
+	Output   string `json:"output"`
+}
+
+// HolderBalance is the balance of a holder at the snapshot block. TokenID is set for ERC1155
+// tokens, whose balances are per token ID; ERC721 balances count the token IDs held.
+type HolderBalance struct {
+	Holder  string
+	TokenID string
+	Balance *big.Int
+}
+
+// holderSnapshotDocument is the summary document of a snapshot, whose holders are stored in its
+// holders subcollection.
+type holderSnapshotDocument struct {
+	Network     string
+	Contract    string
+	BlockNumber int64
+	Source      string
+	Holders     int
+	CreatedAt   time.Time
+}
+
+// DocumentID returns the ID of the snapshot: <network>-<contract>-<block>.
+func (d *holderSnapshotDocument) DocumentID() string {
+	return fmt.Sprintf("%s-%s-%d", d.Network, d.Contract, d.BlockNumber)
+}
+
+// holderSnapshotEntry is a holder document of a snapshot. Balances are decimal strings, as
+// Firestore integers cannot hold token amounts.
+type holderSnapshotEntry struct {
+	Holder  string
+	TokenID string `firestore:",omitempty"`
+	Balance string
+}
+
+// DocumentID returns the holder address, suffixed with the token ID for ERC1155 balances.
+func (e *holderSnapshotEntry) DocumentID() string {
+	if e.TokenID != "" {
+		return e.Holder + "-" + e.TokenID
+	}
+	return e.Holder
+}
+
+// documentID is a Document known only by its ID, for deleting documents that are not loaded.
+type documentID string
+
+func (id documentID) DocumentID() string { return string(id) }
+
+// snapshotTransfer is the part of a transfer a holder snapshot needs.
+type snapshotTransfer struct {
+	BlockNumber int64
+	LogIndex    int
+	Entry       int
+	Standard    string
+	From        string
+	To          string
+	Value       *big.Int
+	TokenID     *big.Int
+}
+
+// BuildHolderSnapshot computes the balances of every holder of SNAPSHOT_CONTRACT on
+// SNAPSHOT_NETWORK (default ETH_MAINNET) as of SNAPSHOT_BLOCK, inclusive, by replaying the
+// accumulated transfer history, and writes them out. SNAPSHOT_SOURCE reads the history from the
+// Firestore transfers collection (firestore, the default; SNAPSHOT_COLLECTION overrides it) or
+// from the BigQuery sink's table (bigquery). SNAPSHOT_OUTPUT writes the snapshot to the
+// alchemy_holder_snapshots collection (firestore, the default) or as a Parquet file to
+// SNAPSHOT_PARQUET_PATH, a local path or gs://bucket/object (parquet).
+//
+// Balances are only complete when the history reaches back to the token's first transfers;
+// the result counts the holders it had to leave out with negative balances.
+func BuildHolderSnapshot(ctx context.Context) (HolderSnapshotResult, error) {
+	var result HolderSnapshotResult
+
+	contract := strings.ToLower(os.Getenv("SNAPSHOT_CONTRACT"))
+	if contract == "" {
+		return result, errors.New("SNAPSHOT_CONTRACT must be set")
+	}
+	network := os.Getenv("SNAPSHOT_NETWORK")
+	if network == "" {
+		network = defaultSnapshotNetwork
+	}
+	block, err := strconv.ParseInt(os.Getenv("SNAPSHOT_BLOCK"), 10, 64)
+	if err != nil || block < 0 {
+		return result, fmt.Errorf("invalid SNAPSHOT_BLOCK %q", os.Getenv("SNAPSHOT_BLOCK"))
+	}
+	source := os.Getenv("SNAPSHOT_SOURCE")
+	if source == "" {
+		source = SnapshotFirestore
+	}
+	output := os.Getenv("SNAPSHOT_OUTPUT")
+	if output == "" {
+		output = SnapshotFirestore
+	}
+	parquetPath := os.Getenv("SNAPSHOT_PARQUET_PATH")
+	switch output {
+	case SnapshotFirestore:
+	case SnapshotParquet:
+		if parquetPath == "" {
+			return result, errors.New("SNAPSHOT_OUTPUT=parquet requires SNAPSHOT_PARQUET_PATH")
+		}
+	default:
+		return result, fmt.Errorf("invalid SNAPSHOT_OUTPUT %q", output)
+	}
+	result.Network, result.Contract, result.BlockNumber = network, contract, block
+
+	var transfers []snapshotTransfer
+	switch source {
+	case SnapshotFirestore:
+		transfers, err = loadFirestoreSnapshotTransfers(ctx, network, contract, block)
+	case SnapshotBigQuery:
+		transfers, err = loadBigQuerySnapshotTransfers(ctx, network, contract, block)
+	default:
+		return result, fmt.Errorf("invalid SNAPSHOT_SOURCE %q", source)
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to load transfers: %w", err)
+	}
+	result.Transfers = len(transfers)
+
+	balances, negative := holderBalances(transfers)
+	result.Holders, result.Negative = len(balances), negative
+	if negative > 0 {
+		log.Printf(`{"level":"warn","message":"holders with negative balances left out of snapshot","contract":"%s","count":%d}`, contract, negative)
+	}
+
+	snapshot := &holderSnapshotDocument{
+		Network:     network,
+		Contract:    contract,
+		BlockNumber: block,
+		Source:      source,
+		Holders:     len(balances),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if output == SnapshotParquet {
+		result.Output = parquetPath
+		return result, writeParquetSnapshot(ctx, parquetPath, snapshot, balances)
+	}
+	result.Output = holderSnapshotsCollectionName + "/" + snapshot.DocumentID()
+	return result, writeFirestoreSnapshot(ctx, snapshot, balances)
+}
+
+// loadFirestoreSnapshotTransfers reads the transfers of contract up to block from the Firestore
+// transfers collection, skipping reverted transfers and those removed by a reorg. The query
+// needs a composite index on Network, Transfer.Contract and Block.Number.
+func loadFirestoreSnapshotTransfers(ctx context.Context, network, contract string, block int64) ([]snapshotTransfer, error) {
+	collection := os.Getenv("SNAPSHOT_COLLECTION")
+	if collection == "" {
+		collection = collectionName
+	}
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	iter := client.Collection(collection).
+		Where("Network", "==", network).
+		Where("Transfer.Contract", "==", contract).
+		Where("Block.Number", "<=", block).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var transfers []snapshotTransfer
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return transfers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if removed, err := snapshot.DataAt("Removed"); err == nil && removed == true {
+			continue
+		}
+		var doc TransferDocument
+		if err := snapshot.DataTo(&doc); err != nil {
+			return nil, err
+		}
+		if err := DecryptFields(ctx, &doc); err != nil {
+			return nil, err
+		}
+		if doc.Reverted || doc.Finality == FinalityOrphaned {
+			continue
+		}
+		transfers = append(transfers, newSnapshotTransfer(&doc))
+	}
+}
+
+// newSnapshotTransfer returns the snapshot view of doc.
+func newSnapshotTransfer(doc *TransferDocument) snapshotTransfer {
+	entry := -1
+	switch {
+	case doc.Transfer.BatchIndex != nil:
+		entry = *doc.Transfer.BatchIndex
+	case doc.Transfer.TraceIndex != nil:
+		entry = *doc.Transfer.TraceIndex
+	}
+	return snapshotTransfer{
+		BlockNumber: doc.Block.Number,
+		LogIndex:    doc.Transfer.LogIndex,
+		Entry:       entry,
+		Standard:    doc.Transfer.Standard,
+		From:        doc.Transfer.From,
+		To:          doc.Transfer.To,
+		Value:       doc.Transfer.Value,
+		TokenID:     doc.Transfer.TokenID,
+	}
+}
+
+// bigQuerySnapshotRow is a row of the BigQuery transfers query.
+type bigQuerySnapshotRow struct {
+	BlockNumber int64               `bigquery:"block_number"`
+	LogIndex    int64               `bigquery:"log_index"`
+	BatchIndex  bigquery.NullInt64  `bigquery:"batch_index"`
+	TraceIndex  bigquery.NullInt64  `bigquery:"trace_index"`
+	Standard    string              `bigquery:"standard"`
+	From        string              `bigquery:"from_address"`
+	To          string              `bigquery:"to_address"`
+	Value       bigquery.NullString `bigquery:"value"`
+	TokenID     bigquery.NullString `bigquery:"token_id"`
+}
+
+// loadBigQuerySnapshotTransfers reads the transfers of contract up to block from the BigQuery
+// sink's table, BIGQUERY_DATASET.BIGQUERY_TABLE. Streamed rows may repeat on redelivery, so only
+// the latest row of each document counts. The sink does not record reorgs: pick a block old
+// enough to be final.
+func loadBigQuerySnapshotTransfers(ctx context.Context, network, contract string, block int64) ([]snapshotTransfer, error) {
+	projectID := getProjectID()
+	if projectID == "" {
+		return nil, errors.New("project ID not found (GCP_PROJECT or GOOGLE_CLOUD_PROJECT)")
+	}
+	dataset := os.Getenv("BIGQUERY_DATASET")
+	if dataset == "" {
+		return nil, errors.New("BIGQUERY_DATASET must be set")
+	}
+	table := os.Getenv("BIGQUERY_TABLE")
+	if table == "" {
+		table = defaultBigQueryTable
+	}
+	client, err := bigquery.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	query := client.Query(fmt.Sprintf("SELECT block_number, log_index, batch_index, trace_index, standard, from_address, to_address, value, token_id\n"+
+		"FROM `%s.%s.%s`\n"+
+		"WHERE network = @network AND LOWER(contract) = @contract AND block_number <= @block AND NOT IFNULL(reverted, FALSE)\n"+
+		"QUALIFY ROW_NUMBER() OVER (PARTITION BY document_id ORDER BY inserted_at DESC) = 1",
+		projectID, dataset, table))
+	query.Parameters = []bigquery.QueryParameter{
+		{Name: "network", Value: network},
+		{Name: "contract", Value: contract},
+		{Name: "block", Value: block},
+	}
+	rows, err := query.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var transfers []snapshotTransfer
+	for {
+		var row bigQuerySnapshotRow
+		err := rows.Next(&row)
+		if errors.Is(err, iterator.Done) {
+			return transfers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		transfer := snapshotTransfer{
+			BlockNumber: row.BlockNumber,
+			LogIndex:    int(row.LogIndex),
+			Entry:       -1,
+			Standard:    row.Standard,
+			From:        row.From,
+			To:          row.To,
+			Value:       parseSnapshotInt(row.Value),
+			TokenID:     parseSnapshotInt(row.TokenID),
+		}
+		switch {
+		case row.BatchIndex.Valid:
+			transfer.Entry = int(row.BatchIndex.Int64)
+		case row.TraceIndex.Valid:
+			transfer.Entry = int(row.TraceIndex.Int64)
+		}
+		transfers = append(transfers, transfer)
+	}
+}
+
+// parseSnapshotInt parses a decimal column, returning nil when it is NULL or malformed.
+func parseSnapshotInt(s bigquery.NullString) *big.Int {
+	if !s.Valid {
+		return nil
+	}
+	v, ok := new(big.Int).SetString(s.StringVal, 10)
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// holderBalances replays transfers in chain order and returns the positive balances, largest
+// first, with the number of holders left out for negative balances. ERC721 balances count the
+// token IDs each holder ends up owning. The zero address, which mints come from and burns go to,
+// is never a holder.
+func holderBalances(transfers []snapshotTransfer) ([]HolderBalance, int) {
+	sort.SliceStable(transfers, func(i, j int) bool {
+		a, b := transfers[i], transfers[j]
+		if a.BlockNumber != b.BlockNumber {
+			return a.BlockNumber < b.BlockNumber
+		}
+		if a.LogIndex != b.LogIndex {
+			return a.LogIndex < b.LogIndex
+		}
+		return a.Entry < b.Entry
+	})
+
+	type key struct{ holder, tokenID string }
+	balances := make(map[key]*big.Int)
+	add := func(k key, delta *big.Int) {
+		balance, ok := balances[k]
+		if !ok {
+			balance = new(big.Int)
+			balances[k] = balance
+		}
+		balance.Add(balance, delta)
+	}
+	owners := make(map[string]string)
+	for _, transfer := range transfers {
+		from, to := strings.ToLower(transfer.From), strings.ToLower(transfer.To)
+		switch {
+		case transfer.Standard == StandardERC721:
+			if transfer.TokenID != nil {
+				owners[transfer.TokenID.String()] = to
+			}
+		case transfer.Value != nil:
+			var tokenID string
+			if transfer.Standard == StandardERC1155 && transfer.TokenID != nil {
+				tokenID = transfer.TokenID.String()
+			}
+			add(key{from, tokenID}, new(big.Int).Neg(transfer.Value))
+			add(key{to, tokenID}, transfer.Value)
+		}
+	}
+	for _, owner := range owners {
+		add(key{owner, ""}, big.NewInt(1))
+	}
+
+	var holders []HolderBalance
+	negative := 0
+	for k, balance := range balances {
+		if common.IsHexAddress(k.holder) && common.HexToAddress(k.holder) == (common.Address{}) {
+			continue
+		}
+		switch balance.Sign() {
+		case 1:
+			holders = append(holders, HolderBalance{Holder: k.holder, TokenID: k.tokenID, Balance: balance})
+		case -1:
+			negative++
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if c := holders[i].Balance.Cmp(holders[j].Balance); c != 0 {
+			return c > 0
+		}
+		if holders[i].Holder != holders[j].Holder {
+			return holders[i].Holder < holders[j].Holder
+		}
+		return holders[i].TokenID < holders[j].TokenID
+	})
+	return holders, negative
+}
+
+// writeFirestoreSnapshot writes the snapshot document and its holders subcollection. Holders left
+// from an earlier build of the same snapshot that no longer hold the token are deleted.
+func writeFirestoreSnapshot(ctx context.Context, snapshot *holderSnapshotDocument, balances []HolderBalance) error {
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return err
+	}
+	holdersCollection := holderSnapshotsCollectionName + "/" + snapshot.DocumentID() + "/holders"
+
+	entries := make([]*holderSnapshotEntry, len(balances))
+	current := make(map[string]bool, len(balances))
+	for i, balance := range balances {
+		entries[i] = &holderSnapshotEntry{Holder: balance.Holder, TokenID: balance.TokenID, Balance: balance.Balance.String()}
+		current[entries[i].DocumentID()] = true
+	}
+	var stale []documentID
+	iter := client.Collection(holdersCollection).Select().Documents(ctx)
+	defer iter.Stop()
+	for {
+		existing, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !current[existing.Ref.ID] {
+			stale = append(stale, documentID(existing.Ref.ID))
+		}
+	}
+
+	if err := writeBatchDocuments(ctx, client, holdersCollection, entries); err != nil {
+		return err
+	}
+	if err := deleteBatchDocuments(ctx, client, holdersCollection, stale); err != nil {
+		return err
+	}
+	_, err = client.Collection(holderSnapshotsCollectionName).Doc(snapshot.DocumentID()).Set(ctx, snapshot)
+	return err
+}
+
+// snapshotParquetSchema is the schema of Parquet snapshots. Balances are decimal strings, as
+// token amounts can exceed every Parquet integer and decimal type.
+var snapshotParquetSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "network", Type: arrow.BinaryTypes.String},
+	{Name: "contract", Type: arrow.BinaryTypes.String},
+	{Name: "block_number", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "holder", Type: arrow.BinaryTypes.String},
+	{Name: "token_id", Type: arrow.BinaryTypes.String, Nullable: true},
+	{Name: "balance", Type: arrow.BinaryTypes.String},
+}, nil)
+
+// writeParquetSnapshot writes one row per holder balance to path as a Snappy-compressed Parquet
+// file.
+func writeParquetSnapshot(ctx context.Context, path string, snapshot *holderSnapshotDocument, balances []HolderBalance) error {
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, snapshotParquetSchema)
+	defer builder.Release()
+	for _, balance := range balances {
+		builder.Field(0).(*array.StringBuilder).Append(snapshot.Network)
+		builder.Field(1).(*array.StringBuilder).Append(snapshot.Contract)
+		builder.Field(2).(*array.Int64Builder).Append(snapshot.BlockNumber)
+		builder.Field(3).(*array.StringBuilder).Append(balance.Holder)
+		if balance.TokenID != "" {
+			builder.Field(4).(*array.StringBuilder).Append(balance.TokenID)
+		} else {
+			builder.Field(4).(*array.StringBuilder).AppendNull()
+		}
+		builder.Field(5).(*array.StringBuilder).Append(balance.Balance.String())
+	}
+	record := builder.NewRecord()
+	defer record.Release()
+
+	out, err := createSnapshotFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	writer, err := pqarrow.NewFileWriter(snapshotParquetSchema, out,
+		parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy)),
+		pqarrow.DefaultWriterProps())
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := writer.Write(record); err != nil {
+		writer.Close()
+		return err
+	}
+	// Closing the Parquet writer closes out, which commits Cloud Storage uploads.
+	return writer.Close()
+}
+
+// createSnapshotFile creates path on the local filesystem, or in Cloud Storage for gs:// paths.
+func createSnapshotFile(ctx context.Context, path string) (io.WriteCloser, error) {
+	object, ok := strings.CutPrefix(path, "gs://")
+	if !ok {
+		return os.Create(path)
+	}
+	bucket, name, ok := strings.Cut(object, "/")
+	if !ok || bucket == "" || name == "" {
+		return nil, fmt.Errorf("invalid Cloud Storage path %q", path)
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	writer := client.Bucket(bucket).Object(name).NewWriter(ctx)
+	writer.ContentType = "application/vnd.apache.parquet"
+	return &storageFile{Writer: writer, client: client}, nil
+}
+
+// storageFile is a Cloud Storage object writer that closes its client with it.
+type storageFile struct {
+	*storage.Writer
+	client *storage.Client
+}
+
+func (f *storageFile) Close() error {
+	err := f.Writer.Close()
+	if closeErr := f.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
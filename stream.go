@@ -0,0 +1,169 @@
+package function
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// decodeStreamedWebhook decodes the request body of r while hashing it, reading block logs one
+// at a time, so a multi-megabyte payload is never held as raw bytes next to its decoded logs.
+// Every decoded log is still held until the body ends: the signature covers the whole body, so no
+// log can be processed, let alone persisted, before it is checked. Peak memory is therefore the
+// decoded logs rather than the body plus the decoded logs, not a bounded chunk of them.
+// The signature is checked once the whole body has been read and before anything is processed;
+// decode errors of unsigned bodies are reported as signature failures. It writes the error
+// response and returns false when the webhook cannot be processed.
+func decodeStreamedWebhook(w http.ResponseWriter, r *http.Request, signature, signingKey string) (*WebhookEvent, bool) {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	body := io.TeeReader(r.Body, mac)
+	webhook, decodeErr := decodeWebhookStream(body)
+	// The decoder stops at the end of the JSON value; hash whatever follows it too.
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		logError("failed to read request body", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	log.Printf(`{"level":"debug","message":"raw webhook streamed","signature":"%s"}`, signature)
+
+	valid := hex.EncodeToString(mac.Sum(nil)) == signature
+	alertSignatureResult(r.Context(), valid)
+	if !valid {
+		logError("signature validation failed", nil)
+		http.Error(w, "Unauthorized", http.StatusForbidden)
+		return nil, false
+	}
+	if decodeErr != nil {
+		logError("failed to parse webhook event", decodeErr)
+		http.Error(w, "Invalid webhook event format", http.StatusBadRequest)
+		return nil, false
+	}
+	return webhook, true
+}
+
+// decodeWebhookStream decodes a webhook in the default shape from r, decoding event.data.block.logs
+// one entry at a time. Unknown fields are skipped.
+func decodeWebhookStream(r io.Reader) (*WebhookEvent, error) {
+	dec := json.NewDecoder(r)
+	var webhook WebhookEvent
+	block := &webhook.Event.Data.Block
+	err := streamObject(dec, func(key string) error {
+		switch key {
+		case "webhookId":
+			return dec.Decode(&webhook.WebhookID)
+		case "id":
+			return dec.Decode(&webhook.ID)
+		case "createdAt":
+			return dec.Decode(&webhook.CreatedAt)
+		case "type":
+			return dec.Decode(&webhook.Type)
+		case "event":
+			return streamObject(dec, func(key string) error {
+				switch key {
+				case "data":
+					return streamObject(dec, func(key string) error {
+						if key != "block" {
+							return skipValue(dec)
+						}
+						return streamObject(dec, func(key string) error {
+							switch key {
+							case "hash":
+								return dec.Decode(&block.Hash)
+							case "number":
+								return dec.Decode(&block.Number)
+							case "timestamp":
+								return dec.Decode(&block.Timestamp)
+							case "logs":
+								return streamArray(dec, func() error {
+									var l WebhookLog
+									if err := dec.Decode(&l); err != nil {
+										return fmt.Errorf("log %d: %w", len(block.Logs), err)
+									}
+									block.Logs = append(block.Logs, l)
+									return nil
+								})
+							default:
+								return skipValue(dec)
+							}
+						})
+					})
+				case "sequenceNumber":
+					return dec.Decode(&webhook.Event.SequenceNumber)
+				case "network":
+					return dec.Decode(&webhook.Event.Network)
+				case "activity":
+					return dec.Decode(&webhook.Event.Activity)
+				case "transaction":
+					return dec.Decode(&webhook.Event.Transaction)
+				default:
+					return skipValue(dec)
+				}
+			})
+		default:
+			return skipValue(dec)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// streamObject reads a JSON object from dec, calling field with each key to consume its value.
+// A null value is accepted as an empty object.
+func streamObject(dec *json.Decoder, field func(key string) error) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("expected object, got %v", token)
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := field(token.(string)); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// streamArray reads a JSON array from dec, calling item to consume each element.
+// A null value is accepted as an empty array.
+func streamArray(dec *json.Decoder, item func() error) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected array, got %v", token)
+	}
+	for dec.More() {
+		if err := item(); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// skipValue consumes the next JSON value from dec.
+func skipValue(dec *json.Decoder) error {
+	var discard json.RawMessage
+	return dec.Decode(&discard)
+}
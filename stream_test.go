@@ -0,0 +1,38 @@
+package function
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeWebhookStream(t *testing.T) {
+	webhook, err := decodeWebhookStream(strings.NewReader(singleTransferBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := singleTransferWebhook(t); !reflect.DeepEqual(webhook, want) {
+		t.Errorf("decodeWebhookStream() = %+v, want %+v", webhook, want)
+	}
+}
+
+func TestDecodeWebhookStreamErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"not an object", `[]`, "expected object"},
+		{"logs not an array", `{"event":{"data":{"block":{"logs":{}}}}}`, "expected array"},
+		{"malformed log", `{"event":{"data":{"block":{"logs":[{"index":"7"}]}}}}`, "log 0"},
+		{"truncated", `{"event":{"data":{"block":{"logs":[`, "unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeWebhookStream(strings.NewReader(tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("decodeWebhookStream() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
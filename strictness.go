@@ -0,0 +1,142 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ValidationPolicy controls what happens to webhooks with Alchemy metadata anomalies.
+type ValidationPolicy string
+
+const (
+	// ValidationIgnore skips the metadata checks.
+	ValidationIgnore ValidationPolicy = "ignore"
+	// ValidationRecord logs anomalies and records them on the webhook's documents.
+	ValidationRecord ValidationPolicy = "record"
+	// ValidationReject rejects webhooks with any anomaly.
+	ValidationReject ValidationPolicy = "reject"
+)
+
+// DecodeFailurePolicy controls what happens to logs that match a supported event but fail to decode.
+type DecodeFailurePolicy string
+
+const (
+	// DecodeFailureSkip logs the failures and drops the logs.
+	DecodeFailureSkip DecodeFailurePolicy = "skip"
+	// DecodeFailureQuarantine keeps the logs in the quarantine collection.
+	DecodeFailureQuarantine DecodeFailurePolicy = "quarantine"
+	// DecodeFailureReject rejects webhooks with any log that fails to decode.
+	DecodeFailureReject DecodeFailurePolicy = "reject"
+)
+
+// UnknownTypePolicy controls what happens to webhooks of a type this function does not know.
+type UnknownTypePolicy string
+
+const (
+	// UnknownTypeProcess parses unknown webhooks like GRAPHQL webhooks.
+	UnknownTypeProcess UnknownTypePolicy = "process"
+	// UnknownTypeSkip acknowledges unknown webhooks without processing them.
+	UnknownTypeSkip UnknownTypePolicy = "skip"
+	// UnknownTypeReject rejects unknown webhooks.
+	UnknownTypeReject UnknownTypePolicy = "reject"
+)
+
+// Strictness bundles the policies deciding how tolerant the pipeline is of unexpected input.
+type Strictness struct {
+	Validation    ValidationPolicy
+	DecodeFailure DecodeFailurePolicy
+	UnknownType   UnknownTypePolicy
+}
+
+// strictnessProfiles are the named profiles selectable with STRICTNESS. standard is the
+// behavior of deployments without a profile.
+var strictnessProfiles = map[string]Strictness{
+	"lenient":  {Validation: ValidationIgnore, DecodeFailure: DecodeFailureSkip, UnknownType: UnknownTypeProcess},
+	"standard": {Validation: ValidationRecord, DecodeFailure: DecodeFailureQuarantine, UnknownType: UnknownTypeProcess},
+	"strict":   {Validation: ValidationReject, DecodeFailure: DecodeFailureReject, UnknownType: UnknownTypeReject},
+}
+
+var (
+	errMetadataRejected      = errors.New("webhook metadata failed validation")
+	errDecodeFailureRejected = errors.New("webhook has logs that failed to decode")
+	errUnknownTypeRejected   = errors.New("unknown webhook type")
+)
+
+// getStrictness returns the profile named in STRICTNESS, defaulting to standard, with any of
+// its policies overridden by VALIDATION_POLICY, DECODE_FAILURE_POLICY and UNKNOWN_TYPE_POLICY.
+func getStrictness() (*Strictness, error) {
+	name := os.Getenv("STRICTNESS")
+	if name == "" {
+		name = "standard"
+	}
+	profile, ok := strictnessProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid STRICTNESS %q", name)
+	}
+
+	switch policy := ValidationPolicy(os.Getenv("VALIDATION_POLICY")); policy {
+	case "":
+	case ValidationIgnore, ValidationRecord, ValidationReject:
+		profile.Validation = policy
+	default:
+		return nil, fmt.Errorf("invalid VALIDATION_POLICY %q", policy)
+	}
+	switch policy := DecodeFailurePolicy(os.Getenv("DECODE_FAILURE_POLICY")); policy {
+	case "":
+	case DecodeFailureSkip, DecodeFailureQuarantine, DecodeFailureReject:
+		profile.DecodeFailure = policy
+	default:
+		return nil, fmt.Errorf("invalid DECODE_FAILURE_POLICY %q", policy)
+	}
+	switch policy := UnknownTypePolicy(os.Getenv("UNKNOWN_TYPE_POLICY")); policy {
+	case "":
+	case UnknownTypeProcess, UnknownTypeSkip, UnknownTypeReject:
+		profile.UnknownType = policy
+	default:
+		return nil, fmt.Errorf("invalid UNKNOWN_TYPE_POLICY %q", policy)
+	}
+	return &profile, nil
+}
+
+// validateMetadata checks the Alchemy metadata of webhook under the validation policy, recording
+// its anomalies on it. It fails when the policy rejects them.
+func (s *Strictness) validateMetadata(webhook *WebhookEvent) error {
+	if s.Validation == ValidationIgnore {
+		return nil
+	}
+	webhook.anomalies = checkMetadata(webhook)
+	if s.Validation == ValidationReject && len(webhook.anomalies) > 0 {
+		return fmt.Errorf("%w: %v", errMetadataRejected, webhook.anomalies)
+	}
+	return nil
+}
+
+// knownWebhookType reports whether webhookType is a webhook type this function parses.
+func knownWebhookType(webhookType string) bool {
+	switch webhookType {
+	case WebhookTypeGraphQL, WebhookTypeAddressActivity, WebhookTypeNFTActivity, WebhookTypeMinedTx, WebhookTypeDroppedTx:
+		return true
+	}
+	return false
+}
+
+// applyDecodeFailures applies the decode-failure policy to the failed logs of parsed, dropping
+// them from the quarantine unless the policy keeps them there. It fails when the policy rejects
+// them.
+func (s *Strictness) applyDecodeFailures(parsed *ParsedWebhook) error {
+	if len(parsed.Errors) == 0 {
+		return nil
+	}
+	if s.DecodeFailure == DecodeFailureQuarantine {
+		return nil
+	}
+	parsed.Quarantined = nil
+	for i := range parsed.Errors {
+		parsed.Errors[i].Quarantined = false
+	}
+	if s.DecodeFailure == DecodeFailureReject {
+		return fmt.Errorf("%w: %d logs", errDecodeFailureRejected, len(parsed.Errors))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package function
+
+import (
+	"math/big"
+	"os"
+	"strings"
+)
+
+const blockSummariesCollectionName = "alchemy_block_summaries"
+
+// TokenVolume is the decimal sum of the values a token moved within a block.
+type TokenVolume struct {
+	Contract string `json:"contract"`
+	Volume   string `json:"volume"`
+}
+
+// BlockSummaryDocument summarizes the transfers one webhook delivered for a block, so per-block
+// stats can be read without aggregating transfers. Contracts and volumes keep the order of first
+// appearance, and volumes only count transfers that carry a value.
+type BlockSummaryDocument struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Block         Block           `json:"block"`
+	TransferCount int             `json:"transferCount"`
+	Contracts     []string        `json:"contracts"`
+	Volumes       []TokenVolume   `json:"volumes"`
+	Network       string          `json:"network"`
+	Alchemy       AlchemyMetadata `json:"alchemy"`
+}
+
+// DocumentID returns <webhookId>-<blockHash>, so each webhook has a single summary per block.
+func (d *BlockSummaryDocument) DocumentID() string {
+	return blockSummaryID(d.Alchemy.WebhookID, d.Block.Hash)
+}
+
+func blockSummaryID(webhookID, blockHash string) string {
+	return webhookID + "-" + blockHash
+}
+
+// blockSummariesEnabled reports whether ENABLE_BLOCK_SUMMARIES turns on per-block summaries.
+func blockSummariesEnabled() bool {
+	return os.Getenv("ENABLE_BLOCK_SUMMARIES") == "true"
+}
+
+// newBlockSummaries summarizes transfers by block, in order of first appearance.
+func newBlockSummaries(transfers []*TransferDocument) []*BlockSummaryDocument {
+	var summaries []*BlockSummaryDocument
+	byHash := make(map[string]*BlockSummaryDocument)
+	contracts := make(map[*BlockSummaryDocument]map[string]bool)
+	volumes := make(map[*BlockSummaryDocument]map[string]int)
+	amounts := make(map[*BlockSummaryDocument][]*big.Int)
+	for _, doc := range transfers {
+		summary, ok := byHash[doc.Block.Hash]
+		if !ok {
+			summary = &BlockSummaryDocument{
+				SchemaVersion: SchemaVersion,
+				Block:         doc.Block,
+				Contracts:     []string{},
+				Network:       doc.Network,
+				Alchemy:       doc.Alchemy,
+			}
+			byHash[doc.Block.Hash] = summary
+			contracts[summary] = make(map[string]bool)
+			volumes[summary] = make(map[string]int)
+			summaries = append(summaries, summary)
+		}
+		summary.TransferCount++
+
+		// Contracts are compared case-insensitively and keep the spelling seen first.
+		key := strings.ToLower(doc.Transfer.Contract)
+		if !contracts[summary][key] {
+			contracts[summary][key] = true
+			summary.Contracts = append(summary.Contracts, doc.Transfer.Contract)
+		}
+		if doc.Transfer.Value == nil {
+			continue
+		}
+		index, ok := volumes[summary][key]
+		if !ok {
+			index = len(summary.Volumes)
+			volumes[summary][key] = index
+			summary.Volumes = append(summary.Volumes, TokenVolume{Contract: doc.Transfer.Contract})
+			amounts[summary] = append(amounts[summary], new(big.Int))
+		}
+		amounts[summary][index].Add(amounts[summary][index], doc.Transfer.Value)
+	}
+
+	for _, summary := range summaries {
+		if summary.Volumes == nil {
+			summary.Volumes = []TokenVolume{}
+		}
+		for i := range summary.Volumes {
+			summary.Volumes[i].Volume = amounts[summary][i].String()
+		}
+	}
+	return summaries
+}
+
+// blockSummaryTombstones returns a tombstone for the summary of every block with a removed
+// transfer among tombstones.
+func blockSummaryTombstones(tombstones []*Tombstone) []*Tombstone {
+	var summaries []*Tombstone
+	seen := make(map[string]bool)
+	for _, tombstone := range tombstones {
+		if tombstone.Kind != KindTransfer {
+			continue
+		}
+		id := blockSummaryID(tombstone.Alchemy.WebhookID, tombstone.Block.Hash)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		summary := *tombstone
+		summary.Collection = blockSummariesCollectionName
+		summary.ID = id
+		summaries = append(summaries, &summary)
+	}
+	return summaries
+}
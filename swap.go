@@ -0,0 +1,190 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const swapsCollectionName = "alchemy_swaps"
+
+// DEX protocols reported in Swap.Protocol.
+const (
+	ProtocolUniswapV2 = "uniswap-v2"
+	ProtocolUniswapV3 = "uniswap-v3"
+)
+
+// Uniswap V2 and V3 pool Swap event ABI definitions.
+const (
+	swapV2EventABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"sender","type":"address"},{"indexed":false,"name":"amount0In","type":"uint256"},{"indexed":false,"name":"amount1In","type":"uint256"},{"indexed":false,"name":"amount0Out","type":"uint256"},{"indexed":false,"name":"amount1Out","type":"uint256"},{"indexed":true,"name":"to","type":"address"}],"name":"Swap","type":"event"}]`
+	swapV3EventABI = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"sender","type":"address"},{"indexed":true,"name":"recipient","type":"address"},{"indexed":false,"name":"amount0","type":"int256"},{"indexed":false,"name":"amount1","type":"int256"},{"indexed":false,"name":"sqrtPriceX96","type":"uint160"},{"indexed":false,"name":"liquidity","type":"uint128"},{"indexed":false,"name":"tick","type":"int24"}],"name":"Swap","type":"event"}]`
+)
+
+var (
+	parsedSwapV2ABI abi.ABI
+	parsedSwapV3ABI abi.ABI
+)
+
+// Swap event signature hashes expected in topics[0].
+var (
+	swapV2EventTopic = crypto.Keccak256Hash([]byte("Swap(address,uint256,uint256,uint256,uint256,address)"))
+	swapV3EventTopic = crypto.Keccak256Hash([]byte("Swap(address,address,int256,int256,uint160,uint128,int24)"))
+)
+
+var (
+	// ErrNotSwap is returned for logs whose topics[0] is not a supported Swap event signature.
+	ErrNotSwap = errors.New("log is not a swap event")
+	// ErrMalformedSwap is returned for Swap logs whose topics or data cannot be decoded.
+	ErrMalformedSwap = errors.New("malformed Swap event")
+)
+
+func init() {
+	var err error
+	parsedSwapV2ABI, err = abi.JSON(strings.NewReader(swapV2EventABI))
+	if err != nil {
+		panic("failed to parse Uniswap V2 Swap event ABI: " + err.Error())
+	}
+	parsedSwapV3ABI, err = abi.JSON(strings.NewReader(swapV3EventABI))
+	if err != nil {
+		panic("failed to parse Uniswap V3 Swap event ABI: " + err.Error())
+	}
+}
+
+// Swap represents a decoded DEX pool swap. Amounts are decimal strings. V2 pools report the
+// in and out amounts of each token; V3 pools report signed pool balance deltas (positive when
+// the pool receives the token) plus the post-swap sqrtPriceX96, liquidity and tick.
+type Swap struct {
+	Pool         string `json:"pool"`
+	Protocol     string `json:"protocol"`
+	Sender       string `json:"sender"`
+	Recipient    string `json:"recipient"`
+	Amount0In    string `json:"amount0In,omitempty"`
+	Amount1In    string `json:"amount1In,omitempty"`
+	Amount0Out   string `json:"amount0Out,omitempty"`
+	Amount1Out   string `json:"amount1Out,omitempty"`
+	Amount0      string `json:"amount0,omitempty"`
+	Amount1      string `json:"amount1,omitempty"`
+	SqrtPriceX96 string `json:"sqrtPriceX96,omitempty"`
+	Liquidity    string `json:"liquidity,omitempty"`
+	Tick         *int64 `json:"tick,omitempty"`
+	LogIndex     int    `json:"logIndex"`
+}
+
+// SwapDocument represents the document structure for DEX swap events.
+type SwapDocument struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Block         Block           `json:"block"`
+	Transaction   Transaction     `json:"transaction"`
+	Swap          Swap            `json:"swap"`
+	Network       string          `json:"network"`
+	Alchemy       AlchemyMetadata `json:"alchemy"`
+	RawLog        *RawLog         `json:"rawLog,omitempty"`
+}
+
+// DocumentID returns the idempotent document ID of the swap.
+func (d *SwapDocument) DocumentID() string {
+	return GetDocumentID(d.Transaction.Hash, d.Swap.LogIndex)
+}
+
+// decodedSwapV2Event holds the decoded amounts from Uniswap V2 Swap event data.
+type decodedSwapV2Event struct {
+	Amount0In  *big.Int `abi:"amount0In"`
+	Amount1In  *big.Int `abi:"amount1In"`
+	Amount0Out *big.Int `abi:"amount0Out"`
+	Amount1Out *big.Int `abi:"amount1Out"`
+}
+
+// decodedSwapV3Event holds the decoded deltas and pool state from Uniswap V3 Swap event data.
+type decodedSwapV3Event struct {
+	Amount0      *big.Int `abi:"amount0"`
+	Amount1      *big.Int `abi:"amount1"`
+	SqrtPriceX96 *big.Int `abi:"sqrtPriceX96"`
+	Liquidity    *big.Int `abi:"liquidity"`
+	Tick         *big.Int `abi:"tick"`
+}
+
+// parseSwapEntry parses a single log entry into a SwapDocument.
+func parseSwapEntry(webhook *WebhookEvent, index int) (*SwapDocument, error) {
+	log := webhook.Event.Data.Block.Logs[index]
+	swap, err := decodeSwapLog(log)
+	if err != nil {
+		return nil, err
+	}
+	return &SwapDocument{
+		SchemaVersion: SchemaVersion,
+		Block:         newBlock(webhook),
+		Transaction:   newTransaction(log),
+		Swap:          swap,
+		Network:       webhook.Event.Network,
+		Alchemy:       newAlchemyMetadata(webhook),
+	}, nil
+}
+
+// decodeSwapLog decodes a Uniswap V2 or V3 Swap log, dispatching by topics[0].
+func decodeSwapLog(log WebhookLog) (Swap, error) {
+	if len(log.Topics) == 0 {
+		return Swap{}, ErrNotSwap
+	}
+	switch common.HexToHash(log.Topics[0]) {
+	case swapV2EventTopic:
+		return decodeSwapV2(log)
+	case swapV3EventTopic:
+		return decodeSwapV3(log)
+	default:
+		return Swap{}, ErrNotSwap
+	}
+}
+
+// decodeSwapV2 decodes a Uniswap V2 pair Swap log.
+func decodeSwapV2(log WebhookLog) (Swap, error) {
+	if len(log.Topics) != 3 {
+		return Swap{}, fmt.Errorf("%w: expected 3 topics on V2 Swap, got %d", ErrMalformedSwap, len(log.Topics))
+	}
+	var decoded decodedSwapV2Event
+	if err := parsedSwapV2ABI.UnpackIntoInterface(&decoded, "Swap", common.FromHex(log.Data)); err != nil {
+		return Swap{}, fmt.Errorf("%w: %v", ErrMalformedSwap, err)
+	}
+
+	swap := newSwap(log, ProtocolUniswapV2)
+	swap.Amount0In = bigIntString(decoded.Amount0In)
+	swap.Amount1In = bigIntString(decoded.Amount1In)
+	swap.Amount0Out = bigIntString(decoded.Amount0Out)
+	swap.Amount1Out = bigIntString(decoded.Amount1Out)
+	return swap, nil
+}
+
+// decodeSwapV3 decodes a Uniswap V3 pool Swap log.
+func decodeSwapV3(log WebhookLog) (Swap, error) {
+	if len(log.Topics) != 3 {
+		return Swap{}, fmt.Errorf("%w: expected 3 topics on V3 Swap, got %d", ErrMalformedSwap, len(log.Topics))
+	}
+	var decoded decodedSwapV3Event
+	if err := parsedSwapV3ABI.UnpackIntoInterface(&decoded, "Swap", common.FromHex(log.Data)); err != nil {
+		return Swap{}, fmt.Errorf("%w: %v", ErrMalformedSwap, err)
+	}
+
+	swap := newSwap(log, ProtocolUniswapV3)
+	swap.Amount0 = bigIntString(decoded.Amount0)
+	swap.Amount1 = bigIntString(decoded.Amount1)
+	swap.SqrtPriceX96 = bigIntString(decoded.SqrtPriceX96)
+	swap.Liquidity = bigIntString(decoded.Liquidity)
+	tick := decoded.Tick.Int64()
+	swap.Tick = &tick
+	return swap, nil
+}
+
+// newSwap reads the pool and the indexed sender and recipient shared by both Swap layouts.
+func newSwap(log WebhookLog, protocol string) Swap {
+	return Swap{
+		Pool:      log.Account.Address,
+		Protocol:  protocol,
+		Sender:    common.HexToAddress(log.Topics[1]).Hex(),
+		Recipient: common.HexToAddress(log.Topics[2]).Hex(),
+		LogIndex:  log.Index,
+	}
+}
@@ -0,0 +1,202 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTelegramAPIURL = "https://api.telegram.org"
+	// telegramTextLimit is the most characters a Telegram message holds.
+	telegramTextLimit = 4096
+)
+
+// defaultTelegramTemplate renders a batch like the Slack and Discord messages, in Telegram HTML.
+const defaultTelegramTemplate = `<b>{{.Header}}</b>
+{{- range .Transfers}}
+• <b>{{.Amount}} {{.Token}}</b> {{if .FromURL}}<a href="{{.FromURL}}">{{.From}}</a>{{else}}{{.From}}{{end}} → {{if .ToURL}}<a href="{{.ToURL}}">{{.To}}</a>{{else}}{{.To}}{{end}}{{if .TxURL}} · <a href="{{.TxURL}}">tx</a>{{end}}{{if .Suppressed}} <i>(+{{.Suppressed}} suppressed)</i>{{end}}
+{{- end}}
+{{- if .More}}
+<i>and {{.More}} more</i>
+{{- end}}`
+
+// telegramChatID is the form of a chat ID: a numeric ID, negative for groups and channels, or
+// the @username of a public channel.
+var telegramChatID = regexp.MustCompile(`^(-?[0-9]+|@[A-Za-z][A-Za-z0-9_]{3,})$`)
+
+// telegramSink sends a message listing a webhook's transfers, reverted ones excluded, to each
+// chat of TELEGRAM_CHAT_IDS through the Telegram Bot API, as the bot of TELEGRAM_BOT_TOKEN. The
+// message is the html/template TELEGRAM_TEMPLATE, or the file at TELEGRAM_TEMPLATE_FILE, executed
+// with the NotificationBatch and sent with the HTML parse mode, so values are escaped for it;
+// the default template matches the Slack and Discord messages. Like them, it alerts on the
+// transfers notification preferences pick, holds back repeated alerts under NOTIFY_COOLDOWN and
+// lists up to NOTIFY_MAX_TRANSFERS transfers, fewer when the message would exceed Telegram's
+// limit, counting the rest. Requests go through the telegram ProviderClient, which retries
+// transport errors, 429 and 5xx responses. Telegram does not deduplicate, so a redelivered webhook is
+// announced again, to every chat when one of them failed.
+type telegramSink struct {
+	endpoint     string
+	chatIDs      []string
+	template     *template.Template
+	maxTransfers int
+	explorers    map[string]string
+	suppressor   *alertSuppressor
+}
+
+func (*telegramSink) Name() string { return sinkTelegram }
+
+// Init reads the bot token, chats and template, which it parses so a malformed one fails the
+// readiness check rather than the first webhook.
+func (s *telegramSink) Init(context.Context) error {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return errors.New("TELEGRAM_BOT_TOKEN must be set")
+	}
+	s.chatIDs = parseList(os.Getenv("TELEGRAM_CHAT_IDS"))
+	if len(s.chatIDs) == 0 {
+		return errors.New("TELEGRAM_CHAT_IDS must be set")
+	}
+	for _, id := range s.chatIDs {
+		if !telegramChatID.MatchString(id) {
+			return fmt.Errorf("invalid TELEGRAM_CHAT_IDS entry %q", id)
+		}
+	}
+	apiURL := os.Getenv("TELEGRAM_API_URL")
+	if apiURL == "" {
+		apiURL = defaultTelegramAPIURL
+	}
+	s.endpoint = strings.TrimSuffix(apiURL, "/") + "/bot" + token + "/sendMessage"
+
+	text := os.Getenv("TELEGRAM_TEMPLATE")
+	if path := os.Getenv("TELEGRAM_TEMPLATE_FILE"); path != "" {
+		if text != "" {
+			return errors.New("TELEGRAM_TEMPLATE and TELEGRAM_TEMPLATE_FILE are mutually exclusive")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read TELEGRAM_TEMPLATE_FILE: %w", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		text = defaultTelegramTemplate
+	}
+	tmpl, err := template.New(sinkTelegram).Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid Telegram template: %w", err)
+	}
+	s.template = tmpl
+
+	s.maxTransfers = max(envInt("NOTIFY_MAX_TRANSFERS", defaultNotifyMaxTransfers), 1)
+	explorers, err := loadExplorers()
+	if err != nil {
+		return err
+	}
+	s.explorers = explorers
+	s.suppressor, err = newAlertSuppressor()
+	return err
+}
+
+// Write sends one message to each chat for the transfers the notification preferences want
+// alerted on now, or nothing when there are none or all of them are suppressed.
+func (s *telegramSink) Write(ctx context.Context, parsed *ParsedWebhook) error {
+	transfers, err := applyNotificationPreferences(ctx, sinkTelegram, parsed.Transfers)
+	if err != nil {
+		return err
+	}
+	admitted, suppressed, undo := s.suppressor.admit(transfers, time.Now())
+	logSuppressedAlerts(sinkTelegram, len(transfers)-len(admitted))
+	if len(admitted) == 0 {
+		return nil
+	}
+	if err := s.notify(ctx, newNotificationBatch(admitted, s.maxTransfers, s.explorers, suppressed)); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// notify renders batch and sends it to each chat.
+func (s *telegramSink) notify(ctx context.Context, batch NotificationBatch) error {
+	text, err := s.render(batch)
+	if err != nil {
+		return err
+	}
+	for _, chatID := range s.chatIDs {
+		if err := s.send(ctx, chatID, text); err != nil {
+			return fmt.Errorf("failed to send to Telegram chat %s: %w", chatID, err)
+		}
+	}
+	return nil
+}
+
+// render executes the template with batch, listing fewer transfers until the message fits.
+func (s *telegramSink) render(batch NotificationBatch) (string, error) {
+	for {
+		var buf strings.Builder
+		if err := s.template.Execute(&buf, batch); err != nil {
+			return "", fmt.Errorf("failed to execute Telegram template: %w", err)
+		}
+		text := strings.TrimSpace(buf.String())
+		if text == "" {
+			return "", errors.New("Telegram template rendered an empty message")
+		}
+		if utf8.RuneCountInString(text) <= telegramTextLimit {
+			return text, nil
+		}
+		if len(batch.Transfers) == 0 {
+			return "", fmt.Errorf("Telegram message exceeds %d characters", telegramTextLimit)
+		}
+		batch.Transfers = batch.Transfers[:len(batch.Transfers)-1]
+		batch.More++
+	}
+}
+
+// send sends text to the chat, checking the ok field of the Bot API response.
+func (s *telegramSink) send(ctx context.Context, chatID, text string) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":              chatID,
+		"text":                 text,
+		"parse_mode":           "HTML",
+		"link_preview_options": map[string]any{"is_disabled": true},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ProviderFor(sinkTelegram).Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err := json.Unmarshal(data, &result); err != nil || !result.OK {
+		if result.Description == "" {
+			result.Description = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%s responded with status %d: %s", sinkTelegram, resp.StatusCode, result.Description)
+	}
+	return nil
+}
+
+// Close is a no-op: the provider client is shared by the instance.
+func (*telegramSink) Close() error { return nil }
@@ -0,0 +1,147 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// timestampedCollections are the default collections of the documents that carry native Firestore
+// timestamps, in the order BackfillFirestoreTimestamps visits them.
+var timestampedCollections = []string{
+	collectionName,
+	revertedCollectionName,
+	approvalsCollectionName,
+	swapsCollectionName,
+	eventsCollectionName,
+	transactionsCollectionName,
+	transferGroupsCollectionName,
+	blockSummariesCollectionName,
+}
+
+// firestoreTimestamped is implemented by documents whose block time and Alchemy creation time are
+// stored as native Firestore timestamps. writeBatchDocuments sets them before every write, so
+// documents decoded from messages, which do not carry them, are written with them too.
+type firestoreTimestamped interface {
+	firestoreTimes() (*Block, *AlchemyMetadata)
+}
+
+func (d *TransferDocument) firestoreTimes() (*Block, *AlchemyMetadata) { return &d.Block, &d.Alchemy }
+func (d *ApprovalDocument) firestoreTimes() (*Block, *AlchemyMetadata) { return &d.Block, &d.Alchemy }
+func (d *SwapDocument) firestoreTimes() (*Block, *AlchemyMetadata)     { return &d.Block, &d.Alchemy }
+func (d *EventDocument) firestoreTimes() (*Block, *AlchemyMetadata)    { return &d.Block, &d.Alchemy }
+func (d *TransactionDocument) firestoreTimes() (*Block, *AlchemyMetadata) {
+	return &d.Block, &d.Alchemy
+}
+func (d *TransferGroupDocument) firestoreTimes() (*Block, *AlchemyMetadata) {
+	return &d.Block, &d.Alchemy
+}
+func (d *BlockSummaryDocument) firestoreTimes() (*Block, *AlchemyMetadata) {
+	return &d.Block, &d.Alchemy
+}
+
+// setFirestoreTimestamps sets Block.Time from the block's Unix timestamp and
+// Alchemy.CreatedAtTime from the webhook's RFC3339 creation time. Either is left nil when its
+// source is missing, as for address activity blocks, which have no timestamp.
+func setFirestoreTimestamps(doc firestoreTimestamped) {
+	block, alchemy := doc.firestoreTimes()
+	block.Time = blockTimestamp(block.Timestamp)
+	alchemy.CreatedAtTime = createdAtTimestamp(alchemy.CreatedAt)
+}
+
+// blockTimestamp returns the time of a Unix block timestamp, or nil for 0.
+func blockTimestamp(timestamp int64) *time.Time {
+	if timestamp == 0 {
+		return nil
+	}
+	t := time.Unix(timestamp, 0).UTC()
+	return &t
+}
+
+// createdAtTimestamp returns the time of an RFC3339 creation time, or nil when it does not parse.
+func createdAtTimestamp(createdAt string) *time.Time {
+	t, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// TimestampBackfillResult reports the outcome of BackfillFirestoreTimestamps.
+type TimestampBackfillResult struct {
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// BackfillFirestoreTimestamps adds the native Firestore timestamps to documents written before
+// they existed, deriving Block.Time and Alchemy.CreatedAtTime from the Block.Timestamp and
+// Alchemy.CreatedAt fields already stored. Documents that have them, or whose sources are
+// missing, are skipped, so the backfill can be interrupted and run again. Updates merge only
+// the new fields and go through a BulkWriter. It visits collections, or the default collections
+// of every document kind with timestamps when none are given; collections of routed transfers
+// must be named.
+func BackfillFirestoreTimestamps(ctx context.Context, collections ...string) (TimestampBackfillResult, error) {
+	if len(collections) == 0 {
+		collections = timestampedCollections
+	}
+	var result TimestampBackfillResult
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		return result, err
+	}
+	writer := client.BulkWriter(ctx)
+	for _, collection := range collections {
+		if err := backfillCollectionTimestamps(ctx, client, writer, collection, &result); err != nil {
+			writer.End()
+			return result, err
+		}
+	}
+	writer.End()
+	return result, nil
+}
+
+func backfillCollectionTimestamps(ctx context.Context, client *firestore.Client, writer *firestore.BulkWriter, collection string, result *TimestampBackfillResult) error {
+	iter := client.Collection(collection).Select("Block.Timestamp", "Block.Time", "Alchemy.CreatedAt", "Alchemy.CreatedAtTime").Documents(ctx)
+	defer iter.Stop()
+	for {
+		snapshot, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var updates []firestore.Update
+		if _, err := snapshot.DataAtPath([]string{"Block", "Time"}); err != nil {
+			if timestamp, err := snapshot.DataAtPath([]string{"Block", "Timestamp"}); err == nil {
+				if seconds, ok := timestamp.(int64); ok {
+					if t := blockTimestamp(seconds); t != nil {
+						updates = append(updates, firestore.Update{FieldPath: []string{"Block", "Time"}, Value: *t})
+					}
+				}
+			}
+		}
+		if _, err := snapshot.DataAtPath([]string{"Alchemy", "CreatedAtTime"}); err != nil {
+			if createdAt, err := snapshot.DataAtPath([]string{"Alchemy", "CreatedAt"}); err == nil {
+				if s, ok := createdAt.(string); ok {
+					if t := createdAtTimestamp(s); t != nil {
+						updates = append(updates, firestore.Update{FieldPath: []string{"Alchemy", "CreatedAtTime"}, Value: *t})
+					}
+				}
+			}
+		}
+		if len(updates) == 0 {
+			result.Skipped++
+			continue
+		}
+		if _, err := writer.Update(snapshot.Ref, updates); err != nil {
+			return err
+		}
+		result.Updated++
+	}
+}
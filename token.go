@@ -0,0 +1,172 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const tokensCollectionName = "alchemy_tokens"
+
+// ERC20 metadata getter selectors.
+var (
+	nameSelector     = hexutil.MustDecode("0x06fdde03")
+	symbolSelector   = hexutil.MustDecode("0x95d89b41")
+	decimalsSelector = hexutil.MustDecode("0x313ce567")
+)
+
+// TokenMetadata holds the name, symbol and decimals reported by a token contract.
+// Fields the contract does not implement are left empty.
+type TokenMetadata struct {
+	Name     string `json:"name,omitempty"`
+	Symbol   string `json:"symbol,omitempty"`
+	Decimals *int   `json:"decimals,omitempty"`
+}
+
+// TokenMetadataEnricher attaches token metadata fetched over RPC to transfers. Metadata is
+// cached per instance in memory and shared across instances in the alchemy_tokens collection.
+type TokenMetadataEnricher struct {
+	mu    sync.Mutex
+	cache map[string]*TokenMetadata
+}
+
+// NewTokenMetadataEnricher returns a token metadata enricher when ENABLE_TOKEN_METADATA is set,
+// reading from the RPC endpoints of each transfer's network. It returns nil when token metadata
+// is disabled.
+func NewTokenMetadataEnricher() (*TokenMetadataEnricher, error) {
+	if os.Getenv("ENABLE_TOKEN_METADATA") != "true" {
+		return nil, nil
+	}
+	if !rpcConfigured() {
+		return nil, errors.New("ENABLE_TOKEN_METADATA requires ALCHEMY_RPC_URL or RPC_ENDPOINTS_<NETWORK>")
+	}
+	return &TokenMetadataEnricher{cache: make(map[string]*TokenMetadata)}, nil
+}
+
+// Name returns the enricher name.
+func (t *TokenMetadataEnricher) Name() string {
+	return "token-metadata"
+}
+
+// Enrich sets the token metadata of transfers, looking up each contract once.
+func (t *TokenMetadataEnricher) Enrich(ctx context.Context, transfers []*TransferDocument) error {
+	for _, transfer := range transfers {
+		if transfer.Transfer.Contract == "" || transfer.isNative() {
+			continue
+		}
+		token, err := t.lookup(ctx, transfer.Network, strings.ToLower(transfer.Transfer.Contract))
+		if err != nil {
+			return err
+		}
+		if *token != (TokenMetadata{}) {
+			transfer.Token = token
+		}
+	}
+	return nil
+}
+
+// lookup returns the metadata of contract from memory, Firestore or RPC, in that order.
+// Contracts without metadata are cached as empty TokenMetadata so they are not queried again.
+func (t *TokenMetadataEnricher) lookup(ctx context.Context, network, contract string) (*TokenMetadata, error) {
+	t.mu.Lock()
+	token, ok := t.cache[contract]
+	t.mu.Unlock()
+	if ok {
+		return token, nil
+	}
+
+	client, err := firestoreClient(ctx)
+	if err != nil {
+		logError("token metadata cache unavailable", err)
+	}
+	if client != nil {
+		snap, err := client.Collection(tokensCollectionName).Doc(contract).Get(ctx)
+		switch {
+		case err == nil:
+			token = &TokenMetadata{}
+			if err := snap.DataTo(token); err != nil {
+				return nil, err
+			}
+		case status.Code(err) != codes.NotFound:
+			logError("failed to read token metadata cache", err)
+		}
+	}
+
+	if token == nil {
+		token, err = fetchTokenMetadata(ctx, network, contract)
+		if err != nil {
+			return nil, err
+		}
+		if client != nil {
+			if _, err := client.Collection(tokensCollectionName).Doc(contract).Set(ctx, token); err != nil {
+				logError("failed to write token metadata cache", err)
+			}
+		}
+	}
+
+	t.mu.Lock()
+	t.cache[contract] = token
+	t.mu.Unlock()
+	return token, nil
+}
+
+// fetchTokenMetadata calls the name(), symbol() and decimals() getters of contract on network.
+// Getters that revert or return unexpected data are treated as not implemented.
+func fetchTokenMetadata(ctx context.Context, network, contract string) (*TokenMetadata, error) {
+	token := &TokenMetadata{}
+	var err error
+	if token.Name, err = callTokenString(ctx, network, contract, nameSelector); err != nil {
+		return nil, err
+	}
+	if token.Symbol, err = callTokenString(ctx, network, contract, symbolSelector); err != nil {
+		return nil, err
+	}
+	result, err := ethCall(ctx, network, contract, decimalsSelector)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 32 {
+		if decimals := new(big.Int).SetBytes(result); decimals.IsInt64() && decimals.Int64() <= 255 {
+			value := int(decimals.Int64())
+			token.Decimals = &value
+		}
+	}
+	return token, nil
+}
+
+// callTokenString calls a string getter, accepting both ABI-encoded strings and the bytes32
+// values returned by early tokens such as MKR.
+func callTokenString(ctx context.Context, network, contract string, selector []byte) (string, error) {
+	result, err := ethCall(ctx, network, contract, selector)
+	if err != nil {
+		return "", err
+	}
+	return decodeABIString(result), nil
+}
+
+// decodeABIString decodes an ABI-encoded string or a zero-padded bytes32, returning "" otherwise.
+func decodeABIString(data []byte) string {
+	if len(data) == 32 {
+		return strings.ToValidUTF8(strings.TrimRight(string(data), "\x00"), "")
+	}
+	if len(data) < 64 {
+		return ""
+	}
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(data)-32) {
+		return ""
+	}
+	start := offset.Uint64()
+	length := new(big.Int).SetBytes(data[start : start+32])
+	if !length.IsUint64() || length.Uint64() > uint64(len(data))-start-32 {
+		return ""
+	}
+	return strings.ToValidUTF8(string(data[start+32:start+32+length.Uint64()]), "")
+}
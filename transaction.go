@@ -0,0 +1,140 @@
+package function
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const transactionsCollectionName = "alchemy_transactions"
+
+// Transaction states reported in TransactionDocument.State.
+const (
+	TransactionStateMined   = "mined"
+	TransactionStateDropped = "dropped"
+)
+
+// WebhookTransaction represents the transaction object of MINED_TRANSACTION and DROPPED_TRANSACTION webhooks.
+// Quantities are 0x-prefixed hex strings.
+type WebhookTransaction struct {
+	BlockHash            string `json:"blockHash"`
+	BlockNumber          string `json:"blockNumber"`
+	From                 string `json:"from"`
+	Gas                  string `json:"gas"`
+	GasPrice             string `json:"gasPrice"`
+	MaxFeePerGas         string `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+	Hash                 string `json:"hash"`
+	Input                string `json:"input"`
+	Nonce                string `json:"nonce"`
+	To                   string `json:"to"`
+	TransactionIndex     string `json:"transactionIndex"`
+	Type                 string `json:"type"`
+	Value                string `json:"value"`
+}
+
+// TransactionDocument represents the document structure for mined and dropped transactions.
+type TransactionDocument struct {
+	SchemaVersion        int             `json:"schemaVersion"`
+	Hash                 string          `json:"hash"`
+	From                 string          `json:"from"`
+	To                   string          `json:"to"`
+	Value                string          `json:"value"`
+	Gas                  int64           `json:"gas"`
+	GasPrice             string          `json:"gasPrice"`
+	MaxFeePerGas         string          `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string          `json:"maxPriorityFeePerGas,omitempty"`
+	Nonce                int64           `json:"nonce"`
+	Type                 int             `json:"type"`
+	TypeName             string          `json:"typeName,omitempty"`
+	ContractCreation     bool            `json:"contractCreation,omitempty"`
+	CreatedContract      string          `json:"createdContract,omitempty"`
+	TransactionIndex     int             `json:"transactionIndex"`
+	Input                string          `json:"input"`
+	Block                Block           `json:"block"`
+	State                string          `json:"state"`
+	Network              string          `json:"network"`
+	Alchemy              AlchemyMetadata `json:"alchemy"`
+}
+
+// DocumentID returns the idempotent document ID of the transaction, its hash.
+func (d *TransactionDocument) DocumentID() string {
+	return d.Hash
+}
+
+// ParseMinedTransaction parses a MINED_TRANSACTION webhook into a TransactionDocument.
+func ParseMinedTransaction(webhook *WebhookEvent) (*TransactionDocument, error) {
+	doc, err := parseWebhookTransaction(webhook)
+	if err != nil {
+		return nil, err
+	}
+	doc.State = TransactionStateMined
+	return doc, nil
+}
+
+// ParseDroppedTransaction parses a DROPPED_TRANSACTION webhook into a TransactionDocument.
+// It shares the document ID of any earlier document for the same hash, so recording it
+// flags that document as dropped.
+func ParseDroppedTransaction(webhook *WebhookEvent) (*TransactionDocument, error) {
+	doc, err := parseWebhookTransaction(webhook)
+	if err != nil {
+		return nil, err
+	}
+	doc.State = TransactionStateDropped
+	return doc, nil
+}
+
+// parseWebhookTransaction converts the transaction object of a transaction webhook into a TransactionDocument.
+func parseWebhookTransaction(webhook *WebhookEvent) (*TransactionDocument, error) {
+	tx := webhook.Event.Transaction
+	if tx == nil || tx.Hash == "" {
+		return nil, fmt.Errorf("%s webhook has no transaction", webhook.Type)
+	}
+
+	doc := &TransactionDocument{
+		SchemaVersion:        SchemaVersion,
+		Hash:                 tx.Hash,
+		From:                 tx.From,
+		To:                   tx.To,
+		Value:                hexToDecimal(tx.Value),
+		GasPrice:             tx.GasPrice,
+		MaxFeePerGas:         tx.MaxFeePerGas,
+		MaxPriorityFeePerGas: tx.MaxPriorityFeePerGas,
+		Input:                tx.Input,
+		Block:                Block{Hash: tx.BlockHash},
+		Network:              webhook.Event.Network,
+		Alchemy:              newAlchemyMetadata(webhook),
+	}
+
+	quantities := []struct {
+		name  string
+		value string
+		set   func(uint64)
+	}{
+		{"gas", tx.Gas, func(v uint64) { doc.Gas = int64(v) }},
+		{"nonce", tx.Nonce, func(v uint64) { doc.Nonce = int64(v) }},
+		{"type", tx.Type, func(v uint64) { doc.Type = int(v) }},
+		{"transactionIndex", tx.TransactionIndex, func(v uint64) { doc.TransactionIndex = int(v) }},
+		{"blockNumber", tx.BlockNumber, func(v uint64) { doc.Block.Number = int64(v) }},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		v, err := parseHexUint64(q.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction %s %q: %w", q.name, q.value, err)
+		}
+		q.set(v)
+	}
+	if tx.Type != "" {
+		doc.TypeName = transactionTypeName(doc.Type)
+	}
+	// Contract creations deploy to the CREATE address of the sender and nonce.
+	if tx.To == "" && tx.From != "" {
+		doc.ContractCreation = true
+		doc.CreatedContract = crypto.CreateAddress(common.HexToAddress(tx.From), uint64(doc.Nonce)).Hex()
+	}
+	return doc, nil
+}
@@ -0,0 +1,66 @@
+package function
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+func init() {
+	if os.Getenv("ENABLE_WARMUP") == "true" {
+		go func() {
+			ctx := context.Background()
+			if err := WarmUp(ctx); err != nil {
+				logError("cold start warm-up failed", err)
+				emitLifecycleEvent(ctx, LifecycleWarmUpFailed, map[string]any{"error": err.Error()})
+				return
+			}
+			emitLifecycleEvent(ctx, LifecycleWarmUpComplete, nil)
+		}()
+	}
+}
+
+// WarmUp loads the lazily initialized configuration, creates the shared GCP clients, wraps the
+// first field encryption data key and initializes the enabled sinks, so the first webhook after a cold start does not pay for them. It is safe to
+// call repeatedly; anything already initialized is reused.
+func WarmUp(ctx context.Context) error {
+	start := time.Now()
+
+	if _, err := LoadGraphQLMapping(); err != nil {
+		return err
+	}
+	settings, err := loadHandlerSettings()
+	if err != nil {
+		return err
+	}
+	if settings.encryptor != nil {
+		if _, err := settings.encryptor.dataKey(ctx); err != nil {
+			return err
+		}
+	}
+
+	if os.Getenv("ENABLE_REPLAY_PROTECTION") == "true" || os.Getenv("ENABLE_IDEMPOTENCY") == "true" ||
+		os.Getenv("ENABLE_SEQUENCE_TRACKING") == "true" {
+		if _, err := firestoreClient(ctx); err != nil {
+			return err
+		}
+	}
+	if err := InitSinks(ctx); err != nil {
+		return err
+	}
+
+	log.Printf(`{"level":"info","message":"warm-up complete","durationMs":%d}`, time.Since(start).Milliseconds())
+	return nil
+}
+
+// handleWarmUp answers a warmer ping: unsigned GET requests that only run WarmUp.
+func handleWarmUp(w http.ResponseWriter, ctx context.Context) {
+	if err := WarmUp(ctx); err != nil {
+		logError("warm-up failed", err)
+		http.Error(w, "Warm-up failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
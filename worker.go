@@ -0,0 +1,160 @@
+package function
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"cloud.google.com/go/pubsub/v2"
+)
+
+const defaultEnrichmentMaxOutstanding = 10
+
+// errUnsupportedContentType fails messages whose payload no registered serializer can read.
+var errUnsupportedContentType = errors.New("unsupported content type")
+
+// RunEnrichmentWorker consumes the transfer messages published by the function from
+// ENRICHMENT_SUBSCRIPTION, runs the configured enrichers over them and writes the enriched
+// transfers to Firestore, plus ALCHEMY_ENRICHED_TOPIC when set. It keeps heavy enrichment off
+// the ingest path: deploy the function without those enrichers and the worker with them.
+// It blocks until ctx is done or the subscription fails.
+//
+// Messages of other types are acknowledged and ignored, and so are messages in a content type no
+// registered serializer can read, which no redelivery would fix; PUBSUB_SERIALIZER must name one
+// that can, or the worker does not start. Messages of an older schema are migrated with
+// MigrateDocument, and messages too new for NegotiateSchema fail like messages that cannot be
+// decoded or written: they are nacked for redelivery, so configure a dead-letter topic on the
+// subscription.
+func RunEnrichmentWorker(ctx context.Context) error {
+	subscription := os.Getenv("ENRICHMENT_SUBSCRIPTION")
+	if subscription == "" {
+		return errors.New("ENRICHMENT_SUBSCRIPTION environment variable is not set")
+	}
+	enrichers, err := LoadEnrichers()
+	if err != nil {
+		return err
+	}
+	if len(enrichers) == 0 {
+		return errors.New("no enrichers are enabled")
+	}
+	encryptor, err := LoadFieldEncryptor()
+	if err != nil {
+		return err
+	}
+	serializer, err := SerializerFor(cmp.Or(os.Getenv("PUBSUB_SERIALIZER"), "json"))
+	if err != nil {
+		return err
+	}
+	if _, ok := decoderForContentType(serializer.ContentType()); !ok {
+		return fmt.Errorf("the enrichment worker cannot read %s payloads; set PUBSUB_SERIALIZER to json, msgpack, protobuf or avro", serializer.Name())
+	}
+
+	client, err := pubsubClient(ctx)
+	if err != nil {
+		return err
+	}
+	writer, err := newWriter(ctx)
+	if err != nil {
+		return err
+	}
+	var enriched Publisher
+	if topic := os.Getenv("ALCHEMY_ENRICHED_TOPIC"); topic != "" {
+		enriched, err = newPublisher(ctx, topic)
+		if err != nil {
+			return err
+		}
+		defer closePublisher(enriched)
+	}
+
+	subscriber := client.Subscriber(subscription)
+	subscriber.ReceiveSettings.MaxOutstandingMessages = envInt("ENRICHMENT_MAX_OUTSTANDING", defaultEnrichmentMaxOutstanding)
+	log.Printf(`{"level":"info","message":"enrichment worker started","subscription":"%s","enrichers":%d}`, subscription, len(enrichers))
+	return subscriber.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		err := enrichMessage(ctx, msg, enrichers, encryptor, writer, enriched)
+		if errors.Is(err, errUnsupportedContentType) {
+			logError("dropping message "+msg.ID, err)
+			msg.Ack()
+			return
+		}
+		if err != nil {
+			logError("failed to enrich message "+msg.ID, err)
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+	})
+}
+
+// enrichMessage enriches and writes the transfers of a single message. Encrypted fields are
+// decrypted for the enrichers and encrypted again for the sinks encryptor covers.
+func enrichMessage(ctx context.Context, msg *pubsub.Message, enrichers []Enricher, encryptor *FieldEncryptor, writer Writer, enriched Publisher) error {
+	if msg.Attributes["type"] != "transfers" {
+		return nil
+	}
+	data, err := payloadJSON(msg.Data, msg.Attributes["content_type"])
+	if err != nil {
+		return err
+	}
+	version, err := NegotiateSchema(msg.Attributes)
+	if err != nil {
+		return err
+	}
+	transfers, err := transfersPayload(data, msg.Attributes, version)
+	if err != nil {
+		return err
+	}
+	if len(transfers) == 0 {
+		return nil
+	}
+	if err := DecryptFields(ctx, transfers); err != nil {
+		return err
+	}
+	// Fields added by a newer, still readable schema are dropped, so the rewritten documents
+	// carry this worker's version.
+	for _, doc := range transfers {
+		doc.SchemaVersion = SchemaVersion
+	}
+
+	enrichTransfers(ctx, enrichers, transfers)
+	setAmountBuckets(transfers)
+	stored, err := encryptor.Transfers(ctx, sinkFirestore, transfers)
+	if err != nil {
+		return err
+	}
+	if err := writeDocuments(ctx, writer, collectionName, stored); err != nil {
+		return err
+	}
+	if enriched != nil {
+		published, err := encryptor.Transfers(ctx, sinkPubSub, transfers)
+		if err != nil {
+			return err
+		}
+		if err := enriched.PublishTransfers(ctx, published); err != nil {
+			return err
+		}
+	}
+	log.Printf(`{"level":"info","message":"enriched transfers","message_id":"%s","webhook_id":"%s","count":%d}`,
+		msg.ID, msg.Attributes["webhook_id"], len(transfers))
+	return nil
+}
+
+// payloadJSON returns the JSON form of a payload encoded as contentType, decoding other encodings
+// through their serializer, so envelopes and schema migrations are read the same way.
+func payloadJSON(data []byte, contentType string) ([]byte, error) {
+	decoder, ok := decoderForContentType(contentType)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnsupportedContentType, contentType)
+	}
+	if _, ok := decoder.(jsonSerializer); ok {
+		return data, nil
+	}
+	var value any
+	if err := decoder.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %w", contentType, err)
+	}
+	return json.Marshal(value)
+}